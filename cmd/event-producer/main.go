@@ -14,6 +14,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/suyog1pathak/transporter/internal/model"
 	"github.com/suyog1pathak/transporter/pkg/queue"
+	"github.com/suyog1pathak/transporter/pkg/version"
 	"gopkg.in/yaml.v3"
 )
 
@@ -45,7 +46,7 @@ It supports creating Kubernetes resource events from YAML manifests.
 Modes:
   websocket - Send events directly to Control Plane via WebSocket (for testing)
   memphis   - Publish events to Memphis queue (production mode)`,
-		Version: "0.1.0",
+		Version: version.String(),
 	}
 )
 
@@ -83,6 +84,8 @@ func init() {
 
 func createK8sEventCmd() *cobra.Command {
 	var manifestFiles []string
+	var asUser, asServiceAccount string
+	var asGroups []string
 
 	cmd := &cobra.Command{
 		Use:   "k8s",
@@ -120,12 +123,26 @@ func createK8sEventCmd() *cobra.Command {
 			event.TTL = ttl
 			event.Priority = priority
 
+			if asUser != "" || asServiceAccount != "" || len(asGroups) > 0 {
+				event.Impersonate = &model.ImpersonationTarget{
+					User:           asUser,
+					Groups:         asGroups,
+					ServiceAccount: asServiceAccount,
+				}
+				if err := event.Validate(); err != nil {
+					return fmt.Errorf("event validation failed: %w", err)
+				}
+			}
+
 			// Publish event
 			return publishEvent(event)
 		},
 	}
 
 	cmd.Flags().StringSliceVarP(&manifestFiles, "manifest", "m", []string{}, "Path to Kubernetes YAML manifest file (can be specified multiple times)")
+	cmd.Flags().StringVar(&asUser, "as", "", "User the agent should impersonate when applying")
+	cmd.Flags().StringSliceVar(&asGroups, "as-group", []string{}, "Group the agent should impersonate (can be specified multiple times)")
+	cmd.Flags().StringVar(&asServiceAccount, "as-service-account", "", "Service account (namespace/name) the agent should impersonate")
 	cmd.MarkFlagRequired("manifest")
 
 	return cmd
@@ -212,6 +229,9 @@ func publishEvent(event *model.Event) error {
 	if event.Type == model.EventTypeK8sResource {
 		fmt.Printf("  Manifests:    %d file(s)\n", len(event.Payload.Manifests))
 	}
+	if event.Impersonate != nil {
+		fmt.Printf("  Impersonate:  %s\n", event.Impersonate.UserName())
+	}
 
 	return nil
 }
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/suyog1pathak/transporter/pkg/storage"
+)
+
+func newAuditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Inspect the audit log",
+	}
+	cmd.AddCommand(newAuditVerifyCmd())
+	return cmd
+}
+
+func newAuditVerifyCmd() *cobra.Command {
+	var (
+		file          string
+		backend       string
+		redisConfig   storage.Config
+		boltPath      string
+		publicKeyPath string
+		allowLegacy   bool
+		jsonOutput    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the audit log hash chain and checkpoints",
+		Long: `Walk the audit log from its oldest retained entry, checking that every entry links
+to the one before it, that its content matches its hash and, with --public-key, that
+checkpoint signatures are valid and at least one checkpoint pins the chain. Entries
+without a hash are a break unless --allow-legacy accepts them at the start of the log.
+Reads an NDJSON export (--file) or the storage backend, and exits non-zero at the first
+broken link.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var publicKey ed25519.PublicKey
+			if publicKeyPath != "" {
+				key, err := storage.LoadAuditPublicKey(publicKeyPath)
+				if err != nil {
+					return err
+				}
+				publicKey = key
+			}
+			verifier := storage.NewChainVerifier(publicKey, allowLegacy)
+
+			var err error
+			if file != "" {
+				err = verifyAuditFile(file, verifier)
+			} else {
+				err = verifyAuditStore(cmd.Context(), backend, redisConfig, boltPath, verifier)
+			}
+			if err != nil {
+				return err
+			}
+
+			report := verifier.Report()
+			if jsonOutput {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				encoder.Encode(report)
+			} else {
+				printChainReport(cmd.OutOrStdout(), report)
+			}
+			return verifier.Err()
+		},
+	}
+
+	cmd.Flags().StringVar(&file, "file", "", "NDJSON audit export to verify (from GET /audit/export); reads the storage backend if unset")
+	cmd.Flags().StringVar(&backend, "storage-backend", storage.BackendRedis, "Storage backend to read (redis, bolt)")
+	addRedisFlags(cmd, &redisConfig)
+	cmd.Flags().StringVar(&boltPath, "bolt-path", "/var/lib/transporter/transporter.db", "Bolt database file (the control plane must be stopped)")
+	cmd.Flags().StringVar(&publicKeyPath, "public-key", "", "PEM ed25519 public key to verify checkpoint signatures")
+	cmd.Flags().BoolVar(&allowLegacy, "allow-legacy", false, "Accept entries without a hash at the start of the log (written before chaining)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the report as JSON")
+
+	return cmd
+}
+
+// verifyAuditFile walks an NDJSON audit export
+func verifyAuditFile(path string, verifier *storage.ChainVerifier) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open audit export: %w", err)
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	for {
+		var entry storage.AuditLogEntry
+		if err := decoder.Decode(&entry); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read audit export: %w", err)
+		}
+		if !verifier.Add(&entry) {
+			return nil
+		}
+	}
+}
+
+// verifyAuditStore walks the audit log of a storage backend
+func verifyAuditStore(ctx context.Context, backend string, redisConfig storage.Config, boltPath string, verifier *storage.ChainVerifier) error {
+	var store storage.Store
+	var err error
+	switch backend {
+	case storage.BackendRedis:
+		store, err = storage.NewRedisStorage(redisConfig)
+	case storage.BackendBolt:
+		store, err = storage.NewBoltStorage(boltPath, storage.Retention{})
+	default:
+		return fmt.Errorf("cannot verify the audit log of storage backend %q", backend)
+	}
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	query := storage.AuditQuery{Limit: storage.MaxAuditPageSize}
+	for {
+		page, err := store.QueryAuditLog(ctx, query)
+		if err != nil {
+			return err
+		}
+		for _, entry := range page.Entries {
+			if !verifier.Add(entry) {
+				return nil
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+func printChainReport(w io.Writer, report *storage.ChainReport) {
+	fmt.Fprintf(w, "Entries:      %d (from %s)\n", report.Entries, report.FirstID)
+	if report.Unchained > 0 {
+		fmt.Fprintf(w, "Unchained:    %d (written before chaining)\n", report.Unchained)
+	}
+	if report.Truncated {
+		fmt.Fprintln(w, "Start:        links to trimmed entries; verified from the oldest retained entry")
+	}
+	fmt.Fprintf(w, "Checkpoints:  %d verified, %d unverified\n", report.Checkpoints, report.Unverified)
+	fmt.Fprintf(w, "Uncovered:    %d (after the last entry pinned by a verified checkpoint)\n", report.Uncovered)
+	fmt.Fprintf(w, "Head:         %s %s\n", report.HeadID, report.HeadHash)
+	if report.Break != nil {
+		fmt.Fprintf(w, "BROKEN:       entry %s (#%d): %s\n", report.Break.ID, report.Break.Index, report.Break.Reason)
+		return
+	}
+	fmt.Fprintln(w, "Chain OK")
+}
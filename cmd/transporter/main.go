@@ -9,6 +9,8 @@ import (
 	"github.com/spf13/viper"
 	"github.com/suyog1pathak/transporter/internal/agent"
 	"github.com/suyog1pathak/transporter/internal/controlplane"
+	"github.com/suyog1pathak/transporter/pkg/storage"
+	"github.com/suyog1pathak/transporter/pkg/version"
 )
 
 var cfgFile string
@@ -18,7 +20,7 @@ var rootCmd = &cobra.Command{
 	Short: "Event-driven multi-cluster Kubernetes management",
 	Long: `Transporter is a lightweight, event-driven system that enables platform teams
 to manage Kubernetes resources across multiple clusters from a centralized control plane.`,
-	Version: "0.1.0",
+	Version: version.String(),
 }
 
 func main() {
@@ -36,6 +38,7 @@ func init() {
 
 	rootCmd.AddCommand(newControlPlaneCmd())
 	rootCmd.AddCommand(newAgentCmd())
+	rootCmd.AddCommand(newAuditCmd())
 }
 
 func initConfig() {
@@ -75,6 +78,18 @@ func newControlPlaneCmd() *cobra.Command {
 	cmd.Flags().StringVar(&cfg.WSAddr, "ws-addr", "0.0.0.0", "WebSocket server address")
 	cmd.Flags().IntVar(&cfg.WSPort, "ws-port", 8080, "WebSocket server port")
 
+	cmd.Flags().StringSliceVar(&cfg.AllowedOrigins, "allowed-origins", []string{}, "Allowed Origin headers for WebSocket clients (\"*\" allows any; requests without Origin are always allowed)")
+	cmd.Flags().DurationVar(&cfg.RegistrationTimeout, "registration-timeout", 10*time.Second, "Time allowed for an agent to register after connecting")
+	cmd.Flags().Int64Var(&cfg.MaxMessageSize, "max-message-size", 16<<20, "Maximum inbound WebSocket message size in bytes")
+	cmd.Flags().Float64Var(&cfg.ConnRateLimit, "conn-rate-limit", 5, "New WebSocket connections per second allowed per client IP (0 disables)")
+	cmd.Flags().IntVar(&cfg.ConnRateBurst, "conn-rate-burst", 10, "Burst size for the per-IP connection rate limit")
+	cmd.Flags().IntVar(&cfg.MaxAgents, "max-agents", 1000, "Maximum number of registered agents (0 for unlimited)")
+
+	cmd.Flags().StringSliceVar(&cfg.Encodings, "encodings", []string{"json", "msgpack"}, "Payload encodings agents may negotiate (json, msgpack)")
+	cmd.Flags().StringSliceVar(&cfg.Compressions, "compressions", []string{"none", "deflate", "zstd"}, "Compressions agents may negotiate (none, deflate, zstd)")
+	cmd.Flags().IntVar(&cfg.MaxFrameSize, "max-frame-size", 1<<20, "Messages to agents larger than this many bytes are chunked (0 disables chunking, minimum 1536)")
+	cmd.Flags().IntVar(&cfg.MaxReassembledSize, "max-reassembled-size", 64<<20, "Maximum size in bytes of a reassembled chunked message from an agent")
+
 	cmd.Flags().BoolVar(&cfg.MemphisEnabled, "memphis-enabled", true, "Enable Memphis queue integration")
 	cmd.Flags().StringVar(&cfg.MemphisHost, "memphis-host", "localhost", "Memphis server hostname")
 	cmd.Flags().StringVar(&cfg.MemphisUsername, "memphis-username", "root", "Memphis username")
@@ -83,12 +98,27 @@ func newControlPlaneCmd() *cobra.Command {
 	cmd.Flags().StringVar(&cfg.MemphisStation, "memphis-station", "transporter-events", "Memphis station name")
 	cmd.Flags().IntVar(&cfg.MemphisAccountID, "memphis-account-id", 0, "Memphis account ID (optional)")
 
-	cmd.Flags().StringVar(&cfg.RedisAddr, "redis-addr", "localhost:6379", "Redis server address")
-	cmd.Flags().StringVar(&cfg.RedisPassword, "redis-password", "", "Redis password")
-	cmd.Flags().IntVar(&cfg.RedisDB, "redis-db", 0, "Redis database number")
+	cmd.Flags().StringVar(&cfg.StorageBackend, "storage-backend", "redis", "Storage backend (redis, bolt, memory)")
+	cmd.Flags().StringVar(&cfg.BoltPath, "bolt-path", "/var/lib/transporter/transporter.db", "Database file for the bolt storage backend")
+	cmd.Flags().IntVar(&cfg.StorageBuffer.Size, "storage-buffer-size", storage.DefaultBufferSize, "Status and audit writes buffered while storage is unavailable")
+	cmd.Flags().DurationVar(&cfg.StorageBuffer.RetryInterval, "storage-retry-interval", storage.DefaultRetryInterval, "Initial delay before retrying buffered writes, doubled after each failure")
+	cmd.Flags().DurationVar(&cfg.StorageBuffer.MaxRetryInterval, "storage-max-retry-interval", storage.DefaultMaxRetryInterval, "Maximum delay between retries of buffered writes")
+	cmd.Flags().DurationVar(&cfg.StorageBuffer.PingInterval, "storage-ping-interval", storage.DefaultPingInterval, "How often storage health is checked while idle")
+
+	cmd.Flags().DurationVar(&cfg.StatusRetention, "status-retention", storage.DefaultEventStatusRetention, "How long event statuses and their per-agent index entries are kept")
+	cmd.Flags().Int64Var(&cfg.AuditMaxLen, "audit-max-len", storage.DefaultAuditMaxLen, "Maximum number of audit log entries kept")
+	cmd.Flags().DurationVar(&cfg.AuditMaxAge, "audit-max-age", 0, "Audit log entries older than this are trimmed (0 keeps them until audit-max-len)")
+	cmd.Flags().DurationVar(&cfg.CompactInterval, "compact-interval", 10*time.Minute, "How often expired data and dangling index entries are pruned (0 disables)")
+	cmd.Flags().StringVar(&cfg.AuditSigningKey, "audit-signing-key", "", "PEM ed25519 private key used to sign audit log checkpoints (empty disables checkpoints)")
+	cmd.Flags().DurationVar(&cfg.AuditCheckpointInterval, "audit-checkpoint-interval", 15*time.Minute, "How often a signed checkpoint of the audit log head is written")
+
+	addRedisFlags(cmd, &cfg.Redis)
 
 	cmd.Flags().DurationVar(&cfg.HeartbeatTimeout, "heartbeat-timeout", 30*time.Second, "Agent heartbeat timeout")
+	cmd.Flags().DurationVar(&cfg.UnhealthyGracePeriod, "unhealthy-grace-period", 60*time.Second, "How long an unhealthy agent is kept before its connection is closed and its events failed over")
 	cmd.Flags().IntVar(&cfg.EventRetryMax, "event-retry-max", 3, "Maximum event retry attempts")
+	cmd.Flags().IntVar(&cfg.UpgradeBatchSize, "upgrade-batch-size", 5, "Agents upgraded in parallel per rollout stage after the canary")
+	cmd.Flags().DurationVar(&cfg.UpgradeRegisterTimeout, "upgrade-register-timeout", 5*time.Minute, "Time for an upgraded agent to register its new version before the rollout is blocked")
 
 	viper.BindPFlags(cmd.Flags())
 
@@ -111,13 +141,41 @@ func newAgentCmd() *cobra.Command {
 	cmd.Flags().StringVar(&cfg.AgentID, "agent-id", "", "Unique agent ID (required)")
 	cmd.Flags().StringVar(&cfg.AgentName, "agent-name", "", "Human-friendly agent name")
 	cmd.Flags().StringVar(&cfg.ClusterName, "cluster-name", "", "Kubernetes cluster name (required)")
-	cmd.Flags().StringVar(&cfg.ClusterProvider, "cluster-provider", "kind", "Cluster provider (eks, gke, aks, kind)")
-	cmd.Flags().StringVar(&cfg.Region, "region", "local", "Cluster region")
+	cmd.Flags().StringVar(&cfg.ClusterProvider, "cluster-provider", "", "Cluster provider (eks, gke, aks, kind; discovered from node providerIDs if empty)")
+	cmd.Flags().StringVar(&cfg.Region, "region", "", "Cluster region (discovered from node topology labels if empty)")
+	cmd.Flags().StringArrayVar(&cfg.Labels, "label", []string{}, "Agent label as key=value (repeatable)")
+	cmd.Flags().StringVar(&cfg.LabelsFile, "labels-file", "", "File of key=value agent labels, e.g. a downward API labels file")
+	cmd.Flags().StringSliceVar(&cfg.Capabilities, "capabilities", []string{}, "Capabilities to advertise (empty to detect from executors, policy and RBAC)")
 	cmd.Flags().StringVar(&cfg.Namespace, "namespace", "default", "Namespace where agent is running")
 	cmd.Flags().StringVar(&cfg.CPURL, "cp-url", "ws://localhost:8080/ws", "Control Plane WebSocket URL")
+	cmd.Flags().StringSliceVar(&cfg.Encodings, "encodings", []string{"json"}, "Preferred payload encodings, most preferred first (json, msgpack)")
+	cmd.Flags().StringSliceVar(&cfg.Compressions, "compressions", []string{"deflate", "none"}, "Preferred compressions, most preferred first (none, deflate, zstd)")
+	cmd.Flags().IntVar(&cfg.MaxFrameSize, "max-frame-size", 1<<20, "Messages larger than this many bytes are chunked (0 for no limit, minimum 1536)")
+	cmd.Flags().DurationVar(&cfg.ReconnectMinBackoff, "reconnect-min-backoff", 1*time.Second, "Initial delay before reconnecting to the Control Plane")
+	cmd.Flags().DurationVar(&cfg.ReconnectMaxBackoff, "reconnect-max-backoff", 1*time.Minute, "Maximum delay between reconnection attempts")
+	cmd.Flags().StringVar(&cfg.Deployment, "deployment", "", "The agent's own Deployment as namespace/name, for self-upgrade (empty disables it)")
+	cmd.Flags().StringVar(&cfg.Container, "container", "transporter-agent", "Name of the agent container in its Deployment")
+	cmd.Flags().StringVar(&cfg.JournalPath, "journal-path", "", "File journaling unconfirmed status updates so they are replayed after restarts (empty to disable)")
+	cmd.Flags().StringVar(&cfg.HealthAddr, "health-addr", ":8081", "Address for the /healthz and /readyz probes (empty to disable)")
 	cmd.Flags().StringVar(&cfg.KubeconfigPath, "kubeconfig", "", "Path to kubeconfig file")
 	cmd.Flags().BoolVar(&cfg.InCluster, "in-cluster", false, "Use in-cluster Kubernetes config")
 	cmd.Flags().DurationVar(&cfg.HeartbeatInterval, "heartbeat-interval", 10*time.Second, "Heartbeat interval")
+	cmd.Flags().DurationVar(&cfg.ClusterHealthInterval, "cluster-health-interval", 30*time.Second, "How often the cluster API server and node readiness are probed for heartbeats")
+	cmd.Flags().IntVar(&cfg.MaxConcurrentEvents, "max-concurrent-events", 4, "Maximum number of events executed in parallel")
+	cmd.Flags().IntVar(&cfg.EventQueueSize, "event-queue-size", 100, "Events waiting for a worker before new events are rejected")
+	cmd.Flags().DurationVar(&cfg.DrainTimeout, "drain-timeout", 30*time.Second, "Time to finish in-flight events on shutdown before cancelling them")
+	cmd.Flags().BoolVar(&cfg.SerializeNamespaces, "serialize-namespaces", false, "Never apply two events touching the same namespace concurrently")
+
+	cmd.Flags().StringVar(&cfg.PolicyFile, "policy-file", "", "Path to agent policy YAML file")
+	cmd.Flags().StringSliceVar(&cfg.Policy.AllowedNamespaces, "allowed-namespaces", []string{}, "Namespaces events may touch (glob patterns, empty allows all)")
+	cmd.Flags().StringSliceVar(&cfg.Policy.DeniedNamespaces, "denied-namespaces", []string{}, "Namespaces events may never touch (glob patterns)")
+	cmd.Flags().StringSliceVar(&cfg.Policy.AllowedKinds, "allowed-kinds", []string{}, "Resource kinds events may touch as Kind.group glob patterns (empty allows all)")
+	cmd.Flags().StringSliceVar(&cfg.Policy.DeniedKinds, "denied-kinds", []string{}, "Resource kinds events may never touch as Kind.group glob patterns")
+	cmd.Flags().StringSliceVar(&cfg.Policy.AllowedOperations, "allowed-operations", []string{}, "Operations events may perform: create, update, delete, script (empty allows all)")
+	cmd.Flags().StringSliceVar(&cfg.Policy.AllowedImpersonationUsers, "allowed-impersonation-users", []string{}, "Users events may impersonate (glob patterns, empty denies all)")
+	cmd.Flags().StringSliceVar(&cfg.Policy.AllowedImpersonationGroups, "allowed-impersonation-groups", []string{}, "Groups events may impersonate (glob patterns, empty denies all)")
+	cmd.Flags().StringSliceVar(&cfg.Policy.AllowedImpersonationServiceAccounts, "allowed-impersonation-service-accounts", []string{}, "Service accounts events may impersonate as namespace/name glob patterns (empty denies all)")
+	cmd.Flags().StringSliceVar(&cfg.Policy.AllowedUpgradeImages, "allowed-upgrade-images", []string{}, "Images the agent may upgrade itself to (glob patterns, empty denies self-upgrade)")
 
 	cmd.MarkFlagRequired("agent-id")
 	cmd.MarkFlagRequired("cluster-name")
@@ -126,3 +184,34 @@ func newAgentCmd() *cobra.Command {
 
 	return cmd
 }
+
+// addRedisFlags binds the Redis connection flags shared by the commands that open storage
+func addRedisFlags(cmd *cobra.Command, cfg *storage.Config) {
+	cmd.Flags().StringVar(&cfg.Mode, "redis-mode", storage.RedisModeStandalone, "Redis deployment (standalone, sentinel, cluster)")
+	cmd.Flags().StringVar(&cfg.Addr, "redis-addr", "localhost:6379", "Redis server address (standalone mode)")
+	cmd.Flags().StringSliceVar(&cfg.Addrs, "redis-addrs", []string{}, "Sentinel addresses (sentinel mode) or seed nodes (cluster mode)")
+	cmd.Flags().StringVar(&cfg.MasterName, "redis-master-name", "", "Master name monitored by Sentinel (sentinel mode)")
+	cmd.Flags().StringVar(&cfg.Username, "redis-username", "", "Redis ACL username")
+	cmd.Flags().StringVar(&cfg.Password, "redis-password", "", "Redis password")
+	cmd.Flags().IntVar(&cfg.DB, "redis-db", 0, "Redis database number (must be 0 in cluster mode)")
+	cmd.Flags().StringVar(&cfg.SentinelUsername, "redis-sentinel-username", "", "Sentinel ACL username")
+	cmd.Flags().StringVar(&cfg.SentinelPassword, "redis-sentinel-password", "", "Sentinel password")
+	cmd.Flags().StringVar(&cfg.KeyPrefix, "redis-key-prefix", "", "Prefix for all Redis keys (cluster mode defaults to \""+storage.DefaultClusterKeyPrefix+"\"; a {hash tag} in it pins every key to one cluster slot)")
+
+	cmd.Flags().BoolVar(&cfg.TLS.Enabled, "redis-tls", false, "Connect to Redis (and Sentinel) over TLS")
+	cmd.Flags().StringVar(&cfg.TLS.CAFile, "redis-tls-ca", "", "PEM CA bundle verifying the Redis server (system roots if empty)")
+	cmd.Flags().StringVar(&cfg.TLS.CertFile, "redis-tls-cert", "", "PEM client certificate for mutual TLS")
+	cmd.Flags().StringVar(&cfg.TLS.KeyFile, "redis-tls-key", "", "PEM client key for mutual TLS")
+	cmd.Flags().StringVar(&cfg.TLS.ServerName, "redis-tls-server-name", "", "Server name to verify in the Redis certificate")
+	cmd.Flags().BoolVar(&cfg.TLS.InsecureSkipVerify, "redis-tls-insecure-skip-verify", false, "Skip Redis certificate verification (testing only)")
+
+	cmd.Flags().IntVar(&cfg.PoolSize, "redis-pool-size", 0, "Maximum Redis connections per node (0 uses 10 per CPU)")
+	cmd.Flags().IntVar(&cfg.MinIdleConns, "redis-min-idle-conns", 0, "Idle Redis connections kept open")
+	cmd.Flags().DurationVar(&cfg.PoolTimeout, "redis-pool-timeout", 0, "How long to wait for a free connection (0 uses read timeout + 1s)")
+	cmd.Flags().DurationVar(&cfg.DialTimeout, "redis-dial-timeout", 5*time.Second, "Redis connect timeout")
+	cmd.Flags().DurationVar(&cfg.ReadTimeout, "redis-read-timeout", 3*time.Second, "Redis read timeout")
+	cmd.Flags().DurationVar(&cfg.WriteTimeout, "redis-write-timeout", 3*time.Second, "Redis write timeout")
+	cmd.Flags().IntVar(&cfg.MaxRetries, "redis-max-retries", 3, "Retries of failed Redis commands (-1 disables)")
+	cmd.Flags().DurationVar(&cfg.ConnMaxIdleTime, "redis-conn-max-idle-time", 30*time.Minute, "Close Redis connections idle for longer than this")
+	cmd.Flags().DurationVar(&cfg.OperationTimeout, "redis-op-timeout", storage.DefaultOperationTimeout, "Timeout of each storage operation against Redis, retries included")
+}
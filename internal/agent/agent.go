@@ -1,17 +1,21 @@
 package agent
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"maps"
 	"os"
 	"os/signal"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/suyog1pathak/transporter/internal/model"
 	"github.com/suyog1pathak/transporter/pkg/executor"
 	"github.com/suyog1pathak/transporter/pkg/logger"
+	"github.com/suyog1pathak/transporter/pkg/version"
 )
 
 // Config holds all configuration for the data plane agent.
@@ -24,15 +28,50 @@ type Config struct {
 	Region          string
 	Namespace       string
 
+	// Registration Metadata
+	Labels       []string // key=value labels; override the labels file and discovered labels
+	LabelsFile   string   // Optional file of key=value lines (e.g. a downward API labels file)
+	Capabilities []string // Explicit capabilities (empty to detect from executors, policy and RBAC)
+
 	// Control Plane Connection
-	CPURL string
+	CPURL        string
+	Encodings    []string // Preferred payload encodings, most preferred first (json, msgpack)
+	Compressions []string // Preferred compressions, most preferred first (none, deflate, zstd)
+	MaxFrameSize int      // Largest frame to exchange before chunking (0 for no limit)
+
+	// Time to finish in-flight events on shutdown before cancelling them
+	DrainTimeout time.Duration
+
+	// Reconnection backoff bounds
+	ReconnectMinBackoff time.Duration
+	ReconnectMaxBackoff time.Duration
 
 	// Kubernetes Config
 	KubeconfigPath string
 	InCluster      bool
 
 	// Heartbeat
-	HeartbeatInterval time.Duration
+	HeartbeatInterval     time.Duration
+	ClusterHealthInterval time.Duration // How often the cluster API server and nodes are probed
+
+	// Execution Concurrency
+	MaxConcurrentEvents int  // Events executed in parallel
+	EventQueueSize      int  // Events waiting for a worker before new ones are rejected
+	SerializeNamespaces bool // Never apply two events touching the same namespace concurrently
+
+	// Execution Guardrails
+	PolicyFile string // Optional YAML policy file (flag values override file values)
+	Policy     Policy
+
+	// Health probes (empty to disable)
+	HealthAddr string
+
+	// File journaling unconfirmed status updates across restarts (empty to disable)
+	JournalPath string
+
+	// Self-upgrade target (empty disables agent_upgrade events)
+	Deployment string // The agent's own Deployment as namespace/name
+	Container  string // Agent container within the Deployment
 
 	Debug bool
 }
@@ -45,9 +84,43 @@ func Run(cfg Config) error {
 	if cfg.AgentName == "" {
 		cfg.AgentName = cfg.AgentID
 	}
+	if cfg.ReconnectMinBackoff <= 0 {
+		cfg.ReconnectMinBackoff = 1 * time.Second
+	}
+	if cfg.ClusterHealthInterval <= 0 {
+		cfg.ClusterHealthInterval = 30 * time.Second
+	}
+	if cfg.MaxConcurrentEvents <= 0 {
+		cfg.MaxConcurrentEvents = 1
+	}
+	if cfg.EventQueueSize < 0 {
+		cfg.EventQueueSize = 0
+	}
+	if cfg.ReconnectMaxBackoff < cfg.ReconnectMinBackoff {
+		cfg.ReconnectMaxBackoff = cfg.ReconnectMinBackoff
+	}
+
+	if _, _, ok := parseDeployment(cfg.Deployment); cfg.Deployment != "" && !ok {
+		return fmt.Errorf("invalid --deployment %q (expected namespace/name)", cfg.Deployment)
+	}
 
 	hostname, _ := os.Hostname()
 
+	policy, err := LoadPolicy(cfg.PolicyFile, cfg.Policy)
+	if err != nil {
+		return fmt.Errorf("failed to load agent policy: %w", err)
+	}
+	logger.Info("Agent policy loaded",
+		"allowed_namespaces", policy.AllowedNamespaces,
+		"denied_namespaces", policy.DeniedNamespaces,
+		"allowed_kinds", policy.AllowedKinds,
+		"denied_kinds", policy.DeniedKinds,
+		"allowed_operations", policy.AllowedOperations,
+		"allowed_impersonation_users", policy.AllowedImpersonationUsers,
+		"allowed_impersonation_groups", policy.AllowedImpersonationGroups,
+		"allowed_impersonation_service_accounts", policy.AllowedImpersonationServiceAccounts,
+		"allowed_upgrade_images", policy.AllowedUpgradeImages)
+
 	// Initialize Kubernetes executor
 	logger.Info("Initializing Kubernetes executor")
 	k8sExecutor, err := executor.NewK8sExecutor(executor.Config{
@@ -59,172 +132,310 @@ func Run(cfg Config) error {
 	}
 	logger.Info("Kubernetes executor initialized")
 
-	// Connect to Control Plane
-	logger.Info("Connecting to Control Plane", "url", cfg.CPURL)
-	conn, _, err := websocket.DefaultDialer.Dial(cfg.CPURL, nil)
+	registration, err := buildRegistration(cfg, hostname, k8sExecutor, policy)
 	if err != nil {
-		return fmt.Errorf("failed to connect to Control Plane: %w", err)
+		return err
 	}
-	defer conn.Close()
-	logger.Info("Connected to Control Plane")
-
-	// Send registration
-	registration := model.AgentRegistration{
-		ID:              cfg.AgentID,
-		Name:            cfg.AgentName,
-		ClusterName:     cfg.ClusterName,
-		ClusterProvider: cfg.ClusterProvider,
-		Region:          cfg.Region,
-		Version:         "0.1.0",
-		Labels:          map[string]string{},
-		Capabilities:    []string{"k8s_crud"},
-		Hostname:        hostname,
-		Namespace:       cfg.Namespace,
-		Metadata:        map[string]string{},
+	logger.Info("Agent registration prepared", "version", registration.Version,
+		"capabilities", registration.Capabilities, "labels", registration.Labels,
+		"cluster_provider", registration.ClusterProvider, "region", registration.Region)
+
+	var statusJournal *journal
+	pending := make([]*model.StatusUpdate, 0)
+	if cfg.JournalPath != "" {
+		statusJournal, pending, err = openJournal(cfg.JournalPath)
+		if err != nil {
+			return fmt.Errorf("failed to open status journal: %w", err)
+		}
+		defer statusJournal.close()
 	}
 
-	if err := conn.WriteJSON(registration); err != nil {
-		return fmt.Errorf("failed to send registration: %w", err)
+	a := &agent{
+		cfg: cfg,
+		registration: &model.RegisterMessage{
+			AgentRegistration: *registration,
+			ProtocolVersions:  []int{model.ProtocolVersion},
+			Encodings:         toEncodings(cfg.Encodings),
+			Compressions:      toCompressions(cfg.Compressions),
+			MaxFrameSize:      cfg.MaxFrameSize,
+		},
+		executor:    k8sExecutor,
+		policy:      policy,
+		journal:     statusJournal,
+		unconfirmed: pending,
+		queue:       make(chan *queuedEvent, cfg.EventQueueSize),
 	}
-
-	var response map[string]string
-	if err := conn.ReadJSON(&response); err != nil {
-		return fmt.Errorf("failed to read registration response: %w", err)
+	if cfg.SerializeNamespaces {
+		a.namespaceLocks = newNamespaceLocks()
 	}
+	a.failInterrupted()
 
-	if response["status"] != "registered" {
-		return fmt.Errorf("registration failed: %s", response["error"])
+	if cfg.HealthAddr != "" {
+		healthServer := a.serveHealth(cfg.HealthAddr)
+		defer healthServer.Close()
 	}
 
-	logger.Info("Agent registered successfully")
-
-	// Start heartbeat goroutine
-	stopHeartbeat := make(chan struct{})
-	go sendHeartbeat(conn, cfg.HeartbeatInterval, stopHeartbeat)
-
 	// Handle graceful shutdown
+	ctx, cancel := context.WithCancel(context.Background())
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
-	// Message processing loop
-	go func() {
-		for {
-			var message map[string]interface{}
-			if err := conn.ReadJSON(&message); err != nil {
-				logger.Error("Error reading message", "error", err)
-				return
-			}
-
-			msgType, ok := message["type"].(string)
-			if !ok {
-				continue
-			}
+	go a.watchClusterHealth(ctx, cfg.ClusterHealthInterval)
+	a.startWorkers(ctx, cfg.MaxConcurrentEvents)
+	logger.Info("Event workers started", "max_concurrent_events", cfg.MaxConcurrentEvents,
+		"queue_size", cfg.EventQueueSize, "serialize_namespaces", cfg.SerializeNamespaces)
 
-			switch msgType {
-			case "event":
-				go handleEvent(conn, message, k8sExecutor)
-			default:
-				logger.Warn("Unknown message type", "type", msgType)
-			}
-		}
+	// Connection supervisor keeps the agent connected until shutdown
+	stopped := make(chan struct{})
+	go func() {
+		a.supervise(ctx)
+		close(stopped)
 	}()
 
 	logger.Info("Agent started successfully, waiting for events...")
 
 	<-sigChan
 	logger.Info("Shutting down agent...")
-	close(stopHeartbeat)
 
-	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-	time.Sleep(1 * time.Second)
+	// Drain in-flight events; a second signal skips the remaining grace period
+	drained := make(chan struct{})
+	go func() {
+		a.drain(cfg.DrainTimeout)
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-sigChan:
+		logger.Warn("Second signal received, exiting without finishing drain")
+	}
+
+	cancel()
+	a.shutdown()
+
+	select {
+	case <-stopped:
+	case <-time.After(1 * time.Second):
+	}
 
 	return nil
 }
 
-func sendHeartbeat(conn *websocket.Conn, interval time.Duration, stop chan struct{}) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+// buildRegistration assembles the registration from configuration, discovered cluster
+// facts and detected capabilities
+func buildRegistration(cfg Config, hostname string, k8s *executor.K8sExecutor, policy *Policy) (*model.AgentRegistration, error) {
+	labels := make(map[string]string)
+	if cfg.LabelsFile != "" {
+		fileLabels, err := loadLabelsFile(cfg.LabelsFile)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(labels, fileLabels)
+	}
+	flagLabels, err := parseLabels(cfg.Labels)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(labels, flagLabels)
 
-	for {
-		select {
-		case <-ticker.C:
-			heartbeat := map[string]interface{}{
-				"type":      "heartbeat",
-				"timestamp": time.Now(),
-				"metrics":   map[string]interface{}{},
-			}
-			if err := conn.WriteJSON(heartbeat); err != nil {
-				logger.Error("Failed to send heartbeat", "error", err)
-				return
-			}
-			logger.Debug("Heartbeat sent")
+	registration := &model.AgentRegistration{
+		ID:              cfg.AgentID,
+		Name:            cfg.AgentName,
+		ClusterName:     cfg.ClusterName,
+		ClusterProvider: cfg.ClusterProvider,
+		Region:          cfg.Region,
+		Version:         version.Version,
+		Labels:          labels,
+		Capabilities:    cfg.Capabilities,
+		Hostname:        hostname,
+		Namespace:       cfg.Namespace,
+		Metadata: map[string]string{
+			"commit":     version.Commit,
+			"build_time": version.BuildTime,
+		},
+	}
 
-		case <-stop:
-			return
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	discoverCluster(ctx, k8s, registration)
+	if len(registration.Capabilities) == 0 {
+		registration.Capabilities = detectCapabilities(ctx, k8s, policy, cfg.Namespace, cfg.Deployment)
 	}
+	if len(registration.Capabilities) == 0 {
+		return nil, fmt.Errorf("agent has no usable capabilities (check RBAC permissions and agent policy, or set --capabilities)")
+	}
+
+	return registration, nil
 }
 
-func handleEvent(conn *websocket.Conn, message map[string]interface{}, k8sExecutor *executor.K8sExecutor) {
-	eventData, err := json.Marshal(message["event"])
-	if err != nil {
-		logger.Error("Failed to marshal event", "error", err)
-		return
-	}
+// agent is the long-lived agent state shared by successive control plane sessions
+type agent struct {
+	cfg          Config
+	registration *model.RegisterMessage
+	executor     *executor.K8sExecutor
+	policy       *Policy
+
+	running        sync.Map          // eventID -> context.CancelFunc for queued and executing events
+	queue          chan *queuedEvent // Events waiting for a worker
+	draining       atomic.Bool       // Set on shutdown; new events are rejected
+	metrics        healthMetrics
+	namespaceLocks *namespaceLocks // Set when events touching a namespace are serialized
+
+	sendMu sync.Mutex // Serializes status sends so updates leave in journal order; taken before mu
+
+	mu               sync.Mutex
+	session          *session              // Current session, nil while disconnected
+	journal          *journal              // Durable copy of unconfirmed, nil when disabled
+	unconfirmed      []*model.StatusUpdate // Status updates not yet confirmed by the CP, oldest first
+	collapseAt       int                   // Unconfirmed updates that trigger the next collapse, beyond maxUnconfirmedUpdates
+	collapsedUpdates int                   // Unconfirmed updates dropped by collapsing
+	health           connectionHealth
+}
 
-	var event model.Event
-	if err := json.Unmarshal(eventData, &event); err != nil {
-		logger.Error("Failed to unmarshal event", "error", err)
+// cancelEvent stops a running event at its next checkpoint
+func (a *agent) cancelEvent(sess *session, cancel *model.CancelMessage, seq uint64) {
+	cancelFunc, ok := a.running.Load(cancel.EventID)
+	if !ok {
+		logger.Warn("Cancel requested for unknown event", "event_id", cancel.EventID)
+		sess.send(model.MessageTypeError, &model.ErrorMessage{
+			Code:    "EVENT_NOT_RUNNING",
+			Message: fmt.Sprintf("event %s is not running on this agent", cancel.EventID),
+			Seq:     seq,
+		})
 		return
 	}
 
+	logger.Info("Cancelling event", "event_id", cancel.EventID, "reason", cancel.Reason)
+	cancelFunc.(context.CancelFunc)()
+}
+
+// handleEvent executes a dispatched event. ctx is cancelled if the event is cancelled.
+func (a *agent) handleEvent(ctx context.Context, event *model.Event) {
+	defer func() {
+		if cancel, ok := a.running.LoadAndDelete(event.ID); ok {
+			cancel.(context.CancelFunc)()
+		}
+	}()
+
 	logger.Info("Received event", "event_id", event.ID, "type", event.Type)
+	if event.Impersonate != nil {
+		logger.Info("Event requests impersonation", "event_id", event.ID,
+			"user", event.Impersonate.UserName(), "groups", event.Impersonate.Groups)
+	}
 
-	sendStatusUpdate(conn, &event, model.StateInProgress, model.PhaseReceived, "Event received, starting execution", nil, nil)
+	// Cancelled while waiting in the queue
+	if a.reportIfCancelled(ctx, event) {
+		return
+	}
+
+	a.sendStatusUpdate(event, model.StateInProgress, model.PhaseReceived, "Event received, starting execution", nil, nil)
 
 	if err := event.Validate(); err != nil {
 		logger.Error("Event validation failed", "event_id", event.ID, "error", err)
-		sendStatusUpdate(conn, &event, model.StateFailed, model.PhaseFailed, err.Error(), nil, nil)
+		a.sendStatusUpdate(event, model.StateFailed, model.PhaseFailed, err.Error(), nil, nil)
+		return
+	}
+
+	if event.Type == model.EventTypeAgentUpgrade {
+		a.handleUpgrade(ctx, event)
 		return
 	}
 
-	sendStatusUpdate(conn, &event, model.StateInProgress, model.PhaseValidating, "Validating event payload", nil, nil)
+	a.sendStatusUpdate(event, model.StateInProgress, model.PhaseValidating, "Validating event payload", nil, nil)
 
+	var targets []executor.ManifestTarget
+	var err error
 	if event.Type == model.EventTypeK8sResource {
-		if err := k8sExecutor.ValidateManifests(event.Payload.Manifests); err != nil {
+		if err := a.executor.ValidateManifests(event.Payload.Manifests); err != nil {
 			logger.Error("Manifest validation failed", "event_id", event.ID, "error", err)
-			sendStatusUpdate(conn, &event, model.StateFailed, model.PhaseFailed, fmt.Sprintf("Manifest validation failed: %v", err), nil, nil)
+			a.sendStatusUpdate(event, model.StateFailed, model.PhaseFailed, fmt.Sprintf("Manifest validation failed: %v", err), nil, nil)
 			return
 		}
+
+		targets, err = a.executor.InspectManifests(ctx, event.Payload.Manifests, event.Impersonate)
+		if err != nil {
+			logger.Error("Manifest inspection failed", "event_id", event.ID, "error", err)
+			a.sendStatusUpdate(event, model.StateFailed, model.PhaseFailed, fmt.Sprintf("Manifest inspection failed: %v", err), nil, nil)
+			return
+		}
+	}
+
+	if violations := a.policy.CheckEvent(event, targets); len(violations) > 0 {
+		result := violationResult(violations)
+		logger.Warn("Event rejected by agent policy", "event_id", event.ID, "violations", len(violations))
+		a.sendStatusUpdate(event, model.StateFailed, model.PhaseFailed, result.ErrorMessage, result, nil)
+		return
+	}
+
+	if a.namespaceLocks != nil {
+		if namespaces := touchedNamespaces(targets); len(namespaces) > 0 {
+			release, err := a.namespaceLocks.acquire(ctx, namespaces)
+			if err != nil {
+				a.reportIfCancelled(ctx, event)
+				return
+			}
+			defer release()
+		}
+	}
+
+	if a.reportIfCancelled(ctx, event) {
+		return
 	}
 
-	sendStatusUpdate(conn, &event, model.StateInProgress, model.PhaseApplying, "Applying changes to cluster", nil, nil)
+	a.sendStatusUpdate(event, model.StateInProgress, model.PhaseApplying, "Applying changes to cluster", nil, nil)
 
-	result, err := k8sExecutor.ExecuteEvent(&event)
+	result, err := a.executor.ExecuteEvent(ctx, event, targets)
 	if err != nil {
+		if a.reportIfCancelled(ctx, event) {
+			return
+		}
 		logger.Error("Event execution failed", "event_id", event.ID, "error", err)
-		sendStatusUpdate(conn, &event, model.StateFailed, model.PhaseFailed, err.Error(), nil, nil)
+		a.sendStatusUpdate(event, model.StateFailed, model.PhaseFailed, err.Error(), nil, nil)
 		return
 	}
 
-	sendStatusUpdate(conn, &event, model.StateInProgress, model.PhaseVerifying, "Verifying changes", nil, nil)
+	a.sendStatusUpdate(event, model.StateInProgress, model.PhaseVerifying, "Verifying changes", nil, nil)
 
 	// TODO: Add actual verification logic here
 	time.Sleep(1 * time.Second)
 
 	if result.Success {
 		logger.Info("Event completed successfully", "event_id", event.ID)
-		sendStatusUpdate(conn, &event, model.StateCompleted, model.PhaseCompleted, "Event completed successfully", result, nil)
+		a.sendStatusUpdate(event, model.StateCompleted, model.PhaseCompleted, "Event completed successfully", result, nil)
 	} else {
 		logger.Error("Event failed", "event_id", event.ID, "error", result.ErrorMessage)
-		sendStatusUpdate(conn, &event, model.StateFailed, model.PhaseFailed, result.ErrorMessage, result, nil)
+		a.sendStatusUpdate(event, model.StateFailed, model.PhaseFailed, result.ErrorMessage, result, nil)
+	}
+}
+
+// deleteResource deletes a resource on behalf of an event once the agent policy
+// allows deleting it; DeleteResource is never called without this check
+func (a *agent) deleteResource(ctx context.Context, event *model.Event, target executor.ManifestTarget) error {
+	target.Delete = true
+	if violations := a.policy.CheckEvent(event, []executor.ManifestTarget{target}); len(violations) > 0 {
+		return fmt.Errorf("%s", violationResult(violations).ErrorMessage)
 	}
+	return a.executor.DeleteResource(ctx, target, event.Impersonate)
 }
 
-func sendStatusUpdate(conn *websocket.Conn, event *model.Event, state model.ExecutionState, phase model.ExecutionPhase,
+// reportIfCancelled sends a cancelled status if the event's context was cancelled
+func (a *agent) reportIfCancelled(ctx context.Context, event *model.Event) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	logger.Info("Event cancelled", "event_id", event.ID)
+	a.sendStatusUpdate(event, model.StateCancelled, model.PhaseFailed, "Event cancelled before completion", nil, nil)
+	return true
+}
+
+func (a *agent) sendStatusUpdate(event *model.Event, state model.ExecutionState, phase model.ExecutionPhase,
 	message string, result *model.EventResult, details map[string]interface{}) {
 
-	update := model.StatusUpdate{
+	if state == model.StateFailed {
+		a.recordError(fmt.Sprintf("event %s failed: %s", event.ID, message))
+	}
+
+	update := &model.StatusUpdate{
 		EventID:   event.ID,
 		AgentID:   event.TargetAgent,
 		State:     state,
@@ -236,20 +447,131 @@ func sendStatusUpdate(conn *websocket.Conn, event *model.Event, state model.Exec
 		Timestamp: time.Now(),
 	}
 
-	statusMsg := map[string]interface{}{
-		"type":      "status_update",
-		"event_id":  update.EventID,
-		"agent_id":  update.AgentID,
-		"state":     update.State,
-		"phase":     update.Phase,
-		"message":   update.Message,
-		"log_level": update.LogLevel,
-		"details":   update.Details,
-		"result":    update.Result,
-		"timestamp": update.Timestamp,
+	a.reportStatus(update)
+}
+
+// reportStatus journals a status update and sends it, keeping it until the control
+// plane confirms it so it can be resent after a reconnect or restart. The update is
+// sent without holding a.mu so a full outbound queue cannot stall acks.
+func (a *agent) reportStatus(update *model.StatusUpdate) {
+	a.sendMu.Lock()
+	defer a.sendMu.Unlock()
+
+	a.mu.Lock()
+	if err := a.journal.append(update); err != nil {
+		logger.Error("Failed to journal status update", "event_id", update.EventID, "error", err)
+	}
+	a.unconfirmed = append(a.unconfirmed, update)
+	if len(a.unconfirmed) > max(maxUnconfirmedUpdates, a.collapseAt) {
+		a.collapseLocked()
+	}
+	sess := a.session
+	a.mu.Unlock()
+
+	if sess == nil {
+		logger.Debug("Control Plane disconnected, deferring status update", "event_id", update.EventID, "state", update.State)
+		return
+	}
+
+	seq, err := sess.send(model.MessageTypeStatusUpdate, update)
+	if err != nil {
+		logger.Error("Failed to send status update, will resend after reconnect", "event_id", update.EventID, "error", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.trackLocked(sess, seq, update)
+}
+
+// collapseLocked bounds the unconfirmed updates, and the journal, while the control
+// plane is unreachable by keeping only the latest update of each event in each state.
+// Caller must hold a.mu.
+func (a *agent) collapseLocked() {
+	before := len(a.unconfirmed)
+	a.unconfirmed = collapseUpdates(a.unconfirmed)
+	dropped := before - len(a.unconfirmed)
+	a.collapsedUpdates += dropped
+
+	// Collapse again only once the remaining updates have doubled
+	a.collapseAt = len(a.unconfirmed) * 2
+	logger.Warn("Too many unconfirmed status updates, keeping only the latest per event and state",
+		"dropped", dropped, "pending", len(a.unconfirmed))
+
+	if a.journal == nil {
+		return
+	}
+	if err := a.journal.rewrite(a.unconfirmed); err != nil {
+		logger.Error("Failed to rewrite status journal", "error", err)
+	}
+}
+
+// trackLocked records a sent status update. Updates on sessions without acks are
+// considered delivered once written. Caller must hold a.mu.
+func (a *agent) trackLocked(sess *session, seq uint64, update *model.StatusUpdate) {
+	if sess.acknowledges() {
+		sess.awaitAck(seq, update)
+		return
+	}
+	a.confirmLocked(update)
+}
+
+// confirm forgets a status update once the control plane has acked it
+func (a *agent) confirm(update *model.StatusUpdate) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.confirmLocked(update)
+}
+
+func (a *agent) confirmLocked(update *model.StatusUpdate) {
+	index := slices.Index(a.unconfirmed, update)
+	if index < 0 {
+		return
+	}
+	a.unconfirmed = slices.Delete(a.unconfirmed, index, index+1)
+
+	if err := a.journal.ack(update); err != nil {
+		logger.Warn("Failed to journal status confirmation", "event_id", update.EventID, "error", err)
+	}
+	a.journal.compact(a.unconfirmed)
+}
+
+// failInterrupted reports events that were still running when the agent last exited:
+// those with a journaled start, or unconfirmed updates, but no final status. Their
+// journaled updates are replayed first, then this final status.
+func (a *agent) failInterrupted() {
+	agents := make(map[string]string) // eventID -> agent the event was sent to
+	final := make(map[string]bool)
+	order := make([]string, 0)
+	track := func(eventID, agentID string) {
+		if _, seen := agents[eventID]; !seen {
+			order = append(order, eventID)
+		}
+		agents[eventID] = agentID
 	}
 
-	if err := conn.WriteJSON(statusMsg); err != nil {
-		logger.Error("Failed to send status update", "event_id", event.ID, "error", err)
+	for _, start := range a.journal.startedEvents() {
+		track(start.EventID, start.AgentID)
 	}
+	a.mu.Lock()
+	for _, update := range a.unconfirmed {
+		track(update.EventID, update.AgentID)
+		final[update.EventID] = isFinal(update.State)
+	}
+	a.mu.Unlock()
+
+	for _, eventID := range order {
+		if final[eventID] {
+			continue
+		}
+		logger.Warn("Event interrupted by agent restart", "event_id", eventID)
+		a.sendStatusUpdate(&model.Event{ID: eventID, TargetAgent: agents[eventID]}, model.StateFailed, model.PhaseFailed,
+			"Agent restarted while the event was executing; its outcome may be partial", nil, nil)
+	}
+}
+
+// isFinal reports whether an execution state ends the event
+func isFinal(state model.ExecutionState) bool {
+	return state == model.StateCompleted || state == model.StateFailed ||
+		state == model.StateExpired || state == model.StateCancelled
 }
@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/executor"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+)
+
+// Label keys set from auto-discovered cluster facts
+const (
+	LabelRegion            = "topology.kubernetes.io/region"
+	LabelZone              = "topology.kubernetes.io/zone"
+	LabelProvider          = "transporter.io/provider"
+	LabelKubernetesVersion = "transporter.io/kubernetes-version"
+)
+
+// providerSchemes maps node providerID schemes to cluster providers
+var providerSchemes = map[string]string{
+	"aws":          "eks",
+	"gce":          "gke",
+	"azure":        "aks",
+	"kind":         "kind",
+	"digitalocean": "doks",
+	"openstack":    "openstack",
+	"vsphere":      "vsphere",
+}
+
+// parseLabels parses key=value label flags
+func parseLabels(flags []string) (map[string]string, error) {
+	labels := make(map[string]string, len(flags))
+	for _, flag := range flags {
+		key, value, found := strings.Cut(flag, "=")
+		if !found || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid label %q (expected key=value)", flag)
+		}
+		labels[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
+
+// loadLabelsFile reads key=value lines, optionally quoted. This is also the format
+// of the Kubernetes downward API labels file, so pod labels can be mounted directly.
+func loadLabelsFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open labels file: %w", err)
+	}
+	defer file.Close()
+
+	labels := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid label on line %d of %s", lineNo, path)
+		}
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		labels[strings.TrimSpace(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read labels file: %w", err)
+	}
+
+	return labels, nil
+}
+
+// discoverCluster adds auto-discovered cluster facts to the registration. Discovered
+// labels never override configured ones, and a configured provider or region wins.
+func discoverCluster(ctx context.Context, k8s *executor.K8sExecutor, registration *model.AgentRegistration) {
+	info, err := k8s.DiscoverCluster(ctx)
+	if err != nil {
+		logger.Warn("Cluster discovery failed, registering without discovered facts", "error", err)
+		return
+	}
+
+	setDefault := func(key, value string) {
+		if _, exists := registration.Labels[key]; !exists && value != "" {
+			registration.Labels[key] = value
+		}
+	}
+
+	setDefault(LabelKubernetesVersion, info.Version)
+	registration.Metadata["kubernetes_version"] = info.Version
+	registration.Metadata["node_count"] = strconv.Itoa(info.NodeCount)
+
+	if len(info.Regions) == 1 {
+		setDefault(LabelRegion, info.Regions[0])
+		if registration.Region == "" {
+			registration.Region = info.Regions[0]
+		}
+	}
+	if len(info.Regions) > 0 {
+		registration.Metadata["regions"] = strings.Join(info.Regions, ",")
+	}
+	if len(info.Zones) == 1 {
+		setDefault(LabelZone, info.Zones[0])
+	}
+	if len(info.Zones) > 0 {
+		registration.Metadata["zones"] = strings.Join(info.Zones, ",")
+	}
+
+	if len(info.ProviderIDs) == 1 {
+		provider, known := providerSchemes[info.ProviderIDs[0]]
+		if !known {
+			provider = info.ProviderIDs[0]
+		}
+		setDefault(LabelProvider, provider)
+		if registration.ClusterProvider == "" {
+			registration.ClusterProvider = provider
+		}
+	}
+
+	logger.Info("Cluster discovered", "kubernetes_version", info.Version, "nodes", info.NodeCount,
+		"regions", info.Regions, "zones", info.Zones, "providers", info.ProviderIDs)
+}
+
+// detectCapabilities advertises what this agent can actually do: the executor must be
+// implemented, the agent policy must allow it, and RBAC must permit it in all namespaces,
+// the agent's namespace, or one of the policy's literal allowed namespaces. If RBAC
+// cannot be checked, permission is assumed and failures surface at execution time.
+// Self-upgrade needs a known Deployment, allowed images and patch access to it.
+func detectCapabilities(ctx context.Context, k8s *executor.K8sExecutor, policy *Policy, agentNamespace, deployment string) []string {
+	capabilities := make([]string, 0)
+
+	namespaces := []string{"", agentNamespace}
+	for _, namespace := range policy.AllowedNamespaces {
+		if !strings.ContainsAny(namespace, "*?[") {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	canI := func(verb, group, resource string) bool {
+		for _, namespace := range namespaces {
+			allowed, err := k8s.CanI(ctx, verb, group, resource, namespace, "")
+			if err != nil {
+				logger.Warn("RBAC capability check failed, assuming allowed", "verb", verb, "resource", resource, "error", err)
+				return true
+			}
+			if allowed {
+				return true
+			}
+		}
+		return false
+	}
+
+	crudAllowed := policy.operationAllowed(OperationCreate) || policy.operationAllowed(OperationUpdate)
+	if crudAllowed && canI("create", "*", "*") && canI("patch", "*", "*") {
+		capabilities = append(capabilities, model.CapabilityK8sCRUD)
+
+		impersonate := (len(policy.AllowedImpersonationUsers) > 0 && canI("impersonate", "", "users")) ||
+			(len(policy.AllowedImpersonationServiceAccounts) > 0 && canI("impersonate", "", "serviceaccounts"))
+		if impersonate {
+			capabilities = append(capabilities, model.CapabilityK8sImpersonate)
+		}
+	}
+
+	if namespace, name, ok := parseDeployment(deployment); ok && len(policy.AllowedUpgradeImages) > 0 {
+		allowed, err := k8s.CanI(ctx, "patch", "apps", "deployments", namespace, name)
+		if err != nil {
+			logger.Warn("RBAC capability check failed, assuming allowed", "verb", "patch", "resource", "deployments", "error", err)
+			allowed = true
+		}
+		if allowed {
+			capabilities = append(capabilities, model.CapabilityAgentUpgrade)
+		}
+	}
+
+	// Script and policy executors are not implemented yet, so they are never advertised
+	return capabilities
+}
@@ -0,0 +1,340 @@
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/internal/testutil"
+	"github.com/suyog1pathak/transporter/pkg/executor"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "none", want: map[string]string{}},
+		{
+			name:  "trimmed",
+			flags: []string{"env=prod", " team = payments ", "tier="},
+			want:  map[string]string{"env": "prod", "team": "payments", "tier": ""},
+		},
+		{name: "value may contain =", flags: []string{"expr=a=b"}, want: map[string]string{"expr": "a=b"}},
+		{name: "later flag wins", flags: []string{"env=dev", "env=prod"}, want: map[string]string{"env": "prod"}},
+		{name: "missing =", flags: []string{"env"}, wantErr: true},
+		{name: "empty key", flags: []string{" =prod"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLabels(tt.flags)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLabels() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !maps.Equal(got, tt.want) {
+				t.Fatalf("parseLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadLabelsFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "downward API format",
+			content: `app="transporter-agent"
+pod-template-hash="7d9f8c6b5"
+topology.kubernetes.io/zone="eu-west-1a"
+`,
+			want: map[string]string{"app": "transporter-agent", "pod-template-hash": "7d9f8c6b5", "topology.kubernetes.io/zone": "eu-west-1a"},
+		},
+		{
+			name:    "comments, blank lines and unquoted values",
+			content: "# Labels for the agent\n\nenv = prod\nquote=\"say \\\"hi\\\"\"\nbroken=\"unterminated\n",
+			want:    map[string]string{"env": "prod", "quote": `say "hi"`, "broken": `"unterminated`},
+		},
+		{name: "empty", want: map[string]string{}},
+		{name: "missing =", content: "env=prod\nteam\n", wantErr: "line 2"},
+		{name: "empty key", content: "=prod\n", wantErr: "line 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "labels")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadLabelsFile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadLabelsFile() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Fatalf("loadLabelsFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := loadLabelsFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("loadLabelsFile() of a missing file succeeded")
+	}
+}
+
+// fakeCluster serves the API calls made by cluster discovery and capability
+// detection: the server version, the node list and access reviews
+type fakeCluster struct {
+	version string
+	nodes   []corev1.Node
+	fail    bool                                                      // Fail every request
+	allow   func(attributes *authorizationv1.ResourceAttributes) bool // nil allows everything
+}
+
+// executor starts the fake API server and returns an executor talking to it
+func (c *fakeCluster) executor(t *testing.T) *executor.K8sExecutor {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/version":
+			json.NewEncoder(w).Encode(map[string]string{"gitVersion": c.version})
+		case r.URL.Path == "/api/v1/nodes":
+			json.NewEncoder(w).Encode(&corev1.NodeList{
+				TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "NodeList"},
+				Items:    c.nodes,
+			})
+		case strings.HasSuffix(r.URL.Path, "/selfsubjectaccessreviews"):
+			// client-go sends built-in types as protobuf
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var review authorizationv1.SelfSubjectAccessReview
+			if _, _, err := scheme.Codecs.UniversalDeserializer().Decode(body, nil, &review); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			review.TypeMeta = metav1.TypeMeta{APIVersion: "authorization.k8s.io/v1", Kind: "SelfSubjectAccessReview"}
+			review.Status.Allowed = c.allow == nil || c.allow(review.Spec.ResourceAttributes)
+			json.NewEncoder(w).Encode(&review)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	k8s, err := executor.NewK8sExecutor(executor.Config{KubeconfigPath: testutil.Kubeconfig(t, server.URL)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k8s
+}
+
+// node returns a node in region and zone with the providerID
+func node(name, region, zone, providerID string) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{corev1.LabelTopologyRegion: region, corev1.LabelTopologyZone: zone},
+		},
+		Spec: corev1.NodeSpec{ProviderID: providerID},
+	}
+}
+
+func TestDiscoverCluster(t *testing.T) {
+	logger.InitLogger(false)
+
+	tests := []struct {
+		name         string
+		cluster      fakeCluster
+		registration model.AgentRegistration
+		wantLabels   map[string]string
+		wantRegion   string
+		wantProvider string
+		wantMetadata map[string]string
+	}{
+		{
+			name: "single region cloud cluster",
+			cluster: fakeCluster{version: "v1.30.2", nodes: []corev1.Node{
+				node("node-1", "eu-west-1", "eu-west-1a", "aws:///eu-west-1a/i-1"),
+				node("node-2", "eu-west-1", "eu-west-1a", "aws:///eu-west-1a/i-2"),
+			}},
+			wantLabels: map[string]string{
+				LabelKubernetesVersion: "v1.30.2",
+				LabelRegion:            "eu-west-1",
+				LabelZone:              "eu-west-1a",
+				LabelProvider:          "eks",
+			},
+			wantRegion:   "eu-west-1",
+			wantProvider: "eks",
+			wantMetadata: map[string]string{"kubernetes_version": "v1.30.2", "node_count": "2", "regions": "eu-west-1", "zones": "eu-west-1a"},
+		},
+		{
+			name: "configured facts win",
+			cluster: fakeCluster{version: "v1.30.2", nodes: []corev1.Node{
+				node("node-1", "eu-west-1", "eu-west-1a", "gce://project/zone/node-1"),
+			}},
+			registration: model.AgentRegistration{
+				Region:          "europe",
+				ClusterProvider: "on-prem",
+				Labels:          map[string]string{LabelRegion: "europe", LabelProvider: "on-prem"},
+			},
+			wantLabels: map[string]string{
+				LabelKubernetesVersion: "v1.30.2",
+				LabelRegion:            "europe",
+				LabelZone:              "eu-west-1a",
+				LabelProvider:          "on-prem",
+			},
+			wantRegion:   "europe",
+			wantProvider: "on-prem",
+		},
+		{
+			name: "several regions and zones are only metadata",
+			cluster: fakeCluster{version: "v1.29.0", nodes: []corev1.Node{
+				node("node-1", "eu-west-1", "eu-west-1a", "vendor://node-1"),
+				node("node-2", "eu-central-1", "eu-central-1b", "vendor://node-2"),
+			}},
+			wantLabels:   map[string]string{LabelKubernetesVersion: "v1.29.0", LabelProvider: "vendor"},
+			wantProvider: "vendor",
+			wantMetadata: map[string]string{"regions": "eu-west-1,eu-central-1", "zones": "eu-west-1a,eu-central-1b"},
+		},
+		{
+			name:       "discovery failure registers without facts",
+			cluster:    fakeCluster{fail: true},
+			wantLabels: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registration := tt.registration
+			if registration.Labels == nil {
+				registration.Labels = make(map[string]string)
+			}
+			registration.Metadata = make(map[string]string)
+
+			discoverCluster(t.Context(), tt.cluster.executor(t), &registration)
+
+			if !maps.Equal(registration.Labels, tt.wantLabels) {
+				t.Errorf("labels = %v, want %v", registration.Labels, tt.wantLabels)
+			}
+			if registration.Region != tt.wantRegion || registration.ClusterProvider != tt.wantProvider {
+				t.Errorf("region %q, provider %q; want %q, %q", registration.Region, registration.ClusterProvider, tt.wantRegion, tt.wantProvider)
+			}
+			for key, want := range tt.wantMetadata {
+				if got := registration.Metadata[key]; got != want {
+					t.Errorf("metadata %s = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectCapabilities(t *testing.T) {
+	logger.InitLogger(false)
+
+	// allowOnly permits the verbs on resources, in the given namespaces ("" for all)
+	allowOnly := func(namespaces []string, verbs ...string) func(*authorizationv1.ResourceAttributes) bool {
+		return func(attributes *authorizationv1.ResourceAttributes) bool {
+			return slices.Contains(verbs, attributes.Verb+" "+attributes.Resource) && slices.Contains(namespaces, attributes.Namespace)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		cluster    fakeCluster
+		policy     Policy
+		deployment string
+		want       []string
+	}{
+		{name: "everything allowed", want: []string{model.CapabilityK8sCRUD}},
+		{
+			name:   "CRUD in an allowed namespace",
+			policy: Policy{AllowedNamespaces: []string{"team-*", "payments"}},
+			cluster: fakeCluster{allow: allowOnly([]string{"payments"},
+				"create *", "patch *")},
+			want: []string{model.CapabilityK8sCRUD},
+		},
+		{
+			name:    "CRUD denied by RBAC",
+			policy:  Policy{AllowedNamespaces: []string{"team-*"}},
+			cluster: fakeCluster{allow: allowOnly([]string{"team-a"}, "create *", "patch *")},
+			want:    []string{},
+		},
+		{name: "CRUD denied by policy", policy: Policy{AllowedOperations: []string{OperationDelete}}, want: []string{}},
+		{
+			name:   "impersonation",
+			policy: Policy{AllowedImpersonationServiceAccounts: []string{"team-a/*"}},
+			cluster: fakeCluster{allow: allowOnly([]string{"", "transporter-system"},
+				"create *", "patch *", "impersonate serviceaccounts")},
+			want: []string{model.CapabilityK8sCRUD, model.CapabilityK8sImpersonate},
+		},
+		{
+			name:    "impersonation denied by RBAC",
+			policy:  Policy{AllowedImpersonationUsers: []string{"alice"}},
+			cluster: fakeCluster{allow: allowOnly([]string{""}, "create *", "patch *", "impersonate serviceaccounts")},
+			want:    []string{model.CapabilityK8sCRUD},
+		},
+		{
+			name:       "self-upgrade",
+			policy:     Policy{AllowedUpgradeImages: []string{"registry.example.com/transporter-agent:*"}},
+			deployment: "transporter-system/transporter-agent",
+			want:       []string{model.CapabilityK8sCRUD, model.CapabilityAgentUpgrade},
+		},
+		{
+			name:       "self-upgrade without allowed images",
+			deployment: "transporter-system/transporter-agent",
+			want:       []string{model.CapabilityK8sCRUD},
+		},
+		{
+			name:       "self-upgrade without a valid deployment",
+			policy:     Policy{AllowedUpgradeImages: []string{"*"}},
+			deployment: "transporter-agent",
+			want:       []string{model.CapabilityK8sCRUD},
+		},
+		{
+			name:    "RBAC that cannot be checked is assumed",
+			cluster: fakeCluster{fail: true},
+			want:    []string{model.CapabilityK8sCRUD},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := detectCapabilities(t.Context(), tt.cluster.executor(t), &tt.policy, "transporter-system", tt.deployment)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("detectCapabilities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+)
+
+// drainPollInterval is how often drain progress is checked
+const drainPollInterval = 250 * time.Millisecond
+
+// drain stops accepting events, tells the control plane, and waits up to the grace
+// period for queued and running events to finish and their status updates to be
+// confirmed. Events still running at the deadline are cancelled.
+func (a *agent) drain(grace time.Duration) {
+	a.draining.Store(true)
+	deadline := time.Now().Add(grace)
+
+	inFlight := a.inFlight()
+	logger.Info("Draining agent", "in_flight", inFlight, "grace_period", grace)
+	a.notifyDraining(a.currentSession())
+
+	if !waitUntil(deadline, func() bool { return a.inFlight() == 0 }) {
+		logger.Warn("Drain grace period expired, cancelling remaining events", "in_flight", a.inFlight())
+		a.running.Range(func(_, cancel any) bool {
+			cancel.(context.CancelFunc)()
+			return true
+		})
+		// Give cancelled events a moment to report at their next checkpoint
+		waitUntil(time.Now().Add(5*time.Second), func() bool { return a.inFlight() == 0 })
+		deadline = time.Now().Add(5 * time.Second)
+	}
+
+	if !waitUntil(deadline, func() bool { return a.unconfirmedCount() == 0 }) {
+		logger.Warn("Exiting with unconfirmed status updates", "updates", a.unconfirmedCount())
+	}
+	logger.Info("Agent drained")
+}
+
+// notifyDraining tells the control plane to stop routing events to this agent
+func (a *agent) notifyDraining(sess *session) {
+	if sess == nil {
+		return
+	}
+	if _, err := sess.send(model.MessageTypeDrain, &model.DrainMessage{
+		Reason:      "agent shutting down",
+		InFlight:    a.inFlight(),
+		GracePeriod: a.cfg.DrainTimeout,
+	}); err != nil {
+		logger.Warn("Failed to send drain notice", "error", err)
+	}
+}
+
+// inFlight returns the number of queued and running events
+func (a *agent) inFlight() int {
+	count := 0
+	a.running.Range(func(_, _ any) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+func (a *agent) unconfirmedCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.unconfirmed)
+}
+
+func (a *agent) currentSession() *session {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.session
+}
+
+// waitUntil polls done until it returns true or the deadline passes
+func waitUntil(deadline time.Time, done func() bool) bool {
+	for !done() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(drainPollInterval)
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/suyog1pathak/transporter/pkg/logger"
+)
+
+// connectionHealth tracks the control plane connection for the health probes
+type connectionHealth struct {
+	State          ConnectionState
+	LastError      string
+	Attempts       int       // Connection attempts since the last successful registration
+	ConnectedSince time.Time // Zero while disconnected
+	ChangedAt      time.Time
+}
+
+// setState records a connection state transition
+func (a *agent) setState(state ConnectionState, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch state {
+	case ConnectionStateConnecting:
+		a.health.Attempts++
+	case ConnectionStateConnected:
+		a.health.Attempts = 0
+		a.health.LastError = ""
+		a.health.ConnectedSince = time.Now()
+	default:
+		a.health.ConnectedSince = time.Time{}
+	}
+	if err != nil {
+		a.health.LastError = err.Error()
+		a.recordError(err.Error())
+	}
+	a.health.State = state
+	a.health.ChangedAt = time.Now()
+}
+
+// healthReport returns the connection health, the number of unconfirmed status
+// updates and how many were dropped by collapsing
+func (a *agent) healthReport() (connectionHealth, int, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.health, len(a.unconfirmed), a.collapsedUpdates
+}
+
+// serveHealth exposes liveness and readiness probes.
+// /healthz fails only once the supervisor has stopped; /readyz fails while disconnected
+// or draining.
+func (a *agent) serveHealth(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		health, _, _ := a.healthReport()
+		a.writeHealth(w, health.State != ConnectionStateStopped)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		health, _, _ := a.healthReport()
+		a.writeHealth(w, health.State == ConnectionStateConnected && !a.draining.Load())
+	})
+
+	server := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		logger.Info("Health endpoint", "url", "http://"+addr+"/healthz")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("Health server failed", "error", err)
+		}
+	}()
+
+	return server
+}
+
+func (a *agent) writeHealth(w http.ResponseWriter, ok bool) {
+	health, unconfirmed, collapsed := a.healthReport()
+
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	body := map[string]interface{}{
+		"agent_id":            a.cfg.AgentID,
+		"state":               health.State,
+		"attempts":            health.Attempts,
+		"last_error":          health.LastError,
+		"state_changed_at":    health.ChangedAt,
+		"unconfirmed_updates": unconfirmed,
+		"collapsed_updates":   collapsed,
+		"draining":            a.draining.Load(),
+	}
+	if !health.ConnectedSince.IsZero() {
+		body["connected_since"] = health.ConnectedSince
+	}
+	json.NewEncoder(w).Encode(body)
+}
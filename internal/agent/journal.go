@@ -0,0 +1,306 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+)
+
+const (
+	// journalCompactThreshold is how many records may be written beyond the pending
+	// updates before the journal file is rewritten
+	journalCompactThreshold = 1000
+
+	// maxUnconfirmedUpdates is how many unconfirmed status updates are kept before
+	// each event's history is collapsed, see collapseUpdates
+	maxUnconfirmedUpdates = 1000
+)
+
+// journalRecord is one line of the journal: a status update, the confirmation of an
+// earlier one, or the start of an event's execution
+type journalRecord struct {
+	Update  *model.StatusUpdate `json:"update,omitempty"`
+	Ack     *journalAck         `json:"ack,omitempty"`
+	Started *journalStart       `json:"started,omitempty"`
+}
+
+// journalStart marks an event the agent began executing. It is kept until the
+// control plane confirms the event's final status, so an event interrupted by a
+// crash is found on restart even after its progress updates were confirmed.
+type journalStart struct {
+	EventID   string    `json:"event_id"`
+	AgentID   string    `json:"agent_id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// journalAck identifies a confirmed status update
+type journalAck struct {
+	EventID   string    `json:"event_id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// journal is an append-only file of status updates not yet confirmed by the control
+// plane, so they survive agent restarts and are replayed after reconnecting. A nil
+// journal is disabled and all its methods are no-ops.
+type journal struct {
+	path    string
+	file    *os.File
+	records int                     // Records in the file, for compaction
+	started map[string]journalStart // eventID -> start of events without a confirmed final status
+	mu      sync.Mutex
+}
+
+// openJournal opens (or creates) the journal and returns the unconfirmed updates it
+// holds, oldest first
+func openJournal(path string) (*journal, []*model.StatusUpdate, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	pending, started, records, err := readJournal(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	j := &journal{path: path, started: started}
+	if err := j.rewrite(pending); err != nil {
+		return nil, nil, err
+	}
+	logger.Info("Status journal opened", "path", path, "records", records, "pending", len(pending), "started", len(started))
+	return j, pending, nil
+}
+
+// readJournal replays the journal file. A torn last line from a crash is ignored.
+func readJournal(path string) ([]*model.StatusUpdate, map[string]journalStart, int, error) {
+	started := make(map[string]journalStart)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, started, 0, nil
+	}
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+
+	pending := make([]*model.StatusUpdate, 0)
+	records := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var record journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			logger.Warn("Skipping unreadable journal record", "path", path, "record", records+1, "error", err)
+			continue
+		}
+		records++
+
+		switch {
+		case record.Started != nil:
+			started[record.Started.EventID] = *record.Started
+		case record.Update != nil:
+			pending = append(pending, record.Update)
+		case record.Ack != nil:
+			for i, update := range pending {
+				if update.EventID == record.Ack.EventID && update.Timestamp.Equal(record.Ack.Timestamp) {
+					if isFinal(update.State) {
+						delete(started, update.EventID)
+					}
+					pending = append(pending[:i], pending[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	return pending, started, records, nil
+}
+
+// append records a status update before it is sent. The first update of an event is
+// preceded by a synced start record, and final updates are synced; progress updates
+// in between are not, since the start record alone lets failInterrupted report the
+// event after a crash.
+func (j *journal) append(update *model.StatusUpdate) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, started := j.started[update.EventID]; !started && !isFinal(update.State) {
+		start := journalStart{EventID: update.EventID, AgentID: update.AgentID, Timestamp: update.Timestamp}
+		if err := j.writeLocked(journalRecord{Started: &start}, true); err != nil {
+			return err
+		}
+		j.started[update.EventID] = start
+	}
+	return j.writeLocked(journalRecord{Update: update}, isFinal(update.State))
+}
+
+// ack records that the control plane confirmed a status update, and forgets the
+// event's start once its final status is confirmed. Acks are not synced; a lost ack
+// only resends an update the control plane skips as a duplicate.
+func (j *journal) ack(update *model.StatusUpdate) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if isFinal(update.State) {
+		delete(j.started, update.EventID)
+	}
+	return j.writeLocked(journalRecord{Ack: &journalAck{EventID: update.EventID, Timestamp: update.Timestamp}}, false)
+}
+
+// startedEvents returns the events without a confirmed final status, oldest first
+func (j *journal) startedEvents() []journalStart {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	starts := slices.Collect(maps.Values(j.started))
+	slices.SortFunc(starts, func(a, b journalStart) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	return starts
+}
+
+// writeLocked appends a record. Caller must hold j.mu.
+func (j *journal) writeLocked(record journalRecord, sync bool) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode journal record: %w", err)
+	}
+
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if sync {
+		if err := j.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync journal: %w", err)
+		}
+	}
+	j.records++
+	return nil
+}
+
+// compact rewrites the journal with only the start records and pending updates once
+// enough confirmed records have accumulated
+func (j *journal) compact(pending []*model.StatusUpdate) {
+	if j == nil {
+		return
+	}
+
+	j.mu.Lock()
+	live := len(j.started) + len(pending)
+	due := live == 0 && j.records > 0 || j.records-live > journalCompactThreshold
+	j.mu.Unlock()
+	if !due {
+		return
+	}
+
+	if err := j.rewrite(pending); err != nil {
+		logger.Warn("Failed to compact status journal", "path", j.path, "error", err)
+	}
+}
+
+// collapseUpdates keeps only the latest update of each event in each state, in their
+// original order. Intermediate phases and log lines are lost, but every state the
+// event went through is still reported, so the control plane accepts the transitions.
+func collapseUpdates(updates []*model.StatusUpdate) []*model.StatusUpdate {
+	type stateKey struct {
+		eventID string
+		state   model.ExecutionState
+	}
+
+	latest := make(map[stateKey]int, len(updates))
+	for i, update := range updates {
+		latest[stateKey{update.EventID, update.State}] = i
+	}
+
+	collapsed := make([]*model.StatusUpdate, 0, len(latest))
+	for i, update := range updates {
+		if latest[stateKey{update.EventID, update.State}] == i {
+			collapsed = append(collapsed, update)
+		}
+	}
+	return collapsed
+}
+
+// rewrite atomically replaces the journal file with the start records and the given updates
+func (j *journal) rewrite(pending []*model.StatusUpdate) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	tmpPath := j.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create journal: %w", err)
+	}
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, start := range j.started {
+		if err := encoder.Encode(journalRecord{Started: &start}); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to write journal: %w", err)
+		}
+	}
+	for _, update := range pending {
+		if err := encoder.Encode(journalRecord{Update: update}); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to write journal: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	tmp.Close()
+
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		return fmt.Errorf("failed to replace journal: %w", err)
+	}
+
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file = file
+	j.records = len(j.started) + len(pending)
+	return nil
+}
+
+// close closes the journal file
+func (j *journal) close() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.file.Close()
+}
@@ -0,0 +1,340 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+)
+
+var journalEpoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func testUpdate(eventID string, state model.ExecutionState, second int) *model.StatusUpdate {
+	return &model.StatusUpdate{
+		EventID:   eventID,
+		AgentID:   "agent-1",
+		State:     state,
+		Message:   string(state),
+		Timestamp: journalEpoch.Add(time.Duration(second) * time.Second),
+	}
+}
+
+// describe lists updates as eventID/state@second for comparisons
+func describe(updates []*model.StatusUpdate) []string {
+	names := make([]string, len(updates))
+	for i, update := range updates {
+		names[i] = update.EventID + "/" + string(update.State) + "@" + update.Timestamp.Format("05")
+	}
+	return names
+}
+
+func TestJournalReplay(t *testing.T) {
+	logger.InitLogger(false)
+
+	a1 := testUpdate("a", model.StateInProgress, 1)
+	b1 := testUpdate("b", model.StateInProgress, 2)
+	a2 := testUpdate("a", model.StateCompleted, 3)
+	b2 := testUpdate("b", model.StateFailed, 4)
+
+	tests := []struct {
+		name   string
+		writes func(j *journal)
+		tail   string // Raw bytes appended after the writes, e.g. a torn record
+		want   []*model.StatusUpdate
+	}{
+		{
+			name:   "empty",
+			writes: func(j *journal) {},
+			want:   []*model.StatusUpdate{},
+		},
+		{
+			name: "unacked updates in order",
+			writes: func(j *journal) {
+				for _, update := range []*model.StatusUpdate{a1, b1, a2} {
+					j.append(update)
+				}
+			},
+			want: []*model.StatusUpdate{a1, b1, a2},
+		},
+		{
+			name: "acks remove their update",
+			writes: func(j *journal) {
+				for _, update := range []*model.StatusUpdate{a1, b1, a2, b2} {
+					j.append(update)
+				}
+				j.ack(a1)
+				j.ack(b2)
+			},
+			want: []*model.StatusUpdate{b1, a2},
+		},
+		{
+			name: "ack of an unknown update is ignored",
+			writes: func(j *journal) {
+				j.append(a1)
+				j.ack(b1)
+			},
+			want: []*model.StatusUpdate{a1},
+		},
+		{
+			name: "torn last record",
+			writes: func(j *journal) {
+				j.append(a1)
+				j.append(b1)
+			},
+			tail: `{"update":{"event_id":"c","sta`,
+			want: []*model.StatusUpdate{a1, b1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "journal", "status.jsonl")
+			j, pending, err := openJournal(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pending) != 0 {
+				t.Fatalf("new journal has %d pending updates", len(pending))
+			}
+			tt.writes(j)
+			j.close()
+
+			if tt.tail != "" {
+				file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+				if err != nil {
+					t.Fatal(err)
+				}
+				file.WriteString(tt.tail)
+				file.Close()
+			}
+
+			reopened, pending, err := openJournal(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reopened.close()
+			if got, want := describe(pending), describe(tt.want); !slices.Equal(got, want) {
+				t.Fatalf("replayed %v, want %v", got, want)
+			}
+
+			// Opening rewrites the journal with only the start records and pending updates
+			if want := len(reopened.started) + len(tt.want); reopened.records != want {
+				t.Fatalf("records after reopen = %d, want %d", reopened.records, want)
+			}
+		})
+	}
+}
+
+func TestJournalCompact(t *testing.T) {
+	logger.InitLogger(false)
+
+	tests := []struct {
+		name        string
+		appended    int
+		acked       int
+		wantRecords int // Records in the file after compact, including the event's start
+	}{
+		{name: "progress confirmed while running", appended: 3, acked: 3, wantRecords: 7},
+		{name: "below the threshold", appended: 10, acked: 5, wantRecords: 16},
+		{name: "beyond the threshold", appended: journalCompactThreshold + 10, acked: journalCompactThreshold + 5, wantRecords: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "status.jsonl")
+			j, _, err := openJournal(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer j.close()
+
+			updates := make([]*model.StatusUpdate, tt.appended)
+			for i := range updates {
+				updates[i] = testUpdate("event", model.StateInProgress, i)
+				j.append(updates[i])
+			}
+			for _, update := range updates[:tt.acked] {
+				j.ack(update)
+			}
+			pending := updates[tt.acked:]
+			j.compact(pending)
+
+			if j.records != tt.wantRecords {
+				t.Fatalf("records = %d, want %d", j.records, tt.wantRecords)
+			}
+			replayed, _, _, err := readJournal(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := describe(replayed), describe(pending); !slices.Equal(got, want) {
+				t.Fatalf("replayed %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCollapseUpdates(t *testing.T) {
+	tests := []struct {
+		name    string
+		updates []*model.StatusUpdate
+		want    []string
+	}{
+		{
+			name: "latest update per event and state",
+			updates: []*model.StatusUpdate{
+				testUpdate("a", model.StateInProgress, 1),
+				testUpdate("a", model.StateInProgress, 2),
+				testUpdate("b", model.StateInProgress, 3),
+				testUpdate("a", model.StateInProgress, 4),
+				testUpdate("a", model.StateCompleted, 5),
+				testUpdate("b", model.StateInProgress, 6),
+			},
+			want: []string{"a/in_progress@04", "a/completed@05", "b/in_progress@06"},
+		},
+		{
+			name: "nothing to collapse",
+			updates: []*model.StatusUpdate{
+				testUpdate("a", model.StateInProgress, 1),
+				testUpdate("a", model.StateFailed, 2),
+				testUpdate("b", model.StateCancelled, 3),
+			},
+			want: []string{"a/in_progress@01", "a/failed@02", "b/cancelled@03"},
+		},
+		{
+			name:    "empty",
+			updates: nil,
+			want:    []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := describe(collapseUpdates(tt.updates)); !slices.Equal(got, tt.want) {
+				t.Fatalf("collapsed to %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportStatusCollapsesWhenFull(t *testing.T) {
+	logger.InitLogger(false)
+
+	j, _, err := openJournal(filepath.Join(t.TempDir(), "status.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.close()
+	a := &agent{journal: j}
+
+	// Disconnected: progress updates of one event pile up until the cap is hit
+	for i := range maxUnconfirmedUpdates + 1 {
+		a.reportStatus(testUpdate("a", model.StateInProgress, i%60))
+	}
+
+	_, pending, collapsed := a.healthReport()
+	if pending != 1 || collapsed != maxUnconfirmedUpdates {
+		t.Fatalf("pending = %d, collapsed = %d, want 1 and %d", pending, collapsed, maxUnconfirmedUpdates)
+	}
+	if j.records != 2 {
+		t.Fatalf("journal records = %d, want the start and 1 update after collapsing", j.records)
+	}
+}
+
+func TestJournalRestartFailsInterrupted(t *testing.T) {
+	logger.InitLogger(false)
+
+	tests := []struct {
+		name    string
+		updates []*model.StatusUpdate // Reported, then confirmed when acked is set
+		acked   bool
+		want    []string // Events reported failed after the restart
+	}{
+		{
+			name:    "confirmed progress",
+			updates: []*model.StatusUpdate{testUpdate("a", model.StateInProgress, 1), testUpdate("a", model.StateInProgress, 2)},
+			acked:   true,
+			want:    []string{"a"},
+		},
+		{
+			name:    "unconfirmed progress",
+			updates: []*model.StatusUpdate{testUpdate("a", model.StateInProgress, 1)},
+			want:    []string{"a"},
+		},
+		{
+			name:    "confirmed final status",
+			updates: []*model.StatusUpdate{testUpdate("a", model.StateInProgress, 1), testUpdate("a", model.StateCompleted, 2)},
+			acked:   true,
+			want:    []string{},
+		},
+		{
+			name:    "unconfirmed final status",
+			updates: []*model.StatusUpdate{testUpdate("a", model.StateInProgress, 1), testUpdate("a", model.StateCompleted, 2)},
+			want:    []string{},
+		},
+		{
+			name: "several events",
+			updates: []*model.StatusUpdate{
+				testUpdate("a", model.StateInProgress, 1),
+				testUpdate("b", model.StateInProgress, 2),
+				testUpdate("a", model.StateFailed, 3),
+				testUpdate("c", model.StateInProgress, 4),
+			},
+			acked: true,
+			want:  []string{"b", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "status.jsonl")
+			j, _, err := openJournal(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a := &agent{journal: j}
+			for _, update := range tt.updates {
+				a.reportStatus(update)
+				if tt.acked {
+					a.confirm(update)
+				}
+			}
+
+			// Crash: only what reached the file survives
+			j.file.Close()
+
+			reopened, pending, err := openJournal(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reopened.close()
+			restarted := &agent{journal: reopened, unconfirmed: pending}
+			restarted.failInterrupted()
+
+			failed := make([]string, 0)
+			for _, update := range restarted.unconfirmed[len(pending):] {
+				if update.State != model.StateFailed || update.Phase != model.PhaseFailed {
+					t.Fatalf("reported %s/%s for %s, want failed", update.State, update.Phase, update.EventID)
+				}
+				if update.AgentID != "agent-1" {
+					t.Fatalf("reported agent %q for %s, want agent-1", update.AgentID, update.EventID)
+				}
+				failed = append(failed, update.EventID)
+			}
+			if !slices.Equal(failed, tt.want) {
+				t.Fatalf("failed %v after restart, want %v", failed, tt.want)
+			}
+
+			// Once the failure is confirmed the event is no longer interrupted
+			for _, update := range slices.Clone(restarted.unconfirmed) {
+				restarted.confirm(update)
+			}
+			if started := reopened.startedEvents(); len(started) != 0 {
+				t.Fatalf("%d events still started after their final status was confirmed", len(started))
+			}
+		})
+	}
+}
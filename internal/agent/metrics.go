@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/executor"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+)
+
+// healthMetrics holds the inputs of the heartbeat health snapshot
+type healthMetrics struct {
+	mu               sync.Mutex
+	cluster          *executor.ClusterHealth
+	clusterCheckedAt time.Time
+	lastError        string
+	lastErrorAt      time.Time
+}
+
+// watchClusterHealth probes the cluster API server until ctx is cancelled. Probing
+// runs on its own interval so a slow API server never delays heartbeats.
+func (a *agent) watchClusterHealth(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, interval)
+		health := a.executor.CheckClusterHealth(probeCtx)
+		cancel()
+
+		if health.Error != "" {
+			logger.Warn("Cluster health check failed", "error", health.Error)
+		}
+
+		a.metrics.mu.Lock()
+		a.metrics.cluster = health
+		a.metrics.clusterCheckedAt = time.Now()
+		a.metrics.mu.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// recordError remembers the most recent error for the health snapshot
+func (a *agent) recordError(message string) {
+	a.metrics.mu.Lock()
+	defer a.metrics.mu.Unlock()
+	a.metrics.lastError = message
+	a.metrics.lastErrorAt = time.Now()
+}
+
+// healthSnapshot assembles the health reported in heartbeats
+func (a *agent) healthSnapshot() *model.AgentHealth {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	queued := len(a.queue)
+	health := &model.AgentHealth{
+		RunningEvents: max(a.inFlight()-queued, 0),
+		QueuedEvents:  queued,
+		MemoryBytes:   mem.Sys,
+		HeapBytes:     mem.HeapAlloc,
+		Goroutines:    runtime.NumGoroutine(),
+		CollectedAt:   time.Now(),
+	}
+
+	a.metrics.mu.Lock()
+	defer a.metrics.mu.Unlock()
+
+	if a.metrics.lastError != "" {
+		lastErrorAt := a.metrics.lastErrorAt
+		health.LastError = a.metrics.lastError
+		health.LastErrorAt = &lastErrorAt
+	}
+	if cluster := a.metrics.cluster; cluster != nil {
+		health.APIServerReachable = cluster.Reachable
+		health.APIServerLatency = cluster.Latency
+		health.APIServerError = cluster.Error
+		health.KubernetesVersion = cluster.Version
+		health.NodeCount = cluster.NodeCount
+		health.ReadyNodes = cluster.ReadyNodes
+		health.ClusterCheckedAt = a.metrics.clusterCheckedAt
+	}
+
+	return health
+}
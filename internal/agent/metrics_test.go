@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// readyNode returns a node whose Ready condition has the given status
+func readyNode(name string, ready corev1.ConditionStatus) corev1.Node {
+	n := node(name, "eu-west-1", "eu-west-1a", "")
+	n.Status.Conditions = []corev1.NodeCondition{
+		{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionTrue},
+		{Type: corev1.NodeReady, Status: ready},
+	}
+	return n
+}
+
+func TestHealthSnapshot(t *testing.T) {
+	logger.InitLogger(false)
+
+	cluster := &fakeCluster{version: "v1.31.0", nodes: []corev1.Node{
+		readyNode("node-1", corev1.ConditionTrue),
+		readyNode("node-2", corev1.ConditionFalse),
+		readyNode("node-3", corev1.ConditionTrue),
+	}}
+	a := &agent{executor: cluster.executor(t), queue: make(chan *queuedEvent, 4)}
+
+	// Before the first probe only the agent's own state is reported
+	health := a.healthSnapshot()
+	if health.APIServerReachable || !health.ClusterCheckedAt.IsZero() || health.LastErrorAt != nil {
+		t.Fatalf("snapshot before probing: %+v", health)
+	}
+
+	// Three events in flight, one of them still queued
+	for _, id := range []string{"event-1", "event-2", "event-3"} {
+		a.running.Store(id, context.CancelFunc(func() {}))
+	}
+	a.queue <- &queuedEvent{event: &model.Event{ID: "event-3"}}
+	a.recordError("event event-0 failed: boom")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	watched := make(chan struct{})
+	go func() {
+		a.watchClusterHealth(ctx, time.Hour)
+		close(watched)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for a.healthSnapshot().ClusterCheckedAt.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("cluster health was never probed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-watched
+
+	health = a.healthSnapshot()
+	if health.RunningEvents != 2 || health.QueuedEvents != 1 {
+		t.Errorf("%d running and %d queued events, want 2 and 1", health.RunningEvents, health.QueuedEvents)
+	}
+	if health.LastError != "event event-0 failed: boom" || health.LastErrorAt == nil {
+		t.Errorf("last error %q at %v", health.LastError, health.LastErrorAt)
+	}
+	if !health.APIServerReachable || health.APIServerError != "" || health.KubernetesVersion != "v1.31.0" {
+		t.Errorf("API server reachable %v, error %q, version %q", health.APIServerReachable, health.APIServerError, health.KubernetesVersion)
+	}
+	if health.NodeCount != 3 || health.ReadyNodes != 2 || !health.ClusterHealthy() {
+		t.Errorf("%d of %d nodes ready, want 2 of 3", health.ReadyNodes, health.NodeCount)
+	}
+	if health.Goroutines == 0 || health.MemoryBytes == 0 || health.CollectedAt.IsZero() {
+		t.Errorf("process metrics missing: %+v", health)
+	}
+
+	// A recorded connection error replaces the previous one
+	a.setState(ConnectionStateDisconnected, errors.New("connection reset"))
+	if health := a.healthSnapshot(); health.LastError != "connection reset" {
+		t.Errorf("last error %q, want the connection error", health.LastError)
+	}
+}
+
+func TestCheckClusterHealth(t *testing.T) {
+	logger.InitLogger(false)
+
+	tests := []struct {
+		name        string
+		cluster     fakeCluster
+		wantReady   int
+		wantError   string
+		wantHealthy bool
+	}{
+		{
+			name:        "ready nodes",
+			cluster:     fakeCluster{version: "v1.31.0", nodes: []corev1.Node{readyNode("node-1", corev1.ConditionTrue)}},
+			wantReady:   1,
+			wantHealthy: true,
+		},
+		{
+			name:      "no ready nodes",
+			cluster:   fakeCluster{version: "v1.31.0", nodes: []corev1.Node{readyNode("node-1", corev1.ConditionUnknown)}},
+			wantReady: 0,
+		},
+		{
+			name:        "no nodes visible",
+			cluster:     fakeCluster{version: "v1.31.0"},
+			wantHealthy: true,
+		},
+		{name: "unreachable API server", cluster: fakeCluster{fail: true}, wantError: "failed to reach API server"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := tt.cluster.executor(t).CheckClusterHealth(t.Context())
+			if tt.wantError != "" {
+				if cluster.Reachable || !strings.Contains(cluster.Error, tt.wantError) {
+					t.Fatalf("reachable %v, error %q; want %q", cluster.Reachable, cluster.Error, tt.wantError)
+				}
+			} else if !cluster.Reachable || cluster.Error != "" || cluster.ReadyNodes != tt.wantReady {
+				t.Fatalf("reachable %v, error %q, %d ready nodes; want %d", cluster.Reachable, cluster.Error, cluster.ReadyNodes, tt.wantReady)
+			}
+
+			health := &model.AgentHealth{
+				APIServerReachable: cluster.Reachable,
+				NodeCount:          cluster.NodeCount,
+				ReadyNodes:         cluster.ReadyNodes,
+			}
+			if health.ClusterHealthy() != tt.wantHealthy {
+				t.Fatalf("ClusterHealthy() = %v, want %v", health.ClusterHealthy(), tt.wantHealthy)
+			}
+		})
+	}
+}
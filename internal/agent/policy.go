@@ -0,0 +1,295 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/executor"
+	"gopkg.in/yaml.v3"
+)
+
+// Operations an event may perform, as referenced by the agent policy
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+	OperationScript = "script"
+)
+
+var validOperations = map[string]bool{
+	OperationCreate: true,
+	OperationUpdate: true,
+	OperationDelete: true,
+	OperationScript: true,
+}
+
+// Policy is the agent-local execution guardrail. It is enforced by the agent
+// regardless of what the control plane sends, so cluster owners keep a veto.
+//
+// Namespace entries are glob patterns ("team-*"). Kind entries are glob patterns
+// over "Kind.group" ("Deployment.apps", "*.rbac.authorization.k8s.io"); core
+// resources have no group suffix ("ConfigMap"). Empty allowlists allow everything,
+// and deny entries always take precedence.
+type Policy struct {
+	AllowedNamespaces []string `yaml:"allowedNamespaces"`
+	DeniedNamespaces  []string `yaml:"deniedNamespaces"`
+	AllowedKinds      []string `yaml:"allowedKinds"`
+	DeniedKinds       []string `yaml:"deniedKinds"`
+	AllowedOperations []string `yaml:"allowedOperations"`
+
+	// Identities events may impersonate. Impersonation is refused unless allowed here.
+	AllowedImpersonationUsers           []string `yaml:"allowedImpersonationUsers"`
+	AllowedImpersonationGroups          []string `yaml:"allowedImpersonationGroups"`
+	AllowedImpersonationServiceAccounts []string `yaml:"allowedImpersonationServiceAccounts"` // namespace/name
+
+	// Images (glob patterns over the full reference) the agent may upgrade itself to.
+	// Self-upgrade is refused unless allowed here.
+	AllowedUpgradeImages []string `yaml:"allowedUpgradeImages"`
+}
+
+// PolicyViolation describes why a single resource was rejected by the policy
+type PolicyViolation struct {
+	Kind      string
+	Name      string
+	Namespace string
+	Operation string
+	Reason    string
+}
+
+// LoadPolicy builds the effective policy from an optional file and flag overrides.
+// Non-empty flag values replace the corresponding file values.
+func LoadPolicy(file string, overrides Policy) (*Policy, error) {
+	policy := &Policy{}
+
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read policy file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, policy); err != nil {
+			return nil, fmt.Errorf("failed to parse policy file: %w", err)
+		}
+	}
+
+	if len(overrides.AllowedNamespaces) > 0 {
+		policy.AllowedNamespaces = overrides.AllowedNamespaces
+	}
+	if len(overrides.DeniedNamespaces) > 0 {
+		policy.DeniedNamespaces = overrides.DeniedNamespaces
+	}
+	if len(overrides.AllowedKinds) > 0 {
+		policy.AllowedKinds = overrides.AllowedKinds
+	}
+	if len(overrides.DeniedKinds) > 0 {
+		policy.DeniedKinds = overrides.DeniedKinds
+	}
+	if len(overrides.AllowedOperations) > 0 {
+		policy.AllowedOperations = overrides.AllowedOperations
+	}
+	if len(overrides.AllowedImpersonationUsers) > 0 {
+		policy.AllowedImpersonationUsers = overrides.AllowedImpersonationUsers
+	}
+	if len(overrides.AllowedImpersonationGroups) > 0 {
+		policy.AllowedImpersonationGroups = overrides.AllowedImpersonationGroups
+	}
+	if len(overrides.AllowedImpersonationServiceAccounts) > 0 {
+		policy.AllowedImpersonationServiceAccounts = overrides.AllowedImpersonationServiceAccounts
+	}
+	if len(overrides.AllowedUpgradeImages) > 0 {
+		policy.AllowedUpgradeImages = overrides.AllowedUpgradeImages
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// Validate checks that the policy patterns and operations are well-formed
+func (p *Policy) Validate() error {
+	for _, op := range p.AllowedOperations {
+		if !validOperations[strings.ToLower(op)] {
+			return fmt.Errorf("invalid policy operation %q (must be create, update, delete or script)", op)
+		}
+	}
+
+	patterns := [][]string{p.AllowedNamespaces, p.DeniedNamespaces, p.AllowedKinds, p.DeniedKinds,
+		p.AllowedImpersonationUsers, p.AllowedImpersonationGroups, p.AllowedImpersonationServiceAccounts,
+		p.AllowedUpgradeImages}
+	for _, list := range patterns {
+		for _, pattern := range list {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid policy pattern %q: %w", pattern, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// CheckEvent returns a violation for every resource in the event the policy forbids
+func (p *Policy) CheckEvent(event *model.Event, targets []executor.ManifestTarget) []PolicyViolation {
+	violations := make([]PolicyViolation, 0)
+
+	if event.Impersonate != nil {
+		if reason := p.checkImpersonation(event.Impersonate); reason != "" {
+			violations = append(violations, PolicyViolation{
+				Kind:   "Impersonation",
+				Name:   event.Impersonate.UserName(),
+				Reason: reason,
+			})
+			return violations
+		}
+	}
+
+	if event.Type == model.EventTypeAgentUpgrade {
+		if image := event.Payload.AgentUpgrade.Image; !matchAny(p.AllowedUpgradeImages, image) {
+			violations = append(violations, PolicyViolation{
+				Kind:   "AgentUpgrade",
+				Name:   image,
+				Reason: fmt.Sprintf("upgrading to image %s is not allowed by agent policy", image),
+			})
+		}
+		return violations
+	}
+
+	if event.Type == model.EventTypeScript {
+		if !p.operationAllowed(OperationScript) {
+			violations = append(violations, PolicyViolation{
+				Kind:      "Script",
+				Operation: OperationScript,
+				Reason:    "operation script is not allowed by agent policy",
+			})
+		}
+		return violations
+	}
+
+	for _, target := range targets {
+		operation := OperationCreate
+		switch {
+		case target.Delete:
+			operation = OperationDelete
+		case target.Exists:
+			operation = OperationUpdate
+		}
+
+		if reason := p.checkTarget(target, operation); reason != "" {
+			violations = append(violations, PolicyViolation{
+				Kind:      target.Kind,
+				Name:      target.Name,
+				Namespace: target.Namespace,
+				Operation: operation,
+				Reason:    reason,
+			})
+		}
+	}
+
+	return violations
+}
+
+// checkTarget returns the reason a resource operation is denied, or "" if allowed
+func (p *Policy) checkTarget(target executor.ManifestTarget, operation string) string {
+	if !p.operationAllowed(operation) {
+		return fmt.Sprintf("operation %s is not allowed by agent policy", operation)
+	}
+
+	kind := target.Kind
+	if target.Group != "" {
+		kind = target.Kind + "." + target.Group
+	}
+	if matchAny(p.DeniedKinds, kind) {
+		return fmt.Sprintf("kind %s is denied by agent policy", kind)
+	}
+	if len(p.AllowedKinds) > 0 && !matchAny(p.AllowedKinds, kind) {
+		return fmt.Sprintf("kind %s is not in the agent policy allowlist", kind)
+	}
+
+	// A Namespace object is governed by its own name
+	namespace := target.Namespace
+	if target.Group == "" && target.Kind == "Namespace" {
+		namespace = target.Name
+	}
+	if namespace == "" {
+		return ""
+	}
+	if matchAny(p.DeniedNamespaces, namespace) {
+		return fmt.Sprintf("namespace %s is denied by agent policy", namespace)
+	}
+	if len(p.AllowedNamespaces) > 0 && !matchAny(p.AllowedNamespaces, namespace) {
+		return fmt.Sprintf("namespace %s is not in the agent policy allowlist", namespace)
+	}
+
+	return ""
+}
+
+// checkImpersonation returns the reason an impersonation target is denied, or "" if allowed
+func (p *Policy) checkImpersonation(target *model.ImpersonationTarget) string {
+	if target.ServiceAccount != "" {
+		if !matchAny(p.AllowedImpersonationServiceAccounts, target.ServiceAccount) {
+			return fmt.Sprintf("impersonating service account %s is not allowed by agent policy", target.ServiceAccount)
+		}
+	} else if !matchAny(p.AllowedImpersonationUsers, target.User) {
+		return fmt.Sprintf("impersonating user %s is not allowed by agent policy", target.User)
+	}
+
+	for _, group := range target.Groups {
+		if !matchAny(p.AllowedImpersonationGroups, group) {
+			return fmt.Sprintf("impersonating group %s is not allowed by agent policy", group)
+		}
+	}
+
+	return ""
+}
+
+// operationAllowed reports whether the operation is permitted
+func (p *Policy) operationAllowed(operation string) bool {
+	if len(p.AllowedOperations) == 0 {
+		return true
+	}
+	for _, op := range p.AllowedOperations {
+		if strings.EqualFold(op, operation) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny reports whether value matches any of the glob patterns (case-insensitive)
+func matchAny(patterns []string, value string) bool {
+	value = strings.ToLower(value)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// violationResult converts policy violations into a failed event result
+func violationResult(violations []PolicyViolation) *model.EventResult {
+	statuses := make([]model.ResourceStatus, 0, len(violations))
+	messages := make([]string, 0, len(violations))
+
+	for _, v := range violations {
+		statuses = append(statuses, model.ResourceStatus{
+			Kind:      v.Kind,
+			Name:      v.Name,
+			Namespace: v.Namespace,
+			Status:    "denied",
+			Message:   v.Reason,
+		})
+		messages = append(messages, v.Reason)
+	}
+
+	return &model.EventResult{
+		Success:        false,
+		ResourceStatus: statuses,
+		ErrorMessage:   "agent policy violation: " + strings.Join(messages, "; "),
+		CompletedAt:    time.Now(),
+	}
+}
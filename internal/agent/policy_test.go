@@ -0,0 +1,245 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/executor"
+)
+
+func TestCheckTarget(t *testing.T) {
+	configMap := executor.ManifestTarget{Version: "v1", Kind: "ConfigMap", Name: "settings", Namespace: "team-a", Namespaced: true}
+	deployment := executor.ManifestTarget{Group: "apps", Version: "v1", Kind: "Deployment", Name: "web", Namespace: "team-a", Namespaced: true}
+	role := executor.ManifestTarget{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole", Name: "admin"}
+	namespace := executor.ManifestTarget{Version: "v1", Kind: "Namespace", Name: "kube-system"}
+
+	tests := []struct {
+		name      string
+		policy    Policy
+		target    executor.ManifestTarget
+		operation string
+		wantDeny  string // Substring of the denial reason, empty when allowed
+	}{
+		{name: "empty policy allows everything", target: deployment, operation: OperationDelete},
+		{
+			name:      "operation not allowed",
+			policy:    Policy{AllowedOperations: []string{OperationCreate, OperationUpdate}},
+			target:    configMap,
+			operation: OperationDelete,
+			wantDeny:  "operation delete",
+		},
+		{
+			name:      "operation allowed case-insensitively",
+			policy:    Policy{AllowedOperations: []string{"DELETE"}},
+			target:    configMap,
+			operation: OperationDelete,
+		},
+		{
+			name:      "operation checked before kind",
+			policy:    Policy{AllowedOperations: []string{OperationCreate}, DeniedKinds: []string{"ConfigMap"}},
+			target:    configMap,
+			operation: OperationUpdate,
+			wantDeny:  "operation update",
+		},
+		{
+			name:      "denied kind with group",
+			policy:    Policy{DeniedKinds: []string{"*.rbac.authorization.k8s.io"}},
+			target:    role,
+			operation: OperationCreate,
+			wantDeny:  "kind ClusterRole.rbac.authorization.k8s.io is denied",
+		},
+		{
+			name:      "core kind has no group suffix",
+			policy:    Policy{AllowedKinds: []string{"ConfigMap"}},
+			target:    configMap,
+			operation: OperationCreate,
+		},
+		{
+			name:      "kind outside allowlist",
+			policy:    Policy{AllowedKinds: []string{"ConfigMap"}},
+			target:    deployment,
+			operation: OperationCreate,
+			wantDeny:  "kind Deployment.apps is not in the agent policy allowlist",
+		},
+		{
+			name:      "kind deny takes precedence over allow",
+			policy:    Policy{AllowedKinds: []string{"*"}, DeniedKinds: []string{"Deployment.apps"}},
+			target:    deployment,
+			operation: OperationCreate,
+			wantDeny:  "kind Deployment.apps is denied",
+		},
+		{
+			name:      "namespace allowlist glob",
+			policy:    Policy{AllowedNamespaces: []string{"team-*"}},
+			target:    deployment,
+			operation: OperationUpdate,
+		},
+		{
+			name:      "namespace outside allowlist",
+			policy:    Policy{AllowedNamespaces: []string{"team-b"}},
+			target:    deployment,
+			operation: OperationUpdate,
+			wantDeny:  "namespace team-a is not in the agent policy allowlist",
+		},
+		{
+			name:      "namespace deny takes precedence over allow",
+			policy:    Policy{AllowedNamespaces: []string{"team-*"}, DeniedNamespaces: []string{"team-a"}},
+			target:    deployment,
+			operation: OperationUpdate,
+			wantDeny:  "namespace team-a is denied",
+		},
+		{
+			name:      "namespace object governed by its name",
+			policy:    Policy{DeniedNamespaces: []string{"kube-*"}},
+			target:    namespace,
+			operation: OperationDelete,
+			wantDeny:  "namespace kube-system is denied",
+		},
+		{
+			name:      "cluster-scoped resource skips namespace rules",
+			policy:    Policy{AllowedNamespaces: []string{"team-b"}},
+			target:    role,
+			operation: OperationCreate,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := tt.policy.checkTarget(tt.target, tt.operation)
+			if tt.wantDeny == "" && reason != "" {
+				t.Fatalf("checkTarget denied: %s", reason)
+			}
+			if !strings.Contains(reason, tt.wantDeny) || tt.wantDeny != "" && reason == "" {
+				t.Fatalf("checkTarget = %q, want a denial containing %q", reason, tt.wantDeny)
+			}
+		})
+	}
+}
+
+func TestCheckEvent(t *testing.T) {
+	existing := executor.ManifestTarget{Version: "v1", Kind: "ConfigMap", Name: "old", Namespace: "team-a", Namespaced: true, Exists: true}
+	missing := executor.ManifestTarget{Version: "v1", Kind: "ConfigMap", Name: "new", Namespace: "team-a", Namespaced: true}
+	deleted := executor.ManifestTarget{Version: "v1", Kind: "ConfigMap", Name: "old", Namespace: "team-a", Namespaced: true, Exists: true, Delete: true}
+
+	k8sEvent := func(impersonate *model.ImpersonationTarget) *model.Event {
+		return &model.Event{ID: "event-1", Type: model.EventTypeK8sResource, Impersonate: impersonate}
+	}
+
+	tests := []struct {
+		name           string
+		policy         Policy
+		event          *model.Event
+		targets        []executor.ManifestTarget
+		wantOperations []string // Operations of the violations, in order
+		wantKinds      []string
+	}{
+		{
+			name:    "allowed",
+			event:   k8sEvent(nil),
+			targets: []executor.ManifestTarget{existing, missing, deleted},
+		},
+		{
+			name:           "operation follows existence",
+			policy:         Policy{AllowedOperations: []string{OperationCreate}},
+			event:          k8sEvent(nil),
+			targets:        []executor.ManifestTarget{existing, missing},
+			wantOperations: []string{OperationUpdate},
+			wantKinds:      []string{"ConfigMap"},
+		},
+		{
+			name:           "delete is its own operation",
+			policy:         Policy{AllowedOperations: []string{OperationCreate, OperationUpdate}},
+			event:          k8sEvent(nil),
+			targets:        []executor.ManifestTarget{existing, deleted},
+			wantOperations: []string{OperationDelete},
+			wantKinds:      []string{"ConfigMap"},
+		},
+		{
+			name:           "every denied target reported",
+			policy:         Policy{DeniedNamespaces: []string{"team-a"}},
+			event:          k8sEvent(nil),
+			targets:        []executor.ManifestTarget{existing, missing},
+			wantOperations: []string{OperationUpdate, OperationCreate},
+			wantKinds:      []string{"ConfigMap", "ConfigMap"},
+		},
+		{
+			name:           "script operation",
+			policy:         Policy{AllowedOperations: []string{OperationCreate}},
+			event:          &model.Event{ID: "event-1", Type: model.EventTypeScript},
+			wantOperations: []string{OperationScript},
+			wantKinds:      []string{"Script"},
+		},
+		{
+			name:           "impersonation denied by default",
+			event:          k8sEvent(&model.ImpersonationTarget{User: "alice"}),
+			targets:        []executor.ManifestTarget{existing},
+			wantOperations: []string{""},
+			wantKinds:      []string{"Impersonation"},
+		},
+		{
+			name:    "allowed user",
+			policy:  Policy{AllowedImpersonationUsers: []string{"alice"}},
+			event:   k8sEvent(&model.ImpersonationTarget{User: "alice"}),
+			targets: []executor.ManifestTarget{existing},
+		},
+		{
+			name:           "impersonation checked before targets",
+			policy:         Policy{DeniedNamespaces: []string{"team-a"}},
+			event:          k8sEvent(&model.ImpersonationTarget{User: "alice"}),
+			targets:        []executor.ManifestTarget{existing},
+			wantOperations: []string{""},
+			wantKinds:      []string{"Impersonation"},
+		},
+		{
+			name:           "group outside allowlist",
+			policy:         Policy{AllowedImpersonationUsers: []string{"alice"}, AllowedImpersonationGroups: []string{"dev"}},
+			event:          k8sEvent(&model.ImpersonationTarget{User: "alice", Groups: []string{"dev", "admin"}}),
+			targets:        []executor.ManifestTarget{existing},
+			wantOperations: []string{""},
+			wantKinds:      []string{"Impersonation"},
+		},
+		{
+			name:    "service account matched as namespace/name",
+			policy:  Policy{AllowedImpersonationServiceAccounts: []string{"team-a/*"}},
+			event:   k8sEvent(&model.ImpersonationTarget{ServiceAccount: "team-a/deployer"}),
+			targets: []executor.ManifestTarget{existing},
+		},
+		{
+			name:           "service account not matched by user allowlist",
+			policy:         Policy{AllowedImpersonationUsers: []string{"*"}},
+			event:          k8sEvent(&model.ImpersonationTarget{ServiceAccount: "team-a/deployer"}),
+			targets:        []executor.ManifestTarget{existing},
+			wantOperations: []string{""},
+			wantKinds:      []string{"Impersonation"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := tt.policy.CheckEvent(tt.event, tt.targets)
+			if len(violations) != len(tt.wantKinds) {
+				t.Fatalf("got %d violations %+v, want %d", len(violations), violations, len(tt.wantKinds))
+			}
+			for i, v := range violations {
+				if v.Kind != tt.wantKinds[i] || v.Operation != tt.wantOperations[i] {
+					t.Fatalf("violation %d = %s/%s, want %s/%s", i, v.Kind, v.Operation, tt.wantKinds[i], tt.wantOperations[i])
+				}
+				if v.Reason == "" {
+					t.Fatalf("violation %d has no reason", i)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicyValidateOperations(t *testing.T) {
+	for _, op := range []string{OperationCreate, OperationUpdate, OperationDelete, OperationScript, "Delete"} {
+		if err := (&Policy{AllowedOperations: []string{op}}).Validate(); err != nil {
+			t.Fatalf("Validate(%s) = %v", op, err)
+		}
+	}
+	if err := (&Policy{AllowedOperations: []string{"patch"}}).Validate(); err == nil {
+		t.Fatal("Validate accepted unknown operation patch")
+	}
+}
@@ -0,0 +1,225 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+)
+
+const (
+	// outboundQueueSize is the number of messages buffered for the writer
+	outboundQueueSize = 256
+
+	// writeTimeout bounds each write so a stalled control plane fails the session
+	writeTimeout = 10 * time.Second
+)
+
+var errSessionClosed = errors.New("session closed")
+
+// session is an established, registered connection to the control plane.
+// A new session is created for every successful (re)connection.
+type session struct {
+	conn        *websocket.Conn
+	codec       *model.Codec
+	done        chan struct{} // Closed when the session ends
+	readTimeout time.Duration // Silence after which the connection is considered dead
+
+	// Gorilla websocket allows a single concurrent writer, so every message is
+	// queued for writeLoop. encodeMu keeps queue order equal to sequence order.
+	encodeMu sync.Mutex
+	outbound chan []model.Frame
+
+	mu       sync.Mutex
+	awaiting map[uint64]*model.StatusUpdate // seq -> status update awaiting a CP ack
+}
+
+// register performs the registration handshake and negotiates the protocol version
+// and transport settings
+func register(conn *websocket.Conn, registration *model.RegisterMessage) (*session, error) {
+	// Registration is always offered in the current protocol as plain JSON
+	codec := model.NewCodec(model.CodecConfig{Version: model.ProtocolVersion})
+	frames, _, err := codec.Encode(model.MessageTypeRegister, registration)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode registration: %w", err)
+	}
+
+	if err := writeFrames(conn, frames); err != nil {
+		return nil, fmt.Errorf("failed to send registration: %w", err)
+	}
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registration response: %w", err)
+	}
+
+	env, err := model.DecodeEnvelope(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode registration response: %w", err)
+	}
+
+	switch env.Type {
+	case model.MessageTypeRegistered:
+		var registered model.RegisteredMessage
+		if err := env.DecodePayload(&registered); err != nil {
+			return nil, err
+		}
+		return &session{
+			conn: conn,
+			codec: codec.WithConfig(model.CodecConfig{
+				Version:      registered.ProtocolVersion,
+				Encoding:     registered.Encoding,
+				Compression:  registered.Compression,
+				MaxFrameSize: registered.MaxFrameSize,
+			}),
+			done:     make(chan struct{}),
+			outbound: make(chan []model.Frame, outboundQueueSize),
+			awaiting: make(map[uint64]*model.StatusUpdate),
+		}, nil
+
+	case model.MessageTypeError:
+		var errMsg model.ErrorMessage
+		if err := env.DecodePayload(&errMsg); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("registration failed: %s (%s)", errMsg.Message, errMsg.Code)
+
+	default:
+		return nil, fmt.Errorf("registration failed: unexpected %q response", env.Type)
+	}
+}
+
+// send encodes a message and queues it for the writer, returning its sequence number.
+// It blocks while the outbound queue is full.
+func (s *session) send(msgType model.MessageType, payload interface{}) (uint64, error) {
+	s.encodeMu.Lock()
+	defer s.encodeMu.Unlock()
+
+	frames, seq, err := s.codec.Encode(msgType, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case s.outbound <- frames:
+		return seq, nil
+	case <-s.done:
+		return 0, errSessionClosed
+	}
+}
+
+// writeLoop is the only goroutine writing messages to the connection. After a
+// write fails it keeps discarding queued messages so senders never block on a
+// dead session.
+func (s *session) writeLoop() {
+	failed := false
+	for {
+		select {
+		case frames := <-s.outbound:
+			if failed {
+				continue
+			}
+			s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := writeFrames(s.conn, frames); err != nil {
+				logger.Error("Error writing to Control Plane", "error", err)
+				s.conn.Close() // Unblock the read loop so the supervisor reconnects
+				failed = true
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// keepAlive makes the read loop fail after timeout without a message or a pong,
+// so a half-open connection is detected and replaced
+func (s *session) keepAlive(timeout time.Duration) {
+	s.readTimeout = timeout
+	s.extendReadDeadline()
+	s.conn.SetPongHandler(func(string) error {
+		s.extendReadDeadline()
+		return nil
+	})
+}
+
+// extendReadDeadline pushes the read deadline out by the keepalive timeout
+func (s *session) extendReadDeadline() {
+	if s.readTimeout > 0 {
+		s.conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+	}
+}
+
+// ping sends a ping; the control plane's pong extends the read deadline
+func (s *session) ping() error {
+	// Control frames may be written concurrently with the writer
+	return s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+}
+
+// acknowledges reports whether the control plane acks messages on this session
+func (s *session) acknowledges() bool {
+	return s.codec.Version() > model.ProtocolVersionLegacy
+}
+
+// awaitAck records a sent status update until the control plane acks it
+func (s *session) awaitAck(seq uint64, update *model.StatusUpdate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.awaiting[seq] = update
+}
+
+// acked returns the status update acknowledged by seq, if any
+func (s *session) acked(seq uint64) (*model.StatusUpdate, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	update, ok := s.awaiting[seq]
+	delete(s.awaiting, seq)
+	return update, ok
+}
+
+// close ends the session, sending a close frame if requested
+func (s *session) close(graceful bool) {
+	if graceful {
+		// Control frames may be written concurrently with the writer
+		s.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	}
+	s.conn.Close()
+}
+
+// writeFrames writes encoded frames to the connection
+func writeFrames(conn *websocket.Conn, frames []model.Frame) error {
+	for _, frame := range frames {
+		messageType := websocket.TextMessage
+		if frame.Binary {
+			messageType = websocket.BinaryMessage
+		}
+		conn.EnableWriteCompression(frame.Compress)
+		if err := conn.WriteMessage(messageType, frame.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toEncodings converts configured encoding names
+func toEncodings(names []string) []model.Encoding {
+	encodings := make([]model.Encoding, 0, len(names))
+	for _, name := range names {
+		encodings = append(encodings, model.Encoding(name))
+	}
+	return encodings
+}
+
+// toCompressions converts configured compression names
+func toCompressions(names []string) []model.Compression {
+	compressions := make([]model.Compression, 0, len(names))
+	for _, name := range names {
+		compressions = append(compressions, model.Compression(name))
+	}
+	return compressions
+}
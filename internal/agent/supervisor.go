@@ -0,0 +1,272 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+)
+
+// ConnectionState is the state of the agent's connection to the control plane
+type ConnectionState string
+
+const (
+	ConnectionStateConnecting   ConnectionState = "connecting"
+	ConnectionStateConnected    ConnectionState = "connected"
+	ConnectionStateDisconnected ConnectionState = "disconnected" // Waiting to retry
+	ConnectionStateStopped      ConnectionState = "stopped"
+)
+
+// backoff computes jittered exponential reconnect delays
+type backoff struct {
+	min      time.Duration
+	max      time.Duration
+	attempts int
+}
+
+// next returns the delay before the next attempt. Half the delay is fixed and
+// half is random so agents disconnected together do not reconnect in lockstep.
+func (b *backoff) next() time.Duration {
+	delay := b.max
+	if b.attempts < 32 {
+		delay = min(b.min<<b.attempts, b.max)
+	}
+	b.attempts++
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+func (b *backoff) reset() {
+	b.attempts = 0
+}
+
+// supervise keeps the agent connected to the control plane until ctx is cancelled
+func (a *agent) supervise(ctx context.Context) {
+	retry := &backoff{min: a.cfg.ReconnectMinBackoff, max: a.cfg.ReconnectMaxBackoff}
+	defer a.setState(ConnectionStateStopped, nil)
+
+	for {
+		a.setState(ConnectionStateConnecting, nil)
+
+		sess, err := a.connect(ctx)
+		if err == nil {
+			retry.reset()
+			err = a.serve(sess)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		delay := retry.next()
+		a.setState(ConnectionStateDisconnected, err)
+		logger.Warn("Disconnected from Control Plane, reconnecting", "error", err, "retry_in", delay, "attempt", retry.attempts)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// connect dials the control plane and registers the agent
+func (a *agent) connect(ctx context.Context) (*session, error) {
+	logger.Info("Connecting to Control Plane", "url", a.cfg.CPURL)
+
+	dialer := *websocket.DefaultDialer
+	dialer.EnableCompression = slices.Contains(a.cfg.Compressions, string(model.CompressionDeflate))
+	conn, _, err := dialer.DialContext(ctx, a.cfg.CPURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Control Plane: %w", err)
+	}
+
+	// Bound the handshake so a stalled control plane does not block reconnection
+	conn.SetReadDeadline(time.Now().Add(a.cfg.HeartbeatInterval * 3))
+	sess, err := register(conn, a.registration)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	sess.keepAlive(a.cfg.HeartbeatInterval * 3)
+
+	negotiated := sess.codec.Config()
+	logger.Info("Agent registered successfully", "protocol_version", negotiated.Version,
+		"encoding", negotiated.Encoding, "compression", negotiated.Compression, "max_frame_size", negotiated.MaxFrameSize)
+
+	return sess, nil
+}
+
+// serve runs a registered session until the connection fails
+func (a *agent) serve(sess *session) error {
+	defer close(sess.done)
+	go sess.writeLoop()
+
+	resent, err := a.attach(sess)
+	if err != nil {
+		a.detach(sess)
+		sess.close(false)
+		return err
+	}
+	if resent > 0 {
+		logger.Info("Resent pending status updates", "count", resent)
+	}
+	if a.draining.Load() {
+		a.notifyDraining(sess) // Drain began while disconnected or before this reconnect
+	}
+	a.setState(ConnectionStateConnected, nil)
+
+	go a.sendHeartbeats(sess)
+
+	err = a.readLoop(sess)
+	a.detach(sess)
+	sess.close(false)
+	return err
+}
+
+// attach makes sess the current session after resending, in order, every status
+// update the control plane has not confirmed. Holding a.mu keeps newer updates from
+// overtaking the resent ones.
+func (a *agent) attach(sess *session) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	updates := slices.Clone(a.unconfirmed)
+	for _, update := range updates {
+		seq, err := sess.send(model.MessageTypeStatusUpdate, update)
+		if err != nil {
+			return 0, fmt.Errorf("failed to resend status update: %w", err)
+		}
+		a.trackLocked(sess, seq, update)
+	}
+
+	a.session = sess
+	return len(updates), nil
+}
+
+// detach clears sess as the current session
+func (a *agent) detach(sess *session) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.session == sess {
+		a.session = nil
+	}
+}
+
+// shutdown closes the current session with a normal closure
+func (a *agent) shutdown() {
+	a.mu.Lock()
+	sess := a.session
+	a.mu.Unlock()
+
+	if sess != nil {
+		sess.close(true)
+	}
+}
+
+// readLoop dispatches messages received from the control plane
+func (a *agent) readLoop(sess *session) error {
+	for {
+		messageType, data, err := sess.conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("failed to read from Control Plane: %w", err)
+		}
+		sess.extendReadDeadline()
+
+		env, err := sess.codec.Decode(messageType == websocket.BinaryMessage, data)
+		if err != nil {
+			logger.Warn("Failed to decode message", "error", err)
+			continue
+		}
+		if env == nil {
+			continue // Waiting for the remaining chunks
+		}
+
+		switch env.Type {
+		case model.MessageTypeEvent:
+			var event model.Event
+			if err := env.DecodePayload(&event); err != nil {
+				logger.Error("Failed to decode event", "error", err)
+				sess.send(model.MessageTypeError, &model.ErrorMessage{Code: "INVALID_EVENT", Message: err.Error(), Seq: env.Seq})
+				continue
+			}
+			if _, err := sess.send(model.MessageTypeAck, &model.AckMessage{Seq: env.Seq, EventID: event.ID}); err != nil {
+				logger.Warn("Failed to acknowledge event", "event_id", event.ID, "error", err)
+			}
+			if a.draining.Load() {
+				logger.Warn("Agent draining, rejecting event", "event_id", event.ID)
+				a.sendStatusUpdate(&event, model.StateFailed, model.PhaseFailed, "Agent is draining and accepts no new events", nil, nil)
+				continue
+			}
+			if !a.dispatch(&event) {
+				logger.Warn("Event queue full, rejecting event", "event_id", event.ID)
+				a.sendStatusUpdate(&event, model.StateFailed, model.PhaseFailed, "Agent event queue is full", nil, nil)
+			}
+
+		case model.MessageTypeCancel:
+			var cancel model.CancelMessage
+			if err := env.DecodePayload(&cancel); err != nil {
+				logger.Error("Failed to decode cancel", "error", err)
+				continue
+			}
+			a.cancelEvent(sess, &cancel, env.Seq)
+
+		case model.MessageTypeAck:
+			var ack model.AckMessage
+			if err := env.DecodePayload(&ack); err != nil {
+				logger.Warn("Failed to decode ack", "error", err)
+				continue
+			}
+			if update, ok := sess.acked(ack.Seq); ok {
+				a.confirm(update)
+				logger.Debug("Control Plane confirmed status update", "event_id", update.EventID, "state", update.State)
+			}
+
+		case model.MessageTypeError:
+			var errMsg model.ErrorMessage
+			if err := env.DecodePayload(&errMsg); err == nil {
+				logger.Warn("Control Plane reported error", "code", errMsg.Code, "message", errMsg.Message, "seq", errMsg.Seq)
+			}
+
+		default:
+			logger.Warn("Unknown message type", "type", env.Type)
+		}
+	}
+}
+
+// sendHeartbeats sends heartbeats and keepalive pings until the session ends
+func (a *agent) sendHeartbeats(sess *session) {
+	ticker := time.NewTicker(a.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := sess.ping(); err != nil {
+				logger.Error("Failed to ping Control Plane", "error", err)
+				sess.conn.Close()
+				return
+			}
+			heartbeat := &model.Heartbeat{
+				AgentID:   a.cfg.AgentID,
+				Timestamp: time.Now(),
+				Metrics:   map[string]interface{}{},
+				Health:    a.healthSnapshot(),
+			}
+			if _, err := sess.send(model.MessageTypeHeartbeat, heartbeat); err != nil {
+				logger.Error("Failed to send heartbeat", "error", err)
+				sess.conn.Close() // Unblock the read loop so the supervisor reconnects
+				return
+			}
+			logger.Debug("Heartbeat sent")
+
+		case <-sess.done:
+			return
+		}
+	}
+}
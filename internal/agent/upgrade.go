@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+	"github.com/suyog1pathak/transporter/pkg/version"
+)
+
+// Pod template annotations recording the upgrade that triggered a rollout
+const (
+	AnnotationUpgradeEvent  = "transporter.io/upgrade-event"
+	AnnotationTargetVersion = "transporter.io/target-version"
+)
+
+// parseDeployment splits a namespace/name Deployment reference
+func parseDeployment(ref string) (namespace, name string, ok bool) {
+	namespace, name, ok = strings.Cut(ref, "/")
+	return namespace, name, ok && namespace != "" && name != ""
+}
+
+// handleUpgrade patches the agent's own Deployment to the requested image. The event
+// completes once the patch is accepted; the rollout then replaces this pod, and the
+// control plane confirms the upgrade when the new version registers.
+func (a *agent) handleUpgrade(ctx context.Context, event *model.Event) {
+	spec := event.Payload.AgentUpgrade
+
+	// Policy comes first: a disallowed image fails even if its version is already running
+	if violations := a.policy.CheckEvent(event, nil); len(violations) > 0 {
+		result := violationResult(violations)
+		logger.Warn("Agent upgrade rejected by agent policy", "event_id", event.ID, "image", spec.Image)
+		a.sendStatusUpdate(event, model.StateFailed, model.PhaseFailed, result.ErrorMessage, result, nil)
+		return
+	}
+
+	if spec.Version == version.Version {
+		a.sendStatusUpdate(event, model.StateCompleted, model.PhaseCompleted,
+			fmt.Sprintf("Agent already running version %s", spec.Version), &model.EventResult{
+				Success:     true,
+				CompletedAt: time.Now(),
+			}, nil)
+		return
+	}
+
+	namespace, name, ok := parseDeployment(a.cfg.Deployment)
+	if !ok {
+		a.sendStatusUpdate(event, model.StateFailed, model.PhaseFailed,
+			"Self-upgrade is disabled: the agent Deployment is not configured (--deployment)", nil, nil)
+		return
+	}
+
+	if a.reportIfCancelled(ctx, event) {
+		return
+	}
+
+	a.sendStatusUpdate(event, model.StateInProgress, model.PhaseApplying,
+		fmt.Sprintf("Upgrading agent from %s to %s", version.Version, spec.Version), nil, nil)
+
+	start := time.Now()
+	err := a.executor.SetDeploymentImage(ctx, namespace, name, a.cfg.Container, spec.Image, map[string]string{
+		AnnotationUpgradeEvent:  event.ID,
+		AnnotationTargetVersion: spec.Version,
+	})
+	if err != nil {
+		logger.Error("Agent upgrade failed", "event_id", event.ID, "error", err)
+		a.sendStatusUpdate(event, model.StateFailed, model.PhaseFailed, err.Error(), nil, nil)
+		return
+	}
+
+	logger.Info("Agent Deployment patched for upgrade", "event_id", event.ID, "deployment", a.cfg.Deployment,
+		"image", spec.Image, "from_version", version.Version, "to_version", spec.Version)
+	a.sendStatusUpdate(event, model.StateCompleted, model.PhaseCompleted,
+		fmt.Sprintf("Deployment patched to %s; version %s registers once this agent drains", spec.Image, spec.Version),
+		&model.EventResult{
+			Success: true,
+			ResourceStatus: []model.ResourceStatus{{
+				Kind:       "Deployment",
+				Name:       name,
+				Namespace:  namespace,
+				APIVersion: "apps/v1",
+				Status:     "updated",
+				Message:    fmt.Sprintf("container %s image set to %s", a.cfg.Container, spec.Image),
+			}},
+			CompletedAt: time.Now(),
+			Duration:    time.Since(start),
+		}, nil)
+}
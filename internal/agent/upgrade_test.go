@@ -0,0 +1,66 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+	"github.com/suyog1pathak/transporter/pkg/version"
+)
+
+func TestHandleUpgrade(t *testing.T) {
+	logger.InitLogger(false)
+	const image = "registry.example.com/transporter-agent"
+
+	tests := []struct {
+		name        string
+		policy      Policy
+		spec        model.AgentUpgradeSpec
+		deployment  string
+		wantState   model.ExecutionState
+		wantMessage string
+	}{
+		{
+			name:        "disallowed image on the running version",
+			spec:        model.AgentUpgradeSpec{Image: "evil.example.com/agent:" + version.Version, Version: version.Version},
+			policy:      Policy{AllowedUpgradeImages: []string{image + ":*"}},
+			deployment:  "transporter-system/transporter-agent",
+			wantState:   model.StateFailed,
+			wantMessage: "not allowed",
+		},
+		{
+			name:        "allowed image on the running version",
+			spec:        model.AgentUpgradeSpec{Image: image + ":" + version.Version, Version: version.Version},
+			policy:      Policy{AllowedUpgradeImages: []string{image + ":*"}},
+			wantState:   model.StateCompleted,
+			wantMessage: "already running",
+		},
+		{
+			name:        "no deployment configured",
+			spec:        model.AgentUpgradeSpec{Image: image + ":v99.0.0", Version: "v99.0.0"},
+			policy:      Policy{AllowedUpgradeImages: []string{image + ":*"}},
+			wantState:   model.StateFailed,
+			wantMessage: "Self-upgrade is disabled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &agent{cfg: Config{Deployment: tt.deployment}, policy: &tt.policy}
+			event := &model.Event{ID: "event-1", Type: model.EventTypeAgentUpgrade, TargetAgent: "agent-1",
+				Payload: model.EventPayload{AgentUpgrade: &tt.spec}}
+
+			a.handleUpgrade(t.Context(), event)
+
+			_, pending, _ := a.healthReport()
+			if pending != 1 {
+				t.Fatalf("%d status updates, want 1", pending)
+			}
+			update := a.unconfirmed[0]
+			if update.State != tt.wantState || !strings.Contains(update.Message, tt.wantMessage) {
+				t.Fatalf("status %s %q, want %s containing %q", update.State, update.Message, tt.wantState, tt.wantMessage)
+			}
+		})
+	}
+}
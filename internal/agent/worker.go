@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/executor"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+)
+
+// queuedEvent is an event waiting for a worker
+type queuedEvent struct {
+	event *model.Event
+	ctx   context.Context
+}
+
+// dispatch queues an event for the worker pool. The event can be cancelled
+// while it waits. Returns false if the queue is full.
+func (a *agent) dispatch(event *model.Event) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, loaded := a.running.LoadOrStore(event.ID, cancel); loaded {
+		cancel()
+		logger.Warn("Event already queued or running, ignoring duplicate", "event_id", event.ID)
+		return true
+	}
+
+	select {
+	case a.queue <- &queuedEvent{event: event, ctx: ctx}:
+		logger.Debug("Event queued", "event_id", event.ID, "queued", len(a.queue))
+		return true
+	default:
+		a.running.Delete(event.ID)
+		cancel()
+		return false
+	}
+}
+
+// startWorkers starts the pool that executes queued events
+func (a *agent) startWorkers(ctx context.Context, count int) {
+	for i := 0; i < count; i++ {
+		go func() {
+			for {
+				select {
+				case queued := <-a.queue:
+					a.handleEvent(queued.ctx, queued.event)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+// namespaceLocks serializes events that touch the same namespace
+type namespaceLocks struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+func newNamespaceLocks() *namespaceLocks {
+	return &namespaceLocks{locks: make(map[string]chan struct{})}
+}
+
+// acquire locks every namespace, in sorted order so concurrent events cannot
+// deadlock. It returns a release function, or an error if ctx ends first.
+func (nl *namespaceLocks) acquire(ctx context.Context, namespaces []string) (func(), error) {
+	held := make([]chan struct{}, 0, len(namespaces))
+	release := func() {
+		for _, lock := range held {
+			<-lock
+		}
+	}
+
+	for _, namespace := range namespaces {
+		lock := nl.get(namespace)
+		select {
+		case lock <- struct{}{}:
+			held = append(held, lock)
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+
+	return release, nil
+}
+
+func (nl *namespaceLocks) get(namespace string) chan struct{} {
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+
+	lock, ok := nl.locks[namespace]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		nl.locks[namespace] = lock
+	}
+	return lock
+}
+
+// touchedNamespaces returns the sorted, distinct namespaces a set of manifests touches.
+// A Namespace object counts as touching the namespace it names.
+func touchedNamespaces(targets []executor.ManifestTarget) []string {
+	seen := make(map[string]bool)
+	namespaces := make([]string, 0)
+
+	for _, target := range targets {
+		namespace := target.Namespace
+		if target.Group == "" && target.Kind == "Namespace" {
+			namespace = target.Name
+		}
+		if namespace == "" || seen[namespace] {
+			continue
+		}
+		seen[namespace] = true
+		namespaces = append(namespaces, namespace)
+	}
+
+	sort.Strings(namespaces)
+	return namespaces
+}
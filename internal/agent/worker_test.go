@@ -0,0 +1,230 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/internal/testutil"
+	"github.com/suyog1pathak/transporter/pkg/executor"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+)
+
+func TestDispatch(t *testing.T) {
+	logger.InitLogger(false)
+	a := &agent{queue: make(chan *queuedEvent, 2)}
+
+	for _, id := range []string{"event-1", "event-2"} {
+		if !a.dispatch(&model.Event{ID: id}) {
+			t.Fatalf("dispatch(%s) rejected with room in the queue", id)
+		}
+	}
+	// A redelivered event is accepted without queueing it twice
+	if !a.dispatch(&model.Event{ID: "event-1"}) || len(a.queue) != 2 {
+		t.Fatalf("duplicate dispatch queued %d events, want 2", len(a.queue))
+	}
+	if a.dispatch(&model.Event{ID: "event-3"}) {
+		t.Fatal("dispatch() accepted an event with the queue full")
+	}
+	if _, ok := a.running.Load("event-3"); ok {
+		t.Fatal("rejected event is still tracked as running")
+	}
+
+	// A queued event can be cancelled before a worker takes it
+	queued := <-a.queue
+	cancel, _ := a.running.Load(queued.event.ID)
+	cancel.(context.CancelFunc)()
+	if queued.ctx.Err() == nil {
+		t.Fatal("cancelling a queued event did not cancel its context")
+	}
+}
+
+func TestNamespaceLocks(t *testing.T) {
+	locks := newNamespaceLocks()
+
+	release, err := locks.acquire(t.Context(), []string{"team-b", "team-c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An event touching a held namespace waits until its context ends
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := locks.acquire(ctx, []string{"team-a", "team-b"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acquire() of a held namespace error = %v, want DeadlineExceeded", err)
+	}
+	// Giving up released team-a, which the waiter had already locked
+	ctx, cancel = context.WithTimeout(t.Context(), time.Second)
+	defer cancel()
+	releaseA, err := locks.acquire(ctx, []string{"team-a", "team-d"})
+	if err != nil {
+		t.Fatalf("namespaces free of the held locks are blocked: %v", err)
+	}
+	releaseA()
+	release()
+
+	// Events taking overlapping namespaces in sorted order never deadlock
+	testutil.Within(t, "overlapping acquires", func() {
+		var wg sync.WaitGroup
+		active := make(map[string]int)
+		var mu sync.Mutex
+		for i := range 20 {
+			namespaces := touchedNamespaces([]executor.ManifestTarget{
+				{Namespace: fmt.Sprintf("ns-%d", i%3)},
+				{Namespace: fmt.Sprintf("ns-%d", (i+1)%3)},
+			})
+			wg.Go(func() {
+				release, err := locks.acquire(context.Background(), namespaces)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				for _, namespace := range namespaces {
+					if active[namespace]++; active[namespace] > 1 {
+						t.Errorf("namespace %s held by two events", namespace)
+					}
+				}
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				for _, namespace := range namespaces {
+					active[namespace]--
+				}
+				mu.Unlock()
+				release()
+			})
+		}
+		wg.Wait()
+	})
+}
+
+func TestTouchedNamespaces(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []executor.ManifestTarget
+		want    []string
+	}{
+		{name: "none", want: []string{}},
+		{
+			name: "sorted and distinct",
+			targets: []executor.ManifestTarget{
+				{Kind: "ConfigMap", Namespace: "team-b"},
+				{Kind: "Deployment", Group: "apps", Namespace: "team-a"},
+				{Kind: "Service", Namespace: "team-b"},
+			},
+			want: []string{"team-a", "team-b"},
+		},
+		{
+			name: "namespace object touches the namespace it names",
+			targets: []executor.ManifestTarget{
+				{Kind: "Namespace", Name: "team-c"},
+				{Kind: "ClusterRole", Group: "rbac.authorization.k8s.io", Name: "reader"},
+			},
+			want: []string{"team-c"},
+		},
+		{
+			name:    "namespace kind in another group",
+			targets: []executor.ManifestTarget{{Kind: "Namespace", Group: "example.com", Name: "team-c"}},
+			want:    []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := touchedNamespaces(tt.targets); !slices.Equal(got, tt.want) {
+				t.Fatalf("touchedNamespaces() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionSendsInSequenceOrder(t *testing.T) {
+	logger.InitLogger(false)
+
+	received := make(chan uint64, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		codec := model.NewCodec(model.CodecConfig{Version: model.ProtocolVersion})
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			env, err := codec.Decode(messageType == websocket.BinaryMessage, data)
+			if err != nil {
+				t.Errorf("decode: %v", err)
+				return
+			}
+			if env != nil {
+				received <- env.Seq
+			}
+		}
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Small frames split every message into chunks, which must not interleave
+	s := &session{
+		conn:     conn,
+		codec:    model.NewCodec(model.CodecConfig{Version: model.ProtocolVersion, MaxFrameSize: 512}),
+		done:     make(chan struct{}),
+		outbound: make(chan []model.Frame, outboundQueueSize),
+		awaiting: make(map[uint64]*model.StatusUpdate),
+	}
+	go s.writeLoop()
+	defer conn.Close()
+
+	var wg sync.WaitGroup
+	for i := range cap(received) {
+		wg.Go(func() {
+			update := &model.StatusUpdate{EventID: fmt.Sprintf("event-%d", i), Message: strings.Repeat("applying ", 200)}
+			if _, err := s.send(model.MessageTypeStatusUpdate, update); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+
+	testutil.Within(t, "receiving every message", func() {
+		var last uint64
+		for range cap(received) {
+			seq := <-received
+			if seq <= last {
+				t.Fatalf("message %d arrived after %d", seq, last)
+			}
+			last = seq
+		}
+	})
+
+	close(s.done)
+
+	// A sender blocked on a full queue gives up when the session ends
+	s = &session{
+		codec:    model.NewCodec(model.CodecConfig{Version: model.ProtocolVersion}),
+		done:     make(chan struct{}),
+		outbound: make(chan []model.Frame, 1),
+	}
+	s.outbound <- nil
+	time.AfterFunc(10*time.Millisecond, func() { close(s.done) })
+	if _, err := s.send(model.MessageTypeStatusUpdate, &model.StatusUpdate{EventID: "event-x"}); !errors.Is(err, errSessionClosed) {
+		t.Fatalf("send() on a closed session error = %v, want errSessionClosed", err)
+	}
+}
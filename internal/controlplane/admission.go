@@ -0,0 +1,192 @@
+package controlplane
+
+import (
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// limiterIdleTimeout is how long a per-IP limiter is kept after its last use
+	limiterIdleTimeout = 10 * time.Minute
+
+	// rejectionAuditInterval is how often rejections of unidentified connections
+	// from one IP are audited for each reason; the rest are only counted
+	rejectionAuditInterval = time.Minute
+)
+
+// admissionController decides whether an incoming WebSocket connection may proceed
+type admissionController struct {
+	allowedOrigins map[string]bool
+	allowAnyOrigin bool
+	rateLimit      rate.Limit
+	rateBurst      int
+
+	limiters   map[string]*ipLimiter         // client IP -> limiter
+	rejections map[string]int64              // reason -> rejected connections
+	sampled    map[rejectionKey]*auditSample // last audited rejection per IP and reason
+	mu         sync.Mutex
+}
+
+// rejectionKey identifies the rejections sampled together in the audit log
+type rejectionKey struct {
+	ip     string
+	reason string
+}
+
+// auditSample tracks the rejections suppressed since the last audited one
+type auditSample struct {
+	auditedAt  time.Time
+	suppressed int
+}
+
+// ipLimiter tracks the connection rate of a single client IP
+type ipLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newAdmissionController creates an admission controller from the control plane config
+func newAdmissionController(cfg Config) *admissionController {
+	ac := &admissionController{
+		allowedOrigins: make(map[string]bool),
+		rateLimit:      rate.Limit(cfg.ConnRateLimit),
+		rateBurst:      cfg.ConnRateBurst,
+		limiters:       make(map[string]*ipLimiter),
+		rejections:     make(map[string]int64),
+		sampled:        make(map[rejectionKey]*auditSample),
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+		if origin == "" {
+			continue
+		}
+		if origin == "*" {
+			ac.allowAnyOrigin = true
+			continue
+		}
+		ac.allowedOrigins[origin] = true
+	}
+
+	if ac.rateBurst <= 0 {
+		ac.rateBurst = 1
+	}
+
+	// Start background cleanup of idle limiters and rejection samples
+	go ac.limiterJanitor()
+
+	return ac
+}
+
+// CheckOrigin reports whether the request origin is acceptable.
+// Requests without an Origin header (non-browser clients such as agents) are allowed,
+// as are same-origin requests and origins from the configured allowlist.
+func (ac *admissionController) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || ac.allowAnyOrigin {
+		return true
+	}
+
+	if ac.allowedOrigins[strings.ToLower(strings.TrimSuffix(origin, "/"))] {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// AllowConnection applies the per-IP connection rate limit
+func (ac *admissionController) AllowConnection(clientIP string) bool {
+	if ac.rateLimit <= 0 {
+		return true
+	}
+
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	entry, exists := ac.limiters[clientIP]
+	if !exists {
+		entry = &ipLimiter{limiter: rate.NewLimiter(ac.rateLimit, ac.rateBurst)}
+		ac.limiters[clientIP] = entry
+	}
+	entry.lastSeen = time.Now()
+
+	return entry.limiter.Allow()
+}
+
+// RecordRejection counts a rejected connection and reports whether it should be
+// audited. Rejections of identified agents are always audited; for unidentified
+// connections one per IP and reason is audited each rejectionAuditInterval, along
+// with the number suppressed since the previous one.
+func (ac *admissionController) RecordRejection(clientIP, agentID, reason string) (audit bool, suppressed int) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	ac.rejections[reason]++
+	if agentID != "" {
+		return true, 0
+	}
+
+	key := rejectionKey{ip: clientIP, reason: reason}
+	sample, exists := ac.sampled[key]
+	now := time.Now()
+	if exists && now.Sub(sample.auditedAt) < rejectionAuditInterval {
+		sample.suppressed++
+		return false, 0
+	}
+	if !exists {
+		sample = &auditSample{}
+		ac.sampled[key] = sample
+	}
+	suppressed = sample.suppressed
+	sample.auditedAt, sample.suppressed = now, 0
+	return true, suppressed
+}
+
+// Rejections returns the number of rejected connections by reason
+func (ac *admissionController) Rejections() map[string]int64 {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	return maps.Clone(ac.rejections)
+}
+
+// limiterJanitor periodically drops limiters and rejection samples for IPs that
+// have gone quiet
+func (ac *admissionController) limiterJanitor() {
+	ticker := time.NewTicker(limiterIdleTimeout)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ac.mu.Lock()
+		for ip, entry := range ac.limiters {
+			if time.Since(entry.lastSeen) > limiterIdleTimeout {
+				delete(ac.limiters, ip)
+			}
+		}
+		for key, sample := range ac.sampled {
+			if time.Since(sample.auditedAt) > limiterIdleTimeout {
+				delete(ac.sampled, key)
+			}
+		}
+		ac.mu.Unlock()
+	}
+}
+
+// clientIP extracts the client IP from the request remote address
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
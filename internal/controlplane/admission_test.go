@@ -0,0 +1,72 @@
+package controlplane
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordRejection(t *testing.T) {
+	type rejection struct {
+		ip, agentID, reason string
+		wantAudit           bool
+		wantSuppressed      int
+	}
+
+	tests := []struct {
+		name       string
+		rejections []rejection
+		age        time.Duration // Added to the sample times before the last rejection
+	}{
+		{
+			name: "first rejection per IP and reason is audited",
+			rejections: []rejection{
+				{ip: "10.0.0.1", reason: "rate_limited", wantAudit: true},
+				{ip: "10.0.0.1", reason: "rate_limited"},
+				{ip: "10.0.0.1", reason: "origin_not_allowed", wantAudit: true},
+				{ip: "10.0.0.2", reason: "rate_limited", wantAudit: true},
+			},
+		},
+		{
+			name: "identified agents are always audited",
+			rejections: []rejection{
+				{ip: "10.0.0.1", agentID: "agent-1", reason: "agent_revoked", wantAudit: true},
+				{ip: "10.0.0.1", agentID: "agent-1", reason: "agent_revoked", wantAudit: true},
+			},
+		},
+		{
+			name: "next interval reports the suppressed count",
+			rejections: []rejection{
+				{ip: "10.0.0.1", reason: "rate_limited", wantAudit: true},
+				{ip: "10.0.0.1", reason: "rate_limited"},
+				{ip: "10.0.0.1", reason: "rate_limited"},
+				{ip: "10.0.0.1", reason: "rate_limited", wantAudit: true, wantSuppressed: 2},
+			},
+			age: rejectionAuditInterval,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ac := newAdmissionController(Config{})
+			for i, r := range tt.rejections {
+				if i == len(tt.rejections)-1 {
+					for _, sample := range ac.sampled {
+						sample.auditedAt = sample.auditedAt.Add(-tt.age)
+					}
+				}
+				audit, suppressed := ac.RecordRejection(r.ip, r.agentID, r.reason)
+				if audit != r.wantAudit || suppressed != r.wantSuppressed {
+					t.Fatalf("rejection %d = (%v, %d), want (%v, %d)", i, audit, suppressed, r.wantAudit, r.wantSuppressed)
+				}
+			}
+
+			total := int64(0)
+			for _, count := range ac.Rejections() {
+				total += count
+			}
+			if total != int64(len(tt.rejections)) {
+				t.Fatalf("counted %d rejections, want %d", total, len(tt.rejections))
+			}
+		})
+	}
+}
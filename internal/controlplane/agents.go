@@ -0,0 +1,356 @@
+package controlplane
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+	"github.com/suyog1pathak/transporter/pkg/registry"
+	"github.com/suyog1pathak/transporter/pkg/router"
+	"github.com/suyog1pathak/transporter/pkg/storage"
+)
+
+// agentView is an agent as returned by the agent API
+type agentView struct {
+	*model.Agent
+	Live          bool `json:"live"`           // Connected to this control plane right now
+	PendingEvents int  `json:"pending_events"` // Events queued for delivery to the agent
+}
+
+// registerAgentRoutes adds the agent read and administration endpoints
+func registerAgentRoutes(mux *http.ServeMux, agentRegistry *registry.AgentRegistry,
+	store storage.Store, eventRouter *router.EventRouter) {
+
+	mux.HandleFunc("GET /agents", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		query := r.URL.Query()
+		cluster := query.Get("cluster")
+
+		// Persisted agents include disconnected ones; live agents may not be saved yet
+		var agentIDs []string
+		var err error
+		if cluster != "" {
+			agentIDs, err = store.ListAgentsByCluster(ctx, cluster)
+		} else {
+			agentIDs, err = store.ListAllAgents(ctx)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list agents: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for _, agent := range agentRegistry.List() {
+			agentIDs = append(agentIDs, agent.ID)
+		}
+		slices.Sort(agentIDs)
+		agentIDs = slices.Compact(agentIDs)
+
+		agents := make([]agentView, 0, len(agentIDs))
+		for _, agentID := range agentIDs {
+			view, err := lookupAgent(ctx, agentRegistry, store, eventRouter, agentID)
+			if err != nil {
+				continue // Deleted while listing
+			}
+			if agentMatches(view.Agent, query) {
+				agents = append(agents, *view)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"agents": agents,
+			"count":  len(agents),
+		})
+	})
+
+	mux.HandleFunc("GET /agents/{id}", func(w http.ResponseWriter, r *http.Request) {
+		view, err := lookupAgent(r.Context(), agentRegistry, store, eventRouter, r.PathValue("id"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Agent not found: %v", err), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(view)
+	})
+
+	mux.HandleFunc("DELETE /agents/{id}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		agentID := r.PathValue("id")
+		user := r.URL.Query().Get("user")
+
+		_, liveErr := agentRegistry.GetAgent(agentID)
+		if _, err := store.GetAgent(ctx, agentID); err != nil && liveErr != nil {
+			http.Error(w, fmt.Sprintf("Agent not found: %v", err), http.StatusNotFound)
+			return
+		}
+
+		// Revoke first so the agent cannot re-register while it is being removed
+		if err := store.RevokeAgent(ctx, agentID); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to revoke agent: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if liveErr == nil {
+			agentRegistry.Unregister(agentID)
+		}
+		agentRegistry.Uncordon(agentID)
+
+		deleteErr := store.DeleteAgent(ctx, agentID)
+		if deleteErr != nil && deleteErr != model.ErrAgentNotFound {
+			http.Error(w, fmt.Sprintf("Failed to delete agent: %v", deleteErr), http.StatusInternalServerError)
+			return
+		}
+
+		// Events that can no longer be delivered, or whose outcome can no longer be
+		// reported, are cancelled
+		cancelled := eventRouter.ClearPendingEvents(agentID)
+		cancelled = append(cancelled, eventRouter.CancelAssigned(agentID)...)
+		cause := model.Cause{Actor: model.ActorUser, ID: user, Reason: "agent_decommissioned"}
+		for _, event := range cancelled {
+			transitionEvent(ctx, store, event, agentID, model.StateCancelled, cause, func(status *model.EventStatus) {
+				status.MarkCancelled("Agent decommissioned")
+			})
+		}
+
+		logger.Info("Agent decommissioned", "agent_id", agentID, "was_connected", liveErr == nil,
+			"cancelled_events", len(cancelled))
+		saveAudit(ctx, store, &storage.AuditLogEntry{
+			Timestamp: time.Now(),
+			AgentID:   agentID,
+			Action:    "agent_decommissioned",
+			User:      user,
+			Details: map[string]interface{}{
+				"was_connected":    liveErr == nil,
+				"was_persisted":    deleteErr == nil,
+				"cancelled_events": len(cancelled),
+			},
+		})
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":           "decommissioned",
+			"agent_id":         agentID,
+			"cancelled_events": len(cancelled),
+		})
+	})
+
+	mux.HandleFunc("DELETE /agents/{id}/revocation", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		agentID := r.PathValue("id")
+
+		restored, err := store.RestoreAgent(ctx, agentID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to restore agent: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if !restored {
+			http.Error(w, fmt.Sprintf("Agent %s is not revoked", agentID), http.StatusNotFound)
+			return
+		}
+
+		logger.Info("Agent revocation lifted", "agent_id", agentID)
+		saveAudit(ctx, store, &storage.AuditLogEntry{
+			Timestamp: time.Now(),
+			AgentID:   agentID,
+			Action:    "agent_restored",
+			User:      r.URL.Query().Get("user"),
+		})
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":   "restored",
+			"agent_id": agentID,
+		})
+	})
+
+	mux.HandleFunc("POST /agents/{id}/cordon", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		agentID := r.PathValue("id")
+
+		var req struct {
+			Reason string `json:"reason"`
+			User   string `json:"user"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid cordon request: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		cordon := &model.Cordon{Reason: req.Reason, User: req.User, At: time.Now()}
+		agent, err := setCordon(ctx, agentRegistry, store, agentID, cordon)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		logger.Info("Agent cordoned", "agent_id", agentID, "reason", req.Reason, "user", req.User)
+		saveAudit(ctx, store, &storage.AuditLogEntry{
+			Timestamp: time.Now(),
+			AgentID:   agentID,
+			Action:    "agent_cordoned",
+			User:      req.User,
+			Details:   map[string]interface{}{"reason": req.Reason},
+		})
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(agent)
+	})
+
+	mux.HandleFunc("POST /agents/{id}/uncordon", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		agentID := r.PathValue("id")
+
+		var req struct {
+			User string `json:"user"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid uncordon request: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		agent, err := setCordon(ctx, agentRegistry, store, agentID, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		logger.Info("Agent uncordoned", "agent_id", agentID, "user", req.User)
+		saveAudit(ctx, store, &storage.AuditLogEntry{
+			Timestamp: time.Now(),
+			AgentID:   agentID,
+			Action:    "agent_uncordoned",
+			User:      req.User,
+		})
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(agent)
+	})
+}
+
+// lookupAgent merges the live registry state of an agent with its persisted record,
+// so disconnected agents are still found with their DisconnectedAt and last health
+func lookupAgent(ctx context.Context, agentRegistry *registry.AgentRegistry, store storage.Store,
+	eventRouter *router.EventRouter, agentID string) (*agentView, error) {
+
+	stored, storedErr := store.GetAgent(ctx, agentID)
+	live, liveErr := agentRegistry.GetAgent(agentID)
+	if liveErr != nil && storedErr != nil {
+		return nil, storedErr
+	}
+
+	view := &agentView{
+		Agent:         stored,
+		Live:          liveErr == nil,
+		PendingEvents: eventRouter.GetPendingEventsCount(agentID),
+	}
+	if liveErr == nil {
+		merged := *live
+		if merged.Health == nil && stored != nil {
+			merged.Health = stored.Health // No heartbeat on the new connection yet
+		}
+		view.Agent = &merged
+	}
+	return view, nil
+}
+
+// listPersistedAgents returns every agent saved in storage
+func listPersistedAgents(ctx context.Context, store storage.Store) ([]*model.Agent, error) {
+	agentIDs, err := store.ListAllAgents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	agents := make([]*model.Agent, 0, len(agentIDs))
+	for _, agentID := range agentIDs {
+		if agent, err := store.GetAgent(ctx, agentID); err == nil {
+			agents = append(agents, agent)
+		}
+	}
+	return agents, nil
+}
+
+// agentMatches applies the GET /agents query filters. Labels are given as repeated
+// label=key=value (or label=key to require only the key).
+func agentMatches(agent *model.Agent, query url.Values) bool {
+	if status := query.Get("status"); status != "" && string(agent.Status) != status {
+		return false
+	}
+	if cluster := query.Get("cluster"); cluster != "" && agent.ClusterName != cluster {
+		return false
+	}
+	if provider := query.Get("provider"); provider != "" && agent.ClusterProvider != provider {
+		return false
+	}
+	if region := query.Get("region"); region != "" && agent.Region != region {
+		return false
+	}
+
+	for _, selector := range query["label"] {
+		key, value, hasValue := strings.Cut(selector, "=")
+		actual, ok := agent.Labels[key]
+		if !ok || (hasValue && actual != value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// setCordon cordons (or, with a nil cordon, uncordons) an agent in the registry and
+// persists it so the cordon survives agent reconnects and control plane restarts
+func setCordon(ctx context.Context, agentRegistry *registry.AgentRegistry, store storage.Store,
+	agentID string, cordon *model.Cordon) (*model.Agent, error) {
+
+	_, liveErr := agentRegistry.GetAgent(agentID)
+	agent, storedErr := store.GetAgent(ctx, agentID)
+	if liveErr != nil && storedErr != nil {
+		return nil, fmt.Errorf("agent %s not found", agentID)
+	}
+
+	var live *model.Agent
+	if cordon != nil {
+		live = agentRegistry.Cordon(agentID, cordon)
+	} else {
+		live = agentRegistry.Uncordon(agentID)
+	}
+
+	if live != nil {
+		agent = live
+	} else if agent == nil {
+		return nil, fmt.Errorf("agent %s not found", agentID) // Disconnected before it was ever saved
+	} else {
+		agent.Cordon = cordon
+	}
+	if err := store.SaveAgent(ctx, agent); err != nil {
+		logger.Warn("Failed to save agent state", "agent_id", agentID, "error", err)
+	}
+	return agent, nil
+}
+
+// restoreCordons reapplies cordons persisted before a control plane restart
+func restoreCordons(ctx context.Context, agentRegistry *registry.AgentRegistry, store storage.Store) {
+	agentIDs, err := store.ListAllAgents(ctx)
+	if err != nil {
+		logger.Warn("Failed to list agents for cordon restore", "error", err)
+		return
+	}
+
+	for _, agentID := range agentIDs {
+		agent, err := store.GetAgent(ctx, agentID)
+		if err != nil || agent.Cordon == nil {
+			continue
+		}
+		agentRegistry.Cordon(agentID, agent.Cordon)
+		logger.Info("Restored agent cordon", "agent_id", agentID, "reason", agent.Cordon.Reason)
+	}
+}
@@ -0,0 +1,157 @@
+package controlplane
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/suyog1pathak/transporter/pkg/logger"
+	"github.com/suyog1pathak/transporter/pkg/storage"
+)
+
+// registerAuditRoutes adds the audit log query and export endpoints
+func registerAuditRoutes(mux *http.ServeMux, store storage.Store) {
+	mux.HandleFunc("GET /audit", func(w http.ResponseWriter, r *http.Request) {
+		query, err := parseAuditQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := store.QueryAuditLog(r.Context(), query)
+		if errors.Is(err, storage.ErrInvalidAuditCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to query audit log: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	})
+
+	// Streams every matching entry as newline-delimited JSON, for log shippers
+	mux.HandleFunc("GET /audit/export", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		query, err := parseAuditQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query.Limit = storage.MaxAuditPageSize
+
+		page, err := store.QueryAuditLog(ctx, query)
+		if errors.Is(err, storage.ErrInvalidAuditCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to query audit log: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+		encoder := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+		exported := 0
+		for {
+			for _, entry := range page.Entries {
+				if err := encoder.Encode(entry); err != nil {
+					return // Client went away
+				}
+			}
+			exported += len(page.Entries)
+			if flusher != nil {
+				flusher.Flush()
+			}
+			if page.NextCursor == "" || r.Context().Err() != nil {
+				break
+			}
+
+			query.Cursor = page.NextCursor
+			if page, err = store.QueryAuditLog(ctx, query); err != nil {
+				// Headers are sent; the truncated export is all we can report
+				logger.Error("Audit export failed", "exported", exported, "error", err)
+				return
+			}
+		}
+		logger.Info("Audit log exported", "entries", exported, "remote_addr", r.RemoteAddr)
+	})
+}
+
+// parseAuditQuery reads audit filters from query parameters. Times are RFC 3339.
+func parseAuditQuery(values url.Values) (storage.AuditQuery, error) {
+	query := storage.AuditQuery{
+		EventID: values.Get("event_id"),
+		AgentID: values.Get("agent_id"),
+		User:    values.Get("user"),
+		Action:  values.Get("action"),
+		Cursor:  values.Get("cursor"),
+	}
+
+	for name, target := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if v := values.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return query, fmt.Errorf("invalid %s: %v", name, err)
+			}
+			*target = t
+		}
+	}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return query, fmt.Errorf("invalid limit: %q", v)
+		}
+		query.Limit = limit
+	}
+	return query, nil
+}
+
+// saveAudit appends an audit entry, logging failures. The entry is written even if
+// ctx is cancelled, since the action it records has already happened.
+func saveAudit(ctx context.Context, store storage.Store, entry *storage.AuditLogEntry) {
+	if err := store.SaveAuditLog(context.WithoutCancel(ctx), entry); err != nil {
+		logger.Error("Failed to save audit log", "action", entry.Action, "event_id", entry.EventID,
+			"agent_id", entry.AgentID, "error", err)
+	}
+}
+
+// runAuditCheckpoints periodically signs the head of the audit chain. A checkpoint
+// is only written when entries were added since the last one.
+func runAuditCheckpoints(store storage.Store, key ed25519.PrivateKey, interval time.Duration) {
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ctx := context.Background()
+	for range ticker.C {
+		recent, err := store.GetRecentAuditLogs(ctx, 1)
+		if err != nil {
+			logger.Error("Failed to read audit log head", "error", err)
+			continue
+		}
+		if len(recent) == 0 || recent[0].Action == storage.ActionAuditCheckpoint || recent[0].Hash == "" {
+			continue
+		}
+
+		head := recent[0]
+		if err := store.SaveAuditLog(ctx, storage.NewAuditCheckpoint(head, key)); err != nil {
+			logger.Error("Failed to save audit checkpoint", "error", err)
+			continue
+		}
+		logger.Info("Audit checkpoint written", "entry_id", head.ID, "entry_hash", head.Hash)
+	}
+}
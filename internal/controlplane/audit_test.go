@@ -0,0 +1,136 @@
+package controlplane
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/suyog1pathak/transporter/pkg/logger"
+	"github.com/suyog1pathak/transporter/pkg/storage"
+)
+
+func TestParseAuditQuery(t *testing.T) {
+	since := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		values  string
+		want    storage.AuditQuery
+		wantErr bool
+	}{
+		{name: "empty"},
+		{
+			name:   "filters",
+			values: "event_id=event-1&agent_id=agent-1&user=alice&action=event_routed&cursor=42&limit=10",
+			want:   storage.AuditQuery{EventID: "event-1", AgentID: "agent-1", User: "alice", Action: "event_routed", Cursor: "42", Limit: 10},
+		},
+		{
+			name:   "time range",
+			values: "since=2026-03-01T10:00:00Z&until=2026-03-01T12:00:00%2B02:00",
+			want:   storage.AuditQuery{Since: since, Until: since},
+		},
+		{name: "invalid since", values: "since=yesterday", wantErr: true},
+		{name: "until without zone", values: "until=2026-03-01T10:00:00", wantErr: true},
+		{name: "zero limit", values: "limit=0", wantErr: true},
+		{name: "non-numeric limit", values: "limit=all", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseAuditQuery(values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAuditQuery() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !got.Since.Equal(tt.want.Since) || !got.Until.Equal(tt.want.Until) {
+				t.Fatalf("range %v..%v, want %v..%v", got.Since, got.Until, tt.want.Since, tt.want.Until)
+			}
+			got.Since, got.Until = tt.want.Since, tt.want.Until
+			if got != tt.want {
+				t.Fatalf("parseAuditQuery() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditRoutes(t *testing.T) {
+	logger.InitLogger(false)
+	ctx := t.Context()
+	store := storage.NewMemoryStorage()
+	mux := http.NewServeMux()
+	registerAuditRoutes(mux, store)
+
+	// More entries than one export page holds
+	const entries = storage.MaxAuditPageSize + 5
+	start := time.Now()
+	for i := range entries {
+		err := store.SaveAuditLog(ctx, &storage.AuditLogEntry{
+			Timestamp: start.Add(time.Duration(i) * time.Millisecond),
+			EventID:   fmt.Sprintf("event-%d", i),
+			Action:    []string{"event_routed", "event_state_changed"}[i%2],
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		return recorder
+	}
+
+	recorder := get("/audit?action=event_routed&limit=2")
+	var page storage.AuditPage
+	if err := json.NewDecoder(recorder.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Code != http.StatusOK || len(page.Entries) != 2 || page.Entries[1].EventID != "event-2" || page.NextCursor == "" {
+		t.Fatalf("first page %d %+v, want event-0 and event-2 with a cursor", recorder.Code, page)
+	}
+	recorder = get("/audit?action=event_routed&limit=2&cursor=" + url.QueryEscape(page.NextCursor))
+	page = storage.AuditPage{}
+	if err := json.NewDecoder(recorder.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 2 || page.Entries[0].EventID != "event-4" {
+		t.Fatalf("second page %+v, want to continue at event-4", page)
+	}
+
+	for _, target := range []string{"/audit?cursor=bogus", "/audit?limit=-1", "/audit/export?since=never", "/audit/export?cursor=bogus"} {
+		if code := get(target).Code; code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", target, code)
+		}
+	}
+
+	recorder = get("/audit/export")
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("export = %d %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	exported := 0
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		var entry storage.AuditLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("line %d: %v", exported+1, err)
+		}
+		if want := fmt.Sprintf("event-%d", exported); entry.EventID != want {
+			t.Fatalf("line %d is %s, want %s", exported+1, entry.EventID, want)
+		}
+		exported++
+	}
+	if exported != entries {
+		t.Fatalf("exported %d entries, want %d", exported, entries)
+	}
+}
@@ -0,0 +1,298 @@
+package controlplane
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+	"github.com/suyog1pathak/transporter/pkg/router"
+	"github.com/suyog1pathak/transporter/pkg/storage"
+)
+
+// labelResubmittedFrom is set on resubmitted events to the ID of the original
+const labelResubmittedFrom = "transporter.io/resubmitted-from"
+
+// errStatusExists marks an event whose status was saved before it was recorded
+var errStatusExists = errors.New("event status already exists")
+
+// registerEventRoutes adds the event submission, spec and timeline endpoints
+func registerEventRoutes(mux *http.ServeMux, store storage.Store, eventRouter *router.EventRouter) {
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var event model.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid event: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		if err := event.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("Event validation failed: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		logger.Info("Received event via HTTP", "event_id", event.ID, "type", event.Type, "target_agent", event.TargetAgent)
+		acceptEvent(r.Context(), w, &event, store, eventRouter, "event_received_http", nil)
+	})
+
+	mux.HandleFunc("GET /events/{id}/spec", func(w http.ResponseWriter, r *http.Request) {
+		spec, err := store.GetEvent(r.Context(), r.PathValue("id"))
+		if errors.Is(err, model.ErrEventNotFound) {
+			http.Error(w, "Event not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get event: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(spec)
+	})
+
+	// Every change of the event's status, oldest first, with what caused it
+	mux.HandleFunc("GET /events/{id}/timeline", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		eventID := r.PathValue("id")
+
+		status, err := store.GetEventStatus(ctx, eventID)
+		if errors.Is(err, model.ErrStatusNotFound) {
+			http.Error(w, "Event not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get event status: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		timeline, err := store.GetEventTimeline(ctx, eventID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get event timeline: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"event_id": eventID,
+			"state":    status.State,
+			"agent_id": status.AgentID,
+			"timeline": timeline,
+		})
+	})
+
+	mux.HandleFunc("POST /events/{id}/resubmit", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		eventID := r.PathValue("id")
+
+		// The original target is reused unless a new one is given
+		var req struct {
+			TargetAgent    string               `json:"target_agent"`
+			TargetSelector *model.AgentSelector `json:"target_selector"`
+			User           string               `json:"user"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid resubmit request: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		spec, err := store.GetEvent(ctx, eventID)
+		if errors.Is(err, model.ErrEventNotFound) {
+			http.Error(w, "Event not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get event: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		event := spec.Event.Clone()
+		delete(event.Labels, router.LabelParentEvent)
+		event.Labels[labelResubmittedFrom] = eventID
+		if req.TargetAgent != "" || req.TargetSelector != nil {
+			event.TargetAgent = req.TargetAgent
+			event.TargetSelector = req.TargetSelector
+		}
+		if req.User != "" {
+			event.CreatedBy = req.User
+		}
+
+		if err := event.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("Event validation failed: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		logger.Info("Resubmitting event", "event_id", event.ID, "original_event_id", eventID, "target_agent", event.TargetAgent)
+		acceptEvent(ctx, w, event, store, eventRouter, "event_resubmitted", map[string]interface{}{
+			"resubmitted_from": eventID,
+		})
+	})
+}
+
+// acceptEvent persists and routes a submitted event, then writes the response.
+// action and details describe the submission in the audit log.
+func acceptEvent(ctx context.Context, w http.ResponseWriter, event *model.Event, store storage.Store,
+	eventRouter *router.EventRouter, action string, details map[string]interface{}) {
+
+	if err := recordEvent(ctx, store, event, action, details); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to persist event: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if event.TargetSelector != nil {
+		result, err := routeBySelector(ctx, store, eventRouter, event)
+		if err != nil {
+			writeRouteError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":   "accepted",
+			"event_id": event.ID,
+			"routed":   result.Routed,
+			"skipped":  result.Skipped,
+			"message":  fmt.Sprintf("Event routed to %d agents", len(result.Routed)),
+		})
+		return
+	}
+
+	if err := eventRouter.RouteEvent(event); err != nil {
+		writeRouteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "accepted",
+		"event_id": event.ID,
+		"message":  "Event routed to agent",
+	})
+}
+
+// recordEvent persists a submitted event with its initial created status, so its
+// status and timeline are available before it is routed, and counts and audits its
+// arrival
+func recordEvent(ctx context.Context, store storage.Store, event *model.Event, action string, details map[string]interface{}) error {
+	spec, err := store.SaveEvent(ctx, event)
+	if err != nil {
+		logger.Error("Failed to persist event", "event_id", event.ID, "error", err)
+		return err
+	}
+
+	writeID := uuid.NewString()
+	err = writeStatus(ctx, store, "event_created", func(ctx context.Context) error {
+		status, err := store.UpdateEventStatus(ctx, event.ID, storage.IdempotentUpdate(writeID, func(status *model.EventStatus) (*model.EventStatus, error) {
+			if status != nil {
+				return nil, errStatusExists
+			}
+			status = model.NewEventStatus(event.ID, event.TargetAgent)
+			status.State = model.StateCreated
+			status.Phase = ""
+			status.Message = "Event created"
+			status.Cause = &model.Cause{Actor: model.ActorUser, ID: event.CreatedBy, Reason: action}
+			describeEvent(status, event)
+			return status, nil
+		}))
+		if errors.Is(err, storage.ErrAlreadyApplied) || errors.Is(err, errStatusExists) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		recordTransition(ctx, store, status, "")
+		return nil
+	})
+	if err != nil {
+		logger.Error("Failed to save initial event status", "event_id", event.ID, "error", err)
+	}
+
+	if details == nil {
+		details = make(map[string]interface{})
+	}
+	details["content_hash"] = spec.ContentHash
+	saveAudit(ctx, store, &storage.AuditLogEntry{
+		Timestamp: time.Now(),
+		EventID:   event.ID,
+		AgentID:   event.TargetAgent,
+		Action:    action,
+		User:      event.CreatedBy,
+		Details:   details,
+	})
+	return nil
+}
+
+// routeBySelector fans a selector event out and persists the child events
+func routeBySelector(ctx context.Context, store storage.Store, eventRouter *router.EventRouter, event *model.Event) (*router.FanoutResult, error) {
+	result, err := eventRouter.RouteBySelector(event)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, child := range result.Events {
+		if _, err := store.SaveEvent(ctx, child); err != nil {
+			logger.Error("Failed to persist child event", "event_id", child.ID, "parent_event_id", event.ID, "error", err)
+		}
+	}
+
+	logger.Info("Event fanned out", "event_id", event.ID, "routed", len(result.Routed), "skipped", len(result.Skipped))
+	saveAudit(ctx, store, &storage.AuditLogEntry{
+		Timestamp: time.Now(),
+		EventID:   event.ID,
+		Action:    "event_fanned_out",
+		User:      event.CreatedBy,
+		Details: map[string]interface{}{
+			"selector": event.TargetSelector,
+			"routed":   result.Routed,
+			"skipped":  result.Skipped,
+		},
+	})
+	return result, nil
+}
+
+// recoverQueuedEvents re-queues events that were waiting for their agent when the
+// control plane last stopped, including events failover requeued after their agent was lost
+func recoverQueuedEvents(ctx context.Context, store storage.Store, eventRouter *router.EventRouter) {
+	recovered := 0
+	for _, state := range []model.ExecutionState{model.StateQueued, model.StateUnknown} {
+		eventIDs, err := store.ListEventsByState(ctx, state)
+		if err != nil {
+			logger.Error("Failed to list events", "state", state, "error", err)
+			continue
+		}
+
+		for _, eventID := range eventIDs {
+			spec, err := store.GetEvent(ctx, eventID)
+			if err != nil {
+				logger.Warn("Cannot recover queued event", "event_id", eventID, "error", err)
+				continue
+			}
+			if state == model.StateUnknown {
+				if spec.Event.Failover != model.FailoverRequeue {
+					continue
+				}
+				err = eventRouter.RequeueEvent(spec.Event)
+			} else {
+				err = eventRouter.RouteEvent(spec.Event)
+			}
+			if err != nil {
+				logger.Warn("Failed to re-queue event", "event_id", eventID, "error", err)
+				continue
+			}
+			recovered++
+		}
+	}
+	if recovered > 0 {
+		logger.Info("Recovered queued events", "count", recovered)
+	}
+}
@@ -0,0 +1,164 @@
+package controlplane
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+	"github.com/suyog1pathak/transporter/pkg/registry"
+	"github.com/suyog1pathak/transporter/pkg/router"
+	"github.com/suyog1pathak/transporter/pkg/storage"
+)
+
+func TestRecordedEventHasTimeline(t *testing.T) {
+	logger.InitLogger(false)
+	ctx := t.Context()
+	store := storage.NewMemoryStorage()
+	mux := http.NewServeMux()
+	registerEventRoutes(mux, store, nil)
+
+	timeline := func(eventID string) (int, []*model.StatusChange) {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/events/"+eventID+"/timeline", nil))
+		var body struct {
+			State    model.ExecutionState  `json:"state"`
+			Timeline []*model.StatusChange `json:"timeline"`
+		}
+		if recorder.Code == http.StatusOK {
+			if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return recorder.Code, body.Timeline
+	}
+
+	if code, _ := timeline("event-1"); code != http.StatusNotFound {
+		t.Fatalf("timeline of an unknown event = %d, want 404", code)
+	}
+
+	event := &model.Event{
+		ID:          "event-1",
+		Type:        model.EventTypeK8sResource,
+		TargetAgent: "agent-1",
+		CreatedBy:   "alice",
+		Payload:     model.EventPayload{Manifests: []string{"kind: ConfigMap"}},
+	}
+	// Recording the same event twice keeps its first status
+	for range 2 {
+		if err := recordEvent(ctx, store, event, "event_received_http", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	code, changes := timeline("event-1")
+	if code != http.StatusOK {
+		t.Fatalf("timeline of a recorded event = %d, want 200", code)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("%d timeline entries, want 1", len(changes))
+	}
+	change := changes[0]
+	if change.From != "" || change.To != model.StateCreated || change.AgentID != "agent-1" || change.CreatedBy != "alice" {
+		t.Fatalf("first change %+v, want the event created for agent-1 by alice", change)
+	}
+	if change.Cause == nil || change.Cause.Actor != model.ActorUser || change.Cause.Reason != "event_received_http" {
+		t.Fatalf("first change cause %+v, want the submitting user", change.Cause)
+	}
+
+	stats, err := store.GetEventStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created := stats.Totals["total"]; created != 1 {
+		t.Fatalf("counted %d created events, want 1", created)
+	}
+}
+
+func TestResubmitEvent(t *testing.T) {
+	logger.InitLogger(false)
+	ctx := t.Context()
+	store := storage.NewMemoryStorage()
+	eventRouter := router.NewEventRouter(router.Config{
+		Registry:      registry.NewAgentRegistry(registry.Config{HeartbeatTimeout: time.Hour}),
+		RetryInterval: time.Hour,
+	})
+	mux := http.NewServeMux()
+	registerEventRoutes(mux, store, eventRouter)
+
+	resubmit := func(eventID, body string) (int, string) {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/events/"+eventID+"/resubmit", strings.NewReader(body))
+		mux.ServeHTTP(recorder, request)
+		var response struct {
+			EventID string `json:"event_id"`
+		}
+		json.NewDecoder(recorder.Body).Decode(&response)
+		return recorder.Code, response.EventID
+	}
+
+	if code, _ := resubmit("event-1", ""); code != http.StatusNotFound {
+		t.Fatalf("resubmitting an unknown event = %d, want 404", code)
+	}
+
+	original := model.NewEvent(model.EventTypeK8sResource, "agent-1",
+		model.EventPayload{Manifests: []string{"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings"}}, "alice")
+	original.ID = "event-1"
+	original.Labels = map[string]string{"team": "payments", router.LabelParentEvent: "rollout-1"}
+	if err := recordEvent(ctx, store, original, "event_received_http", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		body      string
+		wantAgent string
+		wantUser  string
+	}{
+		{name: "same target", wantAgent: "agent-1", wantUser: "alice"},
+		{name: "new target and user", body: `{"target_agent":"agent-2","user":"bob"}`, wantAgent: "agent-2", wantUser: "bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, eventID := resubmit(original.ID, tt.body)
+			if code != http.StatusAccepted || eventID == "" || eventID == original.ID {
+				t.Fatalf("resubmit = %d with event %q, want 202 and a new event", code, eventID)
+			}
+
+			spec, err := store.GetEvent(ctx, eventID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			event := spec.Event
+			if spec.ContentHash != original.ContentHash() || !slices.Equal(event.Payload.Manifests, original.Payload.Manifests) {
+				t.Fatalf("resubmitted event %s does not apply the original's content", eventID)
+			}
+			if event.TargetAgent != tt.wantAgent || event.CreatedBy != tt.wantUser {
+				t.Fatalf("resubmitted to %s by %s, want %s by %s", event.TargetAgent, event.CreatedBy, tt.wantAgent, tt.wantUser)
+			}
+			want := map[string]string{"team": "payments", labelResubmittedFrom: original.ID}
+			if !maps.Equal(event.Labels, want) {
+				t.Fatalf("labels %v, want %v", event.Labels, want)
+			}
+
+			status, err := store.GetEventStatus(ctx, eventID)
+			if err != nil || status.Cause == nil || status.Cause.Reason != "event_resubmitted" {
+				t.Fatalf("status %+v, err %v; want one created by the resubmit", status, err)
+			}
+			page, err := store.QueryAuditLog(ctx, storage.AuditQuery{EventID: eventID, Action: "event_resubmitted"})
+			if err != nil || len(page.Entries) != 1 || page.Entries[0].Details["resubmitted_from"] != original.ID {
+				t.Fatalf("audit entries %v, err %v; want the resubmit recorded", page, err)
+			}
+		})
+	}
+
+	if code, _ := resubmit(original.ID, "{"); code != http.StatusBadRequest {
+		t.Fatalf("resubmit with a malformed body = %d, want 400", code)
+	}
+}
@@ -3,13 +3,17 @@ package controlplane
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/suyog1pathak/transporter/internal/model"
 	"github.com/suyog1pathak/transporter/pkg/logger"
@@ -17,14 +21,34 @@ import (
 	"github.com/suyog1pathak/transporter/pkg/registry"
 	"github.com/suyog1pathak/transporter/pkg/router"
 	"github.com/suyog1pathak/transporter/pkg/storage"
+	"github.com/suyog1pathak/transporter/pkg/upgrade"
+	"github.com/suyog1pathak/transporter/pkg/version"
 )
 
+// agentWriteTimeout bounds each write to an agent, so an agent that stops reading
+// is disconnected instead of stalling its writer
+const agentWriteTimeout = 10 * time.Second
+
 // Config holds all configuration for the control plane server.
 type Config struct {
 	// WebSocket Server
 	WSAddr string
 	WSPort int
 
+	// Connection Admission
+	AllowedOrigins      []string      // Allowed Origin headers for browser clients ("*" allows any)
+	RegistrationTimeout time.Duration // Deadline for an agent to send its registration after connecting
+	MaxMessageSize      int64         // Maximum size in bytes of a single inbound message
+	ConnRateLimit       float64       // New connections per second allowed per client IP (0 disables)
+	ConnRateBurst       int           // Burst size for the per-IP connection rate limit
+	MaxAgents           int           // Maximum number of registered agents (0 for unlimited)
+
+	// Agent Transport
+	Encodings          []string // Payload encodings agents may negotiate (json, msgpack)
+	Compressions       []string // Compressions agents may negotiate (none, deflate, zstd)
+	MaxFrameSize       int      // Outbound messages larger than this are chunked (0 disables chunking)
+	MaxReassembledSize int      // Maximum size of a reassembled chunked message
+
 	// Memphis Config
 	MemphisEnabled         bool
 	MemphisHost            string
@@ -34,14 +58,32 @@ type Config struct {
 	MemphisStation         string
 	MemphisAccountID       int
 
+	// Storage Config
+	StorageBackend string               // redis, bolt or memory
+	BoltPath       string               // Database file for the bolt backend
+	StorageBuffer  storage.BufferConfig // Write-behind buffer for status and audit writes while storage is unavailable
+
+	// Retention
+	StatusRetention time.Duration // How long event statuses and their agent index entries are kept
+	AuditMaxLen     int64         // Audit entries kept
+	AuditMaxAge     time.Duration // Audit entries older than this are trimmed (0 keeps them)
+	CompactInterval time.Duration // How often expired data and dangling index entries are pruned
+
+	// Audit Checkpoints
+	AuditSigningKey         string        // PEM ed25519 private key signing audit checkpoints (empty disables them)
+	AuditCheckpointInterval time.Duration // How often the audit chain head is signed
+
 	// Redis Config
-	RedisAddr     string
-	RedisPassword string
-	RedisDB       int
+	Redis storage.Config // Connection, HA mode, TLS and pool settings; retention is set from the fields above
 
 	// Health & Timeouts
-	HeartbeatTimeout time.Duration
-	EventRetryMax    int
+	HeartbeatTimeout     time.Duration
+	UnhealthyGracePeriod time.Duration // Silence after the heartbeat timeout before an agent is considered lost
+	EventRetryMax        int
+
+	// Agent Upgrades
+	UpgradeBatchSize       int           // Agents upgraded in parallel per stage after the canary
+	UpgradeRegisterTimeout time.Duration // Time for an upgraded agent to register its new version
 
 	Debug bool
 }
@@ -51,18 +93,28 @@ func Run(cfg Config) error {
 	logger.InitLogger(cfg.Debug)
 	logger.Info("Starting Transporter Control Plane")
 
-	// Initialize Redis storage
-	logger.Info("Connecting to Redis", "addr", cfg.RedisAddr)
-	redisStorage, err := storage.NewRedisStorage(storage.Config{
-		Addr:     cfg.RedisAddr,
-		Password: cfg.RedisPassword,
-		DB:       cfg.RedisDB,
-	})
+	// Callbacks outside a request use the background context; each storage
+	// operation is bounded by the backend's own timeout
+	ctx := context.Background()
+
+	// Initialize storage. Status and audit writes are buffered while the backend is
+	// unavailable, and readiness reports its health.
+	backend, err := openStore(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to connect to Redis: %w", err)
+		return err
+	}
+	store := storage.NewBufferedStore(backend, cfg.StorageBuffer)
+	defer store.Close()
+	if compactor, ok := backend.(storage.Compactor); ok && cfg.CompactInterval > 0 {
+		go runCompactor(compactor, cfg.CompactInterval)
+	}
+	if cfg.AuditSigningKey != "" {
+		key, err := storage.LoadAuditSigningKey(cfg.AuditSigningKey)
+		if err != nil {
+			return fmt.Errorf("failed to load audit signing key: %w", err)
+		}
+		go runAuditCheckpoints(store, key, cfg.AuditCheckpointInterval)
 	}
-	defer redisStorage.Close()
-	logger.Info("Redis connected")
 
 	// Initialize Memphis queue (optional)
 	var memphisQueue *queue.MemphisQueue
@@ -85,17 +137,23 @@ func Run(cfg Config) error {
 		logger.Info("Memphis disabled, skipping event consumption")
 	}
 
+	// The registry fails over lost agents' events through the router created below
+	var eventRouter *router.EventRouter
+
 	// Initialize agent registry
 	logger.Info("Initializing agent registry")
-	agentRegistry := registry.NewAgentRegistry(registry.Config{
+	var agentRegistry *registry.AgentRegistry
+	agentRegistry = registry.NewAgentRegistry(registry.Config{
 		HeartbeatTimeout:       cfg.HeartbeatTimeout,
 		HeartbeatCheckInterval: 10 * time.Second,
+		UnhealthyGracePeriod:   cfg.UnhealthyGracePeriod,
+		MaxAgents:              cfg.MaxAgents,
 		OnAgentConnected: func(agent *model.Agent) {
 			logger.Info("Agent connected", "agent_id", agent.ID, "cluster", agent.ClusterName, "region", agent.Region)
-			if err := redisStorage.SaveAgent(agent); err != nil {
+			if err := store.SaveAgent(ctx, agent); err != nil {
 				logger.Warn("Failed to save agent state", "error", err)
 			}
-			redisStorage.SaveAuditLog(&storage.AuditLogEntry{
+			saveAudit(ctx, store, &storage.AuditLogEntry{
 				Timestamp: time.Now(),
 				AgentID:   agent.ID,
 				Action:    "agent_connected",
@@ -104,52 +162,181 @@ func Run(cfg Config) error {
 		OnAgentDisconnected: func(agent *model.Agent) {
 			logger.Info("Agent disconnected", "agent_id", agent.ID)
 			agent.MarkDisconnected()
-			redisStorage.SaveAgent(agent)
-			redisStorage.SaveAuditLog(&storage.AuditLogEntry{
+			// Callbacks run unlocked, so the agent may have reconnected already; keep its new state
+			if live, err := agentRegistry.GetAgent(agent.ID); err != nil || live == agent {
+				if err := store.SaveAgent(ctx, agent); err != nil {
+					logger.Warn("Failed to save agent state", "agent_id", agent.ID, "error", err)
+				}
+			}
+			saveAudit(ctx, store, &storage.AuditLogEntry{
 				Timestamp: time.Now(),
 				AgentID:   agent.ID,
 				Action:    "agent_disconnected",
 			})
 		},
+		OnAgentUnhealthy: func(agent *model.Agent) {
+			if err := store.SaveAgent(ctx, agent); err != nil {
+				logger.Warn("Failed to save agent state", "error", err)
+			}
+			saveAudit(ctx, store, &storage.AuditLogEntry{
+				Timestamp: time.Now(),
+				AgentID:   agent.ID,
+				Action:    "agent_unhealthy",
+				Details:   map[string]interface{}{"last_heartbeat": agent.LastHeartbeat},
+			})
+		},
+		OnAgentLost: func(agent *model.Agent) {
+			failedOver := eventRouter.FailoverAgent(agent.ID, fmt.Sprintf("Agent %s lost (no heartbeat since %s)",
+				agent.ID, agent.LastHeartbeat.Format(time.RFC3339)))
+			logger.Warn("Agent lost, connection closed", "agent_id", agent.ID, "failed_over_events", failedOver)
+			saveAudit(ctx, store, &storage.AuditLogEntry{
+				Timestamp: time.Now(),
+				AgentID:   agent.ID,
+				Action:    "agent_lost",
+				Details: map[string]interface{}{
+					"last_heartbeat":     agent.LastHeartbeat,
+					"failed_over_events": failedOver,
+				},
+			})
+		},
 	})
 	logger.Info("Agent registry initialized")
+	restoreCordons(ctx, agentRegistry, store)
 
 	// Initialize event router
 	logger.Info("Initializing event router")
-	eventRouter := router.NewEventRouter(router.Config{
-		Registry:      agentRegistry,
-		MaxRetries:    cfg.EventRetryMax,
-		RetryInterval: 30 * time.Second,
+	eventRouter = router.NewEventRouter(router.Config{
+		Registry:            agentRegistry,
+		MaxRetries:          cfg.EventRetryMax,
+		RetryInterval:       30 * time.Second,
+		FailoverGracePeriod: cfg.UnhealthyGracePeriod,
+		AgentLookup: func(agentID string) (*model.Agent, error) {
+			return store.GetAgent(ctx, agentID)
+		},
+		AgentLister: func() ([]*model.Agent, error) {
+			return listPersistedAgents(ctx, store)
+		},
+		AgentRevoked: func(agentID string) (bool, error) {
+			return store.IsAgentRevoked(ctx, agentID)
+		},
 		OnEventRouted: func(event *model.Event, agentID string) {
 			logger.Info("Event routed to agent", "event_id", event.ID, "agent_id", agentID)
-			status := model.NewEventStatus(event.ID, agentID)
-			status.UpdateState(model.StateAssigned, "Event routed to agent")
-			redisStorage.SaveEventStatus(status)
-			redisStorage.IncrementEventStateCount(model.StateAssigned)
+			saveAudit(ctx, store, &storage.AuditLogEntry{
+				Timestamp: time.Now(),
+				EventID:   event.ID,
+				AgentID:   agentID,
+				Action:    "event_routed",
+				User:      event.CreatedBy,
+				Details:   map[string]interface{}{"type": event.Type},
+			})
+			transitionEvent(ctx, store, event, agentID, model.StateAssigned, routerCause("event_routed"), func(status *model.EventStatus) {
+				status.UpdateState(model.StateAssigned, "Event routed to agent")
+			})
 		},
 		OnEventQueued: func(event *model.Event, agentID string) {
 			logger.Info("Event queued for offline agent", "event_id", event.ID, "agent_id", agentID)
-			status := model.NewEventStatus(event.ID, agentID)
-			status.UpdateState(model.StateQueued, "Agent offline, event queued")
-			redisStorage.SaveEventStatus(status)
-			redisStorage.IncrementEventStateCount(model.StateQueued)
+			saveAudit(ctx, store, &storage.AuditLogEntry{
+				Timestamp: time.Now(),
+				EventID:   event.ID,
+				AgentID:   agentID,
+				Action:    "event_queued",
+				User:      event.CreatedBy,
+				Details:   map[string]interface{}{"type": event.Type},
+			})
+			transitionEvent(ctx, store, event, agentID, model.StateQueued, routerCause("event_queued"), func(status *model.EventStatus) {
+				status.UpdateState(model.StateQueued, "Agent offline, event queued")
+			})
 		},
 		OnEventExpired: func(event *model.Event) {
 			logger.Warn("Event expired", "event_id", event.ID)
-			status := model.NewEventStatus(event.ID, event.TargetAgent)
-			status.MarkExpired()
-			redisStorage.SaveEventStatus(status)
-			redisStorage.IncrementEventStateCount(model.StateExpired)
+			transitionEvent(ctx, store, event, event.TargetAgent, model.StateExpired, routerCause("event_expired"), func(status *model.EventStatus) {
+				status.MarkExpired()
+			})
 		},
 		OnEventFailed: func(event *model.Event, err error) {
 			logger.Error("Event failed", "event_id", event.ID, "error", err)
-			status := model.NewEventStatus(event.ID, event.TargetAgent)
-			status.MarkFailed(err.Error())
-			redisStorage.SaveEventStatus(status)
-			redisStorage.IncrementEventStateCount(model.StateFailed)
+			transitionEvent(ctx, store, event, event.TargetAgent, model.StateFailed, routerCause("event_failed"), func(status *model.EventStatus) {
+				status.MarkFailed(err.Error())
+			})
+		},
+		OnEventUnknown: func(event *model.Event, reason string) {
+			logger.Warn("Event outcome unknown", "event_id", event.ID, "agent_id", event.TargetAgent, "reason", reason)
+			// Rejected if the final status arrived after all
+			transitionEvent(ctx, store, event, event.TargetAgent, model.StateUnknown, routerCause("agent_lost"), func(status *model.EventStatus) {
+				status.MarkUnknown(reason)
+			})
 		},
 	})
 	logger.Info("Event router initialized")
+	recoverQueuedEvents(ctx, store, eventRouter)
+
+	upgrades := upgrade.NewOrchestrator(upgrade.Config{
+		Route: func(event *model.Event) error {
+			if _, err := store.SaveEvent(ctx, event); err != nil {
+				return fmt.Errorf("failed to persist upgrade event: %w", err)
+			}
+			return eventRouter.RouteEvent(event)
+		},
+		LiveAgent: agentRegistry.GetAgent,
+		EventStatus: func(eventID string) (*model.EventStatus, error) {
+			return store.GetEventStatus(ctx, eventID)
+		},
+		Agents: func() ([]*model.Agent, error) {
+			return listPersistedAgents(ctx, store)
+		},
+		DefaultBatchSize:       cfg.UpgradeBatchSize,
+		DefaultRegisterTimeout: cfg.UpgradeRegisterTimeout,
+		OnAgentProgress: func(rollout *upgrade.Rollout, progress *upgrade.AgentProgress) {
+			if progress.State == upgrade.StateSucceeded {
+				// The upgrade event completed when the Deployment was patched; record the confirmation
+				writeID := uuid.NewString()
+				err := writeStatus(ctx, store, "upgrade_confirmation", func(ctx context.Context) error {
+					_, err := store.UpdateEventStatus(ctx, progress.EventID, storage.IdempotentUpdate(writeID, func(status *model.EventStatus) (*model.EventStatus, error) {
+						if status == nil {
+							return nil, model.ErrStatusNotFound
+						}
+						status.Cause = &model.Cause{Actor: model.ActorControlPlane, Reason: "agent_upgrade_confirmed"}
+						status.AddLog(model.LogLevelInfo, model.PhaseVerifying, progress.Message, nil)
+						return status, nil
+					}))
+					if errors.Is(err, storage.ErrAlreadyApplied) {
+						return nil
+					}
+					return err
+				})
+				if err != nil && !errors.Is(err, model.ErrStatusNotFound) {
+					logger.Error("Failed to save event status", "event_id", progress.EventID, "error", err)
+				}
+			}
+			saveAudit(ctx, store, &storage.AuditLogEntry{
+				Timestamp: time.Now(),
+				EventID:   progress.EventID,
+				AgentID:   progress.AgentID,
+				Action:    "agent_upgrade_" + string(progress.State),
+				User:      rollout.CreatedBy,
+				Details: map[string]interface{}{
+					"rollout_id":   rollout.ID,
+					"version":      rollout.Version,
+					"from_version": progress.FromVersion,
+					"stage":        progress.Stage,
+					"message":      progress.Message,
+				},
+			})
+		},
+		OnRolloutFinished: func(rollout *upgrade.Rollout) {
+			saveAudit(ctx, store, &storage.AuditLogEntry{
+				Timestamp: time.Now(),
+				Action:    "agent_upgrade_rollout_" + string(rollout.State),
+				User:      rollout.CreatedBy,
+				Details: map[string]interface{}{
+					"rollout_id": rollout.ID,
+					"image":      rollout.Image,
+					"version":    rollout.Version,
+					"message":    rollout.Message,
+				},
+			})
+		},
+	})
 
 	// Start Memphis event consumer (if enabled)
 	if cfg.MemphisEnabled && memphisQueue != nil {
@@ -157,15 +344,13 @@ func Run(cfg Config) error {
 		go func() {
 			err := memphisQueue.ConsumeEvents("transporter-cp-consumer", func(event *model.Event) error {
 				logger.Info("Received event", "event_id", event.ID, "type", event.Type, "target_agent", event.TargetAgent)
-				redisStorage.IncrementEventCount()
-				redisStorage.IncrementEventStateCount(model.StateCreated)
-				redisStorage.SaveAuditLog(&storage.AuditLogEntry{
-					Timestamp: time.Now(),
-					EventID:   event.ID,
-					AgentID:   event.TargetAgent,
-					Action:    "event_received",
-					User:      event.CreatedBy,
-				})
+				if err := recordEvent(ctx, store, event, "event_received", nil); err != nil {
+					return err
+				}
+				if event.TargetSelector != nil {
+					_, err := routeBySelector(ctx, store, eventRouter, event)
+					return err
+				}
 				return eventRouter.RouteEvent(event)
 			})
 			if err != nil {
@@ -178,77 +363,138 @@ func Run(cfg Config) error {
 	// Set up HTTP handlers
 	mux := http.NewServeMux()
 
+	admission := newAdmissionController(cfg)
 	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true // TODO: Add proper origin checking
-		},
+		CheckOrigin:       admission.CheckOrigin,
+		EnableCompression: slices.Contains(cfg.Compressions, string(model.CompressionDeflate)),
 	}
 
 	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		handleAgentConnection(w, r, &upgrader, agentRegistry, redisStorage, eventRouter)
+		handleAgentConnection(w, r, &upgrader, admission, cfg, agentRegistry, store, eventRouter)
 	})
 
-	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+	registerEventRoutes(mux, store, eventRouter)
+
+	mux.HandleFunc("POST /events/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		eventID := r.PathValue("id")
+
+		var req struct {
+			Reason string `json:"reason"`
+			User   string `json:"user"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid cancel request: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Reason == "" {
+			req.Reason = "Cancelled by request"
 		}
 
-		var event model.Event
-		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-			http.Error(w, fmt.Sprintf("Invalid event: %v", err), http.StatusBadRequest)
+		status, err := store.GetEventStatus(ctx, eventID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Event not found: %v", err), http.StatusNotFound)
+			return
+		}
+		if status.IsTerminal() {
+			http.Error(w, fmt.Sprintf("Event already %s", status.State), http.StatusConflict)
 			return
 		}
 
-		if err := event.Validate(); err != nil {
-			http.Error(w, fmt.Sprintf("Event validation failed: %v", err), http.StatusBadRequest)
+		wasQueued, err := eventRouter.CancelEvent(eventID, status.AgentID, req.Reason)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to cancel event: %v", err), http.StatusBadGateway)
 			return
 		}
 
-		logger.Info("Received event via HTTP", "event_id", event.ID, "type", event.Type, "target_agent", event.TargetAgent)
-		redisStorage.IncrementEventCount()
-		redisStorage.IncrementEventStateCount(model.StateCreated)
-		redisStorage.SaveAuditLog(&storage.AuditLogEntry{
+		message := "Cancellation sent to agent"
+		if wasQueued {
+			cause := model.Cause{Actor: model.ActorUser, ID: req.User, Reason: "cancel_requested"}
+			transitionEvent(ctx, store, &model.Event{ID: eventID, Type: status.EventType, CreatedBy: status.CreatedBy}, status.AgentID, model.StateCancelled, cause, func(status *model.EventStatus) {
+				status.MarkCancelled(req.Reason)
+			})
+			message = "Queued event cancelled"
+		}
+
+		logger.Info("Event cancellation requested", "event_id", eventID, "agent_id", status.AgentID, "queued", wasQueued)
+		saveAudit(ctx, store, &storage.AuditLogEntry{
 			Timestamp: time.Now(),
-			EventID:   event.ID,
-			AgentID:   event.TargetAgent,
-			Action:    "event_received_http",
-			User:      event.CreatedBy,
+			EventID:   eventID,
+			AgentID:   status.AgentID,
+			Action:    "event_cancel_requested",
+			User:      req.User,
+			Details:   map[string]interface{}{"reason": req.Reason, "queued": wasQueued},
 		})
 
-		if err := eventRouter.RouteEvent(&event); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to route event: %v", err), http.StatusInternalServerError)
-			return
-		}
-
 		w.WriteHeader(http.StatusAccepted)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status":   "accepted",
-			"event_id": event.ID,
-			"message":  "Event routed to agent",
+			"event_id": eventID,
+			"message":  message,
 		})
 	})
 
+	registerAgentRoutes(mux, agentRegistry, store, eventRouter)
+	registerUpgradeRoutes(mux, upgrades, store)
+	registerAuditRoutes(mux, store)
+
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"status":      "healthy",
 			"agent_count": agentRegistry.Count(),
-			"version":     "0.1.0",
+			"version":     version.Version,
+			"storage":     store.Health(),
+		})
+	})
+
+	// Readiness fails while storage is unreachable, so traffic goes to a control plane
+	// that can persist it; writes meanwhile are buffered
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		health := store.Health()
+		status, code := "ready", http.StatusOK
+		if !health.Healthy {
+			status, code = "storage_unavailable", http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  status,
+			"storage": health,
 		})
 	})
 
 	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
-		stats, _ := redisStorage.GetEventStats()
+		var events map[string]int64
+		if stats, err := store.GetEventStats(r.Context()); err == nil {
+			events = stats.Totals
+		}
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"agents": map[string]interface{}{
 				"total":     agentRegistry.Count(),
 				"connected": len(agentRegistry.ListConnected()),
 			},
-			"events": stats,
+			"events": events,
+			"connections": map[string]interface{}{
+				"rejected": admission.Rejections(),
+			},
 		})
 	})
 
+	mux.HandleFunc("GET /stats/events", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := store.GetEventStats(r.Context())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get event stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	})
+
 	server := &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", cfg.WSAddr, cfg.WSPort),
 		Handler: mux,
@@ -269,6 +515,7 @@ func Run(cfg Config) error {
 	logger.Info("Control Plane started successfully!")
 	logger.Info("WebSocket endpoint", "url", fmt.Sprintf("ws://%s:%d/ws", cfg.WSAddr, cfg.WSPort))
 	logger.Info("Health endpoint", "url", fmt.Sprintf("http://%s:%d/health", cfg.WSAddr, cfg.WSPort))
+	logger.Info("Readiness endpoint", "url", fmt.Sprintf("http://%s:%d/ready", cfg.WSAddr, cfg.WSPort))
 	logger.Info("Metrics endpoint", "url", fmt.Sprintf("http://%s:%d/metrics", cfg.WSAddr, cfg.WSPort))
 
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -279,56 +526,296 @@ func Run(cfg Config) error {
 }
 
 func handleAgentConnection(w http.ResponseWriter, r *http.Request, upgrader *websocket.Upgrader,
-	agentRegistry *registry.AgentRegistry, redisStorage *storage.RedisStorage, eventRouter *router.EventRouter) {
+	admission *admissionController, cfg Config, agentRegistry *registry.AgentRegistry,
+	store storage.Store, eventRouter *router.EventRouter) {
+
+	ctx := r.Context()
+	ip := clientIP(r)
+
+	if !admission.CheckOrigin(r) {
+		logger.Warn("Rejected connection with disallowed origin", "remote_addr", r.RemoteAddr, "origin", r.Header.Get("Origin"))
+		auditConnectionRejected(store, admission, r, "", "origin_not_allowed")
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	if !admission.AllowConnection(ip) {
+		logger.Warn("Rejected connection due to rate limit", "remote_addr", r.RemoteAddr)
+		auditConnectionRejected(store, admission, r, "", "rate_limited")
+		http.Error(w, "Too many connection attempts", http.StatusTooManyRequests)
+		return
+	}
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		logger.Error("Failed to upgrade connection", "error", err)
 		return
 	}
+	deflateNegotiated := upgrader.EnableCompression &&
+		strings.Contains(r.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+
+	if cfg.MaxMessageSize > 0 {
+		conn.SetReadLimit(cfg.MaxMessageSize)
+	}
+
+	// The agent must register within the handshake deadline
+	if cfg.RegistrationTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(cfg.RegistrationTimeout))
+	}
 
-	var registration model.AgentRegistration
-	if err := conn.ReadJSON(&registration); err != nil {
-		logger.Error("Failed to read registration", "error", err)
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		logger.Error("Failed to read registration", "remote_addr", r.RemoteAddr, "error", err)
+		auditConnectionRejected(store, admission, r, "", "registration_not_received")
 		conn.Close()
 		return
 	}
+	conn.SetReadDeadline(time.Time{})
+
+	// Registration errors are reported in the agent's own dialect, or the
+	// current protocol if negotiation itself failed
+	codec := model.NewCodec(model.CodecConfig{Version: model.ProtocolVersion})
+	rejectRegistration := func(agentID, reason string, err error) {
+		auditConnectionRejected(store, admission, r, agentID, reason)
+		if frames, _, encErr := codec.Encode(model.MessageTypeError, &model.ErrorMessage{
+			Code:    reason,
+			Message: err.Error(),
+		}); encErr == nil {
+			writeFrames(conn, frames)
+		}
+		conn.Close()
+	}
+
+	env, err := model.DecodeEnvelope(data)
+	if err != nil || env.Type != model.MessageTypeRegister {
+		if err == nil {
+			err = fmt.Errorf("expected %s message, got %q", model.MessageTypeRegister, env.Type)
+		}
+		logger.Error("Invalid registration message", "remote_addr", r.RemoteAddr, "error", err)
+		rejectRegistration("", "invalid_registration", err)
+		return
+	}
+
+	var register model.RegisterMessage
+	if err := env.DecodePayload(&register); err != nil {
+		logger.Error("Invalid registration message", "remote_addr", r.RemoteAddr, "error", err)
+		rejectRegistration("", "invalid_registration", err)
+		return
+	}
+	registration := register.AgentRegistration
+
+	version, err := model.NegotiateProtocolVersion(register.ProtocolVersions)
+	if err != nil {
+		logger.Error("Protocol negotiation failed", "agent_id", registration.ID, "offered", register.ProtocolVersions)
+		rejectRegistration(registration.ID, "unsupported_protocol_version", err)
+		return
+	}
+	codec = model.NewCodec(model.CodecConfig{Version: version})
+
+	// Transport settings apply after the registered response, which is always plain JSON
+	connCodec := codec
+	if version > model.ProtocolVersionLegacy {
+		connCodec = codec.WithConfig(model.CodecConfig{
+			Version:        version,
+			Encoding:       model.NegotiateEncoding(register.Encodings, toEncodings(cfg.Encodings)),
+			Compression:    negotiateCompression(register.Compressions, cfg.Compressions, deflateNegotiated),
+			MaxFrameSize:   model.NegotiateFrameSize(register.MaxFrameSize, cfg.MaxFrameSize),
+			MaxMessageSize: cfg.MaxReassembledSize,
+		})
+	}
 
 	if err := registration.Validate(); err != nil {
 		logger.Error("Invalid registration", "error", err)
-		conn.WriteJSON(map[string]string{"error": err.Error()})
-		conn.Close()
+		rejectRegistration(registration.ID, "invalid_registration", err)
 		return
 	}
 
-	agent, err := agentRegistry.Register(&registration, conn, r.RemoteAddr)
+	revoked, err := store.IsAgentRevoked(ctx, registration.ID)
+	if err != nil {
+		logger.Warn("Failed to check agent revocation", "agent_id", registration.ID, "error", err)
+	}
+	if revoked {
+		logger.Warn("Rejected decommissioned agent", "agent_id", registration.ID)
+		rejectRegistration(registration.ID, "agent_revoked", fmt.Errorf("agent %s has been decommissioned", registration.ID))
+		return
+	}
+
+	// Reconnecting agents replace their old connection and do not count against the cap
+	existing, _ := agentRegistry.Get(registration.ID)
+	agent, err := agentRegistry.Register(&registration, conn, r.RemoteAddr, connCodec)
+	if errors.Is(err, registry.ErrMaxAgentsReached) {
+		logger.Warn("Rejected agent, max agents reached", "agent_id", registration.ID, "max_agents", cfg.MaxAgents)
+		rejectRegistration(registration.ID, "max_agents_reached", err)
+		return
+	}
 	if err != nil {
 		logger.Error("Failed to register agent", "error", err)
-		conn.WriteJSON(map[string]string{"error": err.Error()})
-		conn.Close()
+		rejectRegistration(registration.ID, "registration_failed", err)
 		return
 	}
+	if existing != nil {
+		// The agent's previous connection was closed in favour of this one
+		saveAudit(ctx, store, &storage.AuditLogEntry{
+			Timestamp: time.Now(),
+			AgentID:   agent.ID,
+			Action:    "agent_evicted",
+			Details: map[string]interface{}{
+				"reason":          "superseded_by_reconnect",
+				"old_remote_addr": existing.Agent.ConnectionID,
+				"remote_addr":     r.RemoteAddr,
+			},
+		})
+	}
 
-	conn.WriteJSON(map[string]string{
-		"status":  "registered",
-		"message": fmt.Sprintf("Agent %s registered successfully", agent.ID),
+	negotiated := connCodec.Config()
+	registered, _, err := codec.Encode(model.MessageTypeRegistered, &model.RegisteredMessage{
+		AgentID:         agent.ID,
+		ProtocolVersion: version,
+		Message:         fmt.Sprintf("Agent %s registered successfully", agent.ID),
+		Encoding:        negotiated.Encoding,
+		Compression:     negotiated.Compression,
+		MaxFrameSize:    negotiated.MaxFrameSize,
 	})
+	if err == nil {
+		err = writeFrames(conn, registered)
+	}
+	if err != nil {
+		logger.Error("Failed to send registration response", "agent_id", agent.ID, "error", err)
+		agentRegistry.UnregisterConnection(agent.ID, conn)
+		return
+	}
+	logger.Info("Agent registered", "agent_id", agent.ID, "protocol_version", version,
+		"encoding", negotiated.Encoding, "compression", negotiated.Compression, "max_frame_size", negotiated.MaxFrameSize)
 
-	go handleAgentReads(conn, agent, agentRegistry, redisStorage)
+	go handleAgentReads(conn, agent, agentRegistry, store, eventRouter)
 	go handleAgentWrites(conn, agent, agentRegistry)
 }
 
-func handleAgentReads(conn *websocket.Conn, agent *model.Agent,
-	agentRegistry *registry.AgentRegistry, redisStorage *storage.RedisStorage) {
+// openStore opens the configured storage backend
+func openStore(cfg Config) (storage.Store, error) {
+	retention := storage.Retention{
+		EventStatus: cfg.StatusRetention,
+		AuditMaxLen: cfg.AuditMaxLen,
+		AuditMaxAge: cfg.AuditMaxAge,
+	}
+
+	switch cfg.StorageBackend {
+	case storage.BackendRedis, "":
+		redisConfig := cfg.Redis
+		redisConfig.Retention = retention
+		logger.Info("Connecting to Redis", "mode", redisConfig.Mode, "addr", redisConfig.Addr,
+			"addrs", redisConfig.Addrs, "master", redisConfig.MasterName, "tls", redisConfig.TLS.Enabled)
+		store, err := storage.NewRedisStorage(redisConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+		}
+		logger.Info("Redis connected")
+		return store, nil
+
+	case storage.BackendBolt:
+		store, err := storage.NewBoltStorage(cfg.BoltPath, retention)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open bolt storage: %w", err)
+		}
+		logger.Info("Bolt storage opened", "path", cfg.BoltPath)
+		return store, nil
+
+	case storage.BackendMemory:
+		logger.Warn("Using in-memory storage, state will be lost on restart")
+		return storage.NewMemoryStorage(), nil
+
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (expected redis, bolt or memory)", cfg.StorageBackend)
+	}
+}
+
+// runCompactor periodically prunes expired data and dangling index entries
+func runCompactor(compactor storage.Compactor, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		result, err := compactor.Compact(ctx)
+		cancel()
+		if err != nil {
+			logger.Error("Storage compaction failed", "error", err)
+			continue
+		}
+		logger.Info("Storage compacted", "duration", time.Since(start),
+			"statuses", result.Statuses, "state_entries", result.StateEntries,
+			"agent_entries", result.AgentEntries, "counters", result.Counters, "audit_entries", result.AuditEntries)
+	}
+}
+
+// auditConnectionRejected counts a refused agent connection and records it in the
+// audit log, sampling rejections of unidentified connections per IP
+func auditConnectionRejected(store storage.Store, admission *admissionController, r *http.Request, agentID, reason string) {
+	audit, suppressed := admission.RecordRejection(clientIP(r), agentID, reason)
+	if !audit {
+		return
+	}
+
+	details := map[string]interface{}{
+		"reason":      reason,
+		"remote_addr": r.RemoteAddr,
+		"origin":      r.Header.Get("Origin"),
+	}
+	if suppressed > 0 {
+		details["suppressed"] = suppressed // Rejected since the previous entry for this IP and reason
+	}
+	saveAudit(r.Context(), store, &storage.AuditLogEntry{
+		Timestamp: time.Now(),
+		AgentID:   agentID,
+		Action:    "connection_rejected",
+		Details:   details,
+	})
+}
+
+// writeRouteError responds to an event that could not be routed. Events the target
+// agent cannot execute are rejected with 422 and the error code.
+func writeRouteError(w http.ResponseWriter, err error) {
+	var status int
+	var code string
+	switch {
+	case errors.Is(err, model.ErrMissingCapability):
+		status, code = http.StatusUnprocessableEntity, model.ErrMissingCapability.Code
+	case errors.Is(err, model.ErrNoMatchingAgents):
+		status, code = http.StatusUnprocessableEntity, model.ErrNoMatchingAgents.Code
+	default:
+		http.Error(w, fmt.Sprintf("Failed to route event: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "rejected",
+		"code":   code,
+		"error":  err.Error(),
+	})
+}
+
+func handleAgentReads(conn *websocket.Conn, agent *model.Agent, agentRegistry *registry.AgentRegistry,
+	store storage.Store, eventRouter *router.EventRouter) {
+
+	// Reads outlive the upgrade request, so its context cannot be used
+	ctx := context.Background()
 
 	defer func() {
-		agentRegistry.Unregister(agent.ID)
+		agentRegistry.UnregisterConnection(agent.ID, conn)
 		conn.Close()
 	}()
 
+	agentConn, err := agentRegistry.Get(agent.ID)
+	if err != nil || agentConn.Conn != conn {
+		return // Already superseded by a reconnect
+	}
+
 	for {
-		var message map[string]interface{}
-		if err := conn.ReadJSON(&message); err != nil {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
 			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
 				logger.Info("Agent closed connection", "agent_id", agent.ID)
 			} else {
@@ -337,61 +824,180 @@ func handleAgentReads(conn *websocket.Conn, agent *model.Agent,
 			return
 		}
 
-		msgType, ok := message["type"].(string)
-		if !ok {
+		env, err := agentConn.Codec.Decode(messageType == websocket.BinaryMessage, data)
+		if err != nil {
+			logger.Warn("Failed to decode message from agent", "agent_id", agent.ID, "error", err)
 			continue
 		}
+		if env == nil {
+			continue // Waiting for the remaining chunks
+		}
 
-		switch msgType {
-		case "heartbeat":
-			agentRegistry.UpdateHeartbeat(agent.ID)
-
-		case "status_update":
-			var statusUpdate model.StatusUpdate
-			data, _ := json.Marshal(message)
-			if err := json.Unmarshal(data, &statusUpdate); err != nil {
-				logger.Error("Failed to unmarshal status update", "error", err)
+		switch env.Type {
+		case model.MessageTypeHeartbeat:
+			var heartbeat model.Heartbeat
+			if err := env.DecodePayload(&heartbeat); err != nil {
+				logger.Warn("Failed to decode heartbeat", "agent_id", agent.ID, "error", err)
+			}
+			updated, err := agentRegistry.UpdateHeartbeat(agent.ID, heartbeat.Health)
+			if err != nil || heartbeat.Health == nil {
 				continue
 			}
+			if !heartbeat.Health.ClusterHealthy() {
+				logger.Warn("Agent reports unhealthy cluster", "agent_id", agent.ID,
+					"api_server_error", heartbeat.Health.APIServerError, "ready_nodes", heartbeat.Health.ReadyNodes)
+			}
+			if err := store.SaveAgent(ctx, updated); err != nil {
+				logger.Warn("Failed to save agent state", "agent_id", agent.ID, "error", err)
+			}
 
-			status, err := redisStorage.GetEventStatus(statusUpdate.EventID)
+		case model.MessageTypeDrain:
+			var drain model.DrainMessage
+			if err := env.DecodePayload(&drain); err != nil {
+				logger.Warn("Failed to decode drain message", "agent_id", agent.ID, "error", err)
+				continue
+			}
+			drained, err := agentRegistry.MarkDraining(agent.ID)
 			if err != nil {
-				status = model.NewEventStatus(statusUpdate.EventID, agent.ID)
+				continue
+			}
+			logger.Info("Agent draining", "agent_id", agent.ID, "in_flight", drain.InFlight, "grace_period", drain.GracePeriod)
+			if err := store.SaveAgent(ctx, drained); err != nil {
+				logger.Warn("Failed to save agent state", "agent_id", agent.ID, "error", err)
 			}
+			saveAudit(ctx, store, &storage.AuditLogEntry{
+				Timestamp: time.Now(),
+				AgentID:   agent.ID,
+				Action:    "agent_draining",
+				Details: map[string]interface{}{
+					"reason":       drain.Reason,
+					"in_flight":    drain.InFlight,
+					"grace_period": drain.GracePeriod.String(),
+				},
+			})
+			agentConn.SendMessage(model.MessageTypeAck, &model.AckMessage{Seq: env.Seq})
 
-			if statusUpdate.State != "" {
-				status.State = statusUpdate.State
+		case model.MessageTypeAck:
+			var ack model.AckMessage
+			if err := env.DecodePayload(&ack); err != nil {
+				logger.Warn("Failed to decode ack", "agent_id", agent.ID, "error", err)
+				continue
 			}
-			if statusUpdate.Phase != "" {
-				status.Phase = statusUpdate.Phase
+			logger.Debug("Agent acknowledged message", "agent_id", agent.ID, "seq", ack.Seq, "event_id", ack.EventID)
+			if ack.EventID != "" {
+				saveAudit(ctx, store, &storage.AuditLogEntry{
+					Timestamp: time.Now(),
+					EventID:   ack.EventID,
+					AgentID:   agent.ID,
+					Action:    "event_delivered",
+				})
 			}
-			if statusUpdate.Message != "" {
-				status.Message = statusUpdate.Message
+
+		case model.MessageTypeError:
+			var errMsg model.ErrorMessage
+			if err := env.DecodePayload(&errMsg); err != nil {
+				logger.Warn("Failed to decode error message", "agent_id", agent.ID, "error", err)
+				continue
 			}
-			if statusUpdate.Result != nil {
-				status.Result = statusUpdate.Result
+			logger.Warn("Agent reported error", "agent_id", agent.ID, "code", errMsg.Code, "message", errMsg.Message, "seq", errMsg.Seq)
+
+		case model.MessageTypeStatusUpdate:
+			var statusUpdate model.StatusUpdate
+			if err := env.DecodePayload(&statusUpdate); err != nil {
+				logger.Error("Failed to decode status update", "error", err)
+				continue
 			}
-			if statusUpdate.LogLevel != "" {
-				status.AddLog(statusUpdate.LogLevel, statusUpdate.Phase, statusUpdate.Message, statusUpdate.Details)
+
+			status, err := applyStatusUpdate(ctx, store, eventRouter, agent.ID, &statusUpdate)
+			switch {
+			case errors.Is(err, errDuplicateStatusUpdate):
+				logger.Debug("Skipping duplicate status update", "event_id", statusUpdate.EventID, "timestamp", statusUpdate.Timestamp)
+			case isRejectedUpdate(err):
+				// Acknowledged anyway, resending cannot make it valid
+				logger.Warn("Rejected status update", "agent_id", agent.ID, "event_id", statusUpdate.EventID,
+					"state", statusUpdate.State, "error", err)
+				saveAudit(ctx, store, &storage.AuditLogEntry{
+					Timestamp: time.Now(),
+					EventID:   statusUpdate.EventID,
+					AgentID:   agent.ID,
+					Action:    "status_update_rejected",
+					Details: map[string]interface{}{
+						"state": statusUpdate.State,
+						"error": err.Error(),
+					},
+				})
+			case err != nil:
+				// Leave the update unacknowledged so the agent resends it
+				logger.Error("Failed to save status update", "event_id", statusUpdate.EventID, "error", err)
+				continue
+			case errors.Is(err, errStatusUpdateBuffered):
+				// Left unacknowledged; the resend is skipped as a duplicate once this is saved
+				logger.Warn("Storage unavailable, status update buffered", "event_id", statusUpdate.EventID, "state", statusUpdate.State)
+				continue
+			default:
+				logger.Info("Status update", "event_id", statusUpdate.EventID, "state", status.State, "phase", status.Phase)
+			}
+
+			if env.Version > model.ProtocolVersionLegacy {
+				agentConn.SendMessage(model.MessageTypeAck, &model.AckMessage{Seq: env.Seq, EventID: statusUpdate.EventID})
 			}
 
-			status.UpdatedAt = time.Now()
-			redisStorage.SaveEventStatus(status)
-			logger.Info("Status update", "event_id", statusUpdate.EventID, "state", status.State, "phase", status.Phase)
+		default:
+			logger.Warn("Unknown message type from agent", "agent_id", agent.ID, "type", env.Type)
 		}
 	}
 }
 
 func handleAgentWrites(conn *websocket.Conn, agent *model.Agent, agentRegistry *registry.AgentRegistry) {
 	agentConn, err := agentRegistry.Get(agent.ID)
-	if err != nil {
+	if err != nil || agentConn.Conn != conn {
 		return
 	}
 
-	for msg := range agentConn.SendChan {
-		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-			logger.Error("Error writing to agent", "agent_id", agent.ID, "error", err)
+	for frame := range agentConn.SendChan {
+		if err := writeFrames(conn, []model.Frame{frame}); err != nil {
+			// Closing the connection ends the read loop, which unregisters the agent
+			logger.Error("Error writing to agent, closing connection", "agent_id", agent.ID, "error", err)
+			conn.Close()
 			return
 		}
 	}
 }
+
+// writeFrames writes encoded frames to the connection, each within agentWriteTimeout
+func writeFrames(conn *websocket.Conn, frames []model.Frame) error {
+	for _, frame := range frames {
+		messageType := websocket.TextMessage
+		if frame.Binary {
+			messageType = websocket.BinaryMessage
+		}
+		conn.EnableWriteCompression(frame.Compress)
+		conn.SetWriteDeadline(time.Now().Add(agentWriteTimeout))
+		if err := conn.WriteMessage(messageType, frame.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// negotiateCompression picks the compression for a connection. Deflate is only
+// usable if permessage-deflate was actually negotiated during the upgrade.
+func negotiateCompression(offered []model.Compression, allowed []string, deflateNegotiated bool) model.Compression {
+	candidates := make([]model.Compression, 0, len(allowed))
+	for _, c := range allowed {
+		if model.Compression(c) == model.CompressionDeflate && !deflateNegotiated {
+			continue
+		}
+		candidates = append(candidates, model.Compression(c))
+	}
+	return model.NegotiateCompression(offered, candidates)
+}
+
+// toEncodings converts configured encoding names
+func toEncodings(names []string) []model.Encoding {
+	encodings := make([]model.Encoding, 0, len(names))
+	for _, name := range names {
+		encodings = append(encodings, model.Encoding(name))
+	}
+	return encodings
+}
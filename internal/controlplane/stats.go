@@ -0,0 +1,54 @@
+package controlplane
+
+import (
+	"context"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+	"github.com/suyog1pathak/transporter/pkg/storage"
+)
+
+// recordTransition counts an applied state change of an event in the statistics and
+// audits it. It is the only place statistics are recorded, so they follow the
+// transition table: rejected, duplicate and repeated updates are never counted. from
+// is empty for a newly created event, whose submission is audited separately.
+func recordTransition(ctx context.Context, store storage.Store, status *model.EventStatus, from model.ExecutionState) {
+	ctx = context.WithoutCancel(ctx) // The transition was already saved
+	transition := storage.Transition{
+		EventID:   status.EventID,
+		AgentID:   status.AgentID,
+		EventType: status.EventType,
+		CreatedBy: status.CreatedBy,
+		From:      from,
+		To:        status.State,
+		At:        time.Now(),
+	}
+	if status.AgentID != "" {
+		if agent, err := store.GetAgent(ctx, status.AgentID); err == nil {
+			transition.Cluster = agent.ClusterName
+		}
+	}
+	if status.IsTerminal() && status.Result != nil {
+		transition.Duration = status.Result.Duration
+	}
+
+	if err := store.RecordTransition(ctx, transition); err != nil {
+		logger.Error("Failed to record event statistics", "event_id", status.EventID, "state", status.State, "error", err)
+	}
+
+	if from == "" {
+		return
+	}
+	saveAudit(ctx, store, &storage.AuditLogEntry{
+		Timestamp: transition.At,
+		EventID:   status.EventID,
+		AgentID:   status.AgentID,
+		Action:    "event_state_changed",
+		Details: map[string]interface{}{
+			"from":    from,
+			"to":      status.State,
+			"message": status.Message,
+		},
+	})
+}
@@ -0,0 +1,225 @@
+package controlplane
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+	"github.com/suyog1pathak/transporter/pkg/router"
+	"github.com/suyog1pathak/transporter/pkg/storage"
+)
+
+// errDuplicateStatusUpdate marks a replayed status update that was already applied
+var errDuplicateStatusUpdate = errors.New("status update already applied")
+
+// errAlreadyInState marks a router transition to the state the event is already in
+var errAlreadyInState = errors.New("event already in state")
+
+// errStatusUpdateBuffered marks a status update buffered until storage is available
+var errStatusUpdateBuffered = errors.New("status update buffered")
+
+// writeStatus runs a status write through the store's write-behind buffer, if it
+// has one, so every status write is applied in the order it was made. ctx may be
+// cancelled before a buffered write runs.
+func writeStatus(ctx context.Context, store storage.Store, op string, write func(ctx context.Context) error) error {
+	ctx = context.WithoutCancel(ctx)
+	if buffered, ok := store.(*storage.BufferedStore); ok {
+		return buffered.WriteBehind(ctx, op, write)
+	}
+	return write(ctx)
+}
+
+// transitionEvent atomically moves an event to state through the transition table,
+// calling apply to update the status, and records cause in the event's timeline. A
+// status is created for agentID if the event has none yet. A transition to the state
+// the event is already in for the same agent is skipped, so a redelivered or replayed
+// router write adds nothing to the log or timeline. Rejected transitions are logged
+// and dropped. Nothing waits for the result, so if storage is unavailable the
+// transition is buffered and applied once it is back, even if ctx is cancelled.
+func transitionEvent(ctx context.Context, store storage.Store, event *model.Event, agentID string, state model.ExecutionState,
+	cause model.Cause, apply func(*model.EventStatus)) {
+
+	writeID := uuid.NewString()
+	write := func(ctx context.Context) error {
+		var previous model.ExecutionState
+		status, err := store.UpdateEventStatus(ctx, event.ID, storage.IdempotentUpdate(writeID, func(status *model.EventStatus) (*model.EventStatus, error) {
+			if status == nil {
+				status = model.NewEventStatus(event.ID, agentID)
+				status.State = model.StateCreated
+			}
+			if status.State == state && (agentID == "" || status.AgentID == agentID) {
+				return nil, errAlreadyInState
+			}
+			if err := status.CheckTransition(state); err != nil {
+				return nil, err
+			}
+			previous = status.State
+			describeEvent(status, event)
+			status.Cause = &cause
+			apply(status)
+			return status, nil
+		}))
+		if errors.Is(err, storage.ErrAlreadyApplied) || errors.Is(err, errAlreadyInState) {
+			return nil
+		}
+		if isRejectedUpdate(err) {
+			logger.Warn("Rejected event state change", "event_id", event.ID, "agent_id", agentID, "state", state, "error", err)
+			return nil // Retrying cannot make it valid
+		}
+		if err != nil {
+			return err
+		}
+
+		if previous != status.State {
+			recordTransition(ctx, store, status, previous)
+		}
+		return nil
+	}
+
+	if err := writeStatus(ctx, store, "event_transition", write); err != nil {
+		logger.Error("Failed to save event state change", "event_id", event.ID, "agent_id", agentID, "state", state, "error", err)
+	}
+}
+
+// applyStatusUpdate atomically applies an agent's status update, settling the event
+// in the router once it is final. The update is rejected unless the event is assigned
+// to the agent and the state change is allowed. While earlier status writes wait for
+// storage, the update is buffered behind them and errStatusUpdateBuffered returned;
+// the agent resends it, and the resend is skipped as a duplicate if it was applied.
+func applyStatusUpdate(ctx context.Context, store storage.Store, eventRouter *router.EventRouter, agentID string,
+	update *model.StatusUpdate) (*model.EventStatus, error) {
+
+	type outcome struct {
+		status *model.EventStatus
+		err    error
+	}
+	outcomes := make(chan outcome, 1)
+
+	write := func(ctx context.Context) error {
+		status, err := saveStatusUpdate(ctx, store, eventRouter, agentID, update)
+		if storage.IsUnavailable(err) {
+			return err
+		}
+		if err == nil && status.IsTerminal() {
+			eventRouter.SettleEvent(update.EventID, agentID)
+		}
+		select {
+		case outcomes <- outcome{status, err}:
+		default:
+		}
+		return nil
+	}
+
+	if err := writeStatus(ctx, store, "status_update", write); err != nil {
+		return nil, err
+	}
+	select {
+	case result := <-outcomes:
+		return result.status, result.err
+	default:
+		return nil, errStatusUpdateBuffered
+	}
+}
+
+// saveStatusUpdate applies an agent's status update to the stored status
+func saveStatusUpdate(ctx context.Context, store storage.Store, eventRouter *router.EventRouter, agentID string,
+	update *model.StatusUpdate) (*model.EventStatus, error) {
+
+	var previous model.ExecutionState
+	status, err := store.UpdateEventStatus(ctx, update.EventID, func(status *model.EventStatus) (*model.EventStatus, error) {
+		if status == nil || status.State == model.StateCreated || status.State == model.StateQueued {
+			// The agent may report before the routed transition is saved
+			event := eventRouter.AssignedEvent(update.EventID, agentID)
+			switch {
+			case event == nil && status == nil:
+				return nil, model.ErrStatusNotFound
+			case status == nil:
+				status = model.NewEventStatus(update.EventID, agentID)
+				describeEvent(status, event)
+			case event != nil:
+				status.AgentID = agentID
+				status.State = model.StateAssigned
+			}
+		}
+		if status.AgentID != agentID {
+			return nil, fmt.Errorf("%w: assigned to %s", model.ErrAgentNotAssigned, status.AgentID)
+		}
+
+		// Agents replay their journal after reconnecting; skip updates already applied.
+		// Updates from agents that send no timestamp are duplicates if they change nothing.
+		if !update.Timestamp.IsZero() && !update.Timestamp.After(status.ReportedAt) || update.Timestamp.IsZero() && repeatsStatus(update, status) {
+			return nil, errDuplicateStatusUpdate
+		}
+
+		state := update.State
+		if state == "" {
+			state = status.State
+		}
+		if err := status.CheckTransition(state); err != nil {
+			return nil, err
+		}
+		previous = status.State
+
+		if !update.Timestamp.IsZero() {
+			status.ReportedAt = update.Timestamp
+		}
+		status.State = state
+		if update.Phase != "" {
+			status.Phase = update.Phase
+		}
+		if update.Message != "" {
+			status.Message = update.Message
+		}
+		if update.Result != nil {
+			status.Result = update.Result
+		}
+		if update.LogLevel != "" {
+			status.AddLog(update.LogLevel, update.Phase, update.Message, update.Details)
+		}
+		status.Cause = &model.Cause{Actor: model.ActorAgent, ID: agentID, Reason: "status_update"}
+		status.UpdatedAt = time.Now()
+		return status, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if previous != status.State {
+		recordTransition(ctx, store, status, previous)
+	}
+	return status, nil
+}
+
+// repeatsStatus reports whether an update would leave the status as it is
+func repeatsStatus(update *model.StatusUpdate, status *model.EventStatus) bool {
+	return (update.State == "" || update.State == status.State) &&
+		(update.Phase == "" || update.Phase == status.Phase) &&
+		(update.Message == "" || update.Message == status.Message) &&
+		update.Result == nil && update.LogLevel == ""
+}
+
+// routerCause attributes a status change to the event router
+func routerCause(reason string) model.Cause {
+	return model.Cause{Actor: model.ActorRouter, Reason: reason}
+}
+
+// describeEvent copies the event attributes statistics are broken down by onto its
+// status, unless the status already has them
+func describeEvent(status *model.EventStatus, event *model.Event) {
+	if status.EventType == "" {
+		status.EventType = event.Type
+	}
+	if status.CreatedBy == "" {
+		status.CreatedBy = event.CreatedBy
+	}
+}
+
+// isRejectedUpdate reports whether a status update was refused rather than failed to save
+func isRejectedUpdate(err error) bool {
+	var statusErr *model.StatusError
+	return errors.As(err, &statusErr)
+}
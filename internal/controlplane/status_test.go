@@ -0,0 +1,153 @@
+package controlplane
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+	"github.com/suyog1pathak/transporter/pkg/storage"
+)
+
+func TestRepeatedStatusWritesAreIdempotent(t *testing.T) {
+	logger.InitLogger(false)
+	ctx := t.Context()
+	store := storage.NewMemoryStorage()
+	event := &model.Event{ID: "event-1", Type: model.EventTypeK8sResource, TargetAgent: "agent-1"}
+	cause := model.Cause{Actor: model.ActorRouter, Reason: "assigned"}
+
+	// A redelivered router transition is skipped
+	for range 2 {
+		transitionEvent(ctx, store, event, "agent-1", model.StateAssigned, cause, func(status *model.EventStatus) {
+			status.UpdateState(model.StateAssigned, "Event routed to agent")
+		})
+	}
+
+	started := time.Now()
+	updates := []*model.StatusUpdate{
+		{EventID: event.ID, AgentID: "agent-1", State: model.StateInProgress, Phase: model.PhaseApplying, Message: "Applying", LogLevel: model.LogLevelInfo, Timestamp: started},
+		// Replayed from the agent journal
+		{EventID: event.ID, AgentID: "agent-1", State: model.StateInProgress, Phase: model.PhaseApplying, Message: "Applying", LogLevel: model.LogLevelInfo, Timestamp: started},
+		// Older update delivered late
+		{EventID: event.ID, AgentID: "agent-1", State: model.StateInProgress, Message: "Validating", LogLevel: model.LogLevelInfo, Timestamp: started.Add(-time.Second)},
+		// Repeated by an agent that sends no timestamp
+		{EventID: event.ID, AgentID: "agent-1", State: model.StateInProgress, Phase: model.PhaseApplying, Message: "Applying"},
+	}
+	for i, update := range updates {
+		_, err := saveStatusUpdate(ctx, store, nil, "agent-1", update)
+		if i == 0 && err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && !errors.Is(err, errDuplicateStatusUpdate) {
+			t.Fatalf("update %d: err = %v, want a duplicate", i, err)
+		}
+	}
+
+	status, err := store.GetEventStatus(ctx, event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != model.StateInProgress || status.Message != "Applying" || !status.ReportedAt.Equal(started) {
+		t.Fatalf("status %s %q reported at %v, want the first update", status.State, status.Message, status.ReportedAt)
+	}
+	applying := 0
+	for _, entry := range status.ExecutionLog {
+		if entry.Message == "Applying" {
+			applying++
+		}
+	}
+	if applying != 1 {
+		t.Fatalf("%d log entries for the update, want 1", applying)
+	}
+
+	timeline, err := store.GetEventTimeline(ctx, event.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var states []model.ExecutionState
+	for _, change := range timeline {
+		states = append(states, change.To)
+	}
+	if len(states) != 2 || states[0] != model.StateAssigned || states[1] != model.StateInProgress {
+		t.Fatalf("timeline states %v, want [assigned in_progress]", states)
+	}
+}
+
+func TestStatisticsFollowTransitions(t *testing.T) {
+	logger.InitLogger(false)
+	ctx := t.Context()
+	store := storage.NewMemoryStorage()
+	if err := store.SaveAgent(ctx, &model.Agent{ID: "agent-1", Name: "agent-1", ClusterName: "cluster-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	event := &model.Event{
+		ID:          "event-1",
+		Type:        model.EventTypeK8sResource,
+		TargetAgent: "agent-1",
+		CreatedBy:   "alice",
+		Payload:     model.EventPayload{Manifests: []string{"kind: ConfigMap"}},
+	}
+	if err := recordEvent(ctx, store, event, "event_received_http", nil); err != nil {
+		t.Fatal(err)
+	}
+	transitionEvent(ctx, store, event, "agent-1", model.StateAssigned, model.Cause{Actor: model.ActorRouter, Reason: "assigned"},
+		func(status *model.EventStatus) { status.UpdateState(model.StateAssigned, "Event routed to agent") })
+
+	started := time.Now()
+	completed := &model.StatusUpdate{EventID: event.ID, AgentID: "agent-1", State: model.StateCompleted, Message: "Applied",
+		Result: &model.EventResult{Success: true, Duration: 3 * time.Second}, Timestamp: started.Add(time.Second)}
+	updates := []struct {
+		update  *model.StatusUpdate
+		counted bool
+	}{
+		{update: &model.StatusUpdate{EventID: event.ID, AgentID: "agent-1", State: model.StateInProgress, Message: "Applying", Timestamp: started}, counted: true},
+		// A progress message without a state change
+		{update: &model.StatusUpdate{EventID: event.ID, AgentID: "agent-1", Phase: model.PhaseApplying, Message: "Applied 1 of 2", Timestamp: started.Add(time.Millisecond)}},
+		{update: completed, counted: true},
+		// Replayed after a reconnect
+		{update: completed},
+		// Leaving a final state is rejected
+		{update: &model.StatusUpdate{EventID: event.ID, AgentID: "agent-1", State: model.StateInProgress, Message: "Retrying", Timestamp: started.Add(2 * time.Second)}},
+	}
+	for i, tt := range updates {
+		_, err := saveStatusUpdate(ctx, store, nil, "agent-1", tt.update)
+		if tt.counted && err != nil {
+			t.Fatalf("update %d: %v", i, err)
+		}
+	}
+
+	stats, err := store.GetEventStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{
+		"total":                       1,
+		string(model.StateCreated):    1,
+		string(model.StateAssigned):   1,
+		string(model.StateInProgress): 1,
+		string(model.StateCompleted):  1,
+		string(model.StateFailed):     0,
+	}
+	for state, count := range want {
+		if stats.Totals[state] != count {
+			t.Errorf("totals[%s] = %d, want %d", state, stats.Totals[state], count)
+		}
+	}
+	if stats.ByCluster["cluster-1"][string(model.StateCompleted)] != 1 || stats.ByCreator["alice"][string(model.StateCompleted)] != 1 {
+		t.Errorf("by cluster %v, by creator %v; want the completion attributed", stats.ByCluster, stats.ByCreator)
+	}
+	if histogram := stats.Durations["all"]; histogram == nil || histogram.Count != 1 || histogram.SumSeconds != 3 {
+		t.Errorf("durations %+v, want the one completed execution", histogram)
+	}
+
+	// Every counted change except the creation is audited
+	page, err := store.QueryAuditLog(ctx, storage.AuditQuery{EventID: event.ID, Action: "event_state_changed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 3 {
+		t.Fatalf("%d state changes audited, want 3", len(page.Entries))
+	}
+}
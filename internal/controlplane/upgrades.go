@@ -0,0 +1,97 @@
+package controlplane
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/storage"
+	"github.com/suyog1pathak/transporter/pkg/upgrade"
+)
+
+// registerUpgradeRoutes adds the agent upgrade rollout endpoints
+func registerUpgradeRoutes(mux *http.ServeMux, upgrades *upgrade.Orchestrator, store storage.Store) {
+	mux.HandleFunc("POST /upgrades", func(w http.ResponseWriter, r *http.Request) {
+		var req upgrade.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid upgrade request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		rollout, err := upgrades.Start(req)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, model.ErrNoMatchingAgents) {
+				status = http.StatusUnprocessableEntity
+			}
+			http.Error(w, fmt.Sprintf("Failed to start upgrade: %v", err), status)
+			return
+		}
+
+		saveAudit(r.Context(), store, &storage.AuditLogEntry{
+			Timestamp: time.Now(),
+			Action:    "agent_upgrade_rollout_started",
+			User:      rollout.CreatedBy,
+			Details: map[string]interface{}{
+				"rollout_id": rollout.ID,
+				"image":      rollout.Image,
+				"version":    rollout.Version,
+				"agents":     len(rollout.Agents),
+			},
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(rollout)
+	})
+
+	mux.HandleFunc("GET /upgrades", func(w http.ResponseWriter, r *http.Request) {
+		rollouts := upgrades.List()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"rollouts": rollouts,
+			"count":    len(rollouts),
+		})
+	})
+
+	mux.HandleFunc("GET /upgrades/{id}", func(w http.ResponseWriter, r *http.Request) {
+		rollout, err := upgrades.Get(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rollout)
+	})
+
+	mux.HandleFunc("POST /upgrades/{id}/resume", func(w http.ResponseWriter, r *http.Request) {
+		writeRolloutChange(w, upgrades, upgrades.Resume, r.PathValue("id"))
+	})
+
+	mux.HandleFunc("POST /upgrades/{id}/abort", func(w http.ResponseWriter, r *http.Request) {
+		writeRolloutChange(w, upgrades, upgrades.Abort, r.PathValue("id"))
+	})
+}
+
+// writeRolloutChange applies a resume or abort and responds with the rollout
+func writeRolloutChange(w http.ResponseWriter, upgrades *upgrade.Orchestrator,
+	change func(string) (*upgrade.Rollout, error), rolloutID string) {
+
+	if _, err := upgrades.Get(rolloutID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	rollout, err := change(rolloutID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rollout)
+}
@@ -11,6 +11,16 @@ const (
 	AgentStatusConnected    AgentStatus = "connected"
 	AgentStatusDisconnected AgentStatus = "disconnected"
 	AgentStatusUnhealthy    AgentStatus = "unhealthy"
+	AgentStatusDraining     AgentStatus = "draining" // Finishing in-flight events before shutting down
+)
+
+// Capabilities an agent may advertise at registration
+const (
+	CapabilityK8sCRUD        = "k8s_crud"        // Apply Kubernetes manifests
+	CapabilityK8sImpersonate = "k8s_impersonate" // Apply manifests as an impersonated identity
+	CapabilityScriptExec     = "script_exec"     // Run scripts
+	CapabilityPolicy         = "policy"          // Enforce policies
+	CapabilityAgentUpgrade   = "agent_upgrade"   // Upgrade itself by patching its own Deployment
 )
 
 // Agent represents a data plane agent running in a Kubernetes cluster
@@ -33,6 +43,14 @@ type Agent struct {
 	ConnectedAt    time.Time   `json:"connected_at"`              // When agent connected
 	DisconnectedAt *time.Time  `json:"disconnected_at,omitempty"` // When agent disconnected (nil if connected)
 
+	ProtocolVersion int `json:"protocol_version"` // Negotiated wire protocol version
+
+	// Cordon is set while an admin has excluded the agent from routing
+	Cordon *Cordon `json:"cordon,omitempty"`
+
+	// Health is the latest snapshot reported in a heartbeat
+	Health *AgentHealth `json:"health,omitempty"`
+
 	// Capabilities
 	Capabilities []string `json:"capabilities"` // Supported operations (k8s_crud, script_exec, policy)
 
@@ -42,6 +60,13 @@ type Agent struct {
 	Metadata  map[string]string `json:"metadata,omitempty"`  // Additional metadata
 }
 
+// Cordon records why and by whom an agent was cordoned
+type Cordon struct {
+	Reason string    `json:"reason,omitempty"`
+	User   string    `json:"user,omitempty"`
+	At     time.Time `json:"at"`
+}
+
 // AgentRegistration is sent by an agent when it first connects to the control plane
 type AgentRegistration struct {
 	ID              string            `json:"id"`
@@ -123,6 +148,16 @@ func (a *Agent) MarkDisconnected() {
 	a.DisconnectedAt = &now
 }
 
+// MarkDraining marks the agent as draining (finishing in-flight events before exit)
+func (a *Agent) MarkDraining() {
+	a.Status = AgentStatusDraining
+}
+
+// AcceptsEvents reports whether new events may be routed to the agent
+func (a *Agent) AcceptsEvents() bool {
+	return a.Status == AgentStatusConnected && a.Cordon == nil
+}
+
 // MarkUnhealthy marks the agent as unhealthy (connected but not responding)
 func (a *Agent) MarkUnhealthy() {
 	a.Status = AgentStatusUnhealthy
@@ -138,11 +173,54 @@ func (a *Agent) HasCapability(capability string) bool {
 	return false
 }
 
+// MissingCapabilities returns the required capabilities the agent does not advertise
+func (a *Agent) MissingCapabilities(required []string) []string {
+	missing := make([]string, 0)
+	for _, capability := range required {
+		if !a.HasCapability(capability) {
+			missing = append(missing, capability)
+		}
+	}
+	return missing
+}
+
 // Heartbeat represents a heartbeat message from an agent
 type Heartbeat struct {
 	AgentID   string                 `json:"agent_id"`
 	Timestamp time.Time              `json:"timestamp"`
 	Metrics   map[string]interface{} `json:"metrics,omitempty"` // Optional health metrics
+	Health    *AgentHealth           `json:"health,omitempty"`  // Structured health snapshot
+}
+
+// AgentHealth is a snapshot of an agent's workload and its cluster's health
+type AgentHealth struct {
+	// Agent workload
+	RunningEvents int        `json:"running_events"`
+	QueuedEvents  int        `json:"queued_events"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorAt   *time.Time `json:"last_error_at,omitempty"`
+
+	// Kubernetes API server
+	APIServerReachable bool          `json:"api_server_reachable"`
+	APIServerLatency   time.Duration `json:"api_server_latency"`
+	APIServerError     string        `json:"api_server_error,omitempty"`
+	KubernetesVersion  string        `json:"kubernetes_version,omitempty"`
+	NodeCount          int           `json:"node_count"`
+	ReadyNodes         int           `json:"ready_nodes"`
+	ClusterCheckedAt   time.Time     `json:"cluster_checked_at"`
+
+	// Agent process
+	MemoryBytes uint64 `json:"memory_bytes"` // Memory obtained from the OS by the Go runtime
+	HeapBytes   uint64 `json:"heap_bytes"`
+	Goroutines  int    `json:"goroutines"`
+
+	CollectedAt time.Time `json:"collected_at"`
+}
+
+// ClusterHealthy reports whether the agent's cluster API server is reachable and has
+// at least one ready node
+func (h *AgentHealth) ClusterHealthy() bool {
+	return h.APIServerReachable && (h.NodeCount == 0 || h.ReadyNodes > 0)
 }
 
 // Custom errors for agent validation
@@ -154,6 +232,7 @@ var (
 	ErrMissingCapabilities = &AgentError{Code: "MISSING_CAPABILITIES", Message: "at least one capability is required"}
 	ErrAgentNotFound       = &AgentError{Code: "AGENT_NOT_FOUND", Message: "agent not found"}
 	ErrAgentAlreadyExists  = &AgentError{Code: "AGENT_ALREADY_EXISTS", Message: "agent already exists"}
+	ErrAgentNotConnected   = &AgentError{Code: "AGENT_NOT_CONNECTED", Message: "agent connection is closed"}
 )
 
 // AgentError represents an agent-related error
@@ -0,0 +1,454 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encoding is the serialization used for message payloads
+type Encoding string
+
+const (
+	EncodingJSON    Encoding = "json"
+	EncodingMsgpack Encoding = "msgpack"
+)
+
+// Compression is the compression applied to messages
+type Compression string
+
+const (
+	CompressionNone    Compression = "none"
+	CompressionDeflate Compression = "deflate" // WebSocket permessage-deflate, applied by the transport
+	CompressionZstd    Compression = "zstd"    // zstd applied to the payload inside a binary frame
+)
+
+const (
+	// CompressionThreshold is the size below which messages are sent uncompressed
+	CompressionThreshold = 1024
+
+	// chunkOverhead is reserved in each chunk frame for the envelope around the data
+	chunkOverhead = 512
+
+	// MinFrameSize is the smallest frame size that leaves room for chunk data
+	MinFrameSize = CompressionThreshold + chunkOverhead
+
+	// maxChunks bounds the number of chunks a single message may be split into
+	maxChunks = 1 << 16
+
+	// maxPartialMessages bounds how many chunked messages may be in flight per connection
+	maxPartialMessages = 16
+
+	// partialMessageTimeout is how long an incomplete chunked message is kept
+	partialMessageTimeout = time.Minute
+
+	// maxDecompressedSize bounds the output of a single zstd payload
+	maxDecompressedSize = 256 << 20
+)
+
+// Frame is a single WebSocket message produced by a Codec
+type Frame struct {
+	Binary   bool   // Send as a binary (rather than text) WebSocket message
+	Compress bool   // Request transport-level (permessage-deflate) compression
+	Data     []byte // Frame contents
+}
+
+// CodecConfig holds the settings negotiated for one connection
+type CodecConfig struct {
+	Version        int         // Protocol version
+	Encoding       Encoding    // Payload encoding (default JSON)
+	Compression    Compression // Compression (default none)
+	MaxFrameSize   int         // Frames larger than this are chunked (0 disables chunking)
+	MaxMessageSize int         // Limit for a reassembled inbound message (0 for unlimited)
+}
+
+// Codec encodes and decodes messages for one connection at a negotiated protocol
+// version, assigns sequence numbers and reassembles chunked messages.
+// It is safe for concurrent use.
+type Codec struct {
+	cfg CodecConfig
+	seq atomic.Uint64
+
+	partials map[uint64]*partialMessage // chunk ID -> message being reassembled
+	mu       sync.Mutex
+}
+
+// partialMessage is a chunked message that has not been fully received
+type partialMessage struct {
+	chunks    map[int][]byte // chunk index -> data, filled as chunks arrive
+	total     int
+	size      int
+	binary    bool
+	startedAt time.Time
+}
+
+// binaryEnvelope is the wire form of an Envelope sent in a binary frame
+type binaryEnvelope struct {
+	Version     int         `msgpack:"v"`
+	Type        MessageType `msgpack:"type"`
+	Seq         uint64      `msgpack:"seq"`
+	Timestamp   time.Time   `msgpack:"ts"`
+	Encoding    Encoding    `msgpack:"enc"`
+	Compression Compression `msgpack:"comp,omitempty"`
+	Payload     []byte      `msgpack:"payload"`
+}
+
+// NewCodec creates a codec with the given settings
+func NewCodec(cfg CodecConfig) *Codec {
+	if cfg.Encoding == "" {
+		cfg.Encoding = EncodingJSON
+	}
+	if cfg.Compression == "" {
+		cfg.Compression = CompressionNone
+	}
+	if cfg.MaxFrameSize > 0 && cfg.MaxFrameSize < MinFrameSize {
+		cfg.MaxFrameSize = MinFrameSize
+	}
+	return &Codec{
+		cfg:      cfg,
+		partials: make(map[uint64]*partialMessage),
+	}
+}
+
+// WithConfig returns a codec with new settings that continues this codec's sequence numbers.
+// It is used to switch to the negotiated settings after the registration handshake.
+func (c *Codec) WithConfig(cfg CodecConfig) *Codec {
+	next := NewCodec(cfg)
+	next.seq.Store(c.seq.Load())
+	return next
+}
+
+// Version returns the protocol version this codec encodes
+func (c *Codec) Version() int {
+	return c.cfg.Version
+}
+
+// Config returns the codec settings
+func (c *Codec) Config() CodecConfig {
+	return c.cfg
+}
+
+// Encode serializes a message with the next sequence number. Messages larger than
+// the frame size are split into several chunk frames. It returns the frames and
+// the sequence number assigned to the message.
+func (c *Codec) Encode(msgType MessageType, payload interface{}) ([]Frame, uint64, error) {
+	seq := c.seq.Add(1)
+
+	if c.cfg.Version == ProtocolVersionLegacy {
+		data, err := encodeLegacy(msgType, payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		return []Frame{{Data: data}}, seq, nil
+	}
+
+	frame, err := c.encodeFrame(msgType, seq, payload)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if c.cfg.MaxFrameSize > 0 && len(frame.Data) > c.cfg.MaxFrameSize {
+		frames, err := c.chunk(frame, seq)
+		return frames, seq, err
+	}
+	return []Frame{frame}, seq, nil
+}
+
+// encodeFrame renders a single message as a text (JSON) or binary frame
+func (c *Codec) encodeFrame(msgType MessageType, seq uint64, payload interface{}) (Frame, error) {
+	if c.cfg.Encoding == EncodingJSON && c.cfg.Compression != CompressionZstd {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return Frame{}, fmt.Errorf("failed to marshal %s payload: %w", msgType, err)
+		}
+
+		data, err := json.Marshal(Envelope{
+			Version:   c.cfg.Version,
+			Type:      msgType,
+			Seq:       seq,
+			Timestamp: time.Now(),
+			Payload:   raw,
+		})
+		if err != nil {
+			return Frame{}, fmt.Errorf("failed to marshal %s envelope: %w", msgType, err)
+		}
+		return Frame{Data: data, Compress: c.transportCompress(len(data))}, nil
+	}
+
+	raw, err := marshalPayload(c.cfg.Encoding, payload)
+	if err != nil {
+		return Frame{}, fmt.Errorf("failed to marshal %s payload: %w", msgType, err)
+	}
+	return c.encodeBinary(msgType, seq, c.cfg.Encoding, raw)
+}
+
+// encodeBinary wraps an already-encoded payload in a binary envelope, compressing it if negotiated
+func (c *Codec) encodeBinary(msgType MessageType, seq uint64, encoding Encoding, raw []byte) (Frame, error) {
+	env := binaryEnvelope{
+		Version:   c.cfg.Version,
+		Type:      msgType,
+		Seq:       seq,
+		Timestamp: time.Now(),
+		Encoding:  encoding,
+		Payload:   raw,
+	}
+
+	if c.cfg.Compression == CompressionZstd && len(raw) > CompressionThreshold {
+		env.Payload = zstdEncoder().EncodeAll(raw, nil)
+		env.Compression = CompressionZstd
+	}
+
+	data, err := msgpack.Marshal(&env)
+	if err != nil {
+		return Frame{}, fmt.Errorf("failed to marshal %s envelope: %w", msgType, err)
+	}
+	return Frame{Binary: true, Data: data, Compress: c.transportCompress(len(data))}, nil
+}
+
+// transportCompress reports whether a frame should use permessage-deflate
+func (c *Codec) transportCompress(size int) bool {
+	return c.cfg.Compression == CompressionDeflate && size > CompressionThreshold
+}
+
+// chunk splits an oversized frame into chunk frames
+func (c *Codec) chunk(frame Frame, id uint64) ([]Frame, error) {
+	size := c.cfg.MaxFrameSize - chunkOverhead
+
+	total := (len(frame.Data) + size - 1) / size
+	frames := make([]Frame, 0, total)
+
+	for i := 0; i < total; i++ {
+		end := (i + 1) * size
+		if end > len(frame.Data) {
+			end = len(frame.Data)
+		}
+
+		raw, err := marshalPayload(EncodingMsgpack, &ChunkMessage{
+			ID:     id,
+			Index:  i,
+			Total:  total,
+			Binary: frame.Binary,
+			Data:   frame.Data[i*size : end],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal chunk: %w", err)
+		}
+
+		chunkFrame, err := c.encodeBinary(MessageTypeChunk, 0, EncodingMsgpack, raw)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, chunkFrame)
+	}
+
+	return frames, nil
+}
+
+// Decode parses an inbound frame. It returns a nil envelope and nil error when the
+// frame is a chunk of a message that is not yet complete.
+func (c *Codec) Decode(binary bool, data []byte) (*Envelope, error) {
+	if !binary {
+		return DecodeEnvelope(data)
+	}
+
+	var be binaryEnvelope
+	if err := msgpack.Unmarshal(data, &be); err != nil {
+		return nil, fmt.Errorf("failed to decode binary envelope: %w", err)
+	}
+	if be.Version > ProtocolVersion {
+		return nil, ErrUnsupportedProtocolVersion
+	}
+
+	payload := be.Payload
+	switch be.Compression {
+	case "", CompressionNone:
+	case CompressionZstd:
+		decoded, err := zstdDecoder().DecodeAll(be.Payload, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %s payload: %w", be.Type, err)
+		}
+		payload = decoded
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", be.Compression)
+	}
+
+	env := &Envelope{
+		Version:   be.Version,
+		Type:      be.Type,
+		Seq:       be.Seq,
+		Timestamp: be.Timestamp,
+		Payload:   payload,
+		encoding:  be.Encoding,
+	}
+
+	if env.Type != MessageTypeChunk {
+		return env, nil
+	}
+
+	var chunk ChunkMessage
+	if err := env.DecodePayload(&chunk); err != nil {
+		return nil, err
+	}
+	whole, isBinary, complete, err := c.reassemble(&chunk)
+	if err != nil || !complete {
+		return nil, err
+	}
+	return c.Decode(isBinary, whole)
+}
+
+// reassemble records a chunk and returns the whole frame once every chunk has arrived
+func (c *Codec) reassemble(chunk *ChunkMessage) ([]byte, bool, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if chunk.Total <= 0 || chunk.Index < 0 || chunk.Index >= chunk.Total {
+		return nil, false, false, ErrInvalidChunk
+	}
+	if chunk.Total > maxChunks {
+		return nil, false, false, fmt.Errorf("chunk total %d exceeds %d: %w", chunk.Total, maxChunks, ErrInvalidChunk)
+	}
+	// Every chunk but the last carries at least CompressionThreshold bytes, so a total
+	// beyond that bound cannot belong to a message within the size limit
+	if c.cfg.MaxMessageSize > 0 && (chunk.Total-1)*CompressionThreshold > c.cfg.MaxMessageSize {
+		return nil, false, false, ErrMessageTooLarge
+	}
+
+	// Drop messages whose remaining chunks never arrived
+	for id, p := range c.partials {
+		if time.Since(p.startedAt) > partialMessageTimeout {
+			delete(c.partials, id)
+		}
+	}
+
+	p, exists := c.partials[chunk.ID]
+	if !exists {
+		if len(c.partials) >= maxPartialMessages {
+			return nil, false, false, fmt.Errorf("too many incomplete chunked messages: %w", ErrInvalidChunk)
+		}
+		p = &partialMessage{
+			chunks:    make(map[int][]byte),
+			total:     chunk.Total,
+			binary:    chunk.Binary,
+			startedAt: time.Now(),
+		}
+		c.partials[chunk.ID] = p
+	}
+
+	if p.total != chunk.Total {
+		delete(c.partials, chunk.ID)
+		return nil, false, false, ErrInvalidChunk
+	}
+	if _, dup := p.chunks[chunk.Index]; dup {
+		return nil, false, false, nil // Duplicate chunk
+	}
+
+	p.size += len(chunk.Data)
+	if c.cfg.MaxMessageSize > 0 && p.size > c.cfg.MaxMessageSize {
+		delete(c.partials, chunk.ID)
+		return nil, false, false, ErrMessageTooLarge
+	}
+
+	p.chunks[chunk.Index] = chunk.Data
+	if len(p.chunks) < p.total {
+		return nil, false, false, nil
+	}
+
+	delete(c.partials, chunk.ID)
+	whole := make([]byte, 0, p.size)
+	for i := 0; i < p.total; i++ {
+		whole = append(whole, p.chunks[i]...)
+	}
+	return whole, p.binary, true, nil
+}
+
+// NegotiateEncoding picks the first offered encoding that is allowed, defaulting to JSON
+func NegotiateEncoding(offered, allowed []Encoding) Encoding {
+	for _, o := range offered {
+		for _, a := range allowed {
+			if o == a {
+				return o
+			}
+		}
+	}
+	return EncodingJSON
+}
+
+// NegotiateCompression picks the first offered compression that is allowed, defaulting to none
+func NegotiateCompression(offered, allowed []Compression) Compression {
+	for _, o := range offered {
+		for _, a := range allowed {
+			if o == a {
+				return o
+			}
+		}
+	}
+	return CompressionNone
+}
+
+// NegotiateFrameSize picks the smaller of two frame sizes, where 0 means unlimited.
+// Sizes below MinFrameSize are raised to it so chunk frames fit the agreed size.
+func NegotiateFrameSize(offered, allowed int) int {
+	size := allowed
+	if offered > 0 && (allowed <= 0 || offered < allowed) {
+		size = offered
+	}
+	if size > 0 && size < MinFrameSize {
+		size = MinFrameSize
+	}
+	return size
+}
+
+// marshalPayload serializes a payload in the given encoding
+func marshalPayload(encoding Encoding, v interface{}) ([]byte, error) {
+	if encoding != EncodingMsgpack {
+		return json.Marshal(v)
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json") // Reuse the JSON field names and omitempty rules
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unmarshalPayload deserializes a payload in the given encoding
+func unmarshalPayload(encoding Encoding, data []byte, v interface{}) error {
+	if encoding != EncodingMsgpack {
+		return json.Unmarshal(data, v)
+	}
+
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+var (
+	zstdEncOnce sync.Once
+	zstdEnc     *zstd.Encoder
+	zstdDecOnce sync.Once
+	zstdDec     *zstd.Decoder
+)
+
+// zstdEncoder returns the shared zstd encoder
+func zstdEncoder() *zstd.Encoder {
+	zstdEncOnce.Do(func() {
+		zstdEnc, _ = zstd.NewWriter(nil)
+	})
+	return zstdEnc
+}
+
+// zstdDecoder returns the shared zstd decoder
+func zstdDecoder() *zstd.Decoder {
+	zstdDecOnce.Do(func() {
+		zstdDec, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+	})
+	return zstdDec
+}
@@ -0,0 +1,250 @@
+package model
+
+import (
+	"errors"
+	"math/rand/v2"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPayload returns a status update whose message is size bytes. Text mixes in
+// random letters so compression does not make every message tiny.
+func testPayload(size int) *StatusUpdate {
+	var message strings.Builder
+	rng := rand.New(rand.NewPCG(1, uint64(size)))
+	for message.Len() < size {
+		if rng.IntN(4) == 0 {
+			message.WriteByte(byte('a' + rng.IntN(26)))
+		} else {
+			message.WriteString("status ")
+		}
+	}
+	return &StatusUpdate{
+		EventID:   "event-1",
+		AgentID:   "agent-1",
+		State:     StateInProgress,
+		Phase:     PhaseApplying,
+		Message:   message.String()[:size],
+		Details:   map[string]interface{}{"attempt": "1"},
+		Timestamp: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         CodecConfig
+		size        int
+		wantFrames  int  // 0 to only require more than one
+		wantBinary  bool // Frames are binary
+		wantCompact bool // Encoded frames are smaller than the payload
+		reverse     bool // Deliver chunks in reverse order
+	}{
+		{name: "json", cfg: CodecConfig{Encoding: EncodingJSON}, size: 100, wantFrames: 1},
+		{name: "msgpack", cfg: CodecConfig{Encoding: EncodingMsgpack}, size: 100, wantFrames: 1, wantBinary: true},
+		{name: "json with zstd", cfg: CodecConfig{Encoding: EncodingJSON, Compression: CompressionZstd}, size: 64 << 10,
+			wantFrames: 1, wantBinary: true, wantCompact: true},
+		{name: "msgpack with zstd below threshold", cfg: CodecConfig{Encoding: EncodingMsgpack, Compression: CompressionZstd},
+			size: 100, wantFrames: 1, wantBinary: true},
+		{name: "deflate is left to the transport", cfg: CodecConfig{Compression: CompressionDeflate}, size: 64 << 10, wantFrames: 1},
+		{name: "json chunked", cfg: CodecConfig{MaxFrameSize: 4096}, size: 64 << 10, wantBinary: true},
+		{name: "msgpack chunked out of order", cfg: CodecConfig{Encoding: EncodingMsgpack, MaxFrameSize: 4096}, size: 64 << 10,
+			wantBinary: true, reverse: true},
+		{name: "zstd chunked", cfg: CodecConfig{Encoding: EncodingMsgpack, Compression: CompressionZstd, MaxFrameSize: 2048},
+			size: 256 << 10, wantBinary: true, wantCompact: true},
+		{name: "exactly one frame", cfg: CodecConfig{MaxFrameSize: 1 << 20}, size: 64 << 10, wantFrames: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Version = ProtocolVersion
+			sender, receiver := NewCodec(tt.cfg), NewCodec(tt.cfg)
+			payload := testPayload(tt.size)
+
+			frames, seq, err := sender.Encode(MessageTypeStatusUpdate, payload)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if tt.wantFrames > 0 && len(frames) != tt.wantFrames || tt.wantFrames == 0 && len(frames) < 2 {
+				t.Fatalf("encoded %d frames, want %d (0 for several)", len(frames), tt.wantFrames)
+			}
+			encoded := 0
+			for _, frame := range frames {
+				if frame.Binary != tt.wantBinary {
+					t.Fatalf("frame binary = %v, want %v", frame.Binary, tt.wantBinary)
+				}
+				if tt.cfg.MaxFrameSize > 0 && len(frame.Data) > tt.cfg.MaxFrameSize {
+					t.Fatalf("frame of %d bytes exceeds max frame size %d", len(frame.Data), tt.cfg.MaxFrameSize)
+				}
+				encoded += len(frame.Data)
+			}
+			if tt.wantCompact && encoded >= tt.size {
+				t.Fatalf("encoded %d bytes for a %d byte payload, want compression", encoded, tt.size)
+			}
+
+			if tt.reverse {
+				for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+					frames[i], frames[j] = frames[j], frames[i]
+				}
+			}
+			var env *Envelope
+			for i, frame := range frames {
+				env, err = receiver.Decode(frame.Binary, frame.Data)
+				if err != nil {
+					t.Fatalf("Decode frame %d: %v", i, err)
+				}
+				if env != nil && i < len(frames)-1 {
+					t.Fatalf("message completed after %d of %d frames", i+1, len(frames))
+				}
+			}
+			if env == nil {
+				t.Fatal("message incomplete after every frame")
+			}
+
+			if env.Type != MessageTypeStatusUpdate || env.Seq != seq || env.Version != ProtocolVersion {
+				t.Fatalf("envelope = %s seq %d v%d, want %s seq %d v%d",
+					env.Type, env.Seq, env.Version, MessageTypeStatusUpdate, seq, ProtocolVersion)
+			}
+			var decoded StatusUpdate
+			if err := env.DecodePayload(&decoded); err != nil {
+				t.Fatalf("DecodePayload: %v", err)
+			}
+			if !decoded.Timestamp.Equal(payload.Timestamp) {
+				t.Fatalf("timestamp = %v, want %v", decoded.Timestamp, payload.Timestamp)
+			}
+			decoded.Timestamp = payload.Timestamp // msgpack decodes times in the local zone
+			if !reflect.DeepEqual(&decoded, payload) {
+				t.Fatalf("decoded payload differs:\n%+v\nwant\n%+v", &decoded, payload)
+			}
+		})
+	}
+}
+
+func TestCodecRejectsBadChunks(t *testing.T) {
+	chunked := CodecConfig{Version: ProtocolVersion, Encoding: EncodingMsgpack, MaxFrameSize: 2048}
+
+	tests := []struct {
+		name     string
+		receiver CodecConfig
+		frames   func(frames []Frame) []Frame
+		wantErr  error
+	}{
+		{
+			name:     "reassembled message too large",
+			receiver: CodecConfig{Version: ProtocolVersion, MaxMessageSize: 8 << 10},
+			frames:   func(frames []Frame) []Frame { return frames },
+			wantErr:  ErrMessageTooLarge,
+		},
+		{
+			name:     "duplicate chunks are ignored",
+			receiver: chunked,
+			frames: func(frames []Frame) []Frame {
+				return append([]Frame{frames[0], frames[0]}, frames[1:]...)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, _, err := NewCodec(chunked).Encode(MessageTypeStatusUpdate, testPayload(32<<10))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			receiver := NewCodec(tt.receiver)
+			var env *Envelope
+			for _, frame := range tt.frames(frames) {
+				env, err = receiver.Decode(frame.Binary, frame.Data)
+				if err != nil {
+					break
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decode error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && env == nil {
+				t.Fatal("message incomplete after every frame")
+			}
+		})
+	}
+}
+
+func TestCodecChunkValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     CodecConfig
+		chunk   ChunkMessage
+		wantErr error
+	}{
+		{name: "no chunks", chunk: ChunkMessage{ID: 1, Total: 0}, wantErr: ErrInvalidChunk},
+		{name: "index past total", chunk: ChunkMessage{ID: 1, Index: 2, Total: 2}, wantErr: ErrInvalidChunk},
+		{name: "negative index", chunk: ChunkMessage{ID: 1, Index: -1, Total: 2}, wantErr: ErrInvalidChunk},
+		{name: "total past chunk limit", chunk: ChunkMessage{ID: 1, Total: 1 << 40}, wantErr: ErrInvalidChunk},
+		{
+			name:    "total past message size",
+			cfg:     CodecConfig{MaxMessageSize: 64 << 10},
+			chunk:   ChunkMessage{ID: 1, Total: 1000},
+			wantErr: ErrMessageTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Version = ProtocolVersion
+			codec := NewCodec(tt.cfg)
+			if _, _, _, err := codec.reassemble(&tt.chunk); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("reassemble = %v, want %v", err, tt.wantErr)
+			}
+			if len(codec.partials) != 0 {
+				t.Fatalf("%d partial messages kept after a rejected chunk", len(codec.partials))
+			}
+		})
+	}
+
+	// A chunk that disagrees with earlier chunks about the total drops the message
+	codec := NewCodec(CodecConfig{Version: ProtocolVersion})
+	if _, _, _, err := codec.reassemble(&ChunkMessage{ID: 1, Index: 0, Total: 3, Data: []byte("a")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := codec.reassemble(&ChunkMessage{ID: 1, Index: 1, Total: 2, Data: []byte("b")}); !errors.Is(err, ErrInvalidChunk) {
+		t.Fatalf("mismatched total = %v, want ErrInvalidChunk", err)
+	}
+}
+
+func TestNegotiateFrameSize(t *testing.T) {
+	tests := []struct {
+		name             string
+		offered, allowed int
+		want             int
+	}{
+		{name: "both unlimited", want: 0},
+		{name: "offered unlimited", allowed: 4096, want: 4096},
+		{name: "allowed unlimited", offered: 4096, want: 4096},
+		{name: "smaller offer wins", offered: 2048, allowed: 4096, want: 2048},
+		{name: "smaller limit wins", offered: 8192, allowed: 4096, want: 4096},
+		{name: "tiny offer raised", offered: 64, allowed: 4096, want: MinFrameSize},
+		{name: "tiny limit raised", allowed: 100, want: MinFrameSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NegotiateFrameSize(tt.offered, tt.allowed); got != tt.want {
+				t.Fatalf("NegotiateFrameSize(%d, %d) = %d, want %d", tt.offered, tt.allowed, got, tt.want)
+			}
+		})
+	}
+
+	// Chunk frames must fit the smallest negotiated size
+	codec := NewCodec(CodecConfig{Version: ProtocolVersion, MaxFrameSize: NegotiateFrameSize(1, 0)})
+	frames, _, err := codec.Encode(MessageTypeStatusUpdate, testPayload(16<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, frame := range frames {
+		if len(frame.Data) > MinFrameSize {
+			t.Fatalf("frame of %d bytes exceeds minimum frame size %d", len(frame.Data), MinFrameSize)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package model
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,9 +15,10 @@ import (
 type EventType string
 
 const (
-	EventTypeK8sResource EventType = "k8s_resource"
-	EventTypeScript      EventType = "script"
-	EventTypePolicy      EventType = "policy"
+	EventTypeK8sResource  EventType = "k8s_resource"
+	EventTypeScript       EventType = "script"
+	EventTypePolicy       EventType = "policy"
+	EventTypeAgentUpgrade EventType = "agent_upgrade"
 )
 
 // Event represents a task to be executed by a data plane agent
@@ -22,15 +28,113 @@ type Event struct {
 	Type        EventType `json:"type"`         // Type of event (k8s_resource, script, policy)
 	TargetAgent string    `json:"target_agent"` // Explicit agent ID to execute this event
 
+	// TargetSelector fans the event out to every matching agent (instead of TargetAgent)
+	TargetSelector *AgentSelector `json:"target_selector,omitempty"`
+
 	// Payload
 	Payload EventPayload `json:"payload"`
 
 	// Metadata
 	CreatedAt time.Time         `json:"created_at"`
-	CreatedBy string            `json:"created_by"`          // User/system that created the event
-	TTL       time.Duration     `json:"ttl"`                 // Time-to-live for event expiration
-	Priority  int               `json:"priority"`            // Priority for future use (higher = more urgent)
-	Labels    map[string]string `json:"labels,omitempty"`    // Optional labels for filtering/grouping
+	CreatedBy string            `json:"created_by"`       // User/system that created the event
+	TTL       time.Duration     `json:"ttl"`              // Time-to-live for event expiration
+	Priority  int               `json:"priority"`         // Priority for future use (higher = more urgent)
+	Labels    map[string]string `json:"labels,omitempty"` // Optional labels for filtering/grouping
+
+	// Impersonation
+	Impersonate *ImpersonationTarget `json:"impersonate,omitempty"` // Identity the agent should act as (nil for the agent's own)
+
+	// Failover decides what happens if the agent is lost while executing the event
+	Failover FailoverPolicy `json:"failover,omitempty"`
+}
+
+// FailoverPolicy defines how an event assigned to a lost agent is handled
+type FailoverPolicy string
+
+const (
+	FailoverMarkUnknown FailoverPolicy = "mark_unknown" // Mark the event unknown (default)
+	FailoverRequeue     FailoverPolicy = "requeue"      // Re-queue the event for the agent's return
+)
+
+// ImpersonationTarget identifies the Kubernetes identity an event is applied as
+type ImpersonationTarget struct {
+	User           string   `json:"user,omitempty"`            // User name to impersonate
+	Groups         []string `json:"groups,omitempty"`          // Groups to impersonate
+	ServiceAccount string   `json:"service_account,omitempty"` // Service account as "namespace/name"
+}
+
+// UserName returns the Kubernetes user name to impersonate
+func (it *ImpersonationTarget) UserName() string {
+	if it.ServiceAccount != "" {
+		namespace, name, _ := strings.Cut(it.ServiceAccount, "/")
+		return "system:serviceaccount:" + namespace + ":" + name
+	}
+	return it.User
+}
+
+// Validate performs basic validation on the impersonation target
+func (it *ImpersonationTarget) Validate() error {
+	if it.User != "" && it.ServiceAccount != "" {
+		return ErrConflictingImpersonation
+	}
+	if it.User == "" && it.ServiceAccount == "" {
+		return ErrMissingImpersonationUser
+	}
+	if it.ServiceAccount != "" {
+		namespace, name, ok := strings.Cut(it.ServiceAccount, "/")
+		if !ok || namespace == "" || name == "" {
+			return ErrInvalidServiceAccount
+		}
+	}
+	return nil
+}
+
+// AgentSelector selects agents by cluster facts and labels. All set fields must match.
+type AgentSelector struct {
+	Labels   map[string]string `json:"labels,omitempty"`
+	Cluster  string            `json:"cluster,omitempty"`
+	Provider string            `json:"provider,omitempty"`
+	Region   string            `json:"region,omitempty"`
+}
+
+// IsEmpty reports whether the selector sets no criteria
+func (s *AgentSelector) IsEmpty() bool {
+	return len(s.Labels) == 0 && s.Cluster == "" && s.Provider == "" && s.Region == ""
+}
+
+// Matches reports whether the agent satisfies the selector
+func (s *AgentSelector) Matches(agent *Agent) bool {
+	if s.Cluster != "" && agent.ClusterName != s.Cluster {
+		return false
+	}
+	if s.Provider != "" && agent.ClusterProvider != s.Provider {
+		return false
+	}
+	if s.Region != "" && agent.Region != s.Region {
+		return false
+	}
+	for key, value := range s.Labels {
+		if actual, ok := agent.Labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// eventCapabilities maps event types to the agent capability that executes them
+var eventCapabilities = map[EventType]string{
+	EventTypeK8sResource:  CapabilityK8sCRUD,
+	EventTypeScript:       CapabilityScriptExec,
+	EventTypePolicy:       CapabilityPolicy,
+	EventTypeAgentUpgrade: CapabilityAgentUpgrade,
+}
+
+// RequiredCapabilities returns the agent capabilities needed to execute the event
+func (e *Event) RequiredCapabilities() []string {
+	if capability, ok := eventCapabilities[e.Type]; ok {
+		return []string{capability}
+	}
+	return nil
 }
 
 // EventPayload contains the actual data/instructions for the event
@@ -44,6 +148,15 @@ type EventPayload struct {
 
 	// Policy Payload (for EventTypePolicy)
 	PolicyRules []PolicyRule `json:"policy_rules,omitempty"` // Policy validation rules
+
+	// Agent Upgrade Payload (for EventTypeAgentUpgrade)
+	AgentUpgrade *AgentUpgradeSpec `json:"agent_upgrade,omitempty"`
+}
+
+// AgentUpgradeSpec is the image and version an agent should upgrade itself to
+type AgentUpgradeSpec struct {
+	Image   string `json:"image"`   // Full image reference for the agent container
+	Version string `json:"version"` // Version the new image reports at registration
 }
 
 // PolicyRule represents a validation rule to enforce
@@ -70,6 +183,32 @@ func NewEvent(eventType EventType, targetAgent string, payload EventPayload, cre
 	}
 }
 
+// ContentHash returns a hash of what the event applies: its type, payload and
+// impersonation. Events with the same content share a hash regardless of ID and target.
+func (e *Event) ContentHash() string {
+	data, _ := json.Marshal(struct {
+		Type        EventType            `json:"type"`
+		Payload     EventPayload         `json:"payload"`
+		Impersonate *ImpersonationTarget `json:"impersonate,omitempty"`
+	}{e.Type, e.Payload, e.Impersonate})
+
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Clone copies the event under a new ID with a fresh creation time, keeping its
+// content, target and settings
+func (e *Event) Clone() *Event {
+	clone := *e
+	clone.ID = uuid.New().String()
+	clone.CreatedAt = time.Now()
+	clone.Labels = maps.Clone(e.Labels)
+	if clone.Labels == nil {
+		clone.Labels = make(map[string]string)
+	}
+	return &clone
+}
+
 // IsExpired checks if the event has exceeded its TTL
 func (e *Event) IsExpired() bool {
 	return time.Since(e.CreatedAt) > e.TTL
@@ -80,9 +219,20 @@ func (e *Event) Validate() error {
 	if e.ID == "" {
 		return ErrMissingEventID
 	}
-	if e.TargetAgent == "" {
+	if e.TargetAgent == "" && e.TargetSelector == nil {
 		return ErrMissingTargetAgent
 	}
+	if e.TargetAgent != "" && e.TargetSelector != nil {
+		return ErrConflictingTarget
+	}
+	if e.TargetSelector != nil && e.TargetSelector.IsEmpty() {
+		return ErrEmptySelector
+	}
+	switch e.Failover {
+	case "", FailoverMarkUnknown, FailoverRequeue:
+	default:
+		return ErrInvalidFailoverPolicy
+	}
 	if e.Type == "" {
 		return ErrMissingEventType
 	}
@@ -101,22 +251,48 @@ func (e *Event) Validate() error {
 		if len(e.Payload.PolicyRules) == 0 {
 			return ErrEmptyPolicyRules
 		}
+	case EventTypeAgentUpgrade:
+		if e.Payload.AgentUpgrade == nil || e.Payload.AgentUpgrade.Image == "" {
+			return ErrMissingUpgradeImage
+		}
+		if e.Payload.AgentUpgrade.Version == "" {
+			return ErrMissingUpgradeVersion
+		}
 	default:
 		return ErrUnknownEventType
 	}
 
+	if e.Impersonate != nil {
+		if err := e.Impersonate.Validate(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 // Custom errors for event validation
 var (
-	ErrMissingEventID     = &EventError{Code: "MISSING_EVENT_ID", Message: "event ID is required"}
-	ErrMissingTargetAgent = &EventError{Code: "MISSING_TARGET_AGENT", Message: "target agent is required"}
-	ErrMissingEventType   = &EventError{Code: "MISSING_EVENT_TYPE", Message: "event type is required"}
-	ErrEmptyManifests     = &EventError{Code: "EMPTY_MANIFESTS", Message: "k8s_resource event must have at least one manifest"}
-	ErrEmptyScript        = &EventError{Code: "EMPTY_SCRIPT", Message: "script event must have script content"}
-	ErrEmptyPolicyRules   = &EventError{Code: "EMPTY_POLICY_RULES", Message: "policy event must have at least one rule"}
-	ErrUnknownEventType   = &EventError{Code: "UNKNOWN_EVENT_TYPE", Message: "unknown event type"}
+	ErrMissingEventID        = &EventError{Code: "MISSING_EVENT_ID", Message: "event ID is required"}
+	ErrMissingTargetAgent    = &EventError{Code: "MISSING_TARGET_AGENT", Message: "target agent is required"}
+	ErrMissingEventType      = &EventError{Code: "MISSING_EVENT_TYPE", Message: "event type is required"}
+	ErrEmptyManifests        = &EventError{Code: "EMPTY_MANIFESTS", Message: "k8s_resource event must have at least one manifest"}
+	ErrEmptyScript           = &EventError{Code: "EMPTY_SCRIPT", Message: "script event must have script content"}
+	ErrEmptyPolicyRules      = &EventError{Code: "EMPTY_POLICY_RULES", Message: "policy event must have at least one rule"}
+	ErrUnknownEventType      = &EventError{Code: "UNKNOWN_EVENT_TYPE", Message: "unknown event type"}
+	ErrMissingUpgradeImage   = &EventError{Code: "MISSING_UPGRADE_IMAGE", Message: "agent upgrade requires an image"}
+	ErrMissingUpgradeVersion = &EventError{Code: "MISSING_UPGRADE_VERSION", Message: "agent upgrade requires a target version"}
+
+	ErrConflictingTarget     = &EventError{Code: "CONFLICTING_TARGET", Message: "event cannot set both target agent and target selector"}
+	ErrEmptySelector         = &EventError{Code: "EMPTY_SELECTOR", Message: "target selector must set at least one criterion"}
+	ErrMissingCapability     = &EventError{Code: "MISSING_CAPABILITY", Message: "target agent lacks a capability required by the event"}
+	ErrNoMatchingAgents      = &EventError{Code: "NO_MATCHING_AGENTS", Message: "no agents match the target selector"}
+	ErrInvalidFailoverPolicy = &EventError{Code: "INVALID_FAILOVER_POLICY", Message: "failover must be mark_unknown or requeue"}
+	ErrEventNotFound         = &EventError{Code: "EVENT_NOT_FOUND", Message: "event not found"}
+
+	ErrMissingImpersonationUser = &EventError{Code: "MISSING_IMPERSONATION_USER", Message: "impersonation requires a user or service account"}
+	ErrConflictingImpersonation = &EventError{Code: "CONFLICTING_IMPERSONATION", Message: "impersonation cannot set both user and service account"}
+	ErrInvalidServiceAccount    = &EventError{Code: "INVALID_SERVICE_ACCOUNT", Message: "impersonated service account must be namespace/name"}
 )
 
 // EventError represents an event-related error
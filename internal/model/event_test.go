@@ -0,0 +1,109 @@
+package model
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestImpersonationTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   ImpersonationTarget
+		wantUser string
+		wantErr  error
+	}{
+		{name: "user", target: ImpersonationTarget{User: "alice", Groups: []string{"dev"}}, wantUser: "alice"},
+		{
+			name:     "service account",
+			target:   ImpersonationTarget{ServiceAccount: "team-a/deployer"},
+			wantUser: "system:serviceaccount:team-a:deployer",
+		},
+		{name: "groups only", target: ImpersonationTarget{Groups: []string{"dev"}}, wantErr: ErrMissingImpersonationUser},
+		{
+			name:    "user and service account",
+			target:  ImpersonationTarget{User: "alice", ServiceAccount: "team-a/deployer"},
+			wantErr: ErrConflictingImpersonation,
+		},
+		{name: "service account without namespace", target: ImpersonationTarget{ServiceAccount: "deployer"}, wantErr: ErrInvalidServiceAccount},
+		{name: "service account with empty name", target: ImpersonationTarget{ServiceAccount: "team-a/"}, wantErr: ErrInvalidServiceAccount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.target.Validate()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && tt.target.UserName() != tt.wantUser {
+				t.Fatalf("UserName() = %q, want %q", tt.target.UserName(), tt.wantUser)
+			}
+
+			// Events are rejected with the target's error
+			event := &Event{ID: "event-1", Type: EventTypeK8sResource, TargetAgent: "agent-1", Impersonate: &tt.target,
+				Payload: EventPayload{Manifests: []string{"kind: ConfigMap"}}}
+			if err := event.Validate(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("event Validate() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEventContentHash(t *testing.T) {
+	base := func() *Event {
+		return NewEvent(EventTypeK8sResource, "agent-1", EventPayload{Manifests: []string{"kind: ConfigMap"}}, "alice")
+	}
+	hash := base().ContentHash()
+
+	tests := []struct {
+		name     string
+		modify   func(event *Event)
+		wantSame bool
+	}{
+		{name: "identity, target and labels are ignored", wantSame: true, modify: func(event *Event) {
+			event.ID = "other"
+			event.TargetAgent = "agent-2"
+			event.CreatedBy = "bob"
+			event.Labels = map[string]string{"team": "payments"}
+		}},
+		{name: "manifests", modify: func(event *Event) { event.Payload.Manifests = []string{"kind: Secret"} }},
+		{name: "manifest order", modify: func(event *Event) {
+			event.Payload.Manifests = append([]string{"kind: Secret"}, event.Payload.Manifests...)
+		}},
+		{name: "type", modify: func(event *Event) { event.Type = EventTypeScript }},
+		{name: "impersonation", modify: func(event *Event) { event.Impersonate = &ImpersonationTarget{User: "alice"} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := base()
+			tt.modify(event)
+			if got := event.ContentHash(); (got == hash) != tt.wantSame {
+				t.Fatalf("ContentHash() = %s, base %s; want same %v", got, hash, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestEventClone(t *testing.T) {
+	event := NewEvent(EventTypeK8sResource, "agent-1", EventPayload{Manifests: []string{"kind: ConfigMap"}}, "alice")
+	event.CreatedAt = event.CreatedAt.Add(-time.Hour)
+	event.Labels = map[string]string{"team": "payments"}
+
+	clone := event.Clone()
+	if clone.ID == event.ID || !clone.CreatedAt.After(event.CreatedAt) {
+		t.Fatalf("clone %s created %v, want a new ID and creation time", clone.ID, clone.CreatedAt)
+	}
+	if clone.ContentHash() != event.ContentHash() || clone.TargetAgent != event.TargetAgent || clone.CreatedBy != event.CreatedBy {
+		t.Fatalf("clone %+v does not keep the event's content and target", clone)
+	}
+	clone.Labels["resubmitted"] = "true"
+	if _, ok := event.Labels["resubmitted"]; ok {
+		t.Fatal("clone shares its labels with the original")
+	}
+
+	event.Labels = nil
+	if event.Clone().Labels == nil {
+		t.Fatal("clone of an unlabelled event has nil labels")
+	}
+}
@@ -0,0 +1,216 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Protocol versions understood by this build.
+// Version 0 is the original unversioned protocol where messages are flat JSON
+// objects switched on a "type" field and registration is a bare AgentRegistration.
+const (
+	ProtocolVersionLegacy = 0
+	ProtocolVersion       = 1 // Current (highest) protocol version
+)
+
+// SupportedProtocolVersions lists the versions this build can speak, lowest first
+var SupportedProtocolVersions = []int{ProtocolVersionLegacy, ProtocolVersion}
+
+// MessageType identifies the kind of message carried by an Envelope
+type MessageType string
+
+const (
+	MessageTypeRegister     MessageType = "register"      // Agent -> CP: registration request
+	MessageTypeRegistered   MessageType = "registered"    // CP -> Agent: registration accepted
+	MessageTypeHeartbeat    MessageType = "heartbeat"     // Agent -> CP: liveness and health metrics
+	MessageTypeEvent        MessageType = "event"         // CP -> Agent: event to execute
+	MessageTypeAck          MessageType = "ack"           // Either direction: acknowledges a message by sequence number
+	MessageTypeStatusUpdate MessageType = "status_update" // Agent -> CP: event execution status
+	MessageTypeCancel       MessageType = "cancel"        // CP -> Agent: cancel an event
+	MessageTypeError        MessageType = "error"         // Either direction: protocol or processing error
+	MessageTypeChunk        MessageType = "chunk"         // Either direction: fragment of a message larger than the frame size
+	MessageTypeDrain        MessageType = "drain"         // Agent -> CP: agent is draining and accepts no new events
+)
+
+// Envelope is the versioned wrapper for every message exchanged over the agent WebSocket
+type Envelope struct {
+	Version   int             `json:"v"`
+	Type      MessageType     `json:"type"`
+	Seq       uint64          `json:"seq"`               // Sender-assigned sequence number, increasing per connection
+	Timestamp time.Time       `json:"ts"`                // When the message was sent
+	Payload   json.RawMessage `json:"payload,omitempty"` // Type-specific payload
+
+	encoding Encoding // Encoding of Payload (JSON unless received in a binary frame)
+}
+
+// RegisterMessage is the payload of a register message.
+// The registration fields are inlined so a legacy bare registration decodes into it.
+type RegisterMessage struct {
+	AgentRegistration
+	ProtocolVersions []int `json:"protocol_versions,omitempty"` // Versions the agent can speak
+
+	// Transport preferences, most preferred first (omitted by agents that only speak JSON)
+	Encodings    []Encoding    `json:"encodings,omitempty"`
+	Compressions []Compression `json:"compressions,omitempty"`
+	MaxFrameSize int           `json:"max_frame_size,omitempty"` // Largest frame the agent wants to receive
+}
+
+// RegisteredMessage is the payload of a registered message
+type RegisteredMessage struct {
+	AgentID         string `json:"agent_id"`
+	ProtocolVersion int    `json:"protocol_version"` // Version selected for this connection
+	Message         string `json:"message,omitempty"`
+
+	// Transport settings selected for this connection
+	Encoding     Encoding    `json:"encoding,omitempty"`
+	Compression  Compression `json:"compression,omitempty"`
+	MaxFrameSize int         `json:"max_frame_size,omitempty"`
+}
+
+// ChunkMessage is the payload of a chunk message. A frame larger than the
+// negotiated frame size is split into chunks that share an ID.
+type ChunkMessage struct {
+	ID     uint64 `json:"id"`     // Sequence number of the chunked message
+	Index  int    `json:"index"`  // Zero-based chunk index
+	Total  int    `json:"total"`  // Total number of chunks
+	Binary bool   `json:"binary"` // Whether the reassembled frame is binary
+	Data   []byte `json:"data"`
+}
+
+// AckMessage is the payload of an ack message
+type AckMessage struct {
+	Seq     uint64 `json:"seq"`                // Sequence number being acknowledged
+	EventID string `json:"event_id,omitempty"` // Event the acknowledged message referred to
+}
+
+// CancelMessage is the payload of a cancel message
+type CancelMessage struct {
+	EventID string `json:"event_id"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// DrainMessage is the payload of a drain message
+type DrainMessage struct {
+	Reason      string        `json:"reason,omitempty"`
+	InFlight    int           `json:"in_flight"`    // Events still queued or executing
+	GracePeriod time.Duration `json:"grace_period"` // How long the agent waits for them
+}
+
+// ErrorMessage is the payload of an error message
+type ErrorMessage struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Seq     uint64 `json:"seq,omitempty"` // Sequence number of the offending message, if any
+}
+
+// NegotiateProtocolVersion picks the highest version both sides support.
+// Agents that do not advertise versions speak the legacy protocol.
+func NegotiateProtocolVersion(offered []int) (int, error) {
+	if len(offered) == 0 {
+		return ProtocolVersionLegacy, nil
+	}
+
+	best := -1
+	for _, v := range offered {
+		for _, supported := range SupportedProtocolVersions {
+			if v == supported && v > best {
+				best = v
+			}
+		}
+	}
+	if best < 0 {
+		return 0, ErrUnsupportedProtocolVersion
+	}
+	return best, nil
+}
+
+// encodeLegacy renders the message shapes understood by unversioned agents
+func encodeLegacy(msgType MessageType, payload interface{}) ([]byte, error) {
+	var msg interface{}
+
+	switch msgType {
+	case MessageTypeEvent:
+		msg = map[string]interface{}{"type": "event", "event": payload}
+	case MessageTypeRegistered:
+		registered, ok := payload.(*RegisteredMessage)
+		if !ok {
+			return nil, fmt.Errorf("invalid registered payload %T", payload)
+		}
+		msg = map[string]string{"status": "registered", "message": registered.Message}
+	case MessageTypeError:
+		errMsg, ok := payload.(*ErrorMessage)
+		if !ok {
+			return nil, fmt.Errorf("invalid error payload %T", payload)
+		}
+		msg = map[string]string{"error": errMsg.Message}
+	default:
+		return nil, ErrUnsupportedMessageType
+	}
+
+	return json.Marshal(msg)
+}
+
+// DecodeEnvelope parses an inbound message. Legacy (unversioned) messages are
+// wrapped in a version 0 envelope whose payload is the whole original message.
+func DecodeEnvelope(data []byte) (*Envelope, error) {
+	var probe struct {
+		Version *int        `json:"v"`
+		Type    MessageType `json:"type"`
+		ID      string      `json:"id"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("failed to decode message: %w", err)
+	}
+
+	if probe.Version == nil {
+		env := &Envelope{
+			Version: ProtocolVersionLegacy,
+			Type:    probe.Type,
+			Payload: json.RawMessage(data),
+		}
+		// A bare registration is the first message of a legacy agent
+		if env.Type == "" && probe.ID != "" {
+			env.Type = MessageTypeRegister
+		}
+		return env, nil
+	}
+
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("failed to decode envelope: %w", err)
+	}
+	if env.Version > ProtocolVersion {
+		return nil, ErrUnsupportedProtocolVersion
+	}
+	return &env, nil
+}
+
+// DecodePayload unmarshals the envelope payload into v
+func (e *Envelope) DecodePayload(v interface{}) error {
+	if len(e.Payload) == 0 {
+		return fmt.Errorf("%s message has no payload", e.Type)
+	}
+	if err := unmarshalPayload(e.encoding, e.Payload, v); err != nil {
+		return fmt.Errorf("failed to decode %s payload: %w", e.Type, err)
+	}
+	return nil
+}
+
+// Custom errors for the wire protocol
+var (
+	ErrUnsupportedProtocolVersion = &ProtocolError{Code: "UNSUPPORTED_PROTOCOL_VERSION", Message: "no mutually supported protocol version"}
+	ErrUnsupportedMessageType     = &ProtocolError{Code: "UNSUPPORTED_MESSAGE_TYPE", Message: "message type not supported by protocol version"}
+	ErrMessageTooLarge            = &ProtocolError{Code: "MESSAGE_TOO_LARGE", Message: "reassembled message exceeds size limit"}
+	ErrInvalidChunk               = &ProtocolError{Code: "INVALID_CHUNK", Message: "invalid message chunk"}
+)
+
+// ProtocolError represents a wire protocol error
+type ProtocolError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Message
+}
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"fmt"
+	"slices"
 	"time"
 )
 
@@ -15,6 +17,8 @@ const (
 	StateCompleted  ExecutionState = "completed"
 	StateFailed     ExecutionState = "failed"
 	StateExpired    ExecutionState = "expired"
+	StateCancelled  ExecutionState = "cancelled"
+	StateUnknown    ExecutionState = "unknown" // Agent was lost before reporting a final state
 )
 
 // ExecutionPhase represents granular execution phases within InProgress state
@@ -31,14 +35,20 @@ const (
 
 // EventStatus represents the execution status of an event
 type EventStatus struct {
-	EventID      string         `json:"event_id"`
-	AgentID      string         `json:"agent_id"`
-	State        ExecutionState `json:"state"`
-	Phase        ExecutionPhase `json:"phase,omitempty"`         // Current execution phase
-	Message      string         `json:"message,omitempty"`       // Human-readable status message
-	UpdatedAt    time.Time      `json:"updated_at"`
-	ExecutionLog []LogEntry     `json:"execution_log,omitempty"` // Detailed execution log
-	Result       *EventResult   `json:"result,omitempty"`        // Final result (populated when completed/failed)
+	EventID           string         `json:"event_id"`
+	AgentID           string         `json:"agent_id"`
+	EventType         EventType      `json:"event_type,omitempty"` // Type of the event, for statistics
+	CreatedBy         string         `json:"created_by,omitempty"` // Submitter of the event, for statistics
+	State             ExecutionState `json:"state"`
+	Phase             ExecutionPhase `json:"phase,omitempty"`   // Current execution phase
+	Message           string         `json:"message,omitempty"` // Human-readable status message
+	UpdatedAt         time.Time      `json:"updated_at"`
+	ExecutionLog      []LogEntry     `json:"execution_log,omitempty"`       // Most recent execution log entries
+	LogEntriesOmitted int            `json:"log_entries_omitted,omitempty"` // Older entries, only kept in the timeline
+	Result            *EventResult   `json:"result,omitempty"`              // Final result (populated when completed/failed)
+	Cause             *Cause         `json:"cause,omitempty"`               // What made the latest change
+	ReportedAt        time.Time      `json:"reported_at,omitzero"`          // Timestamp of the latest agent update applied
+	WriteID           string         `json:"write_id,omitempty"`            // ID of the latest write, so a retried write is applied once
 }
 
 // LogEntry represents a single log entry during event execution
@@ -47,7 +57,8 @@ type LogEntry struct {
 	Phase     ExecutionPhase         `json:"phase"`
 	Level     LogLevel               `json:"level"` // info, warning, error
 	Message   string                 `json:"message"`
-	Details   map[string]interface{} `json:"details,omitempty"` // Additional structured data
+	Details   map[string]interface{} `json:"details,omitempty"`   // Additional structured data
+	Truncated bool                   `json:"truncated,omitempty"` // Message or details cut short; see the timeline
 }
 
 // LogLevel represents the severity of a log entry
@@ -152,9 +163,55 @@ func (es *EventStatus) MarkExpired() {
 	es.AddLog(LogLevelWarning, "", "Event expired", nil)
 }
 
+// MarkCancelled marks the event as cancelled
+func (es *EventStatus) MarkCancelled(reason string) {
+	es.State = StateCancelled
+	es.Message = reason
+	es.UpdatedAt = time.Now()
+	es.AddLog(LogLevelWarning, "", reason, nil)
+}
+
+// MarkUnknown marks the event as unknown because its agent was lost mid-execution.
+// The agent may still report the real outcome if it comes back.
+func (es *EventStatus) MarkUnknown(reason string) {
+	es.State = StateUnknown
+	es.Message = reason
+	es.UpdatedAt = time.Now()
+	es.AddLog(LogLevelWarning, "", reason, nil)
+}
+
 // IsTerminal returns true if the event is in a terminal state
 func (es *EventStatus) IsTerminal() bool {
-	return es.State == StateCompleted || es.State == StateFailed || es.State == StateExpired
+	return es.State == StateCompleted || es.State == StateFailed || es.State == StateExpired || es.State == StateCancelled
+}
+
+// stateTransitions lists the states an event may move to from each non-terminal
+// state. Transitions only move forward; terminal states accept no further transitions.
+var stateTransitions = map[ExecutionState][]ExecutionState{
+	StateCreated:    {StateQueued, StateAssigned, StateFailed, StateExpired, StateCancelled},
+	StateQueued:     {StateAssigned, StateFailed, StateExpired, StateCancelled},
+	StateAssigned:   {StateInProgress, StateFailed, StateExpired, StateCancelled, StateUnknown},
+	StateInProgress: {StateCompleted, StateFailed, StateExpired, StateCancelled, StateUnknown},
+	// The lost agent reported back, or failover redelivered the event
+	StateUnknown: {StateAssigned, StateInProgress, StateCompleted, StateFailed, StateExpired, StateCancelled},
+}
+
+// CanTransition reports whether an event may move from one state to another.
+// Staying in a non-terminal state is allowed, for phase and log updates.
+func CanTransition(from, to ExecutionState) bool {
+	allowed, ok := stateTransitions[from]
+	if !ok {
+		return false
+	}
+	return from == to || slices.Contains(allowed, to)
+}
+
+// CheckTransition returns ErrInvalidStateTransition if the event may not move to state
+func (es *EventStatus) CheckTransition(state ExecutionState) error {
+	if !CanTransition(es.State, state) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidStateTransition, es.State, state)
+	}
+	return nil
 }
 
 // StatusUpdate is sent by an agent to update event status
@@ -174,6 +231,7 @@ type StatusUpdate struct {
 var (
 	ErrStatusNotFound         = &StatusError{Code: "STATUS_NOT_FOUND", Message: "event status not found"}
 	ErrInvalidStateTransition = &StatusError{Code: "INVALID_STATE_TRANSITION", Message: "invalid state transition"}
+	ErrAgentNotAssigned       = &StatusError{Code: "AGENT_NOT_ASSIGNED", Message: "event is not assigned to this agent"}
 )
 
 // StatusError represents a status-related error
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to ExecutionState
+		want     bool
+	}{
+		{StateCreated, StateQueued, true},
+		{StateCreated, StateAssigned, true},
+		{StateCreated, StateInProgress, false},
+		{StateQueued, StateQueued, true}, // Phase and log updates
+		{StateQueued, StateAssigned, true},
+		{StateQueued, StateExpired, true},
+		{StateQueued, StateInProgress, false},
+		{StateQueued, StateCompleted, false},
+		{StateAssigned, StateInProgress, true},
+		{StateAssigned, StateFailed, true},
+		{StateAssigned, StateUnknown, true},
+		{StateAssigned, StateQueued, false},
+		{StateAssigned, StateCompleted, false},
+		{StateInProgress, StateCompleted, true},
+		{StateInProgress, StateCancelled, true},
+		{StateInProgress, StateQueued, false},
+		{StateInProgress, StateAssigned, false},
+		{StateUnknown, StateAssigned, true},  // Failover redelivery
+		{StateUnknown, StateCompleted, true}, // The lost agent reported back
+		{StateUnknown, StateExpired, true},
+		{StateUnknown, StateQueued, false},
+		{StateCompleted, StateCompleted, false},
+		{StateCompleted, StateFailed, false},
+		{StateFailed, StateInProgress, false},
+		{StateCancelled, StateAssigned, false},
+		{StateExpired, StateQueued, false},
+		{"bogus", StateQueued, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			if got := CanTransition(tt.from, tt.to); got != tt.want {
+				t.Fatalf("CanTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+
+			err := (&EventStatus{State: tt.from}).CheckTransition(tt.to)
+			if tt.want && err != nil {
+				t.Fatalf("CheckTransition = %v, want nil", err)
+			}
+			if !tt.want && !errors.Is(err, ErrInvalidStateTransition) {
+				t.Fatalf("CheckTransition = %v, want ErrInvalidStateTransition", err)
+			}
+		})
+	}
+}
+
+// TestTransitionsMoveForward checks that no state can be reached again once left,
+// except by failover redelivering an event whose outcome is unknown
+func TestTransitionsMoveForward(t *testing.T) {
+	reachable := func(from ExecutionState) map[ExecutionState]bool {
+		seen := map[ExecutionState]bool{}
+		next := []ExecutionState{from}
+		for len(next) > 0 {
+			state := next[0]
+			next = next[1:]
+			if state == StateUnknown {
+				continue
+			}
+			for _, to := range stateTransitions[state] {
+				if !seen[to] {
+					seen[to] = true
+					next = append(next, to)
+				}
+			}
+		}
+		return seen
+	}
+
+	for from := range stateTransitions {
+		if from == StateUnknown {
+			continue
+		}
+		if reachable(from)[from] {
+			t.Errorf("%s can be reached again after leaving it", from)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits on what a status keeps, so a chatty or failing script does not make every
+// status write multi-MB. The full entries are kept in the event's timeline.
+const (
+	MaxStatusLogEntries = 50        // Most recent log entries kept on a status
+	MaxStatusTextSize   = 4 << 10   // Bytes of a message or log details kept on a status
+	MaxTimelineTextSize = 256 << 10 // Bytes of a message or log details kept in the timeline
+	MaxTimelineEntries  = 1000      // Most recent changes kept in an event's timeline
+)
+
+// StatusField names a status field a change can clear
+type StatusField string
+
+const (
+	FieldPhase   StatusField = "phase"
+	FieldMessage StatusField = "message"
+	FieldResult  StatusField = "result"
+)
+
+// ActorKind identifies what caused a status change
+type ActorKind string
+
+const (
+	ActorAgent        ActorKind = "agent"         // The assigned agent reported it
+	ActorRouter       ActorKind = "router"        // Routing, expiry, retries and failover
+	ActorUser         ActorKind = "user"          // An API request
+	ActorControlPlane ActorKind = "control_plane" // Other control plane housekeeping
+)
+
+// Cause says who or what made a status change
+type Cause struct {
+	Actor  ActorKind `json:"actor"`
+	ID     string    `json:"id,omitempty"`     // Agent ID or user name
+	Reason string    `json:"reason,omitempty"` // What triggered the change, e.g. event_expired
+}
+
+// StatusChange is one entry of an event's timeline: the change of its status made
+// by a single write. Timelines are append-only; a status is the result of applying
+// its timeline's changes in order.
+type StatusChange struct {
+	Seq        int            `json:"seq"` // Position in the timeline, from 1
+	Timestamp  time.Time      `json:"timestamp"`
+	From       ExecutionState `json:"from,omitempty"` // Empty for the change that created the status
+	To         ExecutionState `json:"to"`
+	Phase      ExecutionPhase `json:"phase,omitempty"`    // Set if the phase changed
+	Message    string         `json:"message,omitempty"`  // Set if the message changed
+	AgentID    string         `json:"agent_id,omitempty"` // Set if the event moved to another agent
+	EventType  EventType      `json:"event_type,omitempty"`
+	CreatedBy  string         `json:"created_by,omitempty"`
+	Cause      *Cause         `json:"cause,omitempty"`
+	Result     *EventResult   `json:"result,omitempty"`     // Set if the result changed
+	Logs       []LogEntry     `json:"logs,omitempty"`       // Log entries added by the change
+	ReportedAt time.Time      `json:"reported_at,omitzero"` // Set if an agent update was applied
+	Cleared    []StatusField  `json:"cleared,omitempty"`    // Fields the change emptied, since empty fields above mean unchanged
+	WriteID    string         `json:"write_id,omitempty"`   // Set by a write that may be retried
+}
+
+// NewStatusChange describes how next differs from previous, which is nil for an
+// event without a status yet. Log entries appended to previous's log are the ones
+// added.
+func NewStatusChange(previous, next *EventStatus) *StatusChange {
+	if previous == nil {
+		previous = &EventStatus{}
+	}
+
+	change := &StatusChange{
+		Timestamp: time.Now(),
+		From:      previous.State,
+		To:        next.State,
+		Cause:     next.Cause,
+	}
+	if next.Phase != previous.Phase {
+		change.Phase = next.Phase
+		if next.Phase == "" {
+			change.Cleared = append(change.Cleared, FieldPhase)
+		}
+	}
+	if next.Message != previous.Message {
+		change.Message = capText(next.Message, MaxTimelineTextSize)
+		if next.Message == "" {
+			change.Cleared = append(change.Cleared, FieldMessage)
+		}
+	}
+	if next.AgentID != previous.AgentID {
+		change.AgentID = next.AgentID
+	}
+	if next.EventType != previous.EventType {
+		change.EventType = next.EventType
+	}
+	if next.CreatedBy != previous.CreatedBy {
+		change.CreatedBy = next.CreatedBy
+	}
+	if !reflect.DeepEqual(next.Result, previous.Result) {
+		change.Result = next.Result
+		if next.Result == nil {
+			change.Cleared = append(change.Cleared, FieldResult)
+		}
+	}
+	if next.ReportedAt != previous.ReportedAt {
+		change.ReportedAt = next.ReportedAt
+	}
+	if next.WriteID != previous.WriteID {
+		change.WriteID = next.WriteID
+	}
+	if len(next.ExecutionLog) > len(previous.ExecutionLog) {
+		for _, entry := range next.ExecutionLog[len(previous.ExecutionLog):] {
+			change.Logs = append(change.Logs, capLogEntry(entry, MaxTimelineTextSize))
+		}
+	}
+	return change
+}
+
+// Apply updates the status with a change from its timeline. Messages and log
+// entries are capped, and only the most recent log entries are kept.
+func (es *EventStatus) Apply(change *StatusChange) {
+	es.State = change.To
+	if change.Phase != "" {
+		es.Phase = change.Phase
+	}
+	if change.Message != "" {
+		es.Message = capText(change.Message, MaxStatusTextSize)
+	}
+	if change.AgentID != "" {
+		es.AgentID = change.AgentID
+	}
+	if change.EventType != "" {
+		es.EventType = change.EventType
+	}
+	if change.CreatedBy != "" {
+		es.CreatedBy = change.CreatedBy
+	}
+	if change.Result != nil {
+		result := *change.Result
+		result.ErrorMessage = capText(result.ErrorMessage, MaxStatusTextSize)
+		es.Result = &result
+	}
+	if !change.ReportedAt.IsZero() {
+		es.ReportedAt = change.ReportedAt
+	}
+	if change.WriteID != "" {
+		es.WriteID = change.WriteID
+	}
+	for _, field := range change.Cleared {
+		switch field {
+		case FieldPhase:
+			es.Phase = ""
+		case FieldMessage:
+			es.Message = ""
+		case FieldResult:
+			es.Result = nil
+		}
+	}
+	es.Cause = change.Cause
+	es.UpdatedAt = change.Timestamp
+
+	for _, entry := range change.Logs {
+		es.ExecutionLog = append(es.ExecutionLog, capLogEntry(entry, MaxStatusTextSize))
+	}
+	if excess := len(es.ExecutionLog) - MaxStatusLogEntries; excess > 0 {
+		es.ExecutionLog = append([]LogEntry(nil), es.ExecutionLog[excess:]...)
+		es.LogEntriesOmitted += excess
+	}
+}
+
+// MaterializeStatus rebuilds an event's status from its timeline
+func MaterializeStatus(eventID string, timeline []*StatusChange) *EventStatus {
+	status := &EventStatus{EventID: eventID, ExecutionLog: []LogEntry{}}
+	for _, change := range timeline {
+		status.Apply(change)
+	}
+	return status
+}
+
+// capLogEntry truncates the entry's message to limit bytes and drops its details if
+// they encode to more than limit bytes
+func capLogEntry(entry LogEntry, limit int) LogEntry {
+	if len(entry.Message) > limit {
+		entry.Message = capText(entry.Message, limit)
+		entry.Truncated = true
+	}
+	if len(entry.Details) > 0 {
+		if data, err := json.Marshal(entry.Details); err != nil || len(data) > limit {
+			entry.Details = nil
+			entry.Truncated = true
+		}
+	}
+	return entry
+}
+
+// capText truncates text to at most limit bytes, at a rune boundary, noting how much
+// was cut
+func capText(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	cut := max(limit-len(" ... [0000000000 bytes truncated]"), 0)
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s ... [%d bytes truncated]", text[:cut], len(text)-cut)
+}
@@ -0,0 +1,99 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusChangeRoundTrip(t *testing.T) {
+	base := func() *EventStatus {
+		return &EventStatus{
+			EventID:      "event-1",
+			AgentID:      "agent-1",
+			State:        StateInProgress,
+			Phase:        PhaseApplying,
+			Message:      "Applying manifests",
+			ExecutionLog: []LogEntry{{Message: "received"}},
+			Result:       &EventResult{Success: false, ErrorMessage: "partial"},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		edit        func(status *EventStatus)
+		wantCleared []StatusField
+	}{
+		{
+			name: "state and message change",
+			edit: func(status *EventStatus) {
+				status.State = StateCompleted
+				status.Message = "done"
+			},
+		},
+		{
+			name:        "message cleared",
+			edit:        func(status *EventStatus) { status.Message = "" },
+			wantCleared: []StatusField{FieldMessage},
+		},
+		{
+			name:        "result cleared",
+			edit:        func(status *EventStatus) { status.Result = nil },
+			wantCleared: []StatusField{FieldResult},
+		},
+		{
+			name: "phase and message cleared",
+			edit: func(status *EventStatus) {
+				status.State = StateExpired
+				status.Phase = ""
+				status.Message = ""
+			},
+			wantCleared: []StatusField{FieldPhase, FieldMessage},
+		},
+		{
+			name: "log entries added",
+			edit: func(status *EventStatus) {
+				status.ExecutionLog = append(status.ExecutionLog, LogEntry{Message: "applied"}, LogEntry{Message: "verified"})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous, next := base(), base()
+			tt.edit(next)
+
+			change := NewStatusChange(previous, next)
+			if !reflect.DeepEqual(change.Cleared, tt.wantCleared) {
+				t.Fatalf("Cleared = %v, want %v", change.Cleared, tt.wantCleared)
+			}
+
+			applied := base()
+			applied.Apply(change)
+			applied.Cause, applied.UpdatedAt = next.Cause, next.UpdatedAt
+			if !reflect.DeepEqual(applied, next) {
+				t.Fatalf("applied change gives\n%+v\nwant\n%+v", applied, next)
+			}
+		})
+	}
+}
+
+func TestApplyCapsStatus(t *testing.T) {
+	status := &EventStatus{}
+	for i := range MaxStatusLogEntries + 5 {
+		status.Apply(&StatusChange{
+			Timestamp: time.Now(),
+			To:        StateInProgress,
+			Logs:      []LogEntry{{Message: strings.Repeat("x", i)}},
+		})
+	}
+	if len(status.ExecutionLog) != MaxStatusLogEntries || status.LogEntriesOmitted != 5 {
+		t.Fatalf("log = %d entries, %d omitted; want %d and 5", len(status.ExecutionLog), status.LogEntriesOmitted, MaxStatusLogEntries)
+	}
+
+	status.Apply(&StatusChange{To: StateFailed, Message: strings.Repeat("y", MaxStatusTextSize*2)})
+	if len(status.Message) > MaxStatusTextSize || !strings.HasSuffix(status.Message, "bytes truncated]") {
+		t.Fatalf("message of %d bytes was not capped", len(status.Message))
+	}
+}
@@ -0,0 +1,54 @@
+// Package testutil holds helpers shared by tests
+package testutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Within fails the test if fn does not return within 5 seconds, which in these tests
+// means it deadlocked
+func Within(t *testing.T, name string, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s did not return (deadlock?)", name)
+	}
+}
+
+// Kubeconfig writes a kubeconfig that points at server, e.g. an httptest server
+// standing in for the Kubernetes API, and returns its path
+func Kubeconfig(t *testing.T, server string) string {
+	t.Helper()
+	config := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user:
+    token: test
+`, server)
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
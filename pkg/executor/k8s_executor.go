@@ -2,16 +2,22 @@ package executor
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/suyog1pathak/transporter/internal/model"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
@@ -23,6 +29,7 @@ import (
 
 // K8sExecutor executes Kubernetes operations
 type K8sExecutor struct {
+	restConfig      *rest.Config
 	clientset       *kubernetes.Clientset
 	dynamicClient   dynamic.Interface
 	discoveryClient discovery.CachedDiscoveryInterface
@@ -73,6 +80,7 @@ func NewK8sExecutor(config Config) (*K8sExecutor, error) {
 	mapper := restmapper.NewDeferredDiscoveryRESTMapper(discoveryClient)
 
 	return &K8sExecutor{
+		restConfig:      restConfig,
 		clientset:       clientset,
 		dynamicClient:   dynamicClient,
 		discoveryClient: discoveryClient,
@@ -80,13 +88,34 @@ func NewK8sExecutor(config Config) (*K8sExecutor, error) {
 	}, nil
 }
 
-// ExecuteEvent executes a Kubernetes event
-func (ke *K8sExecutor) ExecuteEvent(event *model.Event) (*model.EventResult, error) {
+// clientFor returns the dynamic client to use for the given impersonation target.
+// Impersonated requests get a dedicated client so identities never leak between events.
+func (ke *K8sExecutor) clientFor(target *model.ImpersonationTarget) (dynamic.Interface, error) {
+	if target == nil {
+		return ke.dynamicClient, nil
+	}
+
+	config := rest.CopyConfig(ke.restConfig)
+	config.Impersonate = rest.ImpersonationConfig{
+		UserName: target.UserName(),
+		Groups:   target.Groups,
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create impersonating client for %s: %w", target.UserName(), err)
+	}
+	return client, nil
+}
+
+// ExecuteEvent executes a Kubernetes event. Cancelling ctx stops it before the next manifest.
+// targets are the event's manifests as inspected by InspectManifests, in order.
+func (ke *K8sExecutor) ExecuteEvent(ctx context.Context, event *model.Event, targets []ManifestTarget) (*model.EventResult, error) {
 	startTime := time.Now()
 
 	switch event.Type {
 	case model.EventTypeK8sResource:
-		return ke.executeK8sResource(event)
+		return ke.executeK8sResource(ctx, event, targets)
 	case model.EventTypeScript:
 		return nil, fmt.Errorf("script execution not yet implemented")
 	case model.EventTypePolicy:
@@ -102,12 +131,25 @@ func (ke *K8sExecutor) ExecuteEvent(event *model.Event) (*model.EventResult, err
 }
 
 // executeK8sResource applies Kubernetes manifests
-func (ke *K8sExecutor) executeK8sResource(event *model.Event) (*model.EventResult, error) {
+func (ke *K8sExecutor) executeK8sResource(ctx context.Context, event *model.Event, targets []ManifestTarget) (*model.EventResult, error) {
 	startTime := time.Now()
 	resourceStatuses := make([]model.ResourceStatus, 0)
 
-	for _, manifestYAML := range event.Payload.Manifests {
-		status := ke.applyManifest(manifestYAML)
+	if len(targets) != len(event.Payload.Manifests) {
+		return nil, fmt.Errorf("inspected %d of %d manifests", len(targets), len(event.Payload.Manifests))
+	}
+
+	client, err := ke.clientFor(event.Impersonate)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, manifestYAML := range event.Payload.Manifests {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("execution stopped after %d of %d manifests: %w",
+				len(resourceStatuses), len(event.Payload.Manifests), err)
+		}
+		status := ke.applyManifest(ctx, client, manifestYAML, targets[i])
 		resourceStatuses = append(resourceStatuses, status)
 	}
 
@@ -134,8 +176,10 @@ func (ke *K8sExecutor) executeK8sResource(event *model.Event) (*model.EventResul
 	}, nil
 }
 
-// applyManifest applies a single YAML manifest
-func (ke *K8sExecutor) applyManifest(manifestYAML string) model.ResourceStatus {
+// applyManifest applies a single YAML manifest using the given client. The resource is
+// created or updated as the inspected target says, the operation the agent policy
+// allowed; it fails if the resource appeared or disappeared since it was inspected.
+func (ke *K8sExecutor) applyManifest(ctx context.Context, client dynamic.Interface, manifestYAML string, target ManifestTarget) model.ResourceStatus {
 	// Decode YAML to unstructured object
 	decoder := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme)
 	obj := &unstructured.Unstructured{}
@@ -171,49 +215,64 @@ func (ke *K8sExecutor) applyManifest(manifestYAML string) model.ResourceStatus {
 		if namespace == "" {
 			namespace = "default"
 		}
-		dr = ke.dynamicClient.Resource(mapping.Resource).Namespace(namespace)
+		dr = client.Resource(mapping.Resource).Namespace(namespace)
 	} else {
 		// Cluster-scoped resource
-		dr = ke.dynamicClient.Resource(mapping.Resource)
+		dr = client.Resource(mapping.Resource)
 	}
 
-	// Try to get existing resource
-	ctx := context.Background()
-	existing, err := dr.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if target.Kind != obj.GetKind() || target.Name != obj.GetName() {
+		return model.ResourceStatus{
+			Kind:       obj.GetKind(),
+			Name:       obj.GetName(),
+			Namespace:  obj.GetNamespace(),
+			APIVersion: obj.GetAPIVersion(),
+			Status:     "failed",
+			Message:    fmt.Sprintf("manifest does not match inspected resource %s %s", target.Kind, target.Name),
+		}
+	}
 
-	if err != nil {
-		if errors.IsNotFound(err) {
-			// Resource doesn't exist - create it
-			_, err := dr.Create(ctx, obj, metav1.CreateOptions{})
-			if err != nil {
-				return model.ResourceStatus{
-					Kind:       obj.GetKind(),
-					Name:       obj.GetName(),
-					Namespace:  obj.GetNamespace(),
-					APIVersion: obj.GetAPIVersion(),
-					Status:     "failed",
-					Message:    fmt.Sprintf("failed to create: %v", err),
-				}
+	if !target.Exists {
+		// Resource didn't exist when inspected - create it
+		_, err := dr.Create(ctx, obj, metav1.CreateOptions{})
+		if err != nil {
+			message := fmt.Sprintf("failed to create: %v", err)
+			if errors.IsAlreadyExists(err) {
+				message = "resource was created since it was inspected; not updating it"
 			}
-
 			return model.ResourceStatus{
 				Kind:       obj.GetKind(),
 				Name:       obj.GetName(),
 				Namespace:  obj.GetNamespace(),
 				APIVersion: obj.GetAPIVersion(),
-				Status:     "created",
-				Message:    "Resource created successfully",
+				Status:     "failed",
+				Message:    message,
 			}
 		}
 
-		// Some other error
+		return model.ResourceStatus{
+			Kind:       obj.GetKind(),
+			Name:       obj.GetName(),
+			Namespace:  obj.GetNamespace(),
+			APIVersion: obj.GetAPIVersion(),
+			Status:     "created",
+			Message:    "Resource created successfully",
+		}
+	}
+
+	existing, err := dr.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		message := fmt.Sprintf("failed to get resource: %v", err)
+		if errors.IsNotFound(err) {
+			message = "resource was deleted since it was inspected; not recreating it"
+		}
 		return model.ResourceStatus{
 			Kind:       obj.GetKind(),
 			Name:       obj.GetName(),
 			Namespace:  obj.GetNamespace(),
 			APIVersion: obj.GetAPIVersion(),
 			Status:     "failed",
-			Message:    fmt.Sprintf("failed to get resource: %v", err),
+			Message:    message,
 		}
 	}
 
@@ -262,18 +321,92 @@ func (ke *K8sExecutor) ValidateManifests(manifests []string) error {
 	return nil
 }
 
-// DeleteResource deletes a Kubernetes resource
-func (ke *K8sExecutor) DeleteResource(kind, name, namespace, apiVersion string) error {
-	// Parse GVK
-	gv, err := schema.ParseGroupVersion(apiVersion)
+// ManifestTarget describes the resource a manifest would act on
+type ManifestTarget struct {
+	Group      string
+	Version    string
+	Kind       string
+	Name       string
+	Namespace  string // Effective namespace (empty for cluster-scoped resources)
+	Namespaced bool
+	Exists     bool // Whether the resource already exists in the cluster
+	Delete     bool // Whether the resource is to be deleted rather than applied
+}
+
+// InspectManifests resolves each manifest to the resource it targets without modifying the cluster.
+// Existence is checked as the impersonated identity when one is given.
+func (ke *K8sExecutor) InspectManifests(ctx context.Context, manifests []string, impersonate *model.ImpersonationTarget) ([]ManifestTarget, error) {
+	client, err := ke.clientFor(impersonate)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme)
+	targets := make([]ManifestTarget, 0, len(manifests))
+
+	for _, manifestYAML := range manifests {
+		obj := &unstructured.Unstructured{}
+		_, gvk, err := decoder.Decode([]byte(manifestYAML), nil, obj)
+		if err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+
+		mapping, err := ke.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return nil, fmt.Errorf("unknown API resource %s: %w", gvk.String(), err)
+		}
+
+		target := ManifestTarget{
+			Group:   gvk.Group,
+			Version: gvk.Version,
+			Kind:    gvk.Kind,
+			Name:    obj.GetName(),
+		}
+
+		var dr dynamic.ResourceInterface
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			target.Namespaced = true
+			target.Namespace = obj.GetNamespace()
+			if target.Namespace == "" {
+				target.Namespace = "default"
+			}
+			dr = client.Resource(mapping.Resource).Namespace(target.Namespace)
+		} else {
+			dr = client.Resource(mapping.Resource)
+		}
+
+		_, err = dr.Get(ctx, target.Name, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			target.Exists = true
+		case errors.IsNotFound(err):
+			target.Exists = false
+		default:
+			return nil, fmt.Errorf("failed to look up %s %s: %w", gvk.Kind, target.Name, err)
+		}
+
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+// DeleteResource deletes a Kubernetes resource as the impersonated identity, if any.
+// The agent only calls it once the target, with Delete set, passed its policy check.
+func (ke *K8sExecutor) DeleteResource(ctx context.Context, target ManifestTarget, impersonate *model.ImpersonationTarget) error {
+	if !target.Delete {
+		return fmt.Errorf("target %s %s is not marked for deletion", target.Kind, target.Name)
+	}
+
+	client, err := ke.clientFor(impersonate)
 	if err != nil {
-		return fmt.Errorf("invalid API version: %w", err)
+		return err
 	}
 
 	gvk := schema.GroupVersionKind{
-		Group:   gv.Group,
-		Version: gv.Version,
-		Kind:    kind,
+		Group:   target.Group,
+		Version: target.Version,
+		Kind:    target.Kind,
 	}
 
 	// Find GVR
@@ -285,17 +418,17 @@ func (ke *K8sExecutor) DeleteResource(kind, name, namespace, apiVersion string)
 	// Get resource interface
 	var dr dynamic.ResourceInterface
 	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		namespace := target.Namespace
 		if namespace == "" {
 			namespace = "default"
 		}
-		dr = ke.dynamicClient.Resource(mapping.Resource).Namespace(namespace)
+		dr = client.Resource(mapping.Resource).Namespace(namespace)
 	} else {
-		dr = ke.dynamicClient.Resource(mapping.Resource)
+		dr = client.Resource(mapping.Resource)
 	}
 
 	// Delete resource
-	ctx := context.Background()
-	err = dr.Delete(ctx, name, metav1.DeleteOptions{})
+	err = dr.Delete(ctx, target.Name, metav1.DeleteOptions{})
 	if err != nil && !errors.IsNotFound(err) {
 		return fmt.Errorf("failed to delete resource: %w", err)
 	}
@@ -304,8 +437,7 @@ func (ke *K8sExecutor) DeleteResource(kind, name, namespace, apiVersion string)
 }
 
 // GetResource retrieves a Kubernetes resource
-func (ke *K8sExecutor) GetResource(kind, name, namespace, apiVersion string) (*unstructured.Unstructured, error) {
-	// Similar to DeleteResource but returns the object
+func (ke *K8sExecutor) GetResource(ctx context.Context, kind, name, namespace, apiVersion string) (*unstructured.Unstructured, error) {
 	gv, err := schema.ParseGroupVersion(apiVersion)
 	if err != nil {
 		return nil, fmt.Errorf("invalid API version: %w", err)
@@ -332,7 +464,6 @@ func (ke *K8sExecutor) GetResource(kind, name, namespace, apiVersion string) (*u
 		dr = ke.dynamicClient.Resource(mapping.Resource)
 	}
 
-	ctx := context.Background()
 	obj, err := dr.Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get resource: %w", err)
@@ -340,3 +471,148 @@ func (ke *K8sExecutor) GetResource(kind, name, namespace, apiVersion string) (*u
 
 	return obj, nil
 }
+
+// ClusterHealth is the result of probing the cluster API server
+type ClusterHealth struct {
+	Reachable  bool
+	Latency    time.Duration // Round trip of the server version request
+	Version    string
+	NodeCount  int
+	ReadyNodes int
+	Error      string
+}
+
+// CheckClusterHealth probes API server reachability and latency, the server
+// version, and node readiness
+func (ke *K8sExecutor) CheckClusterHealth(ctx context.Context) *ClusterHealth {
+	health := &ClusterHealth{}
+
+	start := time.Now()
+	version, err := ke.clientset.Discovery().ServerVersion()
+	health.Latency = time.Since(start)
+	if err != nil {
+		health.Error = fmt.Sprintf("failed to reach API server: %v", err)
+		return health
+	}
+	health.Reachable = true
+	health.Version = version.GitVersion
+
+	// ResourceVersion "0" lets the API server answer from its watch cache
+	nodes, err := ke.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{ResourceVersion: "0"})
+	if err != nil {
+		health.Error = fmt.Sprintf("failed to list nodes: %v", err)
+		return health
+	}
+
+	health.NodeCount = len(nodes.Items)
+	for _, node := range nodes.Items {
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+				health.ReadyNodes++
+			}
+		}
+	}
+
+	return health
+}
+
+// ClusterInfo describes the cluster the executor talks to
+type ClusterInfo struct {
+	Version     string
+	NodeCount   int
+	Regions     []string // Distinct topology.kubernetes.io/region node labels
+	Zones       []string // Distinct topology.kubernetes.io/zone node labels
+	ProviderIDs []string // Distinct providerID schemes (aws, gce, azure, kind, ...)
+}
+
+// DiscoverCluster collects the server version and node topology facts
+func (ke *K8sExecutor) DiscoverCluster(ctx context.Context) (*ClusterInfo, error) {
+	version, err := ke.clientset.Discovery().ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server version: %w", err)
+	}
+
+	nodes, err := ke.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{ResourceVersion: "0"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	info := &ClusterInfo{Version: version.GitVersion, NodeCount: len(nodes.Items)}
+	for _, node := range nodes.Items {
+		info.Regions = appendDistinct(info.Regions, node.Labels[corev1.LabelTopologyRegion])
+		info.Zones = appendDistinct(info.Zones, node.Labels[corev1.LabelTopologyZone])
+		if scheme, _, found := strings.Cut(node.Spec.ProviderID, "://"); found {
+			info.ProviderIDs = appendDistinct(info.ProviderIDs, scheme)
+		}
+	}
+
+	return info, nil
+}
+
+// CanI asks the API server whether the executor's identity may perform verb on the
+// resource. An empty namespace means all namespaces, and an empty name any object.
+func (ke *K8sExecutor) CanI(ctx context.Context, verb, group, resource, namespace, name string) (bool, error) {
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Verb:      verb,
+				Group:     group,
+				Resource:  resource,
+				Namespace: namespace,
+				Name:      name,
+			},
+		},
+	}
+
+	result, err := ke.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to review access for %s %s: %w", verb, resource, err)
+	}
+	return result.Status.Allowed, nil
+}
+
+// SetDeploymentImage points a container of a Deployment at a new image and annotates
+// the pod template, which starts a rollout
+func (ke *K8sExecutor) SetDeploymentImage(ctx context.Context, namespace, name, container, image string,
+	annotations map[string]string) error {
+
+	deployment, err := ke.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get deployment %s/%s: %w", namespace, name, err)
+	}
+	// A strategic merge patch would add an unknown container rather than fail
+	if !slices.ContainsFunc(deployment.Spec.Template.Spec.Containers, func(c corev1.Container) bool {
+		return c.Name == container
+	}) {
+		return fmt.Errorf("deployment %s/%s has no container %q", namespace, name, container)
+	}
+
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{"annotations": annotations},
+				"spec": map[string]interface{}{
+					"containers": []map[string]interface{}{{"name": container, "image": image}},
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("failed to encode deployment patch: %w", err)
+	}
+
+	if _, err := ke.clientset.AppsV1().Deployments(namespace).Patch(ctx, name, types.StrategicMergePatchType,
+		data, metav1.PatchOptions{FieldManager: "transporter-agent"}); err != nil {
+		return fmt.Errorf("failed to patch deployment %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// appendDistinct appends value unless it is empty or already present
+func appendDistinct(values []string, value string) []string {
+	if value == "" || slices.Contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
@@ -0,0 +1,115 @@
+package executor
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/internal/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// identity is the impersonation a request to the API server carried
+type identity struct {
+	user   string
+	groups []string
+}
+
+// newImpersonationTestExecutor returns an executor for a fake API server serving
+// ConfigMaps, and the identity each ConfigMap request was made as
+func newImpersonationTestExecutor(t *testing.T) (*K8sExecutor, func() []identity) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var requests []identity
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api":
+			json.NewEncoder(w).Encode(&metav1.APIVersions{Versions: []string{"v1"}})
+		case "/apis":
+			json.NewEncoder(w).Encode(&metav1.APIGroupList{})
+		case "/api/v1":
+			json.NewEncoder(w).Encode(&metav1.APIResourceList{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{{Name: "configmaps", Kind: "ConfigMap", Namespaced: true,
+					Verbs: metav1.Verbs{"get", "create", "patch", "delete"}}},
+			})
+		case "/api/v1/namespaces/team-a/configmaps/settings":
+			mu.Lock()
+			requests = append(requests, identity{user: r.Header.Get("Impersonate-User"), groups: r.Header.Values("Impersonate-Group")})
+			mu.Unlock()
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(&metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonNotFound, Code: http.StatusNotFound})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	ke, err := NewK8sExecutor(Config{KubeconfigPath: testutil.Kubeconfig(t, server.URL)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ke, func() []identity {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(requests)
+	}
+}
+
+func TestImpersonation(t *testing.T) {
+	manifest := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings\n  namespace: team-a\n"
+	tests := []struct {
+		name        string
+		impersonate *model.ImpersonationTarget
+		want        identity
+	}{
+		{name: "agent identity"},
+		{
+			name:        "user and groups",
+			impersonate: &model.ImpersonationTarget{User: "alice", Groups: []string{"dev", "oncall"}},
+			want:        identity{user: "alice", groups: []string{"dev", "oncall"}},
+		},
+		{
+			name:        "service account",
+			impersonate: &model.ImpersonationTarget{ServiceAccount: "team-a/deployer"},
+			want:        identity{user: "system:serviceaccount:team-a:deployer"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ke, requests := newImpersonationTestExecutor(t)
+
+			targets, err := ke.InspectManifests(t.Context(), []string{manifest}, tt.impersonate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(targets) != 1 || targets[0].Exists || targets[0].Namespace != "team-a" {
+				t.Fatalf("InspectManifests() = %+v, want a missing ConfigMap in team-a", targets)
+			}
+			target := targets[0]
+			target.Delete = true
+			if err := ke.DeleteResource(t.Context(), target, tt.impersonate); err != nil {
+				t.Fatal(err)
+			}
+			// The agent's own client is never left impersonating
+			if _, err := ke.InspectManifests(t.Context(), []string{manifest}, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			want := []identity{tt.want, tt.want, {}}
+			got := requests()
+			if !slices.EqualFunc(got, want, func(a, b identity) bool {
+				return a.user == b.user && slices.Equal(a.groups, b.groups)
+			}) {
+				t.Fatalf("requests made as %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -1,60 +1,99 @@
 package registry
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
-	"github.com/suyog1pathak/transporter/internal/model"
 	"github.com/gorilla/websocket"
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
 )
 
 // AgentConnection wraps a websocket connection with an agent
 type AgentConnection struct {
-	Agent      *model.Agent
-	Conn       *websocket.Conn
-	SendChan   chan []byte // Channel for sending messages to agent
-	mu         sync.Mutex
+	Agent    *model.Agent
+	Conn     *websocket.Conn
+	Codec    *model.Codec     // Encodes messages at the negotiated protocol version
+	SendChan chan model.Frame // Channel for sending frames to agent
+	mu       sync.Mutex
+	closed   bool // Set by Close; SendChan is closed and takes no more frames
 }
 
-// Send sends a message to the agent (thread-safe)
+// Send sends a raw text message to the agent (thread-safe)
 func (ac *AgentConnection) Send(message []byte) error {
+	return ac.sendFrames([]model.Frame{{Data: message}})
+}
+
+// sendFrames queues frames for the agent. All frames of a message are queued or none are.
+func (ac *AgentConnection) sendFrames(frames []model.Frame) error {
 	ac.mu.Lock()
 	defer ac.mu.Unlock()
 
-	select {
-	case ac.SendChan <- message:
-		return nil
-	default:
+	if ac.closed {
+		return model.ErrAgentNotConnected
+	}
+	if cap(ac.SendChan)-len(ac.SendChan) < len(frames) {
 		return fmt.Errorf("send channel full for agent %s", ac.Agent.ID)
 	}
+	for _, frame := range frames {
+		ac.SendChan <- frame
+	}
+	return nil
+}
+
+// SendMessage encodes a typed message for the agent's protocol version and queues it.
+// It returns the sequence number assigned to the message.
+func (ac *AgentConnection) SendMessage(msgType model.MessageType, payload interface{}) (uint64, error) {
+	frames, seq, err := ac.Codec.Encode(msgType, payload)
+	if err != nil {
+		return 0, err
+	}
+	return seq, ac.sendFrames(frames)
 }
 
-// Close closes the agent connection
+// Close closes the agent connection. Sends after Close fail with ErrAgentNotConnected.
 func (ac *AgentConnection) Close() error {
 	ac.mu.Lock()
 	defer ac.mu.Unlock()
 
+	if ac.closed {
+		return nil
+	}
+	ac.closed = true
 	close(ac.SendChan)
 	return ac.Conn.Close()
 }
 
+// ErrMaxAgentsReached is returned when registering a new agent with the registry full
+var ErrMaxAgentsReached = errors.New("maximum number of agents reached")
+
 // AgentRegistry manages all connected agents
 type AgentRegistry struct {
-	agents              map[string]*AgentConnection // agentID -> connection
-	mu                  sync.RWMutex
-	heartbeatTimeout    time.Duration
+	agents                 map[string]*AgentConnection // agentID -> connection
+	cordons                map[string]*model.Cordon    // agentID -> cordon, kept across reconnects
+	mu                     sync.RWMutex
+	heartbeatTimeout       time.Duration
 	heartbeatCheckInterval time.Duration
-	onAgentConnected    func(*model.Agent)
-	onAgentDisconnected func(*model.Agent)
+	unhealthyGracePeriod   time.Duration
+	maxAgents              int
+	onAgentConnected       func(*model.Agent)
+	onAgentDisconnected    func(*model.Agent)
+	onAgentUnhealthy       func(*model.Agent)
+	onAgentLost            func(*model.Agent)
 }
 
 // Config holds configuration for the agent registry
 type Config struct {
 	HeartbeatTimeout       time.Duration
 	HeartbeatCheckInterval time.Duration
+	UnhealthyGracePeriod   time.Duration // How long an unhealthy agent's connection is kept before it is closed
+	MaxAgents              int           // Maximum number of registered agents (0 for unlimited)
 	OnAgentConnected       func(*model.Agent)
 	OnAgentDisconnected    func(*model.Agent)
+	OnAgentUnhealthy       func(*model.Agent) // Heartbeats stopped but the connection is still open
+	OnAgentLost            func(*model.Agent) // Connection closed after the unhealthy grace period
 }
 
 // NewAgentRegistry creates a new agent registry
@@ -65,13 +104,21 @@ func NewAgentRegistry(config Config) *AgentRegistry {
 	if config.HeartbeatCheckInterval == 0 {
 		config.HeartbeatCheckInterval = 10 * time.Second
 	}
+	if config.UnhealthyGracePeriod == 0 {
+		config.UnhealthyGracePeriod = 60 * time.Second
+	}
 
 	registry := &AgentRegistry{
-		agents:              make(map[string]*AgentConnection),
-		heartbeatTimeout:    config.HeartbeatTimeout,
+		agents:                 make(map[string]*AgentConnection),
+		cordons:                make(map[string]*model.Cordon),
+		heartbeatTimeout:       config.HeartbeatTimeout,
 		heartbeatCheckInterval: config.HeartbeatCheckInterval,
-		onAgentConnected:    config.OnAgentConnected,
-		onAgentDisconnected: config.OnAgentDisconnected,
+		unhealthyGracePeriod:   config.UnhealthyGracePeriod,
+		maxAgents:              config.MaxAgents,
+		onAgentConnected:       config.OnAgentConnected,
+		onAgentDisconnected:    config.OnAgentDisconnected,
+		onAgentUnhealthy:       config.OnAgentUnhealthy,
+		onAgentLost:            config.OnAgentLost,
 	}
 
 	// Start background health checker
@@ -80,40 +127,51 @@ func NewAgentRegistry(config Config) *AgentRegistry {
 	return registry
 }
 
-// Register registers a new agent connection
-func (ar *AgentRegistry) Register(registration *model.AgentRegistration, conn *websocket.Conn, connectionID string) (*model.Agent, error) {
-	ar.mu.Lock()
-	defer ar.mu.Unlock()
-
+// Register registers a new agent connection. A reconnecting agent replaces its old
+// connection; a new agent is refused with ErrMaxAgentsReached once the registry is
+// full. Callbacks run after ar.mu is released, since they persist state and may call
+// back into the registry.
+func (ar *AgentRegistry) Register(registration *model.AgentRegistration, conn *websocket.Conn, connectionID string, codec *model.Codec) (*model.Agent, error) {
 	// Validate registration
 	if err := registration.Validate(); err != nil {
 		return nil, err
 	}
 
+	ar.mu.Lock()
+
 	// Check if agent already exists
+	var replaced *model.Agent
 	if existing, exists := ar.agents[registration.ID]; exists {
 		// Close old connection
 		existing.Close()
 		existing.Agent.MarkDisconnected()
-		if ar.onAgentDisconnected != nil {
-			ar.onAgentDisconnected(existing.Agent)
-		}
+		replaced = existing.Agent
+	} else if ar.maxAgents > 0 && len(ar.agents) >= ar.maxAgents {
+		ar.mu.Unlock()
+		return nil, ErrMaxAgentsReached
 	}
 
 	// Create agent from registration
 	agent := registration.ToAgent(connectionID)
+	agent.ProtocolVersion = codec.Version()
+	agent.Cordon = ar.cordons[agent.ID]
 
 	// Create agent connection
 	agentConn := &AgentConnection{
 		Agent:    agent,
 		Conn:     conn,
-		SendChan: make(chan []byte, 100), // Buffered channel for messages
+		Codec:    codec,
+		SendChan: make(chan model.Frame, 256), // Buffered channel for frames
 	}
 
 	// Store in registry
 	ar.agents[agent.ID] = agentConn
+	ar.mu.Unlock()
 
-	// Trigger callback
+	// Trigger callbacks
+	if replaced != nil {
+		ar.notifyDisconnected(replaced)
+	}
 	if ar.onAgentConnected != nil {
 		ar.onAgentConnected(agent)
 	}
@@ -124,12 +182,42 @@ func (ar *AgentRegistry) Register(registration *model.AgentRegistration, conn *w
 // Unregister removes an agent from the registry
 func (ar *AgentRegistry) Unregister(agentID string) error {
 	ar.mu.Lock()
-	defer ar.mu.Unlock()
+	agentConn, exists := ar.agents[agentID]
+	if !exists {
+		ar.mu.Unlock()
+		return model.ErrAgentNotFound
+	}
+	agent := ar.removeLocked(agentConn)
+	ar.mu.Unlock()
 
+	ar.notifyDisconnected(agent)
+	return nil
+}
+
+// UnregisterConnection removes an agent only if it is still registered with conn.
+// A dropped connection must not remove the newer connection of a reconnected agent.
+func (ar *AgentRegistry) UnregisterConnection(agentID string, conn *websocket.Conn) error {
+	ar.mu.Lock()
 	agentConn, exists := ar.agents[agentID]
 	if !exists {
+		ar.mu.Unlock()
 		return model.ErrAgentNotFound
 	}
+	if agentConn.Conn != conn {
+		ar.mu.Unlock()
+		return nil // Superseded by a newer connection
+	}
+	agent := ar.removeLocked(agentConn)
+	ar.mu.Unlock()
+
+	ar.notifyDisconnected(agent)
+	return nil
+}
+
+// removeLocked closes and removes an agent connection and returns its agent, for
+// notifyDisconnected once ar.mu is released. Caller must hold ar.mu.
+func (ar *AgentRegistry) removeLocked(agentConn *AgentConnection) *model.Agent {
+	agentID := agentConn.Agent.ID
 
 	// Mark as disconnected
 	agentConn.Agent.MarkDisconnected()
@@ -140,12 +228,14 @@ func (ar *AgentRegistry) Unregister(agentID string) error {
 	// Remove from registry
 	delete(ar.agents, agentID)
 
-	// Trigger callback
+	return agentConn.Agent
+}
+
+// notifyDisconnected runs the disconnect callback. Caller must not hold ar.mu.
+func (ar *AgentRegistry) notifyDisconnected(agent *model.Agent) {
 	if ar.onAgentDisconnected != nil {
-		ar.onAgentDisconnected(agentConn.Agent)
+		ar.onAgentDisconnected(agent)
 	}
-
-	return nil
 }
 
 // Get retrieves an agent connection by ID
@@ -174,6 +264,49 @@ func (ar *AgentRegistry) GetAgent(agentID string) (*model.Agent, error) {
 	return agentConn.Agent, nil
 }
 
+// Cordon excludes an agent from routing until it is uncordoned. The agent does not
+// need to be connected; the cordon applies when it next registers. Returns the live
+// agent, or nil if it is not connected.
+func (ar *AgentRegistry) Cordon(agentID string, cordon *model.Cordon) *model.Agent {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	ar.cordons[agentID] = cordon
+	if agentConn, exists := ar.agents[agentID]; exists {
+		agentConn.Agent.Cordon = cordon
+		return agentConn.Agent
+	}
+	return nil
+}
+
+// Uncordon makes an agent eligible for routing again. Returns the live agent, or nil
+// if it is not connected.
+func (ar *AgentRegistry) Uncordon(agentID string) *model.Agent {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	delete(ar.cordons, agentID)
+	if agentConn, exists := ar.agents[agentID]; exists {
+		agentConn.Agent.Cordon = nil
+		return agentConn.Agent
+	}
+	return nil
+}
+
+// MarkDraining records that a connected agent is draining
+func (ar *AgentRegistry) MarkDraining(agentID string) (*model.Agent, error) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	agentConn, exists := ar.agents[agentID]
+	if !exists {
+		return nil, model.ErrAgentNotFound
+	}
+
+	agentConn.Agent.MarkDraining()
+	return agentConn.Agent, nil
+}
+
 // List returns all registered agents
 func (ar *AgentRegistry) List() []*model.Agent {
 	ar.mu.RLock()
@@ -210,18 +343,22 @@ func (ar *AgentRegistry) Count() int {
 	return len(ar.agents)
 }
 
-// UpdateHeartbeat updates the heartbeat timestamp for an agent
-func (ar *AgentRegistry) UpdateHeartbeat(agentID string) error {
+// UpdateHeartbeat updates the heartbeat timestamp for an agent and, if the heartbeat
+// carried one, its latest health snapshot
+func (ar *AgentRegistry) UpdateHeartbeat(agentID string, health *model.AgentHealth) (*model.Agent, error) {
 	ar.mu.Lock()
 	defer ar.mu.Unlock()
 
 	agentConn, exists := ar.agents[agentID]
 	if !exists {
-		return model.ErrAgentNotFound
+		return nil, model.ErrAgentNotFound
 	}
 
 	agentConn.Agent.UpdateHeartbeat()
-	return nil
+	if health != nil {
+		agentConn.Agent.Health = health
+	}
+	return agentConn.Agent, nil
 }
 
 // healthChecker periodically checks agent health based on heartbeat
@@ -234,22 +371,43 @@ func (ar *AgentRegistry) healthChecker() {
 	}
 }
 
-// checkAgentHealth checks all agents for health based on heartbeat
+// checkAgentHealth marks agents without a recent heartbeat unhealthy, and closes the
+// connection of agents that stay silent past the unhealthy grace period
 func (ar *AgentRegistry) checkAgentHealth() {
 	ar.mu.Lock()
-	defer ar.mu.Unlock()
 
 	now := time.Now()
+	var unhealthy, lost []*model.Agent
 	for agentID, agentConn := range ar.agents {
 		agent := agentConn.Agent
+		timeSinceHeartbeat := now.Sub(agent.LastHeartbeat)
+
+		if timeSinceHeartbeat > ar.heartbeatTimeout+ar.unhealthyGracePeriod {
+			// The socket is most likely half-open; close it so the agent can reconnect cleanly
+			logger.Warn("Closing unresponsive agent connection", "agent_id", agentID, "since_heartbeat", timeSinceHeartbeat)
+			lost = append(lost, ar.removeLocked(agentConn))
+			continue
+		}
 
-		if agent.Status == model.AgentStatusConnected {
-			timeSinceHeartbeat := now.Sub(agent.LastHeartbeat)
-			if timeSinceHeartbeat > ar.heartbeatTimeout {
-				// Mark as unhealthy
-				agent.MarkUnhealthy()
-				fmt.Printf("Agent %s marked unhealthy (no heartbeat for %v)\n", agentID, timeSinceHeartbeat)
-			}
+		if agent.Status == model.AgentStatusConnected && timeSinceHeartbeat > ar.heartbeatTimeout {
+			agent.MarkUnhealthy()
+			logger.Warn("Agent marked unhealthy", "agent_id", agentID, "since_heartbeat", timeSinceHeartbeat)
+			unhealthy = append(unhealthy, agent)
+		}
+	}
+
+	ar.mu.Unlock()
+
+	// Callbacks run unlocked since they persist state and may call back into the registry
+	for _, agent := range unhealthy {
+		if ar.onAgentUnhealthy != nil {
+			ar.onAgentUnhealthy(agent)
+		}
+	}
+	for _, agent := range lost {
+		ar.notifyDisconnected(agent)
+		if ar.onAgentLost != nil {
+			ar.onAgentLost(agent)
 		}
 	}
 }
@@ -264,6 +422,16 @@ func (ar *AgentRegistry) SendToAgent(agentID string, message []byte) error {
 	return agentConn.Send(message)
 }
 
+// SendMessageToAgent encodes and sends a typed message to a specific agent
+func (ar *AgentRegistry) SendMessageToAgent(agentID string, msgType model.MessageType, payload interface{}) (uint64, error) {
+	agentConn, err := ar.Get(agentID)
+	if err != nil {
+		return 0, err
+	}
+
+	return agentConn.SendMessage(msgType, payload)
+}
+
 // BroadcastToAll sends a message to all connected agents
 func (ar *AgentRegistry) BroadcastToAll(message []byte) {
 	ar.mu.RLock()
@@ -0,0 +1,250 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/internal/testutil"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+)
+
+// dialTestConn returns the server side of a websocket connection to a test server
+func dialTestConn(t *testing.T) *websocket.Conn {
+	t.Helper()
+
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return <-conns
+}
+
+func newTestRegistry(t *testing.T, config Config) *AgentRegistry {
+	t.Helper()
+	logger.InitLogger(false)
+	if config.HeartbeatTimeout == 0 {
+		config.HeartbeatTimeout = time.Hour
+	}
+	return NewAgentRegistry(config)
+}
+
+func register(t *testing.T, registry *AgentRegistry, agentID string, conn *websocket.Conn) *AgentConnection {
+	t.Helper()
+	_, err := registry.Register(&model.AgentRegistration{
+		ID:           agentID,
+		Name:         agentID,
+		ClusterName:  "cluster-1",
+		Version:      "test",
+		Capabilities: []string{model.CapabilityK8sCRUD},
+	}, conn, "conn-"+agentID, model.NewCodec(model.CodecConfig{Version: model.ProtocolVersion}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentConn, err := registry.Get(agentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agentConn
+}
+
+func TestSendAfterClose(t *testing.T) {
+	registry := newTestRegistry(t, Config{})
+	agentConn := register(t, registry, "agent-1", dialTestConn(t))
+
+	if err := registry.Unregister("agent-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A sender still holding the connection must not panic on the closed channel
+	if err := agentConn.Send([]byte("hello")); !errors.Is(err, model.ErrAgentNotConnected) {
+		t.Fatalf("Send after close = %v, want ErrAgentNotConnected", err)
+	}
+	if _, err := agentConn.SendMessage(model.MessageTypeHeartbeat, nil); !errors.Is(err, model.ErrAgentNotConnected) {
+		t.Fatalf("SendMessage after close = %v, want ErrAgentNotConnected", err)
+	}
+	if err := agentConn.Close(); err != nil {
+		t.Fatalf("second Close = %v, want nil", err)
+	}
+}
+
+func TestSendFramesBackpressure(t *testing.T) {
+	registry := newTestRegistry(t, Config{})
+	agentConn := register(t, registry, "agent-1", dialTestConn(t))
+
+	for len(agentConn.SendChan) < cap(agentConn.SendChan)-1 {
+		agentConn.SendChan <- model.Frame{}
+	}
+
+	// A message that does not fit is rejected whole
+	err := agentConn.sendFrames([]model.Frame{{}, {}})
+	if err == nil {
+		t.Fatal("expected an error with one free slot for two frames")
+	}
+	if got := len(agentConn.SendChan); got != cap(agentConn.SendChan)-1 {
+		t.Fatalf("queued %d frames, want %d (none of the rejected message)", got, cap(agentConn.SendChan)-1)
+	}
+	if err := agentConn.sendFrames([]model.Frame{{}}); err != nil {
+		t.Fatalf("single frame = %v, want nil", err)
+	}
+}
+
+func TestCallbacksMayUseRegistry(t *testing.T) {
+	var registry *AgentRegistry
+	events := make(chan string, 10)
+	// Each callback reads the registry, which deadlocks if ar.mu is held
+	callback := func(name string) func(*model.Agent) {
+		return func(agent *model.Agent) {
+			registry.List()
+			registry.GetAgent(agent.ID)
+			events <- name
+		}
+	}
+	registry = newTestRegistry(t, Config{
+		HeartbeatTimeout:     time.Millisecond,
+		UnhealthyGracePeriod: time.Millisecond,
+		OnAgentConnected:     callback("connected"),
+		OnAgentDisconnected:  callback("disconnected"),
+		OnAgentLost:          callback("lost"),
+	})
+
+	tests := []struct {
+		name   string
+		action func()
+		want   []string
+	}{
+		{
+			name:   "register",
+			action: func() { register(t, registry, "agent-1", dialTestConn(t)) },
+			want:   []string{"connected"},
+		},
+		{
+			name:   "reconnect replaces the old connection",
+			action: func() { register(t, registry, "agent-1", dialTestConn(t)) },
+			want:   []string{"disconnected", "connected"},
+		},
+		{
+			name:   "unregister",
+			action: func() { registry.Unregister("agent-1") },
+			want:   []string{"disconnected"},
+		},
+		{
+			name: "unregister connection",
+			action: func() {
+				conn := dialTestConn(t)
+				register(t, registry, "agent-2", conn)
+				registry.UnregisterConnection("agent-2", conn)
+			},
+			want: []string{"connected", "disconnected"},
+		},
+		{
+			name: "lost by the health checker",
+			action: func() {
+				register(t, registry, "agent-3", dialTestConn(t))
+				time.Sleep(10 * time.Millisecond)
+				registry.checkAgentHealth()
+			},
+			want: []string{"connected", "disconnected", "lost"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testutil.Within(t, tt.name, tt.action)
+			for _, want := range tt.want {
+				if got := <-events; got != want {
+					t.Fatalf("callback %q, want %q", got, want)
+				}
+			}
+			if len(events) > 0 {
+				t.Fatalf("unexpected callback %q", <-events)
+			}
+		})
+	}
+}
+
+func TestRegisterEnforcesMaxAgents(t *testing.T) {
+	const maxAgents = 3
+	registry := newTestRegistry(t, Config{MaxAgents: maxAgents})
+
+	// Registrations race for the last slots; exactly maxAgents may succeed
+	conns := make([]*websocket.Conn, 10)
+	for i := range conns {
+		conns[i] = dialTestConn(t)
+	}
+	errs := make(chan error, len(conns))
+	for i, conn := range conns {
+		go func() {
+			_, err := registry.Register(&model.AgentRegistration{
+				ID:           fmt.Sprintf("agent-%d", i),
+				Name:         "agent",
+				ClusterName:  "cluster-1",
+				Version:      "test",
+				Capabilities: []string{model.CapabilityK8sCRUD},
+			}, conn, "conn", model.NewCodec(model.CodecConfig{Version: model.ProtocolVersion}))
+			errs <- err
+		}()
+	}
+
+	registered := 0
+	for range conns {
+		switch err := <-errs; {
+		case err == nil:
+			registered++
+		case !errors.Is(err, ErrMaxAgentsReached):
+			t.Fatalf("Register = %v, want ErrMaxAgentsReached", err)
+		}
+	}
+	if registered != maxAgents || registry.Count() != maxAgents {
+		t.Fatalf("registered %d, count %d; want %d", registered, registry.Count(), maxAgents)
+	}
+
+	// A registered agent reconnecting does not need a free slot
+	agentID := registry.List()[0].ID
+	register(t, registry, agentID, dialTestConn(t))
+	if registry.Count() != maxAgents {
+		t.Fatalf("count %d after reconnect, want %d", registry.Count(), maxAgents)
+	}
+}
+
+func TestUpdateHeartbeatKeepsLastHealth(t *testing.T) {
+	registry := newTestRegistry(t, Config{})
+	agentConn := register(t, registry, "agent-1", dialTestConn(t))
+	registered := agentConn.Agent.LastHeartbeat
+
+	health := &model.AgentHealth{RunningEvents: 2, APIServerReachable: true, CollectedAt: time.Now()}
+	agent, err := registry.UpdateHeartbeat("agent-1", health)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if agent.Health != health || agent.LastHeartbeat.Before(registered) {
+		t.Fatalf("heartbeat at %v with health %+v", agent.LastHeartbeat, agent.Health)
+	}
+
+	// Heartbeats from agents that report no health keep the last snapshot
+	if agent, err = registry.UpdateHeartbeat("agent-1", nil); err != nil || agent.Health != health {
+		t.Fatalf("health %+v, err %v after a heartbeat without health", agent.Health, err)
+	}
+
+	if _, err := registry.UpdateHeartbeat("agent-2", health); !errors.Is(err, model.ErrAgentNotFound) {
+		t.Fatalf("UpdateHeartbeat() of an unknown agent error = %v, want ErrAgentNotFound", err)
+	}
+}
@@ -1,8 +1,10 @@
 package router
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -10,13 +12,6 @@ import (
 	"github.com/suyog1pathak/transporter/pkg/registry"
 )
 
-// EventMessage represents a message containing an event to be sent to an agent
-type EventMessage struct {
-	Type    string       `json:"type"` // "event", "status_request", "heartbeat_request", etc.
-	Event   *model.Event `json:"event,omitempty"`
-	EventID string       `json:"event_id,omitempty"`
-}
-
 // PendingEvent represents an event waiting for an agent to reconnect
 type PendingEvent struct {
 	Event     *model.Event
@@ -32,12 +27,22 @@ type EventRouter struct {
 	mu            sync.RWMutex
 	maxRetries    int
 	retryInterval time.Duration
+	agentLookup   func(string) (*model.Agent, error)
+	agentLister   func() ([]*model.Agent, error)
+	agentRevoked  func(string) (bool, error)
+
+	// Events delivered to agents without a final status yet, for failover
+	assigned            map[string]map[string]*model.Event // agentID -> eventID -> event
+	lostAgents          map[string]time.Time               // agentID -> when it was first seen gone
+	assignedMu          sync.Mutex
+	failoverGracePeriod time.Duration
 
 	// Callbacks
-	onEventRouted func(*model.Event, string) // event, agentID
-	onEventQueued func(*model.Event, string) // event, agentID
+	onEventRouted  func(*model.Event, string) // event, agentID
+	onEventQueued  func(*model.Event, string) // event, agentID
 	onEventExpired func(*model.Event)         // event
 	onEventFailed  func(*model.Event, error)  // event, error
+	onEventUnknown func(*model.Event, string) // event, reason
 }
 
 // Config holds configuration for the event router
@@ -46,11 +51,25 @@ type Config struct {
 	MaxRetries    int
 	RetryInterval time.Duration
 
+	// AgentLookup returns the persisted record of an agent that is not connected, so
+	// its capabilities can be checked while it is offline (optional)
+	AgentLookup func(agentID string) (*model.Agent, error)
+	// AgentLister returns all persisted agents for selector fan-out (optional)
+	AgentLister func() ([]*model.Agent, error)
+	// AgentRevoked reports whether a persisted agent was decommissioned, so selector
+	// fan-out skips it (optional)
+	AgentRevoked func(agentID string) (bool, error)
+
+	// FailoverGracePeriod is how long an agent may stay disconnected with assigned
+	// events before they are failed over according to their FailoverPolicy
+	FailoverGracePeriod time.Duration
+
 	// Optional callbacks
-	OnEventRouted func(*model.Event, string)
-	OnEventQueued func(*model.Event, string)
+	OnEventRouted  func(*model.Event, string)
+	OnEventQueued  func(*model.Event, string)
 	OnEventExpired func(*model.Event)
 	OnEventFailed  func(*model.Event, error)
+	OnEventUnknown func(*model.Event, string)
 }
 
 // NewEventRouter creates a new event router
@@ -61,16 +80,26 @@ func NewEventRouter(config Config) *EventRouter {
 	if config.RetryInterval == 0 {
 		config.RetryInterval = 30 * time.Second
 	}
+	if config.FailoverGracePeriod == 0 {
+		config.FailoverGracePeriod = 60 * time.Second
+	}
 
 	router := &EventRouter{
-		registry:      config.Registry,
-		pendingEvents: make(map[string][]*PendingEvent),
-		maxRetries:    config.MaxRetries,
-		retryInterval: config.RetryInterval,
-		onEventRouted: config.OnEventRouted,
-		onEventQueued: config.OnEventQueued,
-		onEventExpired: config.OnEventExpired,
-		onEventFailed:  config.OnEventFailed,
+		registry:            config.Registry,
+		pendingEvents:       make(map[string][]*PendingEvent),
+		maxRetries:          config.MaxRetries,
+		retryInterval:       config.RetryInterval,
+		agentLookup:         config.AgentLookup,
+		agentLister:         config.AgentLister,
+		agentRevoked:        config.AgentRevoked,
+		assigned:            make(map[string]map[string]*model.Event),
+		lostAgents:          make(map[string]time.Time),
+		failoverGracePeriod: config.FailoverGracePeriod,
+		onEventRouted:       config.OnEventRouted,
+		onEventQueued:       config.OnEventQueued,
+		onEventExpired:      config.OnEventExpired,
+		onEventFailed:       config.OnEventFailed,
+		onEventUnknown:      config.OnEventUnknown,
 	}
 
 	// Start background worker to retry pending events
@@ -97,15 +126,26 @@ func (er *EventRouter) RouteEvent(event *model.Event) error {
 		return fmt.Errorf("event %s is expired", event.ID)
 	}
 
+	if event.TargetSelector != nil {
+		return fmt.Errorf("event %s targets a selector, use RouteBySelector", event.ID)
+	}
+
 	// Get target agent
 	agent, err := er.registry.GetAgent(event.TargetAgent)
 	if err != nil {
-		// Agent not found or disconnected - queue the event
+		// Agent not found or disconnected - check its last known capabilities and queue
+		if err := er.checkCapabilities(event, er.knownAgent(event.TargetAgent)); err != nil {
+			return err
+		}
 		return er.queueEvent(event)
 	}
 
-	// Check if agent is connected and healthy
-	if agent.Status != model.AgentStatusConnected {
+	if err := er.checkCapabilities(event, agent); err != nil {
+		return err
+	}
+
+	// Check if agent is connected, healthy and not draining or cordoned
+	if !agent.AcceptsEvents() {
 		// Queue for later delivery
 		return er.queueEvent(event)
 	}
@@ -114,27 +154,63 @@ func (er *EventRouter) RouteEvent(event *model.Event) error {
 	return er.sendEventToAgent(event, event.TargetAgent)
 }
 
-// sendEventToAgent sends an event to a specific agent
-func (er *EventRouter) sendEventToAgent(event *model.Event, agentID string) error {
-	// Create event message
-	msg := EventMessage{
-		Type:  "event",
-		Event: event,
+// knownAgent returns the persisted record of an agent, or nil if it is unknown
+func (er *EventRouter) knownAgent(agentID string) *model.Agent {
+	if er.agentLookup == nil {
+		return nil
 	}
-
-	// Serialize to JSON
-	data, err := json.Marshal(msg)
+	agent, err := er.agentLookup(agentID)
 	if err != nil {
-		if er.onEventFailed != nil {
-			er.onEventFailed(event, err)
-		}
-		return fmt.Errorf("failed to marshal event: %w", err)
+		return nil
 	}
+	return agent
+}
 
-	// Send to agent via registry
-	if err := er.registry.SendToAgent(agentID, data); err != nil {
-		// Failed to send - queue it
-		return er.queueEvent(event)
+// checkCapabilities fails the event if the agent lacks a capability it requires.
+// Agents that have never registered are given the benefit of the doubt.
+func (er *EventRouter) checkCapabilities(event *model.Event, agent *model.Agent) error {
+	if agent == nil {
+		return nil
+	}
+	missing := agent.MissingCapabilities(event.RequiredCapabilities())
+	if len(missing) == 0 {
+		return nil
+	}
+
+	err := fmt.Errorf("%w: agent %s lacks %s", model.ErrMissingCapability, agent.ID, strings.Join(missing, ", "))
+	if er.onEventFailed != nil {
+		er.onEventFailed(event, err)
+	}
+	return err
+}
+
+// sendEventToAgent sends an event to a specific agent, queueing it if the agent
+// cannot take it right now
+func (er *EventRouter) sendEventToAgent(event *model.Event, agentID string) error {
+	err := er.deliverEvent(event, agentID)
+	if err == nil || isProtocolError(err) {
+		return err
+	}
+	// Failed to send - queue it
+	return er.queueEvent(event)
+}
+
+// deliverEvent sends an event to an agent without queueing it on failure, so it can
+// be called while er.mu is held. An event that cannot be encoded is failed.
+func (er *EventRouter) deliverEvent(event *model.Event, agentID string) error {
+	// Track the assignment first so status updates racing the send are accepted
+	er.trackAssigned(event, agentID)
+
+	// Encode and send to agent via registry
+	if _, err := er.registry.SendMessageToAgent(agentID, model.MessageTypeEvent, event); err != nil {
+		er.untrackAssigned(event.ID, agentID)
+		if isProtocolError(err) {
+			if er.onEventFailed != nil {
+				er.onEventFailed(event, err)
+			}
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		return fmt.Errorf("failed to send event to agent %s: %w", agentID, err)
 	}
 
 	// Trigger callback
@@ -145,11 +221,29 @@ func (er *EventRouter) sendEventToAgent(event *model.Event, agentID string) erro
 	return nil
 }
 
-// queueEvent queues an event for later delivery when agent reconnects
+// isProtocolError reports whether err is an encoding error, which retrying cannot fix
+func isProtocolError(err error) bool {
+	var protocolErr *model.ProtocolError
+	return errors.As(err, &protocolErr)
+}
+
+// queueEvent queues an event for later delivery when agent reconnects. Callbacks
+// run without er.mu held, since they persist state.
 func (er *EventRouter) queueEvent(event *model.Event) error {
-	er.mu.Lock()
-	defer er.mu.Unlock()
+	if err := er.enqueue(event); err != nil {
+		return err
+	}
+
+	// Trigger callback
+	if er.onEventQueued != nil {
+		er.onEventQueued(event, event.TargetAgent)
+	}
+
+	return nil
+}
 
+// enqueue adds an event to its agent's pending queue, or expires it
+func (er *EventRouter) enqueue(event *model.Event) error {
 	agentID := event.TargetAgent
 
 	// Check if event is already expired
@@ -169,16 +263,19 @@ func (er *EventRouter) queueEvent(event *model.Event) error {
 	}
 
 	// Add to pending queue
+	er.mu.Lock()
 	er.pendingEvents[agentID] = append(er.pendingEvents[agentID], pending)
-
-	// Trigger callback
-	if er.onEventQueued != nil {
-		er.onEventQueued(event, agentID)
-	}
+	er.mu.Unlock()
 
 	return nil
 }
 
+// RequeueEvent queues an event whose outcome is unknown for redelivery without
+// marking it queued, so the lost agent can still report the original's outcome
+func (er *EventRouter) RequeueEvent(event *model.Event) error {
+	return er.enqueue(event)
+}
+
 // pendingEventsWorker periodically tries to deliver pending events
 func (er *EventRouter) pendingEventsWorker() {
 	ticker := time.NewTicker(er.retryInterval)
@@ -186,62 +283,250 @@ func (er *EventRouter) pendingEventsWorker() {
 
 	for range ticker.C {
 		er.processPendingEvents()
+		er.checkLostAgents()
 	}
 }
 
-// processPendingEvents attempts to deliver all pending events
+// processPendingEvents attempts to deliver all pending events. Due events are taken
+// off the queue under er.mu; expiry, failure and delivery (and their callbacks, which
+// persist state) happen unlocked, and events that could not be sent are put back at
+// the front of their agent's queue.
 func (er *EventRouter) processPendingEvents() {
-	er.mu.Lock()
-	defer er.mu.Unlock()
-
 	now := time.Now()
+	var expired, exhausted []*model.Event
+	due := make(map[string][]*PendingEvent) // agentID -> events to deliver now
 
+	er.mu.Lock()
 	for agentID, events := range er.pendingEvents {
 		remainingEvents := make([]*PendingEvent, 0)
 
+		// Try to get agent
+		agent, err := er.registry.GetAgent(agentID)
+		available := err == nil && agent.AcceptsEvents()
+
 		for _, pending := range events {
 			// Check if expired
 			if now.After(pending.ExpiresAt) {
-				if er.onEventExpired != nil {
-					er.onEventExpired(pending.Event)
-				}
+				expired = append(expired, pending.Event)
 				continue
 			}
 
 			// Check if max retries exceeded
 			if pending.Retries >= er.maxRetries {
-				if er.onEventFailed != nil {
-					er.onEventFailed(pending.Event, fmt.Errorf("max retries exceeded"))
-				}
+				exhausted = append(exhausted, pending.Event)
 				continue
 			}
 
-			// Try to get agent
-			agent, err := er.registry.GetAgent(agentID)
-			if err != nil || agent.Status != model.AgentStatusConnected {
+			if !available {
 				// Agent still not available, keep in queue
 				remainingEvents = append(remainingEvents, pending)
 				continue
 			}
+			due[agentID] = append(due[agentID], pending)
+		}
+
+		// Update pending events for this agent
+		if len(remainingEvents) > 0 {
+			er.pendingEvents[agentID] = remainingEvents
+		} else {
+			delete(er.pendingEvents, agentID)
+		}
+	}
+	er.mu.Unlock()
+
+	for _, event := range expired {
+		if er.onEventExpired != nil {
+			er.onEventExpired(event)
+		}
+	}
+	for _, event := range exhausted {
+		if er.onEventFailed != nil {
+			er.onEventFailed(event, fmt.Errorf("max retries exceeded"))
+		}
+	}
+
+	for agentID, events := range due {
+		retry := make([]*PendingEvent, 0)
+		for _, pending := range events {
+			// Capabilities may have changed since the event was queued
+			agent, err := er.registry.GetAgent(agentID)
+			if err != nil {
+				retry = append(retry, pending) // Disconnected meanwhile
+				continue
+			}
+			if err := er.checkCapabilities(pending.Event, agent); err != nil {
+				continue
+			}
 
 			// Try to send
-			if err := er.sendEventToAgent(pending.Event, agentID); err != nil {
+			if err := er.deliverEvent(pending.Event, agentID); err != nil {
+				if isProtocolError(err) {
+					continue // Failed by deliverEvent
+				}
 				// Failed to send, increment retry and keep in queue
 				pending.Retries++
-				remainingEvents = append(remainingEvents, pending)
-				continue
+				retry = append(retry, pending)
 			}
+		}
+		if len(retry) > 0 {
+			er.mu.Lock()
+			er.pendingEvents[agentID] = append(retry, er.pendingEvents[agentID]...)
+			er.mu.Unlock()
+		}
+	}
+}
+
+// trackAssigned remembers an event delivered to an agent until its final status arrives
+func (er *EventRouter) trackAssigned(event *model.Event, agentID string) {
+	er.assignedMu.Lock()
+	defer er.assignedMu.Unlock()
+
+	if er.assigned[agentID] == nil {
+		er.assigned[agentID] = make(map[string]*model.Event)
+	}
+	er.assigned[agentID][event.ID] = event
+}
+
+// untrackAssigned forgets an event assignment
+func (er *EventRouter) untrackAssigned(eventID, agentID string) {
+	er.assignedMu.Lock()
+	defer er.assignedMu.Unlock()
 
-			// Successfully sent - remove from pending
+	delete(er.assigned[agentID], eventID)
+	if len(er.assigned[agentID]) == 0 {
+		delete(er.assigned, agentID)
+	}
+}
+
+// AssignedEvent returns an event delivered to an agent that has not settled, or nil
+func (er *EventRouter) AssignedEvent(eventID, agentID string) *model.Event {
+	er.assignedMu.Lock()
+	defer er.assignedMu.Unlock()
+
+	return er.assigned[agentID][eventID]
+}
+
+// SettleEvent records that an event reached a final state. A copy re-queued by
+// failover is dropped, since the agent finished the original after all.
+func (er *EventRouter) SettleEvent(eventID, agentID string) {
+	er.untrackAssigned(eventID, agentID)
+
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	pending := er.pendingEvents[agentID]
+	for i, p := range pending {
+		if p.Event.ID == eventID {
+			er.pendingEvents[agentID] = append(pending[:i:i], pending[i+1:]...)
+			if len(er.pendingEvents[agentID]) == 0 {
+				delete(er.pendingEvents, agentID)
+			}
+			return
 		}
+	}
+}
 
-		// Update pending events for this agent
-		if len(remainingEvents) > 0 {
-			er.pendingEvents[agentID] = remainingEvents
-		} else {
-			delete(er.pendingEvents, agentID)
+// FailoverAgent fails over the events assigned to a lost agent: every event is
+// marked unknown, and those with the requeue policy are also requeued for the
+// agent's return. It returns the number of events failed over.
+func (er *EventRouter) FailoverAgent(agentID, reason string) int {
+	er.assignedMu.Lock()
+	events := er.assigned[agentID]
+	delete(er.assigned, agentID)
+	delete(er.lostAgents, agentID)
+	er.assignedMu.Unlock()
+
+	for _, event := range events {
+		requeue := event.Failover == model.FailoverRequeue && !event.IsExpired()
+		if er.onEventUnknown != nil {
+			if requeue {
+				er.onEventUnknown(event, reason+", requeued for redelivery")
+			} else {
+				er.onEventUnknown(event, reason)
+			}
+		}
+		if requeue {
+			er.RequeueEvent(event)
 		}
 	}
+	return len(events)
+}
+
+// CancelAssigned forgets every event delivered to an agent that has not settled
+// and returns them, oldest first, so the caller can mark them cancelled
+func (er *EventRouter) CancelAssigned(agentID string) []*model.Event {
+	er.assignedMu.Lock()
+	assigned := er.assigned[agentID]
+	delete(er.assigned, agentID)
+	delete(er.lostAgents, agentID)
+	er.assignedMu.Unlock()
+
+	events := make([]*model.Event, 0, len(assigned))
+	for _, event := range assigned {
+		events = append(events, event)
+	}
+	slices.SortFunc(events, func(a, b *model.Event) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return events
+}
+
+// checkLostAgents fails over the events of agents that have been gone for longer
+// than the failover grace period
+func (er *EventRouter) checkLostAgents() {
+	now := time.Now()
+
+	er.assignedMu.Lock()
+	lost := make([]string, 0)
+	for agentID := range er.assigned {
+		if agent, err := er.registry.GetAgent(agentID); err == nil && agent.Status != model.AgentStatusDisconnected {
+			delete(er.lostAgents, agentID)
+			continue
+		}
+
+		lostAt, seen := er.lostAgents[agentID]
+		if !seen {
+			lostAt = now
+			if agent := er.knownAgent(agentID); agent != nil && agent.DisconnectedAt != nil {
+				lostAt = *agent.DisconnectedAt
+			}
+			er.lostAgents[agentID] = lostAt
+		}
+		if now.Sub(lostAt) > er.failoverGracePeriod {
+			lost = append(lost, agentID)
+		}
+	}
+	er.assignedMu.Unlock()
+
+	for _, agentID := range lost {
+		er.FailoverAgent(agentID, fmt.Sprintf("Agent %s lost (disconnected for more than %v)", agentID, er.failoverGracePeriod))
+	}
+}
+
+// CancelEvent cancels an event. A queued event is dropped from the pending queue;
+// otherwise the agent is asked to stop it. It reports whether the event was still queued.
+func (er *EventRouter) CancelEvent(eventID, agentID, reason string) (bool, error) {
+	er.mu.Lock()
+	pending := er.pendingEvents[agentID]
+	for i, p := range pending {
+		if p.Event.ID == eventID {
+			er.pendingEvents[agentID] = append(pending[:i:i], pending[i+1:]...)
+			if len(er.pendingEvents[agentID]) == 0 {
+				delete(er.pendingEvents, agentID)
+			}
+			er.mu.Unlock()
+			return true, nil
+		}
+	}
+	er.mu.Unlock()
+
+	if _, err := er.registry.SendMessageToAgent(agentID, model.MessageTypeCancel, &model.CancelMessage{
+		EventID: eventID,
+		Reason:  reason,
+	}); err != nil {
+		return false, fmt.Errorf("failed to send cancel to agent %s: %w", agentID, err)
+	}
+
+	return false, nil
 }
 
 // GetPendingEventsCount returns the number of pending events for an agent
@@ -264,12 +549,19 @@ func (er *EventRouter) GetTotalPendingEvents() int {
 	return total
 }
 
-// ClearPendingEvents clears all pending events for an agent (useful for cleanup)
-func (er *EventRouter) ClearPendingEvents(agentID string) {
+// ClearPendingEvents clears all pending events for an agent and returns them
+func (er *EventRouter) ClearPendingEvents(agentID string) []*model.Event {
 	er.mu.Lock()
 	defer er.mu.Unlock()
 
+	pending := er.pendingEvents[agentID]
 	delete(er.pendingEvents, agentID)
+
+	events := make([]*model.Event, len(pending))
+	for i, p := range pending {
+		events[i] = p.Event
+	}
+	return events
 }
 
 // GetPendingEvents returns all pending events for an agent
@@ -0,0 +1,204 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/internal/testutil"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+	"github.com/suyog1pathak/transporter/pkg/registry"
+)
+
+// newTestRouter returns a router with one connected agent, agent-1
+func newTestRouter(t *testing.T, config Config) (*EventRouter, *registry.AgentRegistry) {
+	t.Helper()
+	logger.InitLogger(false)
+
+	agents := registry.NewAgentRegistry(registry.Config{HeartbeatTimeout: time.Hour})
+	_, err := agents.Register(&model.AgentRegistration{
+		ID:           "agent-1",
+		Name:         "agent-1",
+		ClusterName:  "cluster-1",
+		Version:      "test",
+		Capabilities: []string{model.CapabilityK8sCRUD},
+	}, nil, "conn-1", model.NewCodec(model.CodecConfig{Version: model.ProtocolVersion}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.Registry = agents
+	config.RetryInterval = time.Hour // processPendingEvents is driven by the tests
+	return NewEventRouter(config), agents
+}
+
+func testEvent(id string) *model.Event {
+	event := model.NewEvent(model.EventTypeK8sResource, "agent-1",
+		model.EventPayload{Manifests: []string{"apiVersion: v1\nkind: Namespace\nmetadata:\n  name: test"}}, "test")
+	event.ID = id
+	return event
+}
+
+// fillSendQueue leaves no room in the agent's send channel
+func fillSendQueue(t *testing.T, agents *registry.AgentRegistry) {
+	t.Helper()
+	conn, err := agents.Get("agent-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(conn.SendChan) < cap(conn.SendChan) {
+		conn.SendChan <- model.Frame{}
+	}
+}
+
+// drainSendQueue empties the agent's send channel
+func drainSendQueue(t *testing.T, agents *registry.AgentRegistry) {
+	t.Helper()
+	conn, err := agents.Get("agent-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(conn.SendChan) > 0 {
+		<-conn.SendChan
+	}
+}
+
+func TestProcessPendingEventsWithFullSendQueue(t *testing.T) {
+	var routed []string
+	router, agents := newTestRouter(t, Config{
+		MaxRetries: 5,
+		OnEventRouted: func(event *model.Event, agentID string) {
+			routed = append(routed, event.ID)
+		},
+	})
+
+	fillSendQueue(t, agents)
+	event := testEvent("event-1")
+	if err := router.RouteEvent(event); err != nil {
+		t.Fatalf("RouteEvent: %v", err)
+	}
+	if got := router.GetPendingEventsCount("agent-1"); got != 1 {
+		t.Fatalf("pending = %d, want 1 (queued on backpressure)", got)
+	}
+
+	// Still backpressured: the event stays queued with one more retry
+	testutil.Within(t, "processPendingEvents", router.processPendingEvents)
+	if got := router.GetPendingEventsCount("agent-1"); got != 1 {
+		t.Fatalf("pending = %d, want 1 after a failed retry", got)
+	}
+
+	drainSendQueue(t, agents)
+	testutil.Within(t, "processPendingEvents", router.processPendingEvents)
+	if got := router.GetPendingEventsCount("agent-1"); got != 0 {
+		t.Fatalf("pending = %d, want 0 after delivery", got)
+	}
+	if len(routed) != 1 || routed[0] != event.ID {
+		t.Fatalf("routed = %v, want [%s]", routed, event.ID)
+	}
+}
+
+func TestRouterCallbacksRunUnlocked(t *testing.T) {
+	var router *EventRouter
+	calls := make(map[string]int)
+	// Each callback reads the router's queue, which deadlocks if er.mu is held
+	callback := func(name string) {
+		router.GetTotalPendingEvents()
+		calls[name]++
+	}
+	router, agents := newTestRouter(t, Config{
+		MaxRetries:     5,
+		OnEventQueued:  func(*model.Event, string) { callback("queued") },
+		OnEventExpired: func(*model.Event) { callback("expired") },
+		OnEventFailed:  func(*model.Event, error) { callback("failed") },
+		OnEventRouted:  func(*model.Event, string) { callback("routed") },
+	})
+
+	fillSendQueue(t, agents)
+	expiring := testEvent("expiring")
+	expiring.TTL = 50 * time.Millisecond
+	script := testEvent("script")
+	script.Type = model.EventTypeScript
+	script.Payload = model.EventPayload{Script: "true"}
+
+	testutil.Within(t, "RouteEvent", func() {
+		if err := router.RouteEvent(expiring); err != nil {
+			t.Errorf("RouteEvent: %v", err)
+		}
+	})
+	// Queued while the agent had the capability; it is checked again on delivery
+	testutil.Within(t, "queueEvent", func() {
+		if err := router.queueEvent(script); err != nil {
+			t.Errorf("queueEvent: %v", err)
+		}
+	})
+
+	time.Sleep(100 * time.Millisecond)
+	drainSendQueue(t, agents)
+	testutil.Within(t, "processPendingEvents", router.processPendingEvents)
+
+	want := map[string]int{"queued": 2, "expired": 1, "failed": 1}
+	for name, count := range want {
+		if calls[name] != count {
+			t.Errorf("%s callbacks = %d, want %d (all: %v)", name, calls[name], count, calls)
+		}
+	}
+	if got := router.GetTotalPendingEvents(); got != 0 {
+		t.Fatalf("pending = %d, want 0", got)
+	}
+}
+
+func TestCancelAssigned(t *testing.T) {
+	router, _ := newTestRouter(t, Config{MaxRetries: 5})
+
+	first, second := testEvent("event-1"), testEvent("event-2")
+	second.CreatedAt = first.CreatedAt.Add(time.Second)
+	for _, event := range []*model.Event{second, first} {
+		if err := router.RouteEvent(event); err != nil {
+			t.Fatalf("RouteEvent: %v", err)
+		}
+	}
+
+	cancelled := router.CancelAssigned("agent-1")
+	if len(cancelled) != 2 || cancelled[0].ID != first.ID || cancelled[1].ID != second.ID {
+		t.Fatalf("cancelled = %v, want [%s %s]", cancelled, first.ID, second.ID)
+	}
+	if router.AssignedEvent(first.ID, "agent-1") != nil {
+		t.Fatal("event still assigned after CancelAssigned")
+	}
+	if got := router.CancelAssigned("agent-1"); len(got) != 0 {
+		t.Fatalf("second CancelAssigned = %v, want none", got)
+	}
+}
+
+func TestFailoverAgent(t *testing.T) {
+	var unknown []string
+	queued := 0
+	router, _ := newTestRouter(t, Config{
+		MaxRetries:     5,
+		OnEventUnknown: func(event *model.Event, reason string) { unknown = append(unknown, event.ID) },
+		OnEventQueued:  func(*model.Event, string) { queued++ },
+	})
+
+	requeue, fail := testEvent("requeue"), testEvent("fail")
+	requeue.Failover = model.FailoverRequeue
+	for _, event := range []*model.Event{requeue, fail} {
+		if err := router.RouteEvent(event); err != nil {
+			t.Fatalf("RouteEvent: %v", err)
+		}
+	}
+
+	if got := router.FailoverAgent("agent-1", "lost"); got != 2 {
+		t.Fatalf("FailoverAgent = %d, want 2", got)
+	}
+	// Both are marked unknown; the requeued one waits without being marked queued
+	if len(unknown) != 2 {
+		t.Fatalf("unknown = %v, want both events", unknown)
+	}
+	if queued != 0 {
+		t.Fatalf("queued callbacks = %d, want 0", queued)
+	}
+	pending := router.GetPendingEvents("agent-1")
+	if len(pending) != 1 || pending[0].ID != requeue.ID {
+		t.Fatalf("pending = %v, want [%s]", pending, requeue.ID)
+	}
+}
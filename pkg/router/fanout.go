@@ -0,0 +1,160 @@
+package router
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+)
+
+// LabelParentEvent is set on fan-out child events to the selector event's ID
+const LabelParentEvent = "transporter.io/parent-event"
+
+// SkippedAgent is a selected agent the event was not routed to
+type SkippedAgent struct {
+	AgentID string `json:"agent_id"`
+	Reason  string `json:"reason"`
+}
+
+// FanoutResult reports how a selector event was routed
+type FanoutResult struct {
+	Routed  map[string]string `json:"routed"`            // agentID -> child event ID
+	Skipped []SkippedAgent    `json:"skipped,omitempty"` // Matching agents that were not routed to
+
+	Events []*model.Event `json:"-"` // The routed child events
+}
+
+// RouteBySelector routes a copy of the event to every agent matching its selector.
+// Connected agents and persisted (offline) agents are both candidates; agents that
+// are cordoned, revoked or lack a required capability are skipped and reported rather
+// than failing the event.
+func (er *EventRouter) RouteBySelector(event *model.Event) (*FanoutResult, error) {
+	if err := event.Validate(); err != nil {
+		if er.onEventFailed != nil {
+			er.onEventFailed(event, err)
+		}
+		return nil, fmt.Errorf("event validation failed: %w", err)
+	}
+	if event.TargetSelector == nil {
+		return nil, fmt.Errorf("event %s has no target selector", event.ID)
+	}
+
+	candidates, skipped, err := er.selectAgents(event.TargetSelector)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 && len(skipped) == 0 {
+		return nil, model.ErrNoMatchingAgents
+	}
+
+	result := &FanoutResult{Routed: make(map[string]string), Skipped: skipped}
+	required := event.RequiredCapabilities()
+
+	for _, agent := range candidates {
+		if missing := agent.MissingCapabilities(required); len(missing) > 0 {
+			result.Skipped = append(result.Skipped, SkippedAgent{
+				AgentID: agent.ID,
+				Reason:  fmt.Sprintf("%s: missing %s", model.ErrMissingCapability.Code, strings.Join(missing, ", ")),
+			})
+			continue
+		}
+
+		child := childEvent(event, agent.ID)
+		if err := er.RouteEvent(child); err != nil {
+			result.Skipped = append(result.Skipped, SkippedAgent{AgentID: agent.ID, Reason: err.Error()})
+			continue
+		}
+		result.Routed[agent.ID] = child.ID
+		result.Events = append(result.Events, child)
+	}
+
+	return result, nil
+}
+
+// selectAgents returns the live and persisted agents matching the selector, preferring
+// the live state of connected agents. Matching agents that are cordoned, or persisted
+// agents that were revoked, are returned as skipped.
+func (er *EventRouter) selectAgents(selector *model.AgentSelector) ([]*model.Agent, []SkippedAgent, error) {
+	agents := make(map[string]*model.Agent)
+	live := make(map[string]bool)
+
+	if er.agentLister != nil {
+		persisted, err := er.agentLister()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list agents: %w", err)
+		}
+		for _, agent := range persisted {
+			agents[agent.ID] = agent
+		}
+	}
+	for _, agent := range er.registry.List() {
+		agents[agent.ID] = agent
+		live[agent.ID] = true
+	}
+
+	selected := make([]*model.Agent, 0)
+	var skipped []SkippedAgent
+	for _, agentID := range slices.Sorted(maps.Keys(agents)) {
+		agent := agents[agentID]
+		if !selector.Matches(agent) {
+			continue
+		}
+		if !live[agentID] {
+			if reason := er.revokedReason(agentID); reason != "" {
+				skipped = append(skipped, SkippedAgent{AgentID: agentID, Reason: reason})
+				continue
+			}
+		}
+		if agent.Cordon != nil {
+			skipped = append(skipped, SkippedAgent{AgentID: agentID, Reason: cordonReason(agent.Cordon)})
+			continue
+		}
+		selected = append(selected, agent)
+	}
+	return selected, skipped, nil
+}
+
+// revokedReason explains why a persisted agent may not be routed to because it was
+// revoked, or returns "" if it was not. Agents whose revocation cannot be checked are
+// skipped too.
+func (er *EventRouter) revokedReason(agentID string) string {
+	if er.agentRevoked == nil {
+		return ""
+	}
+	revoked, err := er.agentRevoked(agentID)
+	if err != nil {
+		return fmt.Sprintf("failed to check whether agent is revoked: %v", err)
+	}
+	if revoked {
+		return "agent is revoked"
+	}
+	return ""
+}
+
+// cordonReason explains why a cordoned agent was skipped
+func cordonReason(cordon *model.Cordon) string {
+	reason := "agent is cordoned"
+	if cordon.User != "" {
+		reason += " by " + cordon.User
+	}
+	if cordon.Reason != "" {
+		reason += ": " + cordon.Reason
+	}
+	return reason
+}
+
+// childEvent copies a selector event for a single agent
+func childEvent(parent *model.Event, agentID string) *model.Event {
+	child := *parent
+	child.ID = parent.ID + "." + agentID
+	child.TargetAgent = agentID
+	child.TargetSelector = nil
+
+	child.Labels = make(map[string]string, len(parent.Labels)+1)
+	maps.Copy(child.Labels, parent.Labels)
+	child.Labels[LabelParentEvent] = parent.ID
+
+	return &child
+}
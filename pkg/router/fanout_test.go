@@ -0,0 +1,178 @@
+package router
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+)
+
+// persistedAgent returns the stored record of an agent that is not connected
+func persistedAgent(id, cluster string, capabilities ...string) *model.Agent {
+	return &model.Agent{ID: id, Name: id, ClusterName: cluster, Status: model.AgentStatusDisconnected, Capabilities: capabilities}
+}
+
+func TestRouteBySelector(t *testing.T) {
+	cordoned := persistedAgent("agent-3", "cluster-1", model.CapabilityK8sCRUD)
+	cordoned.Cordon = &model.Cordon{User: "alice", Reason: "maintenance", At: time.Now()}
+	persisted := map[string]*model.Agent{
+		"agent-1": persistedAgent("agent-1", "cluster-1"), // Stale: the live agent has the capability
+		"agent-2": persistedAgent("agent-2", "cluster-1", model.CapabilityK8sCRUD),
+		"agent-3": cordoned,
+		"agent-4": persistedAgent("agent-4", "cluster-1", model.CapabilityK8sCRUD),
+		"agent-5": persistedAgent("agent-5", "cluster-1", model.CapabilityScriptExec),
+		"agent-6": persistedAgent("agent-6", "cluster-2", model.CapabilityK8sCRUD),
+		"agent-7": persistedAgent("agent-7", "cluster-1", model.CapabilityK8sCRUD),
+	}
+
+	var failed []string
+	router, _ := newTestRouter(t, Config{
+		AgentLister: func() ([]*model.Agent, error) {
+			return slices.Collect(maps.Values(persisted)), nil
+		},
+		AgentLookup: func(agentID string) (*model.Agent, error) {
+			if agent, ok := persisted[agentID]; ok {
+				return agent, nil
+			}
+			return nil, model.ErrAgentNotFound
+		},
+		AgentRevoked: func(agentID string) (bool, error) {
+			if agentID == "agent-7" {
+				return false, errors.New("storage unavailable")
+			}
+			return agentID == "agent-4", nil
+		},
+		OnEventFailed: func(event *model.Event, err error) {
+			failed = append(failed, event.ID)
+		},
+	})
+
+	event := testEvent("event-1")
+	event.TargetAgent = ""
+	event.TargetSelector = &model.AgentSelector{Cluster: "cluster-1"}
+	result, err := router.RouteBySelector(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := slices.Sorted(maps.Keys(result.Routed)); !slices.Equal(got, []string{"agent-1", "agent-2"}) {
+		t.Fatalf("routed to %v, want [agent-1 agent-2]", got)
+	}
+	if result.Routed["agent-2"] != "event-1.agent-2" || len(result.Events) != 2 {
+		t.Fatalf("routed %v with %d child events", result.Routed, len(result.Events))
+	}
+	if queued := router.GetPendingEventsCount("agent-2"); queued != 1 {
+		t.Fatalf("%d events queued for the offline agent, want 1", queued)
+	}
+
+	wantSkipped := map[string]string{
+		"agent-3": "agent is cordoned by alice: maintenance",
+		"agent-4": "agent is revoked",
+		"agent-5": model.ErrMissingCapability.Code,
+		"agent-7": "failed to check whether agent is revoked",
+	}
+	if len(result.Skipped) != len(wantSkipped) {
+		t.Fatalf("skipped %+v, want %v", result.Skipped, slices.Sorted(maps.Keys(wantSkipped)))
+	}
+	for _, skipped := range result.Skipped {
+		want, ok := wantSkipped[skipped.AgentID]
+		if !ok || !strings.Contains(skipped.Reason, want) {
+			t.Errorf("skipped %s: %q, want %q", skipped.AgentID, skipped.Reason, want)
+		}
+	}
+	if len(failed) != 0 {
+		t.Fatalf("events %v failed; skipped agents should not fail the event", failed)
+	}
+
+	event = testEvent("event-2")
+	event.TargetAgent = ""
+	event.TargetSelector = &model.AgentSelector{Cluster: "cluster-3"}
+	if _, err := router.RouteBySelector(event); !errors.Is(err, model.ErrNoMatchingAgents) {
+		t.Fatalf("RouteBySelector() with no matches error = %v, want ErrNoMatchingAgents", err)
+	}
+}
+
+func TestRouteBySelectorSkipsCordonedLiveAgent(t *testing.T) {
+	router, agents := newTestRouter(t, Config{})
+	agents.Cordon("agent-1", &model.Cordon{Reason: "upgrade", At: time.Now()})
+
+	event := testEvent("event-1")
+	event.TargetAgent = ""
+	event.TargetSelector = &model.AgentSelector{Cluster: "cluster-1"}
+	result, err := router.RouteBySelector(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Routed) != 0 || len(result.Skipped) != 1 || result.Skipped[0].Reason != "agent is cordoned: upgrade" {
+		t.Fatalf("routed %v, skipped %+v; want agent-1 skipped as cordoned", result.Routed, result.Skipped)
+	}
+}
+
+func TestCheckCapabilities(t *testing.T) {
+	offline := persistedAgent("agent-2", "cluster-1", model.CapabilityScriptExec)
+	router, _ := newTestRouter(t, Config{
+		AgentLookup: func(agentID string) (*model.Agent, error) {
+			if agentID == offline.ID {
+				return offline, nil
+			}
+			return nil, model.ErrAgentNotFound
+		},
+	})
+
+	tests := []struct {
+		name    string
+		agent   *model.Agent
+		event   *model.Event
+		wantErr bool
+	}{
+		{name: "unknown agent", event: testEvent("event-1")},
+		{name: "capable agent", agent: persistedAgent("agent-1", "cluster-1", model.CapabilityK8sCRUD), event: testEvent("event-1")},
+		{name: "missing capability", agent: offline, event: testEvent("event-1"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := router.checkCapabilities(tt.event, tt.agent)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkCapabilities() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, model.ErrMissingCapability) {
+				t.Fatalf("checkCapabilities() error = %v, want ErrMissingCapability", err)
+			}
+		})
+	}
+
+	// An offline agent is checked against its persisted capabilities before queueing
+	event := testEvent("event-2")
+	event.TargetAgent = offline.ID
+	if err := router.RouteEvent(event); !errors.Is(err, model.ErrMissingCapability) {
+		t.Fatalf("RouteEvent() to an incapable offline agent error = %v, want ErrMissingCapability", err)
+	}
+	if queued := router.GetPendingEventsCount(offline.ID); queued != 0 {
+		t.Fatalf("%d events queued for an incapable agent", queued)
+	}
+}
+
+func TestChildEvent(t *testing.T) {
+	parent := testEvent("event-1")
+	parent.TargetAgent = ""
+	parent.TargetSelector = &model.AgentSelector{Cluster: "cluster-1"}
+	parent.Labels = map[string]string{"team": "payments"}
+
+	child := childEvent(parent, "agent-2")
+	if child.ID != "event-1.agent-2" || child.TargetAgent != "agent-2" || child.TargetSelector != nil {
+		t.Fatalf("child %s targets %q with selector %v", child.ID, child.TargetAgent, child.TargetSelector)
+	}
+	if child.Labels["team"] != "payments" || child.Labels[LabelParentEvent] != "event-1" {
+		t.Fatalf("child labels %v, want the parent's and %s", child.Labels, LabelParentEvent)
+	}
+	if _, ok := parent.Labels[LabelParentEvent]; ok || parent.TargetSelector == nil {
+		t.Fatal("childEvent modified the parent event")
+	}
+	if err := child.Validate(); err != nil {
+		t.Fatalf("child event is invalid: %v", err)
+	}
+}
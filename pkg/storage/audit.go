@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidAuditCursor is returned for a cursor that did not come from a previous page
+var ErrInvalidAuditCursor = errors.New("invalid audit cursor")
+
+// Audit query page sizes
+const (
+	DefaultAuditPageSize = 100
+	MaxAuditPageSize     = 1000
+)
+
+// AuditQuery selects audit log entries. Empty fields match everything.
+type AuditQuery struct {
+	Since   time.Time // Entries at or after this time
+	Until   time.Time // Entries before this time
+	EventID string
+	AgentID string
+	User    string
+	Action  string
+	Cursor  string // NextCursor of the previous page
+	Limit   int    // Page size, DefaultAuditPageSize if unset
+}
+
+// AuditPage is one page of audit log entries, oldest first
+type AuditPage struct {
+	Entries    []*AuditLogEntry `json:"entries"`
+	NextCursor string           `json:"next_cursor,omitempty"` // Empty on the last page
+}
+
+// withDefaults bounds the page size
+func (q AuditQuery) withDefaults() AuditQuery {
+	if q.Limit <= 0 {
+		q.Limit = DefaultAuditPageSize
+	}
+	if q.Limit > MaxAuditPageSize {
+		q.Limit = MaxAuditPageSize
+	}
+	return q
+}
+
+// matches reports whether an entry satisfies the query filters
+func (q AuditQuery) matches(entry *AuditLogEntry) bool {
+	switch {
+	case !q.Since.IsZero() && entry.Timestamp.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !entry.Timestamp.Before(q.Until):
+		return false
+	case q.EventID != "" && entry.EventID != q.EventID:
+		return false
+	case q.AgentID != "" && entry.AgentID != q.AgentID:
+		return false
+	case q.User != "" && entry.User != q.User:
+		return false
+	case q.Action != "" && entry.Action != q.Action:
+		return false
+	}
+	return true
+}
+
+// add appends a matching entry to the page. It reports false once the page is full,
+// in which case the entry is left for the next page.
+func (p *AuditPage) add(q AuditQuery, entry *AuditLogEntry) bool {
+	if !q.matches(entry) {
+		return true
+	}
+	if len(p.Entries) == q.Limit {
+		p.NextCursor = p.Entries[len(p.Entries)-1].ID
+		return false
+	}
+	p.Entries = append(p.Entries, entry)
+	return true
+}
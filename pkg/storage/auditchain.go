@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// The audit log is a hash chain: every entry carries the hash of the entry before
+// it and its own hash over its content including that link, so editing, removing
+// or reordering an entry breaks every later link. Checkpoints signed with the
+// control plane's ed25519 key pin the chain head, so it cannot be recomputed
+// wholesale either.
+
+// ActionAuditCheckpoint is the audit action of signed checkpoints
+const ActionAuditCheckpoint = "audit_checkpoint"
+
+// ContentHash returns the hash of an entry's content and its link to the previous
+// entry. The entry is hashed in canonical JSON (sorted keys, numbers as written),
+// so it verifies the same after a round trip through storage or an export.
+func (e AuditLogEntry) ContentHash() (string, error) {
+	e.ID, e.Hash = "", ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal audit log: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return "", fmt.Errorf("failed to canonicalize audit log: %w", err)
+	}
+	canonical, err := json.Marshal(generic)
+	if err != nil {
+		return "", fmt.Errorf("failed to canonicalize audit log: %w", err)
+	}
+
+	sum := sha256.Sum256(canonical)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// UnmarshalJSON decodes numbers in Details as json.Number, so an entry re-encodes
+// exactly as written and its hash still verifies
+func (e *AuditLogEntry) UnmarshalJSON(data []byte) error {
+	type plain AuditLogEntry
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode((*plain)(e))
+}
+
+// isRetriedAudit reports whether entry is a retry of head, the newest entry, whose
+// earlier attempt was saved. Buffered entries are written in order, so a retry
+// always follows its own first attempt.
+func isRetriedAudit(head, entry *AuditLogEntry) bool {
+	return entry.WriteID != "" && head.WriteID == entry.WriteID
+}
+
+// chainAuditEntry links an entry to the previous entry's hash and returns it
+// encoded for storage
+func chainAuditEntry(entry *AuditLogEntry, prevHash string) ([]byte, error) {
+	entry.ID = ""
+	entry.PrevHash = prevHash
+	hash, err := entry.ContentHash()
+	if err != nil {
+		return nil, err
+	}
+	entry.Hash = hash
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal audit log: %w", err)
+	}
+	return data, nil
+}
+
+// NewAuditCheckpoint returns a checkpoint entry signing the hash of head, the
+// newest entry of the log
+func NewAuditCheckpoint(head *AuditLogEntry, key ed25519.PrivateKey) *AuditLogEntry {
+	timestamp := time.Now().UTC()
+	signature := ed25519.Sign(key, checkpointMessage(head.ID, head.Hash, timestamp))
+	return &AuditLogEntry{
+		Timestamp: timestamp,
+		Action:    ActionAuditCheckpoint,
+		Details: map[string]interface{}{
+			"entry_id":   head.ID,
+			"entry_hash": head.Hash,
+			"key_id":     auditKeyID(key.Public().(ed25519.PublicKey)),
+			"signature":  base64.StdEncoding.EncodeToString(signature),
+		},
+	}
+}
+
+// checkpointMessage is what a checkpoint signs
+func checkpointMessage(entryID, entryHash string, timestamp time.Time) []byte {
+	return []byte(fmt.Sprintf("transporter-audit-checkpoint\n%s\n%s\n%s",
+		entryID, entryHash, timestamp.UTC().Format(time.RFC3339Nano)))
+}
+
+// auditKeyID identifies a checkpoint key by a short fingerprint of its public key
+func auditKeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// LoadAuditSigningKey reads a PEM-encoded (PKCS #8) ed25519 private key
+func LoadAuditSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not an ed25519 key", path)
+	}
+	return ed, nil
+}
+
+// LoadAuditPublicKey reads a PEM-encoded (PKIX) ed25519 public key. The private
+// key is accepted too.
+func LoadAuditPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "PRIVATE KEY" {
+		key, err := LoadAuditSigningKey(path)
+		if err != nil {
+			return nil, err
+		}
+		return key.Public().(ed25519.PublicKey), nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	ed, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an ed25519 key", path)
+	}
+	return ed, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	return block, nil
+}
+
+// ChainBreak describes the first entry that does not verify
+type ChainBreak struct {
+	Index  int    `json:"index"` // Position in the walk, from 0
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// ChainReport summarizes an audit chain walk
+type ChainReport struct {
+	Entries     int         `json:"entries"`     // Entries walked
+	Unchained   int         `json:"unchained"`   // Entries written before chaining, at the start of the log (legacy only)
+	Checkpoints int         `json:"checkpoints"` // Checkpoints whose signature and pinned entry verified
+	Unverified  int         `json:"unverified"`  // Checkpoints skipped: no public key, or their entry was trimmed
+	Uncovered   int         `json:"uncovered"`   // Entries after the last entry pinned by a verified checkpoint
+	Truncated   bool        `json:"truncated"`   // The first chained entry links to an entry no longer retained
+	FirstID     string      `json:"first_id"`    // First entry walked
+	HeadID      string      `json:"head_id"`     // Last entry that verified
+	HeadHash    string      `json:"head_hash"`   // Hash of the last entry that verified
+	Break       *ChainBreak `json:"break,omitempty"`
+}
+
+// ErrNoVerifiedCheckpoint is returned by ChainVerifier.Err when checkpoints were
+// required but none verified, so nothing pins the chain against being rewritten
+var ErrNoVerifiedCheckpoint = errors.New("no verified audit checkpoint")
+
+// chainLink is a verified entry, for checkpoints pinning it
+type chainLink struct {
+	hash  string
+	index int
+}
+
+// ChainVerifier walks the audit log oldest first and stops at the first broken link
+type ChainVerifier struct {
+	publicKey   ed25519.PublicKey
+	allowLegacy bool
+	chained     bool
+	covered     int                  // Walk index of the last entry pinned by a verified checkpoint, -1 if none
+	links       map[string]chainLink // entry ID -> link, for checkpoints
+	report      ChainReport
+}
+
+// NewChainVerifier creates a verifier. Checkpoint signatures are checked if
+// publicKey is set, and at least one checkpoint must then verify. Entries without
+// a hash are a break unless allowLegacy is set, in which case they are accepted at
+// the start of the log only (written before chaining was introduced).
+func NewChainVerifier(publicKey ed25519.PublicKey, allowLegacy bool) *ChainVerifier {
+	return &ChainVerifier{
+		publicKey:   publicKey,
+		allowLegacy: allowLegacy,
+		covered:     -1,
+		links:       make(map[string]chainLink),
+	}
+}
+
+// Add verifies the next entry. It returns false once the chain is broken.
+func (v *ChainVerifier) Add(entry *AuditLogEntry) bool {
+	if v.report.Break != nil {
+		return false
+	}
+	index := v.report.Entries
+	v.report.Entries++
+	if index == 0 {
+		v.report.FirstID = entry.ID
+	}
+
+	fail := func(format string, args ...interface{}) bool {
+		v.report.Break = &ChainBreak{Index: index, ID: entry.ID, Reason: fmt.Sprintf(format, args...)}
+		return false
+	}
+
+	if entry.Hash == "" {
+		if v.chained || !v.allowLegacy {
+			return fail("entry has no hash")
+		}
+		v.report.Unchained++
+		return true
+	}
+
+	if !v.chained {
+		v.chained = true
+		v.report.Truncated = entry.PrevHash != ""
+	} else if entry.PrevHash != v.report.HeadHash {
+		return fail("previous hash %s does not match %s of entry %s", entry.PrevHash, v.report.HeadHash, v.report.HeadID)
+	}
+
+	hash, err := entry.ContentHash()
+	if err != nil {
+		return fail("%v", err)
+	}
+	if hash != entry.Hash {
+		return fail("content hash %s does not match recorded %s", hash, entry.Hash)
+	}
+
+	if entry.Action == ActionAuditCheckpoint {
+		if !v.verifyCheckpoint(entry, fail) {
+			return false
+		}
+	}
+
+	v.links[entry.ID] = chainLink{hash: entry.Hash, index: index}
+	v.report.HeadID = entry.ID
+	v.report.HeadHash = entry.Hash
+	return true
+}
+
+// verifyCheckpoint checks a checkpoint's signature and that the entry it pins is
+// the one in the chain
+func (v *ChainVerifier) verifyCheckpoint(entry *AuditLogEntry, fail func(string, ...interface{}) bool) bool {
+	entryID, _ := entry.Details["entry_id"].(string)
+	entryHash, _ := entry.Details["entry_hash"].(string)
+	keyID, _ := entry.Details["key_id"].(string)
+	encoded, _ := entry.Details["signature"].(string)
+
+	if v.publicKey == nil {
+		v.report.Unverified++
+		return true
+	}
+	if keyID != auditKeyID(v.publicKey) {
+		return fail("checkpoint signed with key %s, not %s", keyID, auditKeyID(v.publicKey))
+	}
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || !ed25519.Verify(v.publicKey, checkpointMessage(entryID, entryHash, entry.Timestamp), signature) {
+		return fail("invalid checkpoint signature")
+	}
+
+	link, ok := v.links[entryID]
+	if !ok {
+		v.report.Unverified++ // Pinned entry trimmed, or before the walk started
+		return true
+	}
+	if link.hash != entryHash {
+		return fail("checkpoint pins entry %s to %s, but the chain has %s", entryID, entryHash, link.hash)
+	}
+	v.report.Checkpoints++
+	v.covered = max(v.covered, link.index)
+	return true
+}
+
+// Report returns the result of the walk so far
+func (v *ChainVerifier) Report() *ChainReport {
+	report := v.report
+	report.Uncovered = report.Entries - v.covered - 1
+	return &report
+}
+
+// Err returns an error if the chain is broken, or if a public key was given but no
+// checkpoint verified
+func (v *ChainVerifier) Err() error {
+	if b := v.report.Break; b != nil {
+		return fmt.Errorf("audit chain broken at entry %s (#%d): %s", b.ID, b.Index, b.Reason)
+	}
+	if v.publicKey != nil && v.report.Checkpoints == 0 {
+		return ErrNoVerifiedCheckpoint
+	}
+	return nil
+}
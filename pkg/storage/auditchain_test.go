@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// buildChain chains n audit entries, inserting a checkpoint signed with key after
+// each index in checkpointAfter
+func buildChain(t *testing.T, n int, key ed25519.PrivateKey, checkpointAfter ...int) []*AuditLogEntry {
+	t.Helper()
+
+	entries := make([]*AuditLogEntry, 0, n)
+	add := func(entry *AuditLogEntry) {
+		prevHash := ""
+		if len(entries) > 0 {
+			prevHash = entries[len(entries)-1].Hash
+		}
+		if _, err := chainAuditEntry(entry, prevHash); err != nil {
+			t.Fatal(err)
+		}
+		entry.ID = fmt.Sprintf("%d-0", len(entries)+1)
+		entries = append(entries, entry)
+	}
+
+	for i := range n {
+		add(&AuditLogEntry{
+			Timestamp: time.Date(2026, 1, 1, 0, 0, i, 0, time.UTC),
+			EventID:   fmt.Sprintf("event-%d", i),
+			Action:    "event_created",
+			Details:   map[string]interface{}{"attempt": i},
+		})
+		for _, after := range checkpointAfter {
+			if after == i {
+				add(NewAuditCheckpoint(entries[len(entries)-1], key))
+			}
+		}
+	}
+	return entries
+}
+
+func TestChainVerifier(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := key.Public().(ed25519.PublicKey)
+
+	tests := []struct {
+		name          string
+		entries       func() []*AuditLogEntry
+		publicKey     ed25519.PublicKey
+		allowLegacy   bool
+		wantBreak     bool
+		wantErr       error
+		wantUncovered int
+		wantUnchained int
+	}{
+		{
+			name:          "intact chain without key",
+			entries:       func() []*AuditLogEntry { return buildChain(t, 5, key) },
+			wantUncovered: 5,
+		},
+		{
+			name:          "checkpoint covers entries before it",
+			entries:       func() []*AuditLogEntry { return buildChain(t, 5, key, 2) },
+			publicKey:     publicKey,
+			wantUncovered: 3, // The checkpoint itself and the two entries after it
+		},
+		{
+			name:      "public key without checkpoints",
+			entries:   func() []*AuditLogEntry { return buildChain(t, 5, key) },
+			publicKey: publicKey,
+			wantErr:   ErrNoVerifiedCheckpoint,
+		},
+		{
+			name:      "checkpoint signed with another key",
+			entries:   func() []*AuditLogEntry { return buildChain(t, 3, otherKey, 1) },
+			publicKey: publicKey,
+			wantBreak: true,
+		},
+		{
+			name: "edited entry",
+			entries: func() []*AuditLogEntry {
+				entries := buildChain(t, 5, key)
+				entries[2].EventID = "forged"
+				return entries
+			},
+			wantBreak: true,
+		},
+		{
+			name: "removed entry",
+			entries: func() []*AuditLogEntry {
+				entries := buildChain(t, 5, key)
+				return append(entries[:2], entries[3:]...)
+			},
+			wantBreak: true,
+		},
+		{
+			name: "rewritten log with hashes stripped",
+			entries: func() []*AuditLogEntry {
+				entries := buildChain(t, 5, key)
+				for _, entry := range entries {
+					entry.Hash, entry.PrevHash = "", ""
+					entry.EventID = "forged"
+				}
+				return entries
+			},
+			wantBreak: true,
+		},
+		{
+			name: "legacy entries allowed at the start",
+			entries: func() []*AuditLogEntry {
+				legacy := []*AuditLogEntry{{ID: "0-1", Action: "event_created"}, {ID: "0-2", Action: "event_created"}}
+				return append(legacy, buildChain(t, 3, key)...)
+			},
+			allowLegacy:   true,
+			wantUnchained: 2,
+			wantUncovered: 5,
+		},
+		{
+			name: "legacy entry after chaining started",
+			entries: func() []*AuditLogEntry {
+				entries := buildChain(t, 3, key)
+				return append(entries, &AuditLogEntry{ID: "9-0", Action: "event_created"})
+			},
+			allowLegacy: true,
+			wantBreak:   true,
+		},
+		{
+			name: "stripped hashes with key and legacy allowed",
+			entries: func() []*AuditLogEntry {
+				entries := buildChain(t, 3, key)
+				for _, entry := range entries {
+					entry.Hash, entry.PrevHash = "", ""
+				}
+				return entries
+			},
+			publicKey:   publicKey,
+			allowLegacy: true,
+			wantErr:     ErrNoVerifiedCheckpoint,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := NewChainVerifier(tt.publicKey, tt.allowLegacy)
+			for _, entry := range tt.entries() {
+				if !verifier.Add(entry) {
+					break
+				}
+			}
+
+			report := verifier.Report()
+			err := verifier.Err()
+			if tt.wantBreak {
+				if report.Break == nil || err == nil {
+					t.Fatalf("expected a break, got report %+v", report)
+				}
+				return
+			}
+			if report.Break != nil {
+				t.Fatalf("unexpected break: %+v", report.Break)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Err() = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if report.Uncovered != tt.wantUncovered {
+				t.Errorf("Uncovered = %d, want %d", report.Uncovered, tt.wantUncovered)
+			}
+			if report.Unchained != tt.wantUnchained {
+				t.Errorf("Unchained = %d, want %d", report.Unchained, tt.wantUnchained)
+			}
+		})
+	}
+}
+
+func TestAuditChainRoundTrip(t *testing.T) {
+	ctx := t.Context()
+	store := NewMemoryStorage()
+	for i := range 4 {
+		err := store.SaveAuditLog(ctx, &AuditLogEntry{
+			Timestamp: time.Now(),
+			EventID:   fmt.Sprintf("event-%d", i),
+			Action:    "event_routed",
+			Details:   map[string]interface{}{"attempt": i, "ratio": 0.5, "queued": true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := store.QueryAuditLog(ctx, AuditQuery{Limit: MaxAuditPageSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewChainVerifier(nil, false)
+	for _, entry := range page.Entries {
+		verifier.Add(entry)
+	}
+	if err := verifier.Err(); err != nil {
+		t.Fatalf("stored chain does not verify: %v", err)
+	}
+	if report := verifier.Report(); report.Entries != 4 || report.Truncated {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
@@ -0,0 +1,777 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bolt buckets
+var (
+	bucketEventStatus = []byte("event_status")   // eventID -> status JSON
+	bucketTimelines   = []byte("timelines")      // eventID -> nested bucket of sequence -> status change JSON
+	bucketAgentEvents = []byte("agent_events")   // agentID -> nested bucket of eventID -> unix nanos
+	bucketEvents      = []byte("events")         // eventID -> spec JSON
+	bucketManifests   = []byte("manifests")      // content hash -> manifest
+	bucketAgents      = []byte("agents")         // agentID -> agent JSON
+	bucketRevoked     = []byte("revoked")        // agentID -> RFC3339 time
+	bucketAudit       = []byte("audit")          // sequence -> entry JSON
+	bucketCounters    = []byte("counters")       // statistics counter -> nested bucket of field -> int64
+	bucketCounterTTL  = []byte("counter_expiry") // statistics counter -> expiry unix nanos
+)
+
+// BoltStorage implements Store in an embedded bbolt file, for single-node installs
+// that do not run Redis
+type BoltStorage struct {
+	db        *bolt.DB
+	retention Retention
+}
+
+var (
+	_ Store     = (*BoltStorage)(nil)
+	_ Compactor = (*BoltStorage)(nil)
+)
+
+// NewBoltStorage opens (or creates) the bbolt database at path. Bolt has no key
+// expiry, so the retention is applied by Compact.
+func NewBoltStorage(path string, retention Retention) (*BoltStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{bucketEventStatus, bucketTimelines, bucketAgentEvents, bucketEvents,
+			bucketManifests, bucketAgents, bucketRevoked, bucketAudit, bucketCounters, bucketCounterTTL} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bolt buckets: %w", err)
+	}
+
+	return &BoltStorage{db: db, retention: retention.withDefaults()}, nil
+}
+
+// Close closes the bbolt database
+func (bs *BoltStorage) Close() error {
+	return bs.db.Close()
+}
+
+// Ping always succeeds, the database is a local file
+func (bs *BoltStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Event Status Operations
+
+// SaveEventStatus saves event status to bbolt
+func (bs *BoltStorage) SaveEventStatus(ctx context.Context, status *model.EventStatus) error {
+	_, err := bs.UpdateEventStatus(ctx, status.EventID, func(*model.EventStatus) (*model.EventStatus, error) {
+		return status, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save event status: %w", err)
+	}
+	return nil
+}
+
+// UpdateEventStatus atomically applies update to an event status in a single
+// read-write transaction
+func (bs *BoltStorage) UpdateEventStatus(ctx context.Context, eventID string, update UpdateFunc) (*model.EventStatus, error) {
+	var result *statusUpdate
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		result, err = applyUpdate(eventID, tx.Bucket(bucketEventStatus).Get([]byte(eventID)), update)
+		if err != nil {
+			return err
+		}
+		if err := putEventStatus(tx, result); err != nil {
+			return fmt.Errorf("failed to save event status: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.status, nil
+}
+
+// putEventStatus writes a status, appends the change to the event's timeline (keeping
+// the newest model.MaxTimelineEntries) and indexes the status under its agent, moving
+// it if it was reassigned
+func putEventStatus(tx *bolt.Tx, update *statusUpdate) error {
+	status := update.status
+	if err := tx.Bucket(bucketEventStatus).Put([]byte(status.EventID), update.statusData); err != nil {
+		return err
+	}
+
+	timeline, err := tx.Bucket(bucketTimelines).CreateBucketIfNotExists([]byte(status.EventID))
+	if err != nil {
+		return err
+	}
+	seq, err := timeline.NextSequence()
+	if err != nil {
+		return err
+	}
+	if err := timeline.Put(encodeUint64(seq), update.changeData); err != nil {
+		return err
+	}
+	// Sequences are contiguous, so dropping one entry per write keeps the newest ones
+	if seq > model.MaxTimelineEntries {
+		if err := timeline.Delete(encodeUint64(seq - model.MaxTimelineEntries)); err != nil {
+			return err
+		}
+	}
+
+	if previous := update.previous; previous != nil && previous.AgentID != status.AgentID {
+		if events := tx.Bucket(bucketAgentEvents).Bucket([]byte(previous.AgentID)); events != nil {
+			if err := events.Delete([]byte(status.EventID)); err != nil {
+				return err
+			}
+		}
+	}
+
+	events, err := tx.Bucket(bucketAgentEvents).CreateBucketIfNotExists([]byte(status.AgentID))
+	if err != nil {
+		return err
+	}
+	return events.Put([]byte(status.EventID), encodeInt64(time.Now().UnixNano()))
+}
+
+// GetEventStatus retrieves event status from bbolt
+func (bs *BoltStorage) GetEventStatus(ctx context.Context, eventID string) (*model.EventStatus, error) {
+	var status *model.EventStatus
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketEventStatus).Get([]byte(eventID))
+		if data == nil {
+			return model.ErrStatusNotFound
+		}
+		status = &model.EventStatus{}
+		if err := json.Unmarshal(data, status); err != nil {
+			return fmt.Errorf("failed to unmarshal event status: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// ListEventsByAgent lists the most recent events for a specific agent
+func (bs *BoltStorage) ListEventsByAgent(ctx context.Context, agentID string, limit int) ([]string, error) {
+	events := make(map[string]time.Time)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketAgentEvents).Bucket([]byte(agentID))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			events[string(k)] = time.Unix(0, decodeInt64(v))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events for agent: %w", err)
+	}
+	return recentEvents(events, limit), nil
+}
+
+// ListEventsByState lists all events currently in a specific state. The status bucket
+// is scanned, which is fine for the event volumes of a single-node install.
+func (bs *BoltStorage) ListEventsByState(ctx context.Context, state model.ExecutionState) ([]string, error) {
+	eventIDs := make([]string, 0)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketEventStatus).ForEach(func(k, v []byte) error {
+			var status struct {
+				State model.ExecutionState `json:"state"`
+			}
+			if err := json.Unmarshal(v, &status); err == nil && status.State == state {
+				eventIDs = append(eventIDs, string(k))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events by state: %w", err)
+	}
+	return eventIDs, nil
+}
+
+// GetEventTimeline reads an event's timeline bucket
+func (bs *BoltStorage) GetEventTimeline(ctx context.Context, eventID string) ([]*model.StatusChange, error) {
+	entries := make([][]byte, 0)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		timeline := tx.Bucket(bucketTimelines).Bucket([]byte(eventID))
+		if timeline == nil {
+			return nil
+		}
+		return timeline.ForEach(func(_, v []byte) error {
+			entries = append(entries, slices.Clone(v))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event timeline: %w", err)
+	}
+	return decodeTimeline(entries)
+}
+
+// Event Spec Operations
+
+// SaveEvent saves a submitted event, storing each manifest once by content hash
+func (bs *BoltStorage) SaveEvent(ctx context.Context, event *model.Event) (*EventSpec, error) {
+	spec, blobs := newEventSpec(event)
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		manifests := tx.Bucket(bucketManifests)
+		for hash, manifest := range blobs {
+			if manifests.Get([]byte(hash)) != nil {
+				continue
+			}
+			if err := manifests.Put([]byte(hash), []byte(manifest)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(bucketEvents).Put([]byte(event.ID), data)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save event: %w", err)
+	}
+	return spec, nil
+}
+
+// GetEvent retrieves a submitted event with its manifests
+func (bs *BoltStorage) GetEvent(ctx context.Context, eventID string) (*EventSpec, error) {
+	var spec *EventSpec
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketEvents).Get([]byte(eventID))
+		if data == nil {
+			return model.ErrEventNotFound
+		}
+		spec = &EventSpec{}
+		if err := json.Unmarshal(data, spec); err != nil {
+			return fmt.Errorf("failed to unmarshal event: %w", err)
+		}
+
+		manifests := tx.Bucket(bucketManifests)
+		return spec.restoreManifests(func(hash string) (string, bool) {
+			manifest := manifests.Get([]byte(hash))
+			return string(manifest), manifest != nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// Agent State Operations
+
+// SaveAgent saves agent state to bbolt
+func (bs *BoltStorage) SaveAgent(ctx context.Context, agent *model.Agent) error {
+	data, err := json.Marshal(agent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal agent: %w", err)
+	}
+
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAgents).Put([]byte(agent.ID), data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save agent: %w", err)
+	}
+	return nil
+}
+
+// GetAgent retrieves agent state from bbolt
+func (bs *BoltStorage) GetAgent(ctx context.Context, agentID string) (*model.Agent, error) {
+	var agent *model.Agent
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketAgents).Get([]byte(agentID))
+		if data == nil {
+			return model.ErrAgentNotFound
+		}
+		agent = &model.Agent{}
+		if err := json.Unmarshal(data, agent); err != nil {
+			return fmt.Errorf("failed to unmarshal agent: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return agent, nil
+}
+
+// ListAllAgents lists all registered agents
+func (bs *BoltStorage) ListAllAgents(ctx context.Context) ([]string, error) {
+	agentIDs := make([]string, 0)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAgents).ForEach(func(k, _ []byte) error {
+			agentIDs = append(agentIDs, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list agents: %w", err)
+	}
+	return agentIDs, nil
+}
+
+// ListAgentsByCluster lists all agents in a specific cluster
+func (bs *BoltStorage) ListAgentsByCluster(ctx context.Context, clusterName string) ([]string, error) {
+	agentIDs := make([]string, 0)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAgents).ForEach(func(k, v []byte) error {
+			var agent struct {
+				ClusterName string `json:"cluster_name"`
+			}
+			if err := json.Unmarshal(v, &agent); err == nil && agent.ClusterName == clusterName {
+				agentIDs = append(agentIDs, string(k))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list agents by cluster: %w", err)
+	}
+	return agentIDs, nil
+}
+
+// DeleteAgent removes an agent from bbolt
+func (bs *BoltStorage) DeleteAgent(ctx context.Context, agentID string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketAgents)
+		if bucket.Get([]byte(agentID)) == nil {
+			return model.ErrAgentNotFound
+		}
+		if err := bucket.Delete([]byte(agentID)); err != nil {
+			return fmt.Errorf("failed to delete agent: %w", err)
+		}
+		return nil
+	})
+}
+
+// RevokeAgent records that an agent was decommissioned. Revoked agents may not register.
+func (bs *BoltStorage) RevokeAgent(ctx context.Context, agentID string) error {
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketRevoked).Put([]byte(agentID), []byte(time.Now().Format(time.RFC3339)))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke agent: %w", err)
+	}
+	return nil
+}
+
+// RestoreAgent lifts the revocation of a decommissioned agent
+func (bs *BoltStorage) RestoreAgent(ctx context.Context, agentID string) (bool, error) {
+	removed := false
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketRevoked)
+		if bucket.Get([]byte(agentID)) == nil {
+			return nil
+		}
+		removed = true
+		return bucket.Delete([]byte(agentID))
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to restore agent: %w", err)
+	}
+	return removed, nil
+}
+
+// IsAgentRevoked reports whether an agent was decommissioned
+func (bs *BoltStorage) IsAgentRevoked(ctx context.Context, agentID string) (bool, error) {
+	revoked := false
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		revoked = tx.Bucket(bucketRevoked).Get([]byte(agentID)) != nil
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to check agent revocation: %w", err)
+	}
+	return revoked, nil
+}
+
+// Audit Log Operations
+
+// SaveAuditLog appends an audit log entry, chained to the newest entry
+func (bs *BoltStorage) SaveAuditLog(ctx context.Context, entry *AuditLogEntry) error {
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketAudit)
+
+		prevHash := ""
+		if _, v := bucket.Cursor().Last(); v != nil {
+			var head AuditLogEntry
+			if json.Unmarshal(v, &head) == nil {
+				if isRetriedAudit(&head, entry) {
+					return nil
+				}
+				prevHash = head.Hash
+			}
+		}
+		data, err := chainAuditEntry(entry, prevHash)
+		if err != nil {
+			return err
+		}
+
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(encodeUint64(seq), data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save audit log: %w", err)
+	}
+	return nil
+}
+
+// GetRecentAuditLogs retrieves recent audit log entries, most recent first
+func (bs *BoltStorage) GetRecentAuditLogs(ctx context.Context, count int) ([]*AuditLogEntry, error) {
+	entries := make([]*AuditLogEntry, 0)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(bucketAudit).Cursor()
+		for k, v := cursor.Last(); k != nil && len(entries) < count; k, v = cursor.Prev() {
+			var entry AuditLogEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				continue
+			}
+			entry.ID = strconv.FormatUint(binary.BigEndian.Uint64(k), 10)
+			entries = append(entries, &entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit logs: %w", err)
+	}
+	return entries, nil
+}
+
+// QueryAuditLog pages through the audit log, oldest first. The cursor is the
+// sequence number of the last entry returned.
+func (bs *BoltStorage) QueryAuditLog(ctx context.Context, query AuditQuery) (*AuditPage, error) {
+	query = query.withDefaults()
+
+	var after uint64
+	if query.Cursor != "" {
+		seq, err := strconv.ParseUint(query.Cursor, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidAuditCursor, query.Cursor)
+		}
+		after = seq
+	}
+
+	page := &AuditPage{Entries: make([]*AuditLogEntry, 0)}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(bucketAudit).Cursor()
+		for k, v := cursor.Seek(encodeUint64(after + 1)); k != nil; k, v = cursor.Next() {
+			var entry AuditLogEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				continue
+			}
+			entry.ID = strconv.FormatUint(binary.BigEndian.Uint64(k), 10)
+			if !page.add(query, &entry) {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	return page, nil
+}
+
+// Statistics Operations
+
+// RecordTransition counts an event state transition in the statistics
+func (bs *BoltStorage) RecordTransition(ctx context.Context, transition Transition) error {
+	return bs.incrementCounters(ctx, transitionCounters(transition))
+}
+
+// GetEventStats retrieves event statistics
+func (bs *BoltStorage) GetEventStats(ctx context.Context) (*EventStats, error) {
+	return buildEventStats(ctx, bs, time.Now())
+}
+
+func (bs *BoltStorage) incrementCounters(ctx context.Context, increments []counterIncrement) error {
+	now := time.Now()
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		counters := tx.Bucket(bucketCounters)
+		expiry := tx.Bucket(bucketCounterTTL)
+		for _, inc := range increments {
+			fields, err := counters.CreateBucketIfNotExists([]byte(inc.Key))
+			if err != nil {
+				return err
+			}
+			value := int64(0)
+			if data := fields.Get([]byte(inc.Field)); data != nil {
+				value = decodeInt64(data)
+			}
+			if err := fields.Put([]byte(inc.Field), encodeInt64(value+inc.Delta)); err != nil {
+				return err
+			}
+			if inc.TTL > 0 {
+				if err := expiry.Put([]byte(inc.Key), encodeInt64(now.Add(inc.TTL).UnixNano())); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to increment stats counters: %w", err)
+	}
+	return nil
+}
+
+func (bs *BoltStorage) readCounters(ctx context.Context, keys ...string) (map[string]map[string]int64, error) {
+	now := time.Now()
+	values := make(map[string]map[string]int64, len(keys))
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		counters := tx.Bucket(bucketCounters)
+		expiry := tx.Bucket(bucketCounterTTL)
+		for _, key := range keys {
+			fields := counters.Bucket([]byte(key))
+			if fields == nil {
+				continue
+			}
+			if data := expiry.Get([]byte(key)); data != nil && now.After(time.Unix(0, decodeInt64(data))) {
+				continue
+			}
+			values[key] = make(map[string]int64)
+			fields.ForEach(func(k, v []byte) error {
+				values[key][string(k)] = decodeInt64(v)
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats counters: %w", err)
+	}
+	return values, nil
+}
+
+func encodeUint64(v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	return buf
+}
+
+func encodeInt64(v int64) []byte {
+	return encodeUint64(uint64(v))
+}
+
+func decodeInt64(data []byte) int64 {
+	if len(data) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(data))
+}
+
+// Compaction
+
+// Compact deletes event statuses and their timelines, specs and agent index entries
+// past the retention, index entries of deleted or reassigned events, manifests no
+// longer referenced by any event, expired statistics counters and audit entries
+// beyond the audit limits
+func (bs *BoltStorage) Compact(ctx context.Context) (*CompactResult, error) {
+	result := &CompactResult{}
+	cutoff := time.Now().Add(-bs.retention.EventStatus)
+
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		// Expired statuses
+		statuses := tx.Bucket(bucketEventStatus)
+		owners := make(map[string]string) // eventID -> agentID of retained statuses
+		expired := make([][]byte, 0)
+		err := statuses.ForEach(func(k, v []byte) error {
+			var status struct {
+				AgentID   string    `json:"agent_id"`
+				UpdatedAt time.Time `json:"updated_at"`
+			}
+			if err := json.Unmarshal(v, &status); err != nil {
+				owners[string(k)] = "" // Unreadable; kept, but no longer indexed
+				return nil
+			}
+			if status.UpdatedAt.Before(cutoff) {
+				expired = append(expired, k)
+				return nil
+			}
+			owners[string(k)] = status.AgentID
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := statuses.Delete(k); err != nil {
+				return err
+			}
+		}
+		result.Statuses = len(expired)
+
+		// Timelines of expired or deleted statuses
+		timelines := tx.Bucket(bucketTimelines)
+		orphaned := make([][]byte, 0)
+		timelines.ForEachBucket(func(k []byte) error {
+			if _, ok := owners[string(k)]; !ok {
+				orphaned = append(orphaned, k)
+			}
+			return nil
+		})
+		for _, k := range orphaned {
+			if err := timelines.DeleteBucket(k); err != nil {
+				return err
+			}
+		}
+		result.Timelines = len(orphaned)
+
+		// Expired event specs, then manifests no event references anymore
+		events := tx.Bucket(bucketEvents)
+		referenced := make(map[string]bool)
+		expiredEvents := make([][]byte, 0)
+		err = events.ForEach(func(k, v []byte) error {
+			var spec EventSpec
+			if err := json.Unmarshal(v, &spec); err != nil {
+				return nil
+			}
+			if spec.StoredAt.Before(cutoff) {
+				expiredEvents = append(expiredEvents, k)
+				return nil
+			}
+			for _, hash := range spec.ManifestHashes {
+				referenced[hash] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expiredEvents {
+			if err := events.Delete(k); err != nil {
+				return err
+			}
+		}
+		result.Events = len(expiredEvents)
+
+		manifests := tx.Bucket(bucketManifests)
+		unreferenced := make([][]byte, 0)
+		manifests.ForEach(func(k, _ []byte) error {
+			if !referenced[string(k)] {
+				unreferenced = append(unreferenced, k)
+			}
+			return nil
+		})
+		for _, k := range unreferenced {
+			if err := manifests.Delete(k); err != nil {
+				return err
+			}
+		}
+		result.Manifests = len(unreferenced)
+
+		// Agent index entries that expired or no longer match their status
+		agentEvents := tx.Bucket(bucketAgentEvents)
+		agentIDs := make([][]byte, 0)
+		agentEvents.ForEachBucket(func(k []byte) error {
+			agentIDs = append(agentIDs, k)
+			return nil
+		})
+		for _, agentID := range agentIDs {
+			events := agentEvents.Bucket(agentID)
+			stale := make([][]byte, 0)
+			events.ForEach(func(k, v []byte) error {
+				owner, ok := owners[string(k)]
+				if !ok || owner != string(agentID) || time.Unix(0, decodeInt64(v)).Before(cutoff) {
+					stale = append(stale, k)
+				}
+				return nil
+			})
+			for _, k := range stale {
+				if err := events.Delete(k); err != nil {
+					return err
+				}
+			}
+			result.AgentEntries += len(stale)
+
+			if k, _ := events.Cursor().First(); k == nil {
+				if err := agentEvents.DeleteBucket(agentID); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Statistics counters past their TTL
+		counters := tx.Bucket(bucketCounters)
+		expiry := tx.Bucket(bucketCounterTTL)
+		expiredCounters := make([][]byte, 0)
+		expiry.ForEach(func(k, v []byte) error {
+			if time.Now().After(time.Unix(0, decodeInt64(v))) {
+				expiredCounters = append(expiredCounters, k)
+			}
+			return nil
+		})
+		for _, k := range expiredCounters {
+			if counters.Bucket(k) != nil {
+				if err := counters.DeleteBucket(k); err != nil {
+					return err
+				}
+			}
+			if err := expiry.Delete(k); err != nil {
+				return err
+			}
+		}
+		result.Counters = len(expiredCounters)
+
+		// Audit entries beyond the length limit or older than the age limit
+		audit := tx.Bucket(bucketAudit)
+		excess := int64(audit.Stats().KeyN) - bs.retention.AuditMaxLen
+		ageCutoff := time.Now().Add(-bs.retention.AuditMaxAge)
+		cursor := audit.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.First() {
+			if excess <= 0 {
+				if bs.retention.AuditMaxAge <= 0 {
+					break
+				}
+				var entry AuditLogEntry
+				if err := json.Unmarshal(v, &entry); err == nil && !entry.Timestamp.Before(ageCutoff) {
+					break
+				}
+			}
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+			excess--
+			result.AuditEntries++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compact bolt storage: %w", err)
+	}
+	return result, nil
+}
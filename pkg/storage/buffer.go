@@ -0,0 +1,365 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+)
+
+// ErrBufferFull is returned for a write that could neither be saved nor buffered
+var ErrBufferFull = errors.New("storage unavailable and write buffer full")
+
+// ErrAlreadyApplied is returned by an IdempotentUpdate whose write was already saved,
+// typically by an attempt that timed out after the backend committed it
+var ErrAlreadyApplied = errors.New("write already applied")
+
+// Default write-behind buffer settings
+const (
+	DefaultBufferSize       = 10000
+	DefaultRetryInterval    = 500 * time.Millisecond
+	DefaultMaxRetryInterval = 30 * time.Second
+	DefaultPingInterval     = 10 * time.Second
+)
+
+// BufferConfig configures the write-behind buffer of a BufferedStore
+type BufferConfig struct {
+	Size             int           // Writes held while the backend is unavailable
+	RetryInterval    time.Duration // Delay before retrying buffered writes, doubled after each failure
+	MaxRetryInterval time.Duration // Upper bound of the retry delay
+	PingInterval     time.Duration // How often backend health is checked while nothing is buffered
+}
+
+func (c BufferConfig) withDefaults() BufferConfig {
+	if c.Size <= 0 {
+		c.Size = DefaultBufferSize
+	}
+	if c.RetryInterval <= 0 {
+		c.RetryInterval = DefaultRetryInterval
+	}
+	if c.MaxRetryInterval < c.RetryInterval {
+		c.MaxRetryInterval = max(DefaultMaxRetryInterval, c.RetryInterval)
+	}
+	if c.PingInterval <= 0 {
+		c.PingInterval = DefaultPingInterval
+	}
+	return c
+}
+
+// StorageHealth reports whether the storage backend is reachable and how many
+// writes are waiting for it
+type StorageHealth struct {
+	Healthy        bool      `json:"healthy"`
+	Buffered       int       `json:"buffered"` // Writes waiting to be retried
+	Capacity       int       `json:"capacity"` // Size of the write buffer
+	Dropped        int64     `json:"dropped"`  // Writes lost to a full buffer or a permanent error on retry
+	LastError      string    `json:"last_error,omitempty"`
+	UnhealthySince time.Time `json:"unhealthy_since,omitzero"`
+	CheckedAt      time.Time `json:"checked_at"`
+}
+
+// bufferedWrite is a write waiting for the backend to come back
+type bufferedWrite struct {
+	op    string
+	write func(ctx context.Context) error
+}
+
+// BufferedStore wraps a Store with a bounded write-behind buffer. Event status,
+// audit and statistics writes that fail because the backend is unavailable are
+// queued and retried in order with backoff, instead of being lost during a Redis
+// failover or blip. Reads and all other writes go straight to the backend.
+type BufferedStore struct {
+	Store
+	config BufferConfig
+
+	mu       sync.Mutex
+	queue    []bufferedWrite
+	inflight int // Writes being made directly; buffered writes wait for them
+	health   StorageHealth
+
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+var _ Store = (*BufferedStore)(nil)
+
+// NewBufferedStore wraps store and starts retrying buffered writes in the background
+func NewBufferedStore(store Store, config BufferConfig) *BufferedStore {
+	config = config.withDefaults()
+	b := &BufferedStore{
+		Store:   store,
+		config:  config,
+		health:  StorageHealth{Healthy: true, Capacity: config.Size, CheckedAt: time.Now()},
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Close makes a last attempt to save buffered writes and closes the backend
+func (b *BufferedStore) Close() error {
+	close(b.done)
+	<-b.stopped
+	b.flush()
+	if n := b.pending(); n > 0 {
+		logger.Error("Closing storage with unsaved buffered writes", "writes", n)
+	}
+	return b.Store.Close()
+}
+
+// Health reports the backend health and the state of the write buffer
+func (b *BufferedStore) Health() StorageHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	health := b.health
+	health.Buffered = len(b.queue)
+	return health
+}
+
+// SaveEventStatus saves an event status, buffering it if the backend is unavailable
+func (b *BufferedStore) SaveEventStatus(ctx context.Context, status *model.EventStatus) error {
+	return b.WriteBehind(ctx, "event_status", func(ctx context.Context) error {
+		return b.Store.SaveEventStatus(ctx, status)
+	})
+}
+
+// SaveAuditLog appends an audit entry, buffering it if the backend is unavailable.
+// Buffered entries are chained when they are finally written, and carry a write ID
+// so a retried entry is not appended twice.
+func (b *BufferedStore) SaveAuditLog(ctx context.Context, entry *AuditLogEntry) error {
+	if entry.WriteID == "" {
+		entry.WriteID = uuid.NewString()
+	}
+	return b.WriteBehind(ctx, "audit_log", func(ctx context.Context) error {
+		return b.Store.SaveAuditLog(ctx, entry)
+	})
+}
+
+// RecordTransition counts a transition, buffering it if the backend is unavailable
+func (b *BufferedStore) RecordTransition(ctx context.Context, transition Transition) error {
+	return b.WriteBehind(ctx, "transition", func(ctx context.Context) error {
+		return b.Store.RecordTransition(ctx, transition)
+	})
+}
+
+// WriteBehind runs write now, or queues it for retry if the backend is unavailable
+// or earlier writes are still queued, so writes are applied in order. It returns
+// nil once the write is queued, ErrBufferFull if the buffer has no room, and
+// any other error of write unchanged. A queued write may already have been
+// committed by an attempt that timed out, so it must be idempotent (see
+// IdempotentUpdate) and safe to run again later with a fresh context.
+func (b *BufferedStore) WriteBehind(ctx context.Context, op string, write func(ctx context.Context) error) error {
+	b.mu.Lock()
+	if len(b.queue) > 0 {
+		defer b.mu.Unlock()
+		return b.enqueueLocked(op, write)
+	}
+	b.inflight++
+	b.mu.Unlock()
+
+	err := write(ctx)
+	if err == nil {
+		b.markHealthy()
+	} else if IsUnavailable(err) {
+		b.markUnhealthy(err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inflight--
+	if err == nil || !IsUnavailable(err) {
+		return err
+	}
+	return b.enqueueLocked(op, write)
+}
+
+// enqueueLocked buffers a write for retry. Caller must hold b.mu.
+func (b *BufferedStore) enqueueLocked(op string, write func(ctx context.Context) error) error {
+	if len(b.queue) >= b.config.Size {
+		b.health.Dropped++
+		logger.Error("Storage write buffer full, dropping write", "op", op, "buffered", len(b.queue))
+		return ErrBufferFull
+	}
+	b.queue = append(b.queue, bufferedWrite{op: op, write: write})
+	if len(b.queue) == 1 {
+		logger.Warn("Storage unavailable, buffering writes", "op", op, "error", b.health.LastError)
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// run retries buffered writes with exponential backoff, and checks the backend's
+// health while the buffer is empty
+func (b *BufferedStore) run() {
+	defer close(b.stopped)
+
+	ticker := time.NewTicker(b.config.PingInterval)
+	defer ticker.Stop()
+
+	retry := b.config.RetryInterval
+	var retryTimer <-chan time.Time
+	for {
+		if retryTimer == nil && b.pending() > 0 {
+			retryTimer = time.After(retry)
+		}
+
+		select {
+		case <-b.done:
+			return
+		case <-b.wake:
+			continue // Arm the retry timer for the first buffered write
+		case <-ticker.C:
+			if b.pending() == 0 {
+				b.ping()
+			}
+			continue
+		case <-retryTimer:
+		}
+
+		retryTimer = nil
+		if b.flush() {
+			retry = b.config.RetryInterval
+		} else {
+			retry = min(2*retry, b.config.MaxRetryInterval)
+		}
+	}
+}
+
+// flush writes buffered writes oldest first, once writes made directly before the
+// first write was buffered have finished. It returns false if the backend is still
+// unavailable or such writes are still running.
+func (b *BufferedStore) flush() bool {
+	flushed := 0
+	for {
+		b.mu.Lock()
+		if b.inflight > 0 && flushed == 0 {
+			b.mu.Unlock()
+			return false
+		}
+		if len(b.queue) == 0 {
+			b.mu.Unlock()
+			if flushed > 0 {
+				logger.Info("Storage available again, buffered writes saved", "writes", flushed)
+			}
+			return true
+		}
+		next := b.queue[0]
+		b.mu.Unlock()
+
+		err := next.write(context.Background())
+		if err != nil && IsUnavailable(err) {
+			b.markUnhealthy(err)
+			return false
+		}
+
+		b.mu.Lock()
+		b.queue[0] = bufferedWrite{}
+		b.queue = b.queue[1:]
+		if err != nil {
+			b.health.Dropped++
+		}
+		b.mu.Unlock()
+
+		if err != nil {
+			logger.Error("Dropping buffered write", "op", next.op, "error", err)
+			continue
+		}
+		b.markHealthy()
+		flushed++
+	}
+}
+
+// ping checks the backend while no writes exercise it
+func (b *BufferedStore) ping() {
+	if err := b.Store.Ping(context.Background()); err != nil {
+		b.markUnhealthy(err)
+		return
+	}
+	b.markHealthy()
+}
+
+func (b *BufferedStore) pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.queue)
+}
+
+func (b *BufferedStore) markHealthy() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.health.Healthy {
+		logger.Info("Storage healthy", "unhealthy_for", time.Since(b.health.UnhealthySince))
+	}
+	b.health.Healthy = true
+	b.health.LastError = ""
+	b.health.UnhealthySince = time.Time{}
+	b.health.CheckedAt = time.Now()
+}
+
+func (b *BufferedStore) markUnhealthy(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.health.Healthy {
+		logger.Error("Storage unhealthy", "error", err)
+		b.health.UnhealthySince = time.Now()
+	}
+	b.health.Healthy = false
+	b.health.LastError = err.Error()
+	b.health.CheckedAt = time.Now()
+}
+
+// IsUnavailable reports whether an operation failed because the backend could not
+// be reached or is temporarily refusing writes (failover, loading, cluster down),
+// rather than because of the request itself. Such operations may succeed if retried.
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, redis.ErrPoolTimeout),
+		errors.As(err, &netErr):
+		return true
+	}
+	return redis.IsLoadingError(err) ||
+		redis.IsReadOnlyError(err) ||
+		redis.IsClusterDownError(err) ||
+		redis.IsTryAgainError(err) ||
+		redis.IsMasterDownError(err)
+}
+
+// IdempotentUpdate wraps an UpdateFunc so it is applied at most once: the status
+// records writeID, and a later attempt with the same ID fails with ErrAlreadyApplied.
+// Writes to one status are applied in order, so only the latest ID needs keeping.
+func IdempotentUpdate(writeID string, update UpdateFunc) UpdateFunc {
+	return func(status *model.EventStatus) (*model.EventStatus, error) {
+		if status != nil && status.WriteID == writeID {
+			return nil, ErrAlreadyApplied
+		}
+		next, err := update(status)
+		if err != nil {
+			return nil, err
+		}
+		next.WriteID = writeID
+		return next, nil
+	}
+}
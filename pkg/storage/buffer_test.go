@@ -0,0 +1,328 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+)
+
+// fakeBackend records the writes applied to it and fails them while down
+type fakeBackend struct {
+	mu      sync.Mutex
+	down    bool
+	failing map[string]error // Writes that fail permanently
+	applied []string
+}
+
+func (f *fakeBackend) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *fakeBackend) write(name string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.down {
+			return io.EOF
+		}
+		if err := f.failing[name]; err != nil {
+			return err
+		}
+		f.applied = append(f.applied, name)
+		return nil
+	}
+}
+
+// newTestBufferedStore returns a buffered store whose retries are driven by the test
+func newTestBufferedStore(t *testing.T, store Store, size int) *BufferedStore {
+	t.Helper()
+	logger.InitLogger(false)
+	buffered := NewBufferedStore(store, BufferConfig{Size: size, RetryInterval: time.Hour, PingInterval: time.Hour})
+	t.Cleanup(func() { buffered.Close() })
+	return buffered
+}
+
+func TestBufferedStoreReplayOrder(t *testing.T) {
+	errInvalid := errors.New("invalid write")
+
+	// A step writes name, or toggles the backend with "down" and "up", or flushes with "flush"
+	tests := []struct {
+		name        string
+		steps       []string
+		failing     map[string]error
+		size        int
+		wantErrs    map[string]error // Errors returned by WriteBehind
+		wantApplied []string
+		wantPending int
+		wantDropped int64
+	}{
+		{
+			name:        "healthy backend writes through",
+			steps:       []string{"a", "b", "c"},
+			wantApplied: []string{"a", "b", "c"},
+		},
+		{
+			name:        "writes buffered during an outage replay in order",
+			steps:       []string{"a", "down", "b", "c", "up", "d", "flush", "e"},
+			wantApplied: []string{"a", "b", "c", "d", "e"},
+		},
+		{
+			name:        "still down on retry keeps the buffer",
+			steps:       []string{"down", "a", "b", "flush"},
+			wantApplied: []string{},
+			wantPending: 2,
+		},
+		{
+			name:        "permanent error on retry is dropped",
+			steps:       []string{"down", "a", "b", "c", "up", "flush"},
+			failing:     map[string]error{"b": errInvalid},
+			wantApplied: []string{"a", "c"},
+			wantDropped: 1,
+		},
+		{
+			name:        "permanent error without an outage is returned",
+			steps:       []string{"a", "b"},
+			failing:     map[string]error{"b": errInvalid},
+			wantErrs:    map[string]error{"b": errInvalid},
+			wantApplied: []string{"a"},
+		},
+		{
+			name:        "full buffer drops new writes",
+			steps:       []string{"down", "a", "b", "c", "up", "flush"},
+			size:        2,
+			wantErrs:    map[string]error{"c": ErrBufferFull},
+			wantApplied: []string{"a", "b"},
+			wantDropped: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeBackend{failing: tt.failing, applied: []string{}}
+			size := tt.size
+			if size == 0 {
+				size = 10
+			}
+			buffered := newTestBufferedStore(t, NewMemoryStorage(), size)
+
+			for _, step := range tt.steps {
+				switch step {
+				case "down", "up":
+					backend.setDown(step == "down")
+				case "flush":
+					buffered.flush()
+				default:
+					err := buffered.WriteBehind(t.Context(), "test", backend.write(step))
+					if !errors.Is(err, tt.wantErrs[step]) {
+						t.Fatalf("write %s = %v, want %v", step, err, tt.wantErrs[step])
+					}
+				}
+			}
+
+			if !slices.Equal(backend.applied, tt.wantApplied) {
+				t.Fatalf("applied %v, want %v", backend.applied, tt.wantApplied)
+			}
+			health := buffered.Health()
+			if health.Buffered != tt.wantPending || health.Dropped != tt.wantDropped {
+				t.Fatalf("buffered %d, dropped %d; want %d and %d", health.Buffered, health.Dropped, tt.wantPending, tt.wantDropped)
+			}
+			if health.Healthy != (tt.wantPending == 0) {
+				t.Fatalf("healthy = %v with %d writes pending", health.Healthy, tt.wantPending)
+			}
+		})
+	}
+}
+
+// flakyAuditStore fails audit writes while down
+type flakyAuditStore struct {
+	*MemoryStorage
+	backend *fakeBackend
+}
+
+func (s *flakyAuditStore) SaveAuditLog(ctx context.Context, entry *AuditLogEntry) error {
+	if err := s.backend.write(entry.EventID)(ctx); err != nil {
+		return err
+	}
+	return s.MemoryStorage.SaveAuditLog(ctx, entry)
+}
+
+func TestBufferedAuditEntriesChainInOrder(t *testing.T) {
+	backend := &fakeBackend{}
+	buffered := newTestBufferedStore(t, &flakyAuditStore{MemoryStorage: NewMemoryStorage(), backend: backend}, 10)
+	ctx := t.Context()
+
+	for i := range 6 {
+		backend.setDown(i >= 2 && i < 4) // Entries 2 and 3 are buffered, 4 and 5 queue behind them
+		entry := &AuditLogEntry{Timestamp: time.Now(), EventID: fmt.Sprintf("event-%d", i), Action: "event_created"}
+		if err := buffered.SaveAuditLog(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !buffered.flush() {
+		t.Fatal("flush failed with the backend up")
+	}
+
+	page, err := buffered.QueryAuditLog(ctx, AuditQuery{Limit: MaxAuditPageSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewChainVerifier(nil, false)
+	got := make([]string, 0, len(page.Entries))
+	for _, entry := range page.Entries {
+		verifier.Add(entry)
+		got = append(got, entry.EventID)
+	}
+	if err := verifier.Err(); err != nil {
+		t.Fatalf("replayed entries do not chain: %v", err)
+	}
+	want := []string{"event-0", "event-1", "event-2", "event-3", "event-4", "event-5"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("audit order %v, want %v", got, want)
+	}
+}
+
+func TestBufferedStoreWaitsForDirectWrites(t *testing.T) {
+	backend := &fakeBackend{applied: []string{}}
+	buffered := newTestBufferedStore(t, NewMemoryStorage(), 10)
+
+	// a is written directly and is still running when b is buffered
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- buffered.WriteBehind(t.Context(), "test", func(ctx context.Context) error {
+			close(started)
+			<-release
+			return backend.write("a")(ctx)
+		})
+	}()
+	<-started
+
+	backend.setDown(true)
+	if err := buffered.WriteBehind(t.Context(), "test", backend.write("b")); err != nil {
+		t.Fatal(err)
+	}
+	backend.setDown(false)
+
+	// c is queued behind b rather than written directly
+	if err := buffered.WriteBehind(t.Context(), "test", backend.write("c")); err != nil {
+		t.Fatal(err)
+	}
+	if buffered.flush() {
+		t.Fatal("flush ran buffered writes ahead of a direct write")
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !buffered.flush() {
+		t.Fatal("flush failed with the backend up")
+	}
+	if want := []string{"a", "b", "c"}; !slices.Equal(backend.applied, want) {
+		t.Fatalf("applied %v, want %v", backend.applied, want)
+	}
+}
+
+// committingStore commits writes but reports a timeout for the first attempt of
+// each, like a backend that applied a write whose reply was lost
+type committingStore struct {
+	*MemoryStorage
+	mu       sync.Mutex
+	attempts map[string]int
+}
+
+func (s *committingStore) timedOut(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts[key]++
+	return s.attempts[key] == 1
+}
+
+func (s *committingStore) SaveAuditLog(ctx context.Context, entry *AuditLogEntry) error {
+	if err := s.MemoryStorage.SaveAuditLog(ctx, entry); err != nil {
+		return err
+	}
+	if s.timedOut("audit:" + entry.EventID) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+func (s *committingStore) UpdateEventStatus(ctx context.Context, eventID string, update UpdateFunc) (*model.EventStatus, error) {
+	status, err := s.MemoryStorage.UpdateEventStatus(ctx, eventID, update)
+	if err != nil {
+		return nil, err
+	}
+	if s.timedOut("status:" + eventID) {
+		return nil, context.DeadlineExceeded
+	}
+	return status, nil
+}
+
+func TestBufferedStoreRetriedWritesApplyOnce(t *testing.T) {
+	store := &committingStore{MemoryStorage: NewMemoryStorage(), attempts: make(map[string]int)}
+	buffered := newTestBufferedStore(t, store, 10)
+	ctx := t.Context()
+
+	if err := buffered.SaveAuditLog(ctx, &AuditLogEntry{Timestamp: time.Now(), EventID: "event-1", Action: "event_created"}); err != nil {
+		t.Fatal(err)
+	}
+
+	writeID := "write-1"
+	err := buffered.WriteBehind(ctx, "test", func(ctx context.Context) error {
+		_, err := buffered.UpdateEventStatus(ctx, "event-1", IdempotentUpdate(writeID, func(status *model.EventStatus) (*model.EventStatus, error) {
+			if status == nil {
+				status = model.NewEventStatus("event-1", "agent-1")
+			}
+			status.AddLog(model.LogLevelInfo, model.PhaseApplying, "applied", nil)
+			return status, nil
+		}))
+		if errors.Is(err, ErrAlreadyApplied) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The status write is buffered behind the audit entry, and times out once more on replay
+	if buffered.flush() {
+		t.Fatal("flush succeeded although the status write timed out")
+	}
+	if !buffered.flush() {
+		t.Fatal("flush failed with the backend up")
+	}
+
+	page, err := buffered.QueryAuditLog(ctx, AuditQuery{Limit: MaxAuditPageSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 {
+		t.Fatalf("%d audit entries after a retried write, want 1", len(page.Entries))
+	}
+	status, err := buffered.GetEventStatus(ctx, "event-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.ExecutionLog) != 1 {
+		t.Fatalf("%d log entries after a retried write, want 1", len(status.ExecutionLog))
+	}
+	timeline, err := buffered.GetEventTimeline(ctx, "event-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timeline) != 1 {
+		t.Fatalf("%d timeline entries after a retried write, want 1", len(timeline))
+	}
+}
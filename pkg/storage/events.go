@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+)
+
+// EventSpec is a submitted event as persisted. Manifests are stored once per content
+// hash and shared between events, so repeated rollouts of the same manifests only
+// add the (small) event record.
+type EventSpec struct {
+	Event          *model.Event `json:"event"`
+	ContentHash    string       `json:"content_hash"`              // Hash of the event's type, payload and impersonation
+	ManifestHashes []string     `json:"manifest_hashes,omitempty"` // Content addresses of the manifests, in order
+	StoredAt       time.Time    `json:"stored_at"`
+}
+
+// manifestHash returns the content address of a manifest
+func manifestHash(manifest string) string {
+	sum := sha256.Sum256([]byte(manifest))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// newEventSpec prepares an event for storage. The returned spec's event has its
+// manifests replaced by ManifestHashes; blobs maps each hash to its manifest.
+func newEventSpec(event *model.Event) (*EventSpec, map[string]string) {
+	stored := *event
+	stored.Payload.Manifests = nil
+
+	spec := &EventSpec{
+		Event:       &stored,
+		ContentHash: event.ContentHash(),
+		StoredAt:    time.Now(),
+	}
+
+	blobs := make(map[string]string, len(event.Payload.Manifests))
+	for _, manifest := range event.Payload.Manifests {
+		hash := manifestHash(manifest)
+		spec.ManifestHashes = append(spec.ManifestHashes, hash)
+		blobs[hash] = manifest
+	}
+	return spec, blobs
+}
+
+// restoreManifests puts the manifests back into a stored spec's event
+func (s *EventSpec) restoreManifests(lookup func(hash string) (string, bool)) error {
+	if len(s.ManifestHashes) == 0 {
+		return nil
+	}
+
+	manifests := make([]string, 0, len(s.ManifestHashes))
+	for _, hash := range s.ManifestHashes {
+		manifest, ok := lookup(hash)
+		if !ok {
+			return fmt.Errorf("manifest %s of event %s is missing", hash, s.Event.ID)
+		}
+		manifests = append(manifests, manifest)
+	}
+	s.Event.Payload.Manifests = manifests
+	return nil
+}
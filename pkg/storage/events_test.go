@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+)
+
+func TestNewEventSpec(t *testing.T) {
+	namespace := "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: team-a"
+	configMap := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: settings"
+	event := model.NewEvent(model.EventTypeK8sResource, "agent-1", model.EventPayload{
+		Manifests: []string{namespace, configMap, namespace},
+	}, "alice")
+
+	spec, blobs := newEventSpec(event)
+	if spec.Event.Payload.Manifests != nil || len(event.Payload.Manifests) != 3 {
+		t.Fatal("the stored event should drop its manifests without changing the submitted one")
+	}
+	if len(spec.ManifestHashes) != 3 || len(blobs) != 2 || spec.ManifestHashes[0] != spec.ManifestHashes[2] {
+		t.Fatalf("%d hashes and %d blobs, want 3 hashes of 2 distinct manifests", len(spec.ManifestHashes), len(blobs))
+	}
+	if spec.ContentHash != event.ContentHash() || !strings.HasPrefix(spec.ManifestHashes[0], "sha256:") {
+		t.Fatalf("content hash %s, manifest hash %s", spec.ContentHash, spec.ManifestHashes[0])
+	}
+
+	lookup := func(hash string) (string, bool) {
+		manifest, ok := blobs[hash]
+		return manifest, ok
+	}
+	if err := spec.restoreManifests(lookup); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(spec.Event.Payload.Manifests, event.Payload.Manifests) {
+		t.Fatalf("restored manifests %q, want the submitted ones in order", spec.Event.Payload.Manifests)
+	}
+
+	delete(blobs, manifestHash(configMap))
+	spec, _ = newEventSpec(event)
+	if err := spec.restoreManifests(lookup); err == nil || !strings.Contains(err.Error(), "is missing") {
+		t.Fatalf("restoreManifests() with a missing manifest error = %v", err)
+	}
+}
+
+func TestSaveEventSharesManifests(t *testing.T) {
+	store := NewMemoryStorage()
+	shared := "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: shared"
+
+	// Rolling the same manifests out again only adds the event record
+	for _, agentID := range []string{"agent-1", "agent-2", "agent-3"} {
+		event := model.NewEvent(model.EventTypeK8sResource, agentID, model.EventPayload{Manifests: []string{shared}}, "alice")
+		if _, err := store.SaveEvent(t.Context(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(store.events) != 3 || len(store.manifests) != 1 {
+		t.Fatalf("%d events sharing %d manifests, want 3 sharing 1", len(store.events), len(store.manifests))
+	}
+}
@@ -0,0 +1,457 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+)
+
+// memoryAuditLimit caps the audit entries kept in memory
+const memoryAuditLimit = 10000
+
+// MemoryStorage implements Store in process memory. Nothing survives a restart, so it
+// is meant for tests and local development.
+type MemoryStorage struct {
+	statuses    map[string][]byte               // eventID -> status JSON
+	timelines   map[string][][]byte             // eventID -> status change JSON, oldest first
+	agentEvents map[string]map[string]time.Time // agentID -> eventID -> last saved
+	events      map[string][]byte               // eventID -> spec JSON
+	manifests   map[string]string               // content hash -> manifest
+	agents      map[string][]byte               // agentID -> agent JSON
+	revoked     map[string]time.Time
+	audit       [][]byte                    // Oldest first
+	auditSeq    uint64                      // Sequence number of the newest audit entry
+	counters    map[string]map[string]int64 // Statistics counter -> field -> count
+	expiry      map[string]time.Time        // Statistics counter -> expiry, for counters with a TTL
+	mu          sync.RWMutex
+}
+
+var _ Store = (*MemoryStorage)(nil)
+
+// NewMemoryStorage creates an empty in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		statuses:    make(map[string][]byte),
+		timelines:   make(map[string][][]byte),
+		agentEvents: make(map[string]map[string]time.Time),
+		events:      make(map[string][]byte),
+		manifests:   make(map[string]string),
+		agents:      make(map[string][]byte),
+		revoked:     make(map[string]time.Time),
+		counters:    make(map[string]map[string]int64),
+		expiry:      make(map[string]time.Time),
+	}
+}
+
+// Close is a no-op for in-memory storage
+func (ms *MemoryStorage) Close() error {
+	return nil
+}
+
+// Ping always succeeds
+func (ms *MemoryStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// SaveEventStatus saves event status in memory
+func (ms *MemoryStorage) SaveEventStatus(ctx context.Context, status *model.EventStatus) error {
+	_, err := ms.UpdateEventStatus(ctx, status.EventID, func(*model.EventStatus) (*model.EventStatus, error) {
+		return status, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save event status: %w", err)
+	}
+	return nil
+}
+
+// GetEventStatus retrieves event status from memory
+func (ms *MemoryStorage) GetEventStatus(ctx context.Context, eventID string) (*model.EventStatus, error) {
+	ms.mu.RLock()
+	data, ok := ms.statuses[eventID]
+	ms.mu.RUnlock()
+	if !ok {
+		return nil, model.ErrStatusNotFound
+	}
+
+	var status model.EventStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal event status: %w", err)
+	}
+	return &status, nil
+}
+
+// UpdateEventStatus atomically applies update to an event status
+func (ms *MemoryStorage) UpdateEventStatus(ctx context.Context, eventID string, update UpdateFunc) (*model.EventStatus, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	result, err := applyUpdate(eventID, ms.statuses[eventID], update)
+	if err != nil {
+		return nil, err
+	}
+	ms.putEventStatus(result)
+	return result.status, nil
+}
+
+// putEventStatus stores an encoded status, appends the change to the event's
+// timeline, keeping the newest model.MaxTimelineEntries, and indexes the status under
+// its agent, moving it if it was reassigned. The caller holds ms.mu.
+func (ms *MemoryStorage) putEventStatus(update *statusUpdate) {
+	status := update.status
+	ms.statuses[status.EventID] = update.statusData
+	timeline := append(ms.timelines[status.EventID], update.changeData)
+	if excess := len(timeline) - model.MaxTimelineEntries; excess > 0 {
+		timeline = timeline[excess:]
+	}
+	ms.timelines[status.EventID] = timeline
+	if previous := update.previous; previous != nil && previous.AgentID != status.AgentID {
+		delete(ms.agentEvents[previous.AgentID], status.EventID)
+	}
+	events, ok := ms.agentEvents[status.AgentID]
+	if !ok {
+		events = make(map[string]time.Time)
+		ms.agentEvents[status.AgentID] = events
+	}
+	events[status.EventID] = time.Now()
+}
+
+// ListEventsByAgent lists the most recent events for a specific agent
+func (ms *MemoryStorage) ListEventsByAgent(ctx context.Context, agentID string, limit int) ([]string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return recentEvents(ms.agentEvents[agentID], limit), nil
+}
+
+// ListEventsByState lists all events currently in a specific state
+func (ms *MemoryStorage) ListEventsByState(ctx context.Context, state model.ExecutionState) ([]string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	eventIDs := make([]string, 0)
+	for eventID, data := range ms.statuses {
+		var status struct {
+			State model.ExecutionState `json:"state"`
+		}
+		if err := json.Unmarshal(data, &status); err != nil {
+			continue
+		}
+		if status.State == state {
+			eventIDs = append(eventIDs, eventID)
+		}
+	}
+	sort.Strings(eventIDs)
+	return eventIDs, nil
+}
+
+// GetEventTimeline returns an event's timeline
+func (ms *MemoryStorage) GetEventTimeline(ctx context.Context, eventID string) ([]*model.StatusChange, error) {
+	ms.mu.RLock()
+	entries := ms.timelines[eventID]
+	ms.mu.RUnlock()
+	return decodeTimeline(entries)
+}
+
+// SaveEvent saves a submitted event, storing each manifest once by content hash
+func (ms *MemoryStorage) SaveEvent(ctx context.Context, event *model.Event) (*EventSpec, error) {
+	spec, blobs := newEventSpec(event)
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for hash, manifest := range blobs {
+		ms.manifests[hash] = manifest
+	}
+	ms.events[event.ID] = data
+	return spec, nil
+}
+
+// GetEvent retrieves a submitted event with its manifests
+func (ms *MemoryStorage) GetEvent(ctx context.Context, eventID string) (*EventSpec, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	data, ok := ms.events[eventID]
+	if !ok {
+		return nil, model.ErrEventNotFound
+	}
+
+	var spec EventSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal event: %w", err)
+	}
+	if err := spec.restoreManifests(func(hash string) (string, bool) {
+		manifest, ok := ms.manifests[hash]
+		return manifest, ok
+	}); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// SaveAgent saves agent state in memory
+func (ms *MemoryStorage) SaveAgent(ctx context.Context, agent *model.Agent) error {
+	data, err := json.Marshal(agent)
+	if err != nil {
+		return fmt.Errorf("failed to marshal agent: %w", err)
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.agents[agent.ID] = data
+	return nil
+}
+
+// GetAgent retrieves agent state from memory
+func (ms *MemoryStorage) GetAgent(ctx context.Context, agentID string) (*model.Agent, error) {
+	ms.mu.RLock()
+	data, ok := ms.agents[agentID]
+	ms.mu.RUnlock()
+	if !ok {
+		return nil, model.ErrAgentNotFound
+	}
+
+	var agent model.Agent
+	if err := json.Unmarshal(data, &agent); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal agent: %w", err)
+	}
+	return &agent, nil
+}
+
+// ListAllAgents lists all registered agents
+func (ms *MemoryStorage) ListAllAgents(ctx context.Context) ([]string, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	agentIDs := make([]string, 0, len(ms.agents))
+	for agentID := range ms.agents {
+		agentIDs = append(agentIDs, agentID)
+	}
+	sort.Strings(agentIDs)
+	return agentIDs, nil
+}
+
+// ListAgentsByCluster lists all agents in a specific cluster
+func (ms *MemoryStorage) ListAgentsByCluster(ctx context.Context, clusterName string) ([]string, error) {
+	agentIDs, _ := ms.ListAllAgents(ctx)
+	return slices.DeleteFunc(agentIDs, func(agentID string) bool {
+		agent, err := ms.GetAgent(ctx, agentID)
+		return err != nil || agent.ClusterName != clusterName
+	}), nil
+}
+
+// DeleteAgent removes an agent from memory
+func (ms *MemoryStorage) DeleteAgent(ctx context.Context, agentID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.agents[agentID]; !ok {
+		return model.ErrAgentNotFound
+	}
+	delete(ms.agents, agentID)
+	return nil
+}
+
+// RevokeAgent records that an agent was decommissioned. Revoked agents may not register.
+func (ms *MemoryStorage) RevokeAgent(ctx context.Context, agentID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.revoked[agentID] = time.Now()
+	return nil
+}
+
+// RestoreAgent lifts the revocation of a decommissioned agent
+func (ms *MemoryStorage) RestoreAgent(ctx context.Context, agentID string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	_, ok := ms.revoked[agentID]
+	delete(ms.revoked, agentID)
+	return ok, nil
+}
+
+// IsAgentRevoked reports whether an agent was decommissioned
+func (ms *MemoryStorage) IsAgentRevoked(ctx context.Context, agentID string) (bool, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	_, ok := ms.revoked[agentID]
+	return ok, nil
+}
+
+// SaveAuditLog saves an audit log entry chained to the newest entry, dropping the
+// oldest beyond memoryAuditLimit
+func (ms *MemoryStorage) SaveAuditLog(ctx context.Context, entry *AuditLogEntry) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	prevHash := ""
+	if len(ms.audit) > 0 {
+		var head AuditLogEntry
+		if json.Unmarshal(ms.audit[len(ms.audit)-1], &head) == nil {
+			if isRetriedAudit(&head, entry) {
+				return nil
+			}
+			prevHash = head.Hash
+		}
+	}
+	data, err := chainAuditEntry(entry, prevHash)
+	if err != nil {
+		return err
+	}
+
+	ms.audit = append(ms.audit, data)
+	ms.auditSeq++
+	if excess := len(ms.audit) - memoryAuditLimit; excess > 0 {
+		ms.audit = slices.Delete(ms.audit, 0, excess)
+	}
+	return nil
+}
+
+// GetRecentAuditLogs retrieves recent audit log entries, most recent first
+func (ms *MemoryStorage) GetRecentAuditLogs(ctx context.Context, count int) ([]*AuditLogEntry, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	entries := make([]*AuditLogEntry, 0, min(count, len(ms.audit)))
+	for i := len(ms.audit) - 1; i >= 0 && len(entries) < count; i-- {
+		entry, err := ms.auditEntry(i)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// QueryAuditLog pages through the audit log, oldest first. The cursor is the
+// sequence number of the last entry returned.
+func (ms *MemoryStorage) QueryAuditLog(ctx context.Context, query AuditQuery) (*AuditPage, error) {
+	query = query.withDefaults()
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	// ms.audit[i] has sequence number first+i
+	first := ms.auditSeq - uint64(len(ms.audit)) + 1
+	start := 0
+	if query.Cursor != "" {
+		after, err := strconv.ParseUint(query.Cursor, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidAuditCursor, query.Cursor)
+		}
+		if after >= first {
+			start = int(min(after-first+1, uint64(len(ms.audit))))
+		}
+	}
+
+	page := &AuditPage{Entries: make([]*AuditLogEntry, 0)}
+	for i := start; i < len(ms.audit); i++ {
+		entry, err := ms.auditEntry(i)
+		if err != nil {
+			continue
+		}
+		if !page.add(query, entry) {
+			break
+		}
+	}
+	return page, nil
+}
+
+// auditEntry decodes the i-th retained audit entry. The caller holds ms.mu.
+func (ms *MemoryStorage) auditEntry(i int) (*AuditLogEntry, error) {
+	var entry AuditLogEntry
+	if err := json.Unmarshal(ms.audit[i], &entry); err != nil {
+		return nil, err
+	}
+	entry.ID = strconv.FormatUint(ms.auditSeq-uint64(len(ms.audit)-1-i), 10)
+	return &entry, nil
+}
+
+// RecordTransition counts an event state transition in the statistics
+func (ms *MemoryStorage) RecordTransition(ctx context.Context, transition Transition) error {
+	return ms.incrementCounters(ctx, transitionCounters(transition))
+}
+
+// GetEventStats retrieves event statistics
+func (ms *MemoryStorage) GetEventStats(ctx context.Context) (*EventStats, error) {
+	return buildEventStats(ctx, ms, time.Now())
+}
+
+func (ms *MemoryStorage) incrementCounters(ctx context.Context, increments []counterIncrement) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	for _, inc := range increments {
+		fields, ok := ms.counters[inc.Key]
+		if !ok {
+			// Drop expired counters as new ones appear, so rate buckets do not pile up
+			for key, expires := range ms.expiry {
+				if now.After(expires) {
+					delete(ms.counters, key)
+					delete(ms.expiry, key)
+				}
+			}
+			fields = make(map[string]int64)
+			ms.counters[inc.Key] = fields
+		}
+		fields[inc.Field] += inc.Delta
+		if inc.TTL > 0 {
+			ms.expiry[inc.Key] = now.Add(inc.TTL)
+		}
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) readCounters(ctx context.Context, keys ...string) (map[string]map[string]int64, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	now := time.Now()
+	values := make(map[string]map[string]int64, len(keys))
+	for _, key := range keys {
+		fields, ok := ms.counters[key]
+		if !ok {
+			continue
+		}
+		if expires, ok := ms.expiry[key]; ok && now.After(expires) {
+			continue
+		}
+		values[key] = make(map[string]int64, len(fields))
+		for field, count := range fields {
+			values[key][field] = count
+		}
+	}
+	return values, nil
+}
+
+// recentEvents returns up to limit event IDs, most recently saved first
+func recentEvents(events map[string]time.Time, limit int) []string {
+	eventIDs := make([]string, 0, len(events))
+	for eventID := range events {
+		eventIDs = append(eventIDs, eventID)
+	}
+	sort.Slice(eventIDs, func(i, j int) bool {
+		ti, tj := events[eventIDs[i]], events[eventIDs[j]]
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return eventIDs[i] > eventIDs[j]
+	})
+	if limit > 0 && len(eventIDs) > limit {
+		eventIDs = eventIDs[:limit]
+	}
+	return eventIDs
+}
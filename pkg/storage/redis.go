@@ -3,92 +3,189 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/suyog1pathak/transporter/internal/model"
 )
 
+// maxUpdateRetries bounds optimistic concurrency retries of UpdateEventStatus
+const maxUpdateRetries = 10
+
+// compactBatch is how many keys or statuses the compactor reads per round trip
+const compactBatch = 500
+
 // RedisStorage implements persistent storage using Redis
 type RedisStorage struct {
-	client *redis.Client
-	ctx    context.Context
+	client    redis.UniversalClient
+	prefix    string        // Prepended to every key
+	spread    bool          // Keys are spread over cluster hash slots; see slotKey
+	timeout   time.Duration // Bounds each operation
+	retention Retention
+	auditMu   sync.Mutex // Serializes this process's audit appends, which chain to the stream head
 }
 
+var (
+	_ Store     = (*RedisStorage)(nil)
+	_ Compactor = (*RedisStorage)(nil)
+)
+
 // Config holds Redis configuration
 type Config struct {
-	Addr     string // Redis server address (host:port)
-	Password string // Redis password (empty for no password)
-	DB       int    // Redis database number (0-15)
+	Mode       string   // standalone (default), sentinel or cluster
+	Addr       string   // Redis server address (host:port), standalone mode
+	Addrs      []string // Sentinel addresses, or cluster seed nodes
+	MasterName string   // Master monitored by Sentinel
+	Username   string   // ACL username (empty for the default user)
+	Password   string   // Redis password (empty for no password)
+	DB         int      // Redis database number (0-15); cluster mode only has 0
+
+	SentinelUsername string // ACL username for Sentinel
+	SentinelPassword string // Password for Sentinel, if it differs from the Redis one
+
+	KeyPrefix string   // Prepended to every key; see DefaultClusterKeyPrefix
+	TLS       RedisTLS // TLS to Redis and Sentinel
+
+	// Connection pool and timeouts (zero keeps the client defaults)
+	PoolSize        int
+	MinIdleConns    int
+	PoolTimeout     time.Duration
+	DialTimeout     time.Duration
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	MaxRetries      int
+	ConnMaxIdleTime time.Duration
+
+	OperationTimeout time.Duration // Bounds each storage operation, retries included (default 5s)
+
+	Retention Retention // How long statuses, indexes and audit entries are kept
 }
 
+// DefaultOperationTimeout bounds a Redis storage operation unless configured
+const DefaultOperationTimeout = 5 * time.Second
+
 // NewRedisStorage creates a new Redis storage instance
 func NewRedisStorage(config Config) (*RedisStorage, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     config.Addr,
-		Password: config.Password,
-		DB:       config.DB,
-	})
+	prefix, err := config.keyPrefix()
+	if err != nil {
+		return nil, err
+	}
+	client, err := newRedisClient(config)
+	if err != nil {
+		return nil, err
+	}
 
-	ctx := context.Background()
+	timeout := config.OperationTimeout
+	if timeout <= 0 {
+		timeout = DefaultOperationTimeout
+	}
 
 	// Test connection
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
 	return &RedisStorage{
-		client: client,
-		ctx:    ctx,
+		client:    client,
+		prefix:    prefix,
+		spread:    config.spreadsKeys(),
+		timeout:   timeout,
+		retention: config.Retention.withDefaults(),
 	}, nil
 }
 
+// key builds a key name, with the configured prefix
+func (rs *RedisStorage) key(format string, args ...interface{}) string {
+	if len(args) == 0 {
+		return rs.prefix + format
+	}
+	return rs.prefix + fmt.Sprintf(format, args...)
+}
+
+// slotKey builds the name of a key that is written in transactions with the other
+// keys of its group. When keys are spread over a cluster the group is the key's hash
+// tag, so the group shares a slot.
+func (rs *RedisStorage) slotKey(group, format string, args ...interface{}) string {
+	if !rs.spread {
+		return rs.key(format, args...)
+	}
+	if len(args) > 0 {
+		format = fmt.Sprintf(format, args...)
+	}
+	return rs.prefix + "{" + group + "}:" + format
+}
+
+// eventKey builds the name of an event's status, timeline or spec key. Spread over a
+// cluster, the keys of an event share a hash tag on its ID.
+func (rs *RedisStorage) eventKey(kind, eventID string) string {
+	if !rs.spread {
+		return rs.key("event:%s:%s", kind, eventID)
+	}
+	return rs.key("event:%s:{%s}", kind, eventID)
+}
+
+// agentKey builds the name of an agent key or agent index
+func (rs *RedisStorage) agentKey(format string, args ...interface{}) string {
+	return rs.slotKey("agents", format, args...)
+}
+
+// counterKey builds the name of a statistics counter hash
+func (rs *RedisStorage) counterKey(name string) string {
+	return rs.slotKey("stats", "%s", name)
+}
+
+// withTimeout bounds an operation by the configured operation timeout
+func (rs *RedisStorage) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, rs.timeout)
+}
+
 // Close closes the Redis connection
 func (rs *RedisStorage) Close() error {
 	return rs.client.Close()
 }
 
-// Event Status Operations
-
-// SaveEventStatus saves event status to Redis
-func (rs *RedisStorage) SaveEventStatus(status *model.EventStatus) error {
-	key := fmt.Sprintf("event:status:%s", status.EventID)
+// Ping checks that Redis is reachable
+func (rs *RedisStorage) Ping(ctx context.Context) error {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
 
-	data, err := json.Marshal(status)
-	if err != nil {
-		return fmt.Errorf("failed to marshal event status: %w", err)
-	}
-
-	// Save with TTL of 7 days (configurable)
-	ttl := 7 * 24 * time.Hour
-	if err := rs.client.Set(rs.ctx, key, data, ttl).Err(); err != nil {
-		return fmt.Errorf("failed to save event status: %w", err)
+	if err := rs.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to ping Redis: %w", err)
 	}
+	return nil
+}
 
-	// Add to event list for the agent
-	agentEventsKey := fmt.Sprintf("agent:events:%s", status.AgentID)
-	if err := rs.client.ZAdd(rs.ctx, agentEventsKey, redis.Z{
-		Score:  float64(time.Now().Unix()),
-		Member: status.EventID,
-	}).Err(); err != nil {
-		return fmt.Errorf("failed to add event to agent list: %w", err)
-	}
+// Event Status Operations
 
-	// Add to events by state index
-	stateKey := fmt.Sprintf("events:state:%s", status.State)
-	if err := rs.client.SAdd(rs.ctx, stateKey, status.EventID).Err(); err != nil {
-		return fmt.Errorf("failed to add event to state index: %w", err)
+// SaveEventStatus saves event status to Redis, moving it between the state and
+// agent indexes in the same transaction unless they are in other cluster slots
+func (rs *RedisStorage) SaveEventStatus(ctx context.Context, status *model.EventStatus) error {
+	_, err := rs.UpdateEventStatus(ctx, status.EventID, func(*model.EventStatus) (*model.EventStatus, error) {
+		return status, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save event status: %w", err)
 	}
-
 	return nil
 }
 
 // GetEventStatus retrieves event status from Redis
-func (rs *RedisStorage) GetEventStatus(eventID string) (*model.EventStatus, error) {
-	key := fmt.Sprintf("event:status:%s", eventID)
+func (rs *RedisStorage) GetEventStatus(ctx context.Context, eventID string) (*model.EventStatus, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
 
-	data, err := rs.client.Get(rs.ctx, key).Bytes()
+	key := rs.eventKey("status", eventID)
+
+	data, err := rs.client.Get(ctx, key).Bytes()
 	if err == redis.Nil {
 		return nil, model.ErrStatusNotFound
 	}
@@ -104,12 +201,131 @@ func (rs *RedisStorage) GetEventStatus(eventID string) (*model.EventStatus, erro
 	return &status, nil
 }
 
+// UpdateEventStatus atomically applies update to an event status. The status key is
+// WATCHed and the write retried if another writer changed it in between. When keys
+// are spread over a cluster, the status is added to its new indexes before it is
+// saved and removed from the previous ones after.
+func (rs *RedisStorage) UpdateEventStatus(ctx context.Context, eventID string, update UpdateFunc) (*model.EventStatus, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	key := rs.eventKey("status", eventID)
+
+	var result *statusUpdate
+	txf := func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Bytes()
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("failed to get event status: %w", err)
+		}
+
+		result, err = applyUpdate(eventID, data, update)
+		if err != nil {
+			return err
+		}
+
+		if rs.spread {
+			// The indexes are in other slots. Index the event before saving it, so a
+			// status is never missing from its indexes if the save is interrupted.
+			if _, err := rs.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				rs.indexEventStatus(ctx, pipe, result)
+				return nil
+			}); err != nil {
+				return fmt.Errorf("failed to index event status: %w", err)
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			rs.writeEventStatus(ctx, pipe, result)
+			if !rs.spread {
+				rs.indexEventStatus(ctx, pipe, result)
+				rs.unindexPrevious(ctx, pipe, result)
+			}
+			return nil
+		})
+		return err
+	}
+
+	for range maxUpdateRetries {
+		err := rs.client.Watch(ctx, txf, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue // Changed concurrently; re-read and retry
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rs.spread {
+			rs.reindexEventStatus(ctx, result)
+		}
+		return result.status, nil
+	}
+	return nil, fmt.Errorf("failed to update event status %s: too much contention", eventID)
+}
+
+// writeEventStatus queues the commands that save a status and append the change to
+// the event's timeline stream. The timeline expires with the status and keeps about
+// model.MaxTimelineEntries changes.
+func (rs *RedisStorage) writeEventStatus(ctx context.Context, pipe redis.Pipeliner, update *statusUpdate) {
+	status := update.status
+	timelineKey := rs.eventKey("timeline", status.EventID)
+
+	pipe.Set(ctx, rs.eventKey("status", status.EventID), update.statusData, rs.retention.EventStatus)
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: timelineKey,
+		MaxLen: model.MaxTimelineEntries,
+		Approx: true, // Trimming whole stream nodes is cheaper; a few more entries may be kept
+		Values: map[string]interface{}{"data": update.changeData},
+	})
+	pipe.Expire(ctx, timelineKey, rs.retention.EventStatus)
+}
+
+// indexEventStatus queues the commands that add a status to its state set and agent
+// index, and trim old entries of the agent index to the retention. They may be
+// repeated.
+func (rs *RedisStorage) indexEventStatus(ctx context.Context, pipe redis.Pipeliner, update *statusUpdate) {
+	now := time.Now()
+	status := update.status
+	agentEventsKey := rs.key("agent:events:%s", status.AgentID)
+
+	pipe.SAdd(ctx, rs.key("events:state:%s", status.State), status.EventID)
+	pipe.ZAdd(ctx, agentEventsKey, redis.Z{
+		Score:  float64(now.Unix()),
+		Member: status.EventID,
+	})
+	pipe.ZRemRangeByScore(ctx, agentEventsKey, "-inf", fmt.Sprintf("(%d", now.Add(-rs.retention.EventStatus).Unix()))
+}
+
+// unindexPrevious queues the commands that move a status out of its previous state
+// set, and agent index if it was reassigned
+func (rs *RedisStorage) unindexPrevious(ctx context.Context, pipe redis.Pipeliner, update *statusUpdate) {
+	previous, status := update.previous, update.status
+	if previous != nil && previous.State != status.State {
+		pipe.SRem(ctx, rs.key("events:state:%s", previous.State), status.EventID)
+	}
+	if previous != nil && previous.AgentID != status.AgentID {
+		pipe.ZRem(ctx, rs.key("agent:events:%s", previous.AgentID), status.EventID)
+	}
+}
+
+// reindexEventStatus moves a saved status out of its previous indexes when they are
+// in other slots. The status is indexed again in case the compactor pruned it while
+// it was being saved. Failures leave dangling entries, which the compactor prunes.
+func (rs *RedisStorage) reindexEventStatus(ctx context.Context, update *statusUpdate) {
+	_, _ = rs.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		rs.indexEventStatus(ctx, pipe, update)
+		rs.unindexPrevious(ctx, pipe, update)
+		return nil
+	})
+}
+
 // ListEventsByAgent lists all events for a specific agent
-func (rs *RedisStorage) ListEventsByAgent(agentID string, limit int) ([]string, error) {
-	key := fmt.Sprintf("agent:events:%s", agentID)
+func (rs *RedisStorage) ListEventsByAgent(ctx context.Context, agentID string, limit int) ([]string, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	key := rs.key("agent:events:%s", agentID)
 
 	// Get most recent events (sorted by timestamp, descending)
-	eventIDs, err := rs.client.ZRevRange(rs.ctx, key, 0, int64(limit-1)).Result()
+	eventIDs, err := rs.client.ZRevRange(ctx, key, 0, int64(limit-1)).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list events for agent: %w", err)
 	}
@@ -118,10 +334,13 @@ func (rs *RedisStorage) ListEventsByAgent(agentID string, limit int) ([]string,
 }
 
 // ListEventsByState lists all events in a specific state
-func (rs *RedisStorage) ListEventsByState(state model.ExecutionState) ([]string, error) {
-	key := fmt.Sprintf("events:state:%s", state)
+func (rs *RedisStorage) ListEventsByState(ctx context.Context, state model.ExecutionState) ([]string, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	key := rs.key("events:state:%s", state)
 
-	eventIDs, err := rs.client.SMembers(rs.ctx, key).Result()
+	eventIDs, err := rs.client.SMembers(ctx, key).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list events by state: %w", err)
 	}
@@ -129,40 +348,173 @@ func (rs *RedisStorage) ListEventsByState(state model.ExecutionState) ([]string,
 	return eventIDs, nil
 }
 
+// GetEventTimeline reads an event's timeline stream
+func (rs *RedisStorage) GetEventTimeline(ctx context.Context, eventID string) ([]*model.StatusChange, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	// The stream is trimmed approximately; read only the newest entries it should keep
+	messages, err := rs.client.XRevRangeN(ctx, rs.eventKey("timeline", eventID), "+", "-", model.MaxTimelineEntries).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event timeline: %w", err)
+	}
+
+	entries := make([][]byte, 0, len(messages))
+	for _, msg := range slices.Backward(messages) {
+		if data, ok := msg.Values["data"].(string); ok {
+			entries = append(entries, []byte(data))
+		}
+	}
+	return decodeTimeline(entries)
+}
+
+// Event Spec Operations
+
+// SaveEvent saves a submitted event. Each manifest is stored once under its content
+// hash; the TTL of manifests already stored is extended so they outlive every event
+// referencing them, and only missing manifests are uploaded.
+func (rs *RedisStorage) SaveEvent(ctx context.Context, event *model.Event) (*EventSpec, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	spec, blobs := newEventSpec(event)
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+	ttl := rs.retention.EventStatus
+
+	hashes := make([]string, 0, len(blobs))
+	for hash := range blobs {
+		hashes = append(hashes, hash)
+	}
+
+	pipe := rs.client.Pipeline()
+	extended := make([]*redis.BoolCmd, len(hashes))
+	for i, hash := range hashes {
+		extended[i] = pipe.Expire(ctx, rs.key("manifest:%s", hash), ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to refresh manifests: %w", err)
+	}
+
+	saveManifests := func(pipe redis.Pipeliner) error {
+		for i, hash := range hashes {
+			if !extended[i].Val() {
+				pipe.Set(ctx, rs.key("manifest:%s", hash), blobs[hash], ttl)
+			}
+		}
+		return nil
+	}
+	if rs.spread {
+		// Manifests are in other slots; upload them before the spec referencing them
+		if _, err := rs.client.Pipelined(ctx, saveManifests); err != nil {
+			return nil, fmt.Errorf("failed to save event manifests: %w", err)
+		}
+	}
+	_, err = rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if !rs.spread {
+			saveManifests(pipe)
+		}
+		pipe.Set(ctx, rs.eventKey("spec", event.ID), data, ttl)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save event: %w", err)
+	}
+
+	return spec, nil
+}
+
+// GetEvent retrieves a submitted event with its manifests
+func (rs *RedisStorage) GetEvent(ctx context.Context, eventID string) (*EventSpec, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	data, err := rs.client.Get(ctx, rs.eventKey("spec", eventID)).Bytes()
+	if err == redis.Nil {
+		return nil, model.ErrEventNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event: %w", err)
+	}
+
+	var spec EventSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal event: %w", err)
+	}
+	if len(spec.ManifestHashes) == 0 {
+		return &spec, nil
+	}
+
+	// Pipelined rather than MGET, which cannot read keys in several cluster slots
+	pipe := rs.client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(spec.ManifestHashes))
+	for i, hash := range spec.ManifestHashes {
+		cmds[i] = pipe.Get(ctx, rs.key("manifest:%s", hash))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get event manifests: %w", err)
+	}
+
+	manifests := make(map[string]string, len(cmds))
+	for i, cmd := range cmds {
+		if manifest, err := cmd.Result(); err == nil {
+			manifests[spec.ManifestHashes[i]] = manifest
+		}
+	}
+	if err := spec.restoreManifests(func(hash string) (string, bool) {
+		manifest, ok := manifests[hash]
+		return manifest, ok
+	}); err != nil {
+		return nil, err
+	}
+
+	return &spec, nil
+}
+
 // Agent State Operations
 
-// SaveAgent saves agent state to Redis
-func (rs *RedisStorage) SaveAgent(agent *model.Agent) error {
-	key := fmt.Sprintf("agent:%s", agent.ID)
+// SaveAgent saves agent state to Redis. An agent that moved to another cluster is
+// moved between the cluster indexes in the same transaction.
+func (rs *RedisStorage) SaveAgent(ctx context.Context, agent *model.Agent) error {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
 
 	data, err := json.Marshal(agent)
 	if err != nil {
 		return fmt.Errorf("failed to marshal agent: %w", err)
 	}
 
-	if err := rs.client.Set(rs.ctx, key, data, 0).Err(); err != nil {
-		return fmt.Errorf("failed to save agent: %w", err)
-	}
-
-	// Add to agent list
-	if err := rs.client.SAdd(rs.ctx, "agents:all", agent.ID).Err(); err != nil {
-		return fmt.Errorf("failed to add agent to list: %w", err)
+	previous, err := rs.GetAgent(ctx, agent.ID)
+	if err != nil && err != model.ErrAgentNotFound {
+		return err
 	}
 
-	// Index by cluster
-	clusterKey := fmt.Sprintf("agents:cluster:%s", agent.ClusterName)
-	if err := rs.client.SAdd(rs.ctx, clusterKey, agent.ID).Err(); err != nil {
-		return fmt.Errorf("failed to add agent to cluster index: %w", err)
+	_, err = rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, rs.agentKey("agent:%s", agent.ID), data, 0)
+		pipe.SAdd(ctx, rs.agentKey("agents:all"), agent.ID)
+		if previous != nil && previous.ClusterName != agent.ClusterName {
+			pipe.SRem(ctx, rs.agentKey("agents:cluster:%s", previous.ClusterName), agent.ID)
+		}
+		pipe.SAdd(ctx, rs.agentKey("agents:cluster:%s", agent.ClusterName), agent.ID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save agent: %w", err)
 	}
 
 	return nil
 }
 
 // GetAgent retrieves agent state from Redis
-func (rs *RedisStorage) GetAgent(agentID string) (*model.Agent, error) {
-	key := fmt.Sprintf("agent:%s", agentID)
+func (rs *RedisStorage) GetAgent(ctx context.Context, agentID string) (*model.Agent, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	key := rs.agentKey("agent:%s", agentID)
 
-	data, err := rs.client.Get(rs.ctx, key).Bytes()
+	data, err := rs.client.Get(ctx, key).Bytes()
 	if err == redis.Nil {
 		return nil, model.ErrAgentNotFound
 	}
@@ -179,8 +531,11 @@ func (rs *RedisStorage) GetAgent(agentID string) (*model.Agent, error) {
 }
 
 // ListAllAgents lists all registered agents
-func (rs *RedisStorage) ListAllAgents() ([]string, error) {
-	agentIDs, err := rs.client.SMembers(rs.ctx, "agents:all").Result()
+func (rs *RedisStorage) ListAllAgents(ctx context.Context) ([]string, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	agentIDs, err := rs.client.SMembers(ctx, rs.agentKey("agents:all")).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list agents: %w", err)
 	}
@@ -189,10 +544,13 @@ func (rs *RedisStorage) ListAllAgents() ([]string, error) {
 }
 
 // ListAgentsByCluster lists all agents in a specific cluster
-func (rs *RedisStorage) ListAgentsByCluster(clusterName string) ([]string, error) {
-	key := fmt.Sprintf("agents:cluster:%s", clusterName)
+func (rs *RedisStorage) ListAgentsByCluster(ctx context.Context, clusterName string) ([]string, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	key := rs.agentKey("agents:cluster:%s", clusterName)
 
-	agentIDs, err := rs.client.SMembers(rs.ctx, key).Result()
+	agentIDs, err := rs.client.SMembers(ctx, key).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list agents by cluster: %w", err)
 	}
@@ -201,69 +559,131 @@ func (rs *RedisStorage) ListAgentsByCluster(clusterName string) ([]string, error
 }
 
 // DeleteAgent removes an agent from Redis
-func (rs *RedisStorage) DeleteAgent(agentID string) error {
+func (rs *RedisStorage) DeleteAgent(ctx context.Context, agentID string) error {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
 	// Get agent first to remove from indexes
-	agent, err := rs.GetAgent(agentID)
+	agent, err := rs.GetAgent(ctx, agentID)
 	if err != nil {
 		return err
 	}
 
-	// Delete agent data
-	key := fmt.Sprintf("agent:%s", agentID)
-	if err := rs.client.Del(rs.ctx, key).Err(); err != nil {
+	// Delete agent data and index entries together
+	_, err = rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, rs.agentKey("agent:%s", agentID))
+		pipe.SRem(ctx, rs.agentKey("agents:all"), agentID)
+		pipe.SRem(ctx, rs.agentKey("agents:cluster:%s", agent.ClusterName), agentID)
+		return nil
+	})
+	if err != nil {
 		return fmt.Errorf("failed to delete agent: %w", err)
 	}
 
-	// Remove from agent list
-	if err := rs.client.SRem(rs.ctx, "agents:all", agentID).Err(); err != nil {
-		return fmt.Errorf("failed to remove agent from list: %w", err)
-	}
+	return nil
+}
 
-	// Remove from cluster index
-	clusterKey := fmt.Sprintf("agents:cluster:%s", agent.ClusterName)
-	if err := rs.client.SRem(rs.ctx, clusterKey, agentID).Err(); err != nil {
-		return fmt.Errorf("failed to remove agent from cluster index: %w", err)
-	}
+// RevokeAgent records that an agent was decommissioned. Revoked agents may not register.
+func (rs *RedisStorage) RevokeAgent(ctx context.Context, agentID string) error {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
 
+	if err := rs.client.HSet(ctx, rs.agentKey("agents:revoked"), agentID, time.Now().Format(time.RFC3339)).Err(); err != nil {
+		return fmt.Errorf("failed to revoke agent: %w", err)
+	}
 	return nil
 }
 
-// Audit Log Operations
+// RestoreAgent lifts the revocation of a decommissioned agent
+func (rs *RedisStorage) RestoreAgent(ctx context.Context, agentID string) (bool, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
 
-// AuditLogEntry represents an audit log entry
-type AuditLogEntry struct {
-	Timestamp time.Time              `json:"timestamp"`
-	EventID   string                 `json:"event_id"`
-	AgentID   string                 `json:"agent_id"`
-	Action    string                 `json:"action"` // event_created, event_routed, event_completed, etc.
-	User      string                 `json:"user,omitempty"`
-	Details   map[string]interface{} `json:"details,omitempty"`
+	removed, err := rs.client.HDel(ctx, rs.agentKey("agents:revoked"), agentID).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to restore agent: %w", err)
+	}
+	return removed > 0, nil
 }
 
-// SaveAuditLog saves an audit log entry
-func (rs *RedisStorage) SaveAuditLog(entry *AuditLogEntry) error {
-	data, err := json.Marshal(entry)
+// IsAgentRevoked reports whether an agent was decommissioned
+func (rs *RedisStorage) IsAgentRevoked(ctx context.Context, agentID string) (bool, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	revoked, err := rs.client.HExists(ctx, rs.agentKey("agents:revoked"), agentID).Result()
 	if err != nil {
-		return fmt.Errorf("failed to marshal audit log: %w", err)
+		return false, fmt.Errorf("failed to check agent revocation: %w", err)
 	}
+	return revoked, nil
+}
+
+// Audit Log Operations
+
+// SaveAuditLog appends an audit log entry, chained to the newest entry
+func (rs *RedisStorage) SaveAuditLog(ctx context.Context, entry *AuditLogEntry) error {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
 
-	// Add to audit log stream
-	if err := rs.client.XAdd(rs.ctx, &redis.XAddArgs{
-		Stream: "audit:log",
-		Values: map[string]interface{}{
-			"data": string(data),
-		},
-	}).Err(); err != nil {
-		return fmt.Errorf("failed to save audit log: %w", err)
+	txf := func(tx *redis.Tx) error {
+		prevHash := ""
+		last, err := tx.XRevRangeN(ctx, rs.key("audit:log"), "+", "-", 1).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read audit log head: %w", err)
+		}
+		if len(last) > 0 {
+			var head AuditLogEntry
+			if dataStr, ok := last[0].Values["data"].(string); ok && json.Unmarshal([]byte(dataStr), &head) == nil {
+				if isRetriedAudit(&head, entry) {
+					return nil
+				}
+				prevHash = head.Hash
+			}
+		}
+
+		data, err := chainAuditEntry(entry, prevHash)
+		if err != nil {
+			return err
+		}
+
+		// Add to audit log stream, trimming it to the retained length
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: rs.key("audit:log"),
+				MaxLen: rs.retention.AuditMaxLen,
+				Approx: true,
+				Values: map[string]interface{}{
+					"data": string(data),
+				},
+			})
+			return nil
+		})
+		return err
 	}
 
-	return nil
+	rs.auditMu.Lock()
+	defer rs.auditMu.Unlock()
+
+	for range maxUpdateRetries {
+		err := rs.client.Watch(ctx, txf, rs.key("audit:log"))
+		if errors.Is(err, redis.TxFailedErr) {
+			continue // Another entry was appended; chain to it instead
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save audit log: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("failed to save audit log: too much contention")
 }
 
 // GetRecentAuditLogs retrieves recent audit log entries
-func (rs *RedisStorage) GetRecentAuditLogs(count int) ([]*AuditLogEntry, error) {
+func (rs *RedisStorage) GetRecentAuditLogs(ctx context.Context, count int) ([]*AuditLogEntry, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
 	// Read from stream (most recent entries)
-	messages, err := rs.client.XRevRangeN(rs.ctx, "audit:log", "+", "-", int64(count)).Result()
+	messages, err := rs.client.XRevRangeN(ctx, rs.key("audit:log"), "+", "-", int64(count)).Result()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get audit logs: %w", err)
 	}
@@ -279,6 +699,7 @@ func (rs *RedisStorage) GetRecentAuditLogs(count int) ([]*AuditLogEntry, error)
 		if err := json.Unmarshal([]byte(dataStr), &entry); err != nil {
 			continue
 		}
+		entry.ID = msg.ID
 
 		entries = append(entries, &entry)
 	}
@@ -286,49 +707,395 @@ func (rs *RedisStorage) GetRecentAuditLogs(count int) ([]*AuditLogEntry, error)
 	return entries, nil
 }
 
-// Statistics Operations
+// auditClockSlack widens the stream ID range of an audit query, since entry
+// timestamps are taken by the control plane and stream IDs by Redis
+const auditClockSlack = time.Minute
 
-// IncrementEventCount increments the total event count
-func (rs *RedisStorage) IncrementEventCount() error {
-	return rs.client.Incr(rs.ctx, "stats:events:total").Err()
+// QueryAuditLog pages through the audit stream, oldest first. The cursor is the
+// stream ID of the last entry returned.
+func (rs *RedisStorage) QueryAuditLog(ctx context.Context, query AuditQuery) (*AuditPage, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	query = query.withDefaults()
+
+	start, end := "-", "+"
+	if !query.Since.IsZero() {
+		start = strconv.FormatInt(query.Since.Add(-auditClockSlack).UnixMilli(), 10)
+	}
+	if !query.Until.IsZero() {
+		end = strconv.FormatInt(query.Until.Add(auditClockSlack).UnixMilli(), 10)
+	}
+	if query.Cursor != "" {
+		ms, seq, ok := strings.Cut(query.Cursor, "-")
+		if _, err := strconv.ParseUint(ms, 10, 64); err != nil || !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidAuditCursor, query.Cursor)
+		}
+		if _, err := strconv.ParseUint(seq, 10, 64); err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidAuditCursor, query.Cursor)
+		}
+		start = "(" + query.Cursor
+	}
+
+	page := &AuditPage{Entries: make([]*AuditLogEntry, 0)}
+	for {
+		messages, err := rs.client.XRangeN(ctx, rs.key("audit:log"), start, end, compactBatch).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to query audit log: %w", err)
+		}
+
+		for _, msg := range messages {
+			dataStr, ok := msg.Values["data"].(string)
+			if !ok {
+				continue
+			}
+			var entry AuditLogEntry
+			if err := json.Unmarshal([]byte(dataStr), &entry); err != nil {
+				continue
+			}
+			entry.ID = msg.ID
+			if !page.add(query, &entry) {
+				return page, nil
+			}
+		}
+
+		if len(messages) < compactBatch {
+			return page, nil
+		}
+		start = "(" + messages[len(messages)-1].ID
+	}
 }
 
-// IncrementEventStateCount increments the count for a specific event state
-func (rs *RedisStorage) IncrementEventStateCount(state model.ExecutionState) error {
-	key := fmt.Sprintf("stats:events:state:%s", state)
-	return rs.client.Incr(rs.ctx, key).Err()
+// Statistics Operations
+
+// RecordTransition counts an event state transition in the statistics
+func (rs *RedisStorage) RecordTransition(ctx context.Context, transition Transition) error {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	return rs.incrementCounters(ctx, transitionCounters(transition))
 }
 
 // GetEventStats retrieves event statistics
-func (rs *RedisStorage) GetEventStats() (map[string]int64, error) {
-	stats := make(map[string]int64)
+func (rs *RedisStorage) GetEventStats(ctx context.Context) (*EventStats, error) {
+	ctx, cancel := rs.withTimeout(ctx)
+	defer cancel()
+
+	return buildEventStats(ctx, rs, time.Now())
+}
+
+func (rs *RedisStorage) incrementCounters(ctx context.Context, increments []counterIncrement) error {
+	_, err := rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, inc := range increments {
+			pipe.HIncrBy(ctx, rs.counterKey(inc.Key), inc.Field, inc.Delta)
+			if inc.TTL > 0 {
+				pipe.Expire(ctx, rs.counterKey(inc.Key), inc.TTL)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to increment stats counters: %w", err)
+	}
+	return nil
+}
 
-	// Get total events
-	total, err := rs.client.Get(rs.ctx, "stats:events:total").Int64()
-	if err != nil && err != redis.Nil {
+func (rs *RedisStorage) readCounters(ctx context.Context, keys ...string) (map[string]map[string]int64, error) {
+	pipe := rs.client.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.HGetAll(ctx, rs.counterKey(key))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
 		return nil, err
 	}
-	stats["total"] = total
 
-	// Get counts by state
-	states := []model.ExecutionState{
-		model.StateCreated,
-		model.StateQueued,
-		model.StateAssigned,
-		model.StateInProgress,
-		model.StateCompleted,
-		model.StateFailed,
-		model.StateExpired,
+	values := make(map[string]map[string]int64, len(keys))
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		if len(fields) == 0 {
+			continue
+		}
+		values[keys[i]] = make(map[string]int64, len(fields))
+		for field, value := range fields {
+			count, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			values[keys[i]][field] = count
+		}
 	}
+	return values, nil
+}
 
-	for _, state := range states {
-		key := fmt.Sprintf("stats:events:state:%s", state)
-		count, err := rs.client.Get(rs.ctx, key).Int64()
-		if err != nil && err != redis.Nil {
+// Compaction
+
+// Compact removes state and agent index entries whose status expired or moved to
+// another state or agent, and trims audit entries older than the audit retention.
+// Statuses and their timelines expire through their TTL.
+func (rs *RedisStorage) Compact(ctx context.Context) (*CompactResult, error) {
+	result := &CompactResult{}
+
+	stateKeys, err := rs.scanKeys(ctx, rs.key("events:state:*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range stateKeys {
+		index := statusIndex{key: key, field: "state", value: strings.TrimPrefix(key, rs.key("events:state:"))}
+		pruned, err := rs.pruneIndex(ctx, index)
+		if err != nil {
 			return nil, err
 		}
-		stats[string(state)] = count
+		result.StateEntries += pruned
 	}
 
-	return stats, nil
+	cutoff := time.Now().Add(-rs.retention.EventStatus)
+	agentKeys, err := rs.scanKeys(ctx, rs.key("agent:events:*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range agentKeys {
+		expired, err := rs.client.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprintf("(%d", cutoff.Unix())).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to trim agent index: %w", err)
+		}
+		index := statusIndex{key: key, sorted: true, field: "agent_id", value: strings.TrimPrefix(key, rs.key("agent:events:"))}
+		pruned, err := rs.pruneIndex(ctx, index)
+		if err != nil {
+			return nil, err
+		}
+		result.AgentEntries += int(expired) + pruned
+	}
+
+	if rs.retention.AuditMaxAge > 0 {
+		minID := fmt.Sprintf("%d-0", time.Now().Add(-rs.retention.AuditMaxAge).UnixMilli())
+		trimmed, err := rs.client.XTrimMinID(ctx, rs.key("audit:log"), minID).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to trim audit log: %w", err)
+		}
+		result.AuditEntries = trimmed
+	}
+
+	return result, nil
+}
+
+// statusIndex is a state set or agent index, listing the events whose status has
+// value in field
+type statusIndex struct {
+	key    string
+	sorted bool   // An agent index, a sorted set scored by save time
+	field  string // JSON field of the status: state or agent_id
+	value  string
+}
+
+// matches reports whether a status belongs in the index
+func (index statusIndex) matches(status *model.EventStatus) bool {
+	if index.field == "agent_id" {
+		return status.AgentID == index.value
+	}
+	return string(status.State) == index.value
+}
+
+// pruneIndexScript removes the members of an index (KEYS[1]) whose status (KEYS[i],
+// for the member ARGV[i+2]) is gone or no longer has the value ARGV[3] in field
+// ARGV[2]. ARGV[1] is "zset" for a sorted set. Checking and removing in one script
+// keeps an event a writer just moved into the index. Statuses that cannot be
+// decoded are kept.
+var pruneIndexScript = redis.NewScript(`
+local removed = 0
+for i = 2, #KEYS do
+	local member = ARGV[i + 2]
+	local data = redis.call('GET', KEYS[i])
+	local keep = false
+	if data then
+		local ok, status = pcall(cjson.decode, data)
+		keep = not ok or status[ARGV[2]] == ARGV[3]
+	end
+	if not keep then
+		if ARGV[1] == 'zset' then
+			removed = removed + redis.call('ZREM', KEYS[1], member)
+		else
+			removed = removed + redis.call('SREM', KEYS[1], member)
+		end
+	end
+end
+return removed
+`)
+
+// pruneIndex removes the index entries whose status is gone or does not match the
+// index, and returns how many it removed. Entries found stale are re-checked
+// atomically with their removal, so an event a writer moves in meanwhile is kept.
+func (rs *RedisStorage) pruneIndex(ctx context.Context, index statusIndex) (int, error) {
+	members, err := rs.indexMembers(ctx, index.key, index.sorted)
+	if err != nil {
+		return 0, err
+	}
+	candidates, err := rs.staleMembers(ctx, members, index.matches)
+	if err != nil {
+		return 0, err
+	}
+	return rs.removeStale(ctx, index, candidates)
+}
+
+// removeStale removes the candidates from the index that are still stale, and returns
+// how many it removed
+func (rs *RedisStorage) removeStale(ctx context.Context, index statusIndex, candidates []string) (int, error) {
+	if rs.spread {
+		return rs.pruneSpreadIndex(ctx, index, candidates)
+	}
+
+	kind := "set"
+	if index.sorted {
+		kind = "zset"
+	}
+	removed := 0
+	for start := 0; start < len(candidates); start += compactBatch {
+		batch := candidates[start:min(start+compactBatch, len(candidates))]
+		keys := make([]string, 0, len(batch)+1)
+		args := make([]interface{}, 0, len(batch)+3)
+		keys = append(keys, index.key)
+		args = append(args, kind, index.field, index.value)
+		for _, eventID := range batch {
+			keys = append(keys, rs.eventKey("status", eventID))
+			args = append(args, eventID)
+		}
+		n, err := pruneIndexScript.Run(ctx, rs.client, keys, args...).Int()
+		if err != nil {
+			return removed, fmt.Errorf("failed to prune index %s: %w", index.key, err)
+		}
+		removed += n
+	}
+	return removed, nil
+}
+
+// pruneSpreadIndex removes stale entries from an index in another cluster slot than
+// the statuses, where they cannot be checked atomically. Entries are removed and then
+// restored if their status matches the index after all; writers also index a status
+// again after saving it, which covers a save that lands after the re-check.
+func (rs *RedisStorage) pruneSpreadIndex(ctx context.Context, index statusIndex, candidates []string) (int, error) {
+	if len(candidates) == 0 {
+		return 0, nil
+	}
+	members := make([]interface{}, len(candidates))
+	for i, eventID := range candidates {
+		members[i] = eventID
+	}
+	var err error
+	if index.sorted {
+		err = rs.client.ZRem(ctx, index.key, members...).Err()
+	} else {
+		err = rs.client.SRem(ctx, index.key, members...).Err()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune index %s: %w", index.key, err)
+	}
+
+	stale, err := rs.staleMembers(ctx, candidates, index.matches)
+	if err != nil {
+		return 0, err
+	}
+	restore := slices.DeleteFunc(slices.Clone(candidates), func(eventID string) bool {
+		return slices.Contains(stale, eventID)
+	})
+	if len(restore) == 0 {
+		return len(candidates), nil
+	}
+	_, err = rs.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, eventID := range restore {
+			if index.sorted {
+				pipe.ZAdd(ctx, index.key, redis.Z{Score: float64(time.Now().Unix()), Member: eventID})
+			} else {
+				pipe.SAdd(ctx, index.key, eventID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to restore index %s entries: %w", index.key, err)
+	}
+	return len(candidates) - len(restore), nil
+}
+
+// scanKeys returns the keys matching pattern without blocking Redis. A cluster is
+// scanned on every master.
+func (rs *RedisStorage) scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	var mu sync.Mutex
+	keys := make([]string, 0)
+	scan := func(ctx context.Context, client redis.Cmdable) error {
+		iter := client.Scan(ctx, 0, pattern, compactBatch).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			keys = append(keys, iter.Val())
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+
+	var err error
+	if cluster, ok := rs.client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scan(ctx, node)
+		})
+	} else {
+		err = scan(ctx, rs.client)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", pattern, err)
+	}
+	return keys, nil
+}
+
+// indexMembers returns the members of a set, or of a sorted set without scores
+func (rs *RedisStorage) indexMembers(ctx context.Context, key string, sorted bool) ([]string, error) {
+	var iter *redis.ScanIterator
+	if sorted {
+		iter = rs.client.ZScan(ctx, key, 0, "", compactBatch).Iterator()
+	} else {
+		iter = rs.client.SScan(ctx, key, 0, "", compactBatch).Iterator()
+	}
+
+	members := make([]string, 0)
+	for i := 0; iter.Next(ctx); i++ {
+		if sorted && i%2 == 1 {
+			continue // ZSCAN yields member, score pairs
+		}
+		members = append(members, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", key, err)
+	}
+	return members, nil
+}
+
+// staleMembers returns the event IDs whose status is gone or fails keep
+func (rs *RedisStorage) staleMembers(ctx context.Context, eventIDs []string, keep func(*model.EventStatus) bool) ([]string, error) {
+	stale := make([]string, 0)
+	for start := 0; start < len(eventIDs); start += compactBatch {
+		batch := eventIDs[start:min(start+compactBatch, len(eventIDs))]
+
+		pipe := rs.client.Pipeline()
+		cmds := make([]*redis.StringCmd, len(batch))
+		for i, eventID := range batch {
+			cmds[i] = pipe.Get(ctx, rs.eventKey("status", eventID))
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return nil, fmt.Errorf("failed to read event statuses: %w", err)
+		}
+
+		for i, cmd := range cmds {
+			data, err := cmd.Bytes()
+			if err == redis.Nil {
+				stale = append(stale, batch[i])
+				continue
+			}
+			var status model.EventStatus
+			if err != nil || json.Unmarshal(data, &status) != nil {
+				continue // Keep entries that cannot be checked
+			}
+			if !keep(&status) {
+				stale = append(stale, batch[i])
+			}
+		}
+	}
+	return stale, nil
 }
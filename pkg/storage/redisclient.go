@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis deployment modes
+const (
+	RedisModeStandalone = "standalone"
+	RedisModeSentinel   = "sentinel"
+	RedisModeCluster    = "cluster"
+)
+
+// DefaultClusterKeyPrefix namespaces the keys in cluster mode. Keys are spread over
+// the cluster: the keys of one event share a hash tag on the event ID, agents and
+// statistics each have their own, and the state and agent indexes are updated outside
+// the event transaction. A prefix with a {hash tag} pins every key to one slot instead.
+const DefaultClusterKeyPrefix = "transporter:"
+
+// RedisTLS configures TLS to Redis (and to Sentinel in sentinel mode)
+type RedisTLS struct {
+	Enabled            bool
+	CAFile             string // PEM CA bundle; the system roots are used when empty
+	CertFile           string // PEM client certificate, for mutual TLS
+	KeyFile            string // PEM client key, for mutual TLS
+	ServerName         string // Overrides the name verified in the server certificate
+	InsecureSkipVerify bool   // Skip server certificate verification (testing only)
+}
+
+// newRedisClient builds the client for the configured deployment mode
+func newRedisClient(config Config) (redis.UniversalClient, error) {
+	tlsConfig, err := config.TLS.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.Mode {
+	case RedisModeStandalone, "":
+		return redis.NewClient(&redis.Options{
+			Addr:            config.Addr,
+			Username:        config.Username,
+			Password:        config.Password,
+			DB:              config.DB,
+			TLSConfig:       tlsConfig,
+			PoolSize:        config.PoolSize,
+			MinIdleConns:    config.MinIdleConns,
+			PoolTimeout:     config.PoolTimeout,
+			DialTimeout:     config.DialTimeout,
+			ReadTimeout:     config.ReadTimeout,
+			WriteTimeout:    config.WriteTimeout,
+			MaxRetries:      config.MaxRetries,
+			ConnMaxIdleTime: config.ConnMaxIdleTime,
+		}), nil
+
+	case RedisModeSentinel:
+		if config.MasterName == "" || len(config.Addrs) == 0 {
+			return nil, fmt.Errorf("sentinel mode requires a master name and sentinel addresses")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       config.MasterName,
+			SentinelAddrs:    config.Addrs,
+			SentinelUsername: config.SentinelUsername,
+			SentinelPassword: config.SentinelPassword,
+			Username:         config.Username,
+			Password:         config.Password,
+			DB:               config.DB,
+			TLSConfig:        tlsConfig,
+			PoolSize:         config.PoolSize,
+			MinIdleConns:     config.MinIdleConns,
+			PoolTimeout:      config.PoolTimeout,
+			DialTimeout:      config.DialTimeout,
+			ReadTimeout:      config.ReadTimeout,
+			WriteTimeout:     config.WriteTimeout,
+			MaxRetries:       config.MaxRetries,
+			ConnMaxIdleTime:  config.ConnMaxIdleTime,
+		}), nil
+
+	case RedisModeCluster:
+		if len(config.Addrs) == 0 {
+			return nil, fmt.Errorf("cluster mode requires seed node addresses")
+		}
+		if config.DB != 0 {
+			return nil, fmt.Errorf("redis cluster only supports database 0")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:           config.Addrs,
+			Username:        config.Username,
+			Password:        config.Password,
+			TLSConfig:       tlsConfig,
+			PoolSize:        config.PoolSize,
+			MinIdleConns:    config.MinIdleConns,
+			PoolTimeout:     config.PoolTimeout,
+			DialTimeout:     config.DialTimeout,
+			ReadTimeout:     config.ReadTimeout,
+			WriteTimeout:    config.WriteTimeout,
+			MaxRetries:      config.MaxRetries,
+			ConnMaxIdleTime: config.ConnMaxIdleTime,
+		}), nil
+
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q (expected %s, %s or %s)",
+			config.Mode, RedisModeStandalone, RedisModeSentinel, RedisModeCluster)
+	}
+}
+
+// keyPrefix returns the prefix for all keys. The other modes keep the unprefixed key
+// names unless a prefix is set.
+func (config Config) keyPrefix() (string, error) {
+	if config.KeyPrefix == "" && config.Mode == RedisModeCluster {
+		return DefaultClusterKeyPrefix, nil
+	}
+	if config.Mode == RedisModeCluster && strings.ContainsAny(config.KeyPrefix, "{}") && !hasHashTag(config.KeyPrefix) {
+		// Cluster would hash the braces of the prefix rather than those of the key
+		return "", fmt.Errorf("redis key prefix %q has braces but no {hash tag}", config.KeyPrefix)
+	}
+	return config.KeyPrefix, nil
+}
+
+// spreadsKeys reports whether keys are spread over the hash slots of a cluster, so
+// that only keys sharing a hash tag can be written in one transaction
+func (config Config) spreadsKeys() bool {
+	return config.Mode == RedisModeCluster && !hasHashTag(config.KeyPrefix)
+}
+
+// hasHashTag reports whether a key prefix pins every key to one cluster hash slot
+func hasHashTag(prefix string) bool {
+	open := strings.Index(prefix, "{")
+	if open < 0 {
+		return false
+	}
+	close := strings.Index(prefix[open+1:], "}")
+	return close > 0 // An empty tag {} does not count
+}
+
+// clientConfig builds the TLS client configuration, or nil if TLS is disabled
+func (t RedisTLS) clientConfig() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in redis CA %s", t.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redis client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestNewRedisClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		want    string // Client type, empty when an error is expected
+		wantErr string
+	}{
+		{name: "standalone by default", config: Config{Addr: "localhost:6379"}, want: "*redis.Client"},
+		{
+			name:   "sentinel",
+			config: Config{Mode: RedisModeSentinel, MasterName: "mymaster", Addrs: []string{"sentinel-0:26379", "sentinel-1:26379"}},
+			want:   "*redis.Client",
+		},
+		{
+			name:    "sentinel without master name",
+			config:  Config{Mode: RedisModeSentinel, Addrs: []string{"sentinel-0:26379"}},
+			wantErr: "sentinel mode requires",
+		},
+		{
+			name:    "sentinel without addresses",
+			config:  Config{Mode: RedisModeSentinel, MasterName: "mymaster"},
+			wantErr: "sentinel mode requires",
+		},
+		{name: "cluster", config: Config{Mode: RedisModeCluster, Addrs: []string{"node-0:6379"}}, want: "*redis.ClusterClient"},
+		{name: "cluster without seed nodes", config: Config{Mode: RedisModeCluster}, wantErr: "requires seed node addresses"},
+		{
+			name:    "cluster with a database",
+			config:  Config{Mode: RedisModeCluster, Addrs: []string{"node-0:6379"}, DB: 1},
+			wantErr: "only supports database 0",
+		},
+		{name: "unknown mode", config: Config{Mode: "replicated"}, wantErr: `unknown redis mode "replicated"`},
+		{
+			name:    "unreadable CA",
+			config:  Config{Addr: "localhost:6379", TLS: RedisTLS{Enabled: true, CAFile: filepath.Join(t.TempDir(), "missing.pem")}},
+			wantErr: "failed to read redis CA",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := newRedisClient(tt.config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("newRedisClient() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newRedisClient() error = %v", err)
+			}
+			defer client.Close()
+
+			var got string
+			switch client.(type) {
+			case *redis.Client:
+				got = "*redis.Client"
+			case *redis.ClusterClient:
+				got = "*redis.ClusterClient"
+			}
+			if got != tt.want {
+				t.Fatalf("newRedisClient() = %T, want %s", client, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedisTLSClientConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir)
+	garbage := filepath.Join(dir, "garbage.pem")
+	if err := os.WriteFile(garbage, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if config, err := (RedisTLS{CAFile: certFile}).clientConfig(); err != nil || config != nil {
+		t.Fatalf("disabled TLS gave %v, %v; want no configuration", config, err)
+	}
+
+	config, err := RedisTLS{Enabled: true, CAFile: certFile, CertFile: certFile, KeyFile: keyFile, ServerName: "redis.internal"}.clientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.RootCAs == nil || len(config.Certificates) != 1 || config.ServerName != "redis.internal" || config.InsecureSkipVerify {
+		t.Fatalf("unexpected TLS configuration %+v", config)
+	}
+
+	config, err = RedisTLS{Enabled: true}.clientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.RootCAs != nil || len(config.Certificates) != 0 {
+		t.Fatal("TLS without files should use the system roots and no client certificate")
+	}
+
+	for name, tls := range map[string]RedisTLS{
+		"no certificates in CA":   {Enabled: true, CAFile: garbage},
+		"client cert without key": {Enabled: true, CertFile: certFile},
+		"unreadable client key":   {Enabled: true, CertFile: certFile, KeyFile: garbage},
+	} {
+		if _, err := tls.clientConfig(); err == nil {
+			t.Errorf("%s: clientConfig() succeeded", name)
+		}
+	}
+}
+
+func TestRedisKeyLayout(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     Config
+		wantSpread bool
+		wantKeys   map[string]string // Key name by what it is
+		wantErr    bool
+	}{
+		{
+			name:   "standalone",
+			config: Config{},
+			wantKeys: map[string]string{
+				"status":  "event:status:event-1",
+				"agent":   "agent:agent-1",
+				"counter": "stats:totals",
+			},
+		},
+		{
+			name:   "sentinel with prefix",
+			config: Config{Mode: RedisModeSentinel, KeyPrefix: "tp:"},
+			wantKeys: map[string]string{
+				"status":  "tp:event:status:event-1",
+				"agent":   "tp:agent:agent-1",
+				"counter": "tp:stats:totals",
+			},
+		},
+		{
+			name:       "cluster spreads keys by default",
+			config:     Config{Mode: RedisModeCluster},
+			wantSpread: true,
+			wantKeys: map[string]string{
+				"status":  "transporter:event:status:{event-1}",
+				"agent":   "transporter:{agents}:agent:agent-1",
+				"counter": "transporter:{stats}:stats:totals",
+			},
+		},
+		{
+			name:   "cluster prefix with hash tag pins one slot",
+			config: Config{Mode: RedisModeCluster, KeyPrefix: "{transporter}:"},
+			wantKeys: map[string]string{
+				"status":  "{transporter}:event:status:event-1",
+				"agent":   "{transporter}:agent:agent-1",
+				"counter": "{transporter}:stats:totals",
+			},
+		},
+		{name: "cluster prefix with empty hash tag", config: Config{Mode: RedisModeCluster, KeyPrefix: "{}:"}, wantErr: true},
+		{name: "cluster prefix with unclosed brace", config: Config{Mode: RedisModeCluster, KeyPrefix: "tp{"}, wantErr: true},
+		{
+			name:   "braces only matter in cluster mode",
+			config: Config{KeyPrefix: "{}:"},
+			wantKeys: map[string]string{
+				"status": "{}:event:status:event-1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, err := tt.config.keyPrefix()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("keyPrefix() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if spread := tt.config.spreadsKeys(); spread != tt.wantSpread {
+				t.Fatalf("spreadsKeys() = %v, want %v", spread, tt.wantSpread)
+			}
+			rs := &RedisStorage{prefix: prefix, spread: tt.config.spreadsKeys()}
+			got := map[string]string{
+				"status":  rs.eventKey("status", "event-1"),
+				"agent":   rs.agentKey("agent:%s", "agent-1"),
+				"counter": rs.counterKey("stats:totals"),
+			}
+			for kind, want := range tt.wantKeys {
+				if got[kind] != want {
+					t.Errorf("%s key = %q, want %q", kind, got[kind], want)
+				}
+			}
+
+			// Keys written in one transaction must share a slot
+			if tt.wantSpread {
+				status, timeline := rs.eventKey("status", "event-1"), rs.eventKey("timeline", "event-1")
+				if hashTag(status) != "event-1" || hashTag(timeline) != "event-1" {
+					t.Errorf("event keys %q and %q do not share the event hash tag", status, timeline)
+				}
+				if hashTag(rs.agentKey("agents:all")) != hashTag(rs.agentKey("agents:cluster:%s", "prod")) {
+					t.Error("agent indexes do not share a hash tag")
+				}
+			}
+		})
+	}
+}
+
+// hashTag returns the part of a key Redis Cluster hashes
+func hashTag(key string) string {
+	open := strings.Index(key, "{")
+	if open < 0 {
+		return key
+	}
+	close := strings.Index(key[open+1:], "}")
+	if close <= 0 {
+		return key
+	}
+	return key[open+1 : open+1+close]
+}
+
+// writeTestCertificate writes a self-signed certificate and its key as PEM files
+func writeTestCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redis.internal"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+)
+
+// Statistics are kept as counters: named hashes of field -> count. Backends only
+// increment and read counters; which counters a transition touches and how they
+// are reported is defined here, so every backend reports the same statistics.
+
+// Counter names
+const (
+	counterTotals    = "stats:events:totals"   // state -> count, plus "total" for created events
+	counterByAgent   = "stats:events:agent"    // agent|state -> count
+	counterByCluster = "stats:events:cluster"  // cluster|state -> count
+	counterByType    = "stats:events:type"     // type|state -> count
+	counterByCreator = "stats:events:creator"  // creator|state -> count
+	counterDurations = "stats:events:duration" // type|bucket, type|count, type|sum_ms -> value
+	counterMinute    = "stats:events:minute:"  // + unix minute start; state -> count
+	counterHour      = "stats:events:hour:"    // + unix hour start; state -> count
+)
+
+// Rate windows reported by GetEventStats, and how long their buckets are kept
+const (
+	rateMinutes     = 60
+	rateHours       = 24
+	minuteBucketTTL = 2 * time.Hour
+	hourBucketTTL   = 8 * 24 * time.Hour
+)
+
+// durationBuckets are the upper bounds of the execution duration histogram
+var durationBuckets = []struct {
+	label string
+	bound time.Duration
+}{
+	{"1s", time.Second},
+	{"5s", 5 * time.Second},
+	{"15s", 15 * time.Second},
+	{"30s", 30 * time.Second},
+	{"1m", time.Minute},
+	{"2m", 2 * time.Minute},
+	{"5m", 5 * time.Minute},
+	{"10m", 10 * time.Minute},
+	{"30m", 30 * time.Minute},
+	{"1h", time.Hour},
+	{"+Inf", 0},
+}
+
+// Transition is an applied change of an event's state, the unit event statistics
+// are derived from
+type Transition struct {
+	EventID   string
+	AgentID   string
+	Cluster   string
+	EventType model.EventType
+	CreatedBy string
+	From      model.ExecutionState // Empty when the event was just created
+	To        model.ExecutionState
+	At        time.Time
+	Duration  time.Duration // Execution time reported with a final state
+}
+
+// EventStats reports event statistics
+type EventStats struct {
+	Totals    map[string]int64              `json:"totals"`     // Events created ("total") and transitions into each state
+	ByAgent   map[string]map[string]int64   `json:"by_agent"`   // agent -> state -> transitions
+	ByCluster map[string]map[string]int64   `json:"by_cluster"` // cluster -> state -> transitions
+	ByType    map[string]map[string]int64   `json:"by_type"`    // event type -> state -> transitions
+	ByCreator map[string]map[string]int64   `json:"by_creator"` // creator -> state -> transitions
+	PerMinute []RateBucket                  `json:"per_minute"` // Last hour, oldest first
+	PerHour   []RateBucket                  `json:"per_hour"`   // Last day, oldest first
+	Durations map[string]*DurationHistogram `json:"durations"`  // Event type (or "all") -> execution durations
+}
+
+// RateBucket counts transitions into each state during one interval
+type RateBucket struct {
+	Start  time.Time        `json:"start"`
+	Counts map[string]int64 `json:"counts"`
+}
+
+// DurationHistogram is a cumulative histogram of execution durations
+type DurationHistogram struct {
+	Count      int64             `json:"count"`
+	SumSeconds float64           `json:"sum_seconds"`
+	Buckets    []HistogramBucket `json:"buckets"`
+}
+
+// HistogramBucket counts durations up to and including LE
+type HistogramBucket struct {
+	LE    string `json:"le"`
+	Count int64  `json:"count"`
+}
+
+// counterIncrement adds Delta to a counter field. Counters with a TTL expire that
+// long after their last increment.
+type counterIncrement struct {
+	Key   string
+	Field string
+	Delta int64
+	TTL   time.Duration
+}
+
+// counterStore is implemented by each backend to hold statistics counters
+type counterStore interface {
+	incrementCounters(ctx context.Context, increments []counterIncrement) error
+	readCounters(ctx context.Context, keys ...string) (map[string]map[string]int64, error)
+}
+
+// transitionCounters returns the counter increments recording a transition
+func transitionCounters(t Transition) []counterIncrement {
+	state := string(t.To)
+	increments := []counterIncrement{
+		{Key: counterTotals, Field: state, Delta: 1},
+		{Key: counterMinute + strconv.FormatInt(t.At.Truncate(time.Minute).Unix(), 10), Field: state, Delta: 1, TTL: minuteBucketTTL},
+		{Key: counterHour + strconv.FormatInt(t.At.Truncate(time.Hour).Unix(), 10), Field: state, Delta: 1, TTL: hourBucketTTL},
+	}
+	if t.From == "" && t.To == model.StateCreated {
+		increments = append(increments, counterIncrement{Key: counterTotals, Field: "total", Delta: 1})
+	}
+
+	for key, value := range map[string]string{
+		counterByAgent:   t.AgentID,
+		counterByCluster: t.Cluster,
+		counterByType:    string(t.EventType),
+		counterByCreator: t.CreatedBy,
+	} {
+		if value != "" {
+			increments = append(increments, counterIncrement{Key: key, Field: value + "|" + state, Delta: 1})
+		}
+	}
+
+	if t.Duration > 0 {
+		bucket := durationBuckets[len(durationBuckets)-1].label
+		for _, b := range durationBuckets[:len(durationBuckets)-1] {
+			if t.Duration <= b.bound {
+				bucket = b.label
+				break
+			}
+		}
+
+		types := []string{"all"}
+		if t.EventType != "" {
+			types = append(types, string(t.EventType))
+		}
+		for _, eventType := range types {
+			increments = append(increments,
+				counterIncrement{Key: counterDurations, Field: eventType + "|" + bucket, Delta: 1},
+				counterIncrement{Key: counterDurations, Field: eventType + "|count", Delta: 1},
+				counterIncrement{Key: counterDurations, Field: eventType + "|sum_ms", Delta: t.Duration.Milliseconds()},
+			)
+		}
+	}
+
+	return increments
+}
+
+// buildEventStats reads the counters into a statistics report
+func buildEventStats(ctx context.Context, counters counterStore, now time.Time) (*EventStats, error) {
+	minuteStart := now.Truncate(time.Minute).Add(-(rateMinutes - 1) * time.Minute)
+	hourStart := now.Truncate(time.Hour).Add(-(rateHours - 1) * time.Hour)
+
+	keys := []string{counterTotals, counterByAgent, counterByCluster, counterByType, counterByCreator, counterDurations}
+	for i := range rateMinutes {
+		keys = append(keys, counterMinute+strconv.FormatInt(minuteStart.Add(time.Duration(i)*time.Minute).Unix(), 10))
+	}
+	for i := range rateHours {
+		keys = append(keys, counterHour+strconv.FormatInt(hourStart.Add(time.Duration(i)*time.Hour).Unix(), 10))
+	}
+
+	values, err := counters.readCounters(ctx, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event stats: %w", err)
+	}
+
+	stats := &EventStats{
+		Totals:    map[string]int64{"total": values[counterTotals]["total"]},
+		ByAgent:   splitCounter(values[counterByAgent]),
+		ByCluster: splitCounter(values[counterByCluster]),
+		ByType:    splitCounter(values[counterByType]),
+		ByCreator: splitCounter(values[counterByCreator]),
+		PerMinute: make([]RateBucket, 0, rateMinutes),
+		PerHour:   make([]RateBucket, 0, rateHours),
+		Durations: make(map[string]*DurationHistogram),
+	}
+	for _, state := range statsStates {
+		stats.Totals[string(state)] = values[counterTotals][string(state)]
+	}
+
+	for i := range rateMinutes {
+		start := minuteStart.Add(time.Duration(i) * time.Minute)
+		stats.PerMinute = append(stats.PerMinute, RateBucket{
+			Start:  start,
+			Counts: nonNil(values[counterMinute+strconv.FormatInt(start.Unix(), 10)]),
+		})
+	}
+	for i := range rateHours {
+		start := hourStart.Add(time.Duration(i) * time.Hour)
+		stats.PerHour = append(stats.PerHour, RateBucket{
+			Start:  start,
+			Counts: nonNil(values[counterHour+strconv.FormatInt(start.Unix(), 10)]),
+		})
+	}
+
+	for eventType, fields := range splitCounter(values[counterDurations]) {
+		histogram := &DurationHistogram{
+			Count:      fields["count"],
+			SumSeconds: float64(fields["sum_ms"]) / 1000,
+			Buckets:    make([]HistogramBucket, 0, len(durationBuckets)),
+		}
+		cumulative := int64(0)
+		for _, b := range durationBuckets {
+			cumulative += fields[b.label]
+			histogram.Buckets = append(histogram.Buckets, HistogramBucket{LE: b.label, Count: cumulative})
+		}
+		stats.Durations[eventType] = histogram
+	}
+
+	return stats, nil
+}
+
+// splitCounter turns "name|field" counter fields into name -> field -> count
+func splitCounter(fields map[string]int64) map[string]map[string]int64 {
+	split := make(map[string]map[string]int64)
+	for field, count := range fields {
+		name, sub, ok := strings.Cut(field, "|")
+		if !ok {
+			continue
+		}
+		if split[name] == nil {
+			split[name] = make(map[string]int64)
+		}
+		split[name][sub] = count
+	}
+	return split
+}
+
+func nonNil(counts map[string]int64) map[string]int64 {
+	if counts == nil {
+		return map[string]int64{}
+	}
+	return counts
+}
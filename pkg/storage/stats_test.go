@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+)
+
+func TestTransitionCounters(t *testing.T) {
+	at := time.Date(2026, 3, 1, 10, 42, 17, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		transition Transition
+		want       map[string]int64 // key|field -> delta of the non-rate counters
+	}{
+		{
+			name:       "created",
+			transition: Transition{EventType: model.EventTypeK8sResource, CreatedBy: "alice", To: model.StateCreated, At: at},
+			want: map[string]int64{
+				counterTotals + "|created":              1,
+				counterTotals + "|total":                1,
+				counterByType + "|k8s_resource|created": 1,
+				counterByCreator + "|alice|created":     1,
+			},
+		},
+		{
+			name:       "assigned without a cluster",
+			transition: Transition{AgentID: "agent-1", From: model.StateCreated, To: model.StateAssigned, At: at},
+			want: map[string]int64{
+				counterTotals + "|assigned":          1,
+				counterByAgent + "|agent-1|assigned": 1,
+			},
+		},
+		{
+			name:       "duration on a bucket bound",
+			transition: Transition{EventType: model.EventTypeK8sResource, From: model.StateInProgress, To: model.StateCompleted, At: at, Duration: 5 * time.Second},
+			want: map[string]int64{
+				counterTotals + "|completed":              1,
+				counterByType + "|k8s_resource|completed": 1,
+				counterDurations + "|all|5s":              1,
+				counterDurations + "|all|count":           1,
+				counterDurations + "|all|sum_ms":          5000,
+				counterDurations + "|k8s_resource|5s":     1,
+				counterDurations + "|k8s_resource|count":  1,
+				counterDurations + "|k8s_resource|sum_ms": 5000,
+			},
+		},
+		{
+			name:       "duration past the last bound",
+			transition: Transition{From: model.StateInProgress, To: model.StateFailed, At: at, Duration: 2 * time.Hour},
+			want: map[string]int64{
+				counterTotals + "|failed":        1,
+				counterDurations + "|all|+Inf":   1,
+				counterDurations + "|all|count":  1,
+				counterDurations + "|all|sum_ms": (2 * time.Hour).Milliseconds(),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]int64)
+			rates := 0
+			for _, increment := range transitionCounters(tt.transition) {
+				switch increment.Key {
+				case counterMinute + "1772361720", counterHour + "1772359200":
+					if increment.TTL == 0 || increment.Field != string(tt.transition.To) {
+						t.Errorf("rate increment %+v", increment)
+					}
+					rates++
+				default:
+					got[increment.Key+"|"+increment.Field] += increment.Delta
+				}
+			}
+			if rates != 2 {
+				t.Errorf("%d rate increments, want one per minute and hour bucket", rates)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("counters %v, want %v", got, tt.want)
+			}
+			for key, delta := range tt.want {
+				if got[key] != delta {
+					t.Errorf("%s += %d, want %d", key, got[key], delta)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildEventStats(t *testing.T) {
+	ctx := t.Context()
+	store := NewMemoryStorage()
+	now := time.Date(2026, 3, 1, 10, 42, 17, 0, time.UTC)
+
+	for _, transition := range []Transition{
+		{To: model.StateCreated, At: now},
+		{From: model.StateInProgress, To: model.StateCompleted, At: now.Add(-time.Minute), Duration: 500 * time.Millisecond},
+		{From: model.StateInProgress, To: model.StateCompleted, At: now.Add(-2 * time.Hour), Duration: 40 * time.Second},
+		{From: model.StateInProgress, To: model.StateFailed, At: now.Add(-2 * 24 * time.Hour)}, // Outside both windows
+	} {
+		if err := store.incrementCounters(ctx, transitionCounters(transition)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := buildEventStats(ctx, store, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Totals["total"] != 1 || stats.Totals[string(model.StateCompleted)] != 2 || stats.Totals[string(model.StateFailed)] != 1 {
+		t.Fatalf("totals %v", stats.Totals)
+	}
+
+	if len(stats.PerMinute) != rateMinutes || len(stats.PerHour) != rateHours {
+		t.Fatalf("%d minute and %d hour buckets, want %d and %d", len(stats.PerMinute), len(stats.PerHour), rateMinutes, rateHours)
+	}
+	last := stats.PerMinute[rateMinutes-1]
+	if !last.Start.Equal(now.Truncate(time.Minute)) || last.Counts[string(model.StateCreated)] != 1 {
+		t.Fatalf("last minute bucket %+v, want the created event", last)
+	}
+	if stats.PerMinute[rateMinutes-2].Counts[string(model.StateCompleted)] != 1 {
+		t.Fatalf("previous minute bucket %+v, want one completion", stats.PerMinute[rateMinutes-2])
+	}
+	hourly := int64(0)
+	for _, bucket := range stats.PerHour {
+		hourly += bucket.Counts[string(model.StateCompleted)] + bucket.Counts[string(model.StateFailed)]
+	}
+	if hourly != 2 || stats.PerHour[rateHours-3].Counts[string(model.StateCompleted)] != 1 {
+		t.Fatalf("hourly buckets counted %d final states, want the 2 of the last day", hourly)
+	}
+
+	// Buckets are cumulative
+	histogram := stats.Durations["all"]
+	if histogram == nil || histogram.Count != 2 || histogram.SumSeconds != 40.5 {
+		t.Fatalf("histogram %+v, want 2 durations summing to 40.5s", histogram)
+	}
+	want := map[string]int64{"1s": 1, "30s": 1, "1m": 2, "+Inf": 2}
+	for _, bucket := range histogram.Buckets {
+		if count, ok := want[bucket.LE]; ok && bucket.Count != count {
+			t.Errorf("bucket le=%s counts %d, want %d", bucket.LE, bucket.Count, count)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+)
+
+// Storage backends selectable by the control plane
+const (
+	BackendRedis  = "redis"
+	BackendBolt   = "bolt"
+	BackendMemory = "memory"
+)
+
+// Store persists event status, agent state, the audit log and statistics. Each
+// status write appends the change it makes to the event's timeline, and the saved
+// status is materialized from that change in the same transaction. Every
+// operation takes a context bounding how long it may block; network backends also
+// apply their own per-operation timeout.
+type Store interface {
+	// Close releases the backend's resources
+	Close() error
+
+	// Ping checks that the backend is reachable
+	Ping(ctx context.Context) error
+
+	// Event status
+	SaveEventStatus(ctx context.Context, status *model.EventStatus) error
+	GetEventStatus(ctx context.Context, eventID string) (*model.EventStatus, error)
+	UpdateEventStatus(ctx context.Context, eventID string, update UpdateFunc) (*model.EventStatus, error)
+	ListEventsByAgent(ctx context.Context, agentID string, limit int) ([]string, error) // Most recent first
+	ListEventsByState(ctx context.Context, state model.ExecutionState) ([]string, error)
+	GetEventTimeline(ctx context.Context, eventID string) ([]*model.StatusChange, error) // Oldest first, empty if unknown
+
+	// Submitted events
+	SaveEvent(ctx context.Context, event *model.Event) (*EventSpec, error)
+	GetEvent(ctx context.Context, eventID string) (*EventSpec, error) // model.ErrEventNotFound if unknown or expired
+
+	// Agent state
+	SaveAgent(ctx context.Context, agent *model.Agent) error
+	GetAgent(ctx context.Context, agentID string) (*model.Agent, error)
+	ListAllAgents(ctx context.Context) ([]string, error)
+	ListAgentsByCluster(ctx context.Context, clusterName string) ([]string, error)
+	DeleteAgent(ctx context.Context, agentID string) error
+	RevokeAgent(ctx context.Context, agentID string) error
+	RestoreAgent(ctx context.Context, agentID string) (bool, error)
+	IsAgentRevoked(ctx context.Context, agentID string) (bool, error)
+
+	// Audit log
+	SaveAuditLog(ctx context.Context, entry *AuditLogEntry) error
+	GetRecentAuditLogs(ctx context.Context, count int) ([]*AuditLogEntry, error) // Most recent first
+	QueryAuditLog(ctx context.Context, query AuditQuery) (*AuditPage, error)
+
+	// Statistics
+	RecordTransition(ctx context.Context, transition Transition) error
+	GetEventStats(ctx context.Context) (*EventStats, error)
+}
+
+// Compactor is implemented by backends that need periodic cleanup of expired data
+// and dangling index entries
+type Compactor interface {
+	Compact(ctx context.Context) (*CompactResult, error)
+}
+
+// CompactResult reports what a compaction removed
+type CompactResult struct {
+	Statuses     int   `json:"statuses"`      // Expired event statuses
+	Timelines    int   `json:"timelines"`     // Timelines of expired or deleted event statuses
+	Events       int   `json:"events"`        // Expired event specs
+	Manifests    int   `json:"manifests"`     // Manifests no longer referenced by an event
+	StateEntries int   `json:"state_entries"` // Dangling or stale state index entries
+	AgentEntries int   `json:"agent_entries"` // Dangling or expired agent index entries
+	Counters     int   `json:"counters"`      // Expired statistics counters (rate buckets)
+	AuditEntries int64 `json:"audit_entries"` // Audit entries past the retention
+}
+
+// Retention bounds how long persisted data is kept
+type Retention struct {
+	EventStatus time.Duration // Event statuses and specs, and their agent index entries
+	AuditMaxLen int64         // Audit entries kept, oldest trimmed first
+	AuditMaxAge time.Duration // Audit entries older than this are trimmed (0 keeps them)
+}
+
+// Default retention
+const (
+	DefaultEventStatusRetention = 7 * 24 * time.Hour
+	DefaultAuditMaxLen          = 100000
+)
+
+func (r Retention) withDefaults() Retention {
+	if r.EventStatus <= 0 {
+		r.EventStatus = DefaultEventStatusRetention
+	}
+	if r.AuditMaxLen <= 0 {
+		r.AuditMaxLen = DefaultAuditMaxLen
+	}
+	return r
+}
+
+// UpdateFunc changes an event status inside an atomic read-modify-write. It receives
+// nil if the event has no status yet and returns the updated status, whose difference
+// to the stored one is recorded in the timeline; an error aborts the update and is
+// returned unchanged by UpdateEventStatus.
+type UpdateFunc func(status *model.EventStatus) (*model.EventStatus, error)
+
+// AuditLogEntry represents an audit log entry
+type AuditLogEntry struct {
+	ID        string                 `json:"id,omitempty"` // Position in the log, assigned by the backend
+	Timestamp time.Time              `json:"timestamp"`
+	EventID   string                 `json:"event_id"`
+	AgentID   string                 `json:"agent_id"`
+	Action    string                 `json:"action"` // event_created, event_routed, event_completed, etc.
+	User      string                 `json:"user,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	PrevHash  string                 `json:"prev_hash,omitempty"` // Hash of the previous entry, empty for the first
+	Hash      string                 `json:"hash,omitempty"`      // Hash of this entry, see ContentHash
+	WriteID   string                 `json:"write_id,omitempty"`  // Identifies a retried write, so it is appended once
+}
+
+// statsStates are the event states counted in the statistics
+var statsStates = []model.ExecutionState{
+	model.StateCreated,
+	model.StateQueued,
+	model.StateAssigned,
+	model.StateInProgress,
+	model.StateCompleted,
+	model.StateFailed,
+	model.StateExpired,
+	model.StateCancelled,
+	model.StateUnknown,
+}
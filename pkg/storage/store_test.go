@@ -0,0 +1,646 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/suyog1pathak/transporter/internal/model"
+)
+
+// testBackend opens an empty store of one backend
+type testBackend struct {
+	name string
+	open func(t *testing.T) Store
+}
+
+// testBackends returns every backend the Store tests run against. Redis is included
+// when TRANSPORTER_TEST_REDIS_ADDR points at a server the tests may write to; its keys
+// get a unique prefix and are deleted afterwards.
+func testBackends() []testBackend {
+	backends := []testBackend{
+		{
+			name: "memory",
+			open: func(t *testing.T) Store { return NewMemoryStorage() },
+		},
+		{
+			name: "bolt",
+			open: func(t *testing.T) Store {
+				store, err := NewBoltStorage(filepath.Join(t.TempDir(), "transporter.db"), Retention{EventStatus: DefaultEventStatusRetention})
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { store.Close() })
+				return store
+			},
+		},
+	}
+
+	addr := os.Getenv("TRANSPORTER_TEST_REDIS_ADDR")
+	if addr == "" {
+		return backends
+	}
+	openRedis := func(t *testing.T, spread bool) *RedisStorage {
+		prefix := "transporter-test-" + uuid.NewString() + ":"
+		store, err := NewRedisStorage(Config{Addr: addr, KeyPrefix: prefix})
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.spread = spread
+		t.Cleanup(func() {
+			defer store.Close()
+			ctx := context.Background()
+			keys, err := store.scanKeys(ctx, prefix+"*")
+			if err == nil && len(keys) > 0 {
+				store.client.Del(ctx, keys...)
+			}
+		})
+		return store
+	}
+	return append(backends,
+		testBackend{
+			name: "redis",
+			open: func(t *testing.T) Store { return openRedis(t, false) },
+		},
+		// The key layout and write order used on Redis Cluster, on a single server
+		testBackend{
+			name: "redis-cluster-layout",
+			open: func(t *testing.T) Store { return openRedis(t, true) },
+		},
+	)
+}
+
+// runStoreTests runs test against every backend
+func runStoreTests(t *testing.T, test func(t *testing.T, store Store)) {
+	for _, backend := range testBackends() {
+		t.Run(backend.name, func(t *testing.T) {
+			test(t, backend.open(t))
+		})
+	}
+}
+
+// runRedisTests runs test against the Redis backends, if a server is configured
+func runRedisTests(t *testing.T, test func(t *testing.T, store *RedisStorage)) {
+	ran := false
+	for _, backend := range testBackends() {
+		if !strings.HasPrefix(backend.name, "redis") {
+			continue
+		}
+		ran = true
+		t.Run(backend.name, func(t *testing.T) {
+			test(t, backend.open(t).(*RedisStorage))
+		})
+	}
+	if !ran {
+		t.Skip("set TRANSPORTER_TEST_REDIS_ADDR to run against Redis")
+	}
+}
+
+// setState returns an update moving a status to state for agentID, creating it if needed
+func setState(eventID, agentID string, state model.ExecutionState) UpdateFunc {
+	return func(status *model.EventStatus) (*model.EventStatus, error) {
+		if status == nil {
+			status = model.NewEventStatus(eventID, agentID)
+		}
+		status.AgentID = agentID
+		status.State = state
+		status.Message = fmt.Sprintf("%s on %s", state, agentID)
+		return status, nil
+	}
+}
+
+func TestStoreEventStatus(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, store Store) {
+		ctx := t.Context()
+
+		if _, err := store.GetEventStatus(ctx, "event-1"); !errors.Is(err, model.ErrStatusNotFound) {
+			t.Fatalf("GetEventStatus() of an unknown event error = %v, want ErrStatusNotFound", err)
+		}
+		if timeline, err := store.GetEventTimeline(ctx, "event-1"); err != nil || len(timeline) != 0 {
+			t.Fatalf("GetEventTimeline() of an unknown event = %v, %v; want empty", timeline, err)
+		}
+
+		if _, err := store.UpdateEventStatus(ctx, "event-1", setState("event-1", "agent-1", model.StateAssigned)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.UpdateEventStatus(ctx, "event-2", setState("event-2", "agent-1", model.StateAssigned)); err != nil {
+			t.Fatal(err)
+		}
+		updated, err := store.UpdateEventStatus(ctx, "event-1", setState("event-1", "agent-2", model.StateInProgress))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.State != model.StateInProgress || updated.AgentID != "agent-2" {
+			t.Fatalf("UpdateEventStatus() = %s on %s", updated.State, updated.AgentID)
+		}
+
+		status, err := store.GetEventStatus(ctx, "event-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.State != model.StateInProgress || status.AgentID != "agent-2" || status.Message != "in_progress on agent-2" {
+			t.Fatalf("GetEventStatus() = %s on %s: %q", status.State, status.AgentID, status.Message)
+		}
+
+		// The event moved between the state and agent indexes
+		indexes := []struct {
+			name string
+			list func() ([]string, error)
+			want []string
+		}{
+			{"assigned", func() ([]string, error) { return store.ListEventsByState(ctx, model.StateAssigned) }, []string{"event-2"}},
+			{"in progress", func() ([]string, error) { return store.ListEventsByState(ctx, model.StateInProgress) }, []string{"event-1"}},
+			{"agent-1", func() ([]string, error) { return store.ListEventsByAgent(ctx, "agent-1", 0) }, []string{"event-2"}},
+			{"agent-2", func() ([]string, error) { return store.ListEventsByAgent(ctx, "agent-2", 0) }, []string{"event-1"}},
+		}
+		for _, index := range indexes {
+			got, err := index.list()
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, index.want) {
+				t.Errorf("%s index = %v, want %v", index.name, got, index.want)
+			}
+		}
+
+		timeline, err := store.GetEventTimeline(ctx, "event-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(timeline) != 2 || timeline[0].From != "" || timeline[0].To != model.StateAssigned ||
+			timeline[1].From != model.StateAssigned || timeline[1].To != model.StateInProgress {
+			t.Fatalf("timeline %+v, want the assignment then the start, oldest first", timeline)
+		}
+
+		// An update that fails leaves the status as it was
+		errAbort := errors.New("abort")
+		_, err = store.UpdateEventStatus(ctx, "event-1", func(*model.EventStatus) (*model.EventStatus, error) {
+			return nil, errAbort
+		})
+		if !errors.Is(err, errAbort) {
+			t.Fatalf("UpdateEventStatus() error = %v, want the update's error", err)
+		}
+		if timeline, _ := store.GetEventTimeline(ctx, "event-1"); len(timeline) != 2 {
+			t.Fatalf("aborted update added to the timeline: %d entries", len(timeline))
+		}
+
+		// SaveEventStatus is an update that replaces the status
+		status.State = model.StateCompleted
+		if err := store.SaveEventStatus(ctx, status); err != nil {
+			t.Fatal(err)
+		}
+		if completed, _ := store.ListEventsByState(ctx, model.StateCompleted); !slices.Equal(completed, []string{"event-1"}) {
+			t.Fatalf("completed index = %v, want [event-1]", completed)
+		}
+	})
+}
+
+func TestCompactKeepsLiveIndexEntries(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, store Store) {
+		compactor, ok := store.(Compactor)
+		if !ok {
+			t.Skip("backend does not need compaction")
+		}
+		ctx := t.Context()
+
+		moves := []struct {
+			eventID, agentID string
+			state            model.ExecutionState
+		}{
+			{"event-1", "agent-1", model.StateAssigned},
+			{"event-2", "agent-1", model.StateAssigned},
+			{"event-1", "agent-1", model.StateInProgress},
+			{"event-2", "agent-2", model.StateAssigned}, // Reassigned
+			{"event-1", "agent-1", model.StateCompleted},
+		}
+		for _, move := range moves {
+			if _, err := store.UpdateEventStatus(ctx, move.eventID, setState(move.eventID, move.agentID, move.state)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		result, err := compactor.Compact(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Statuses != 0 || result.StateEntries != 0 || result.AgentEntries != 0 {
+			t.Fatalf("Compact() = %+v, want nothing removed", result)
+		}
+
+		for state, want := range map[model.ExecutionState][]string{
+			model.StateAssigned:   {"event-2"},
+			model.StateInProgress: nil,
+			model.StateCompleted:  {"event-1"},
+		} {
+			got, err := store.ListEventsByState(ctx, state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("%s events = %v, want %v", state, got, want)
+			}
+		}
+		for agentID, want := range map[string][]string{"agent-1": {"event-1"}, "agent-2": {"event-2"}} {
+			got, err := store.ListEventsByAgent(ctx, agentID, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("%s events = %v, want %v", agentID, got, want)
+			}
+		}
+	})
+}
+
+func TestRedisCompactPrunesStaleIndexEntries(t *testing.T) {
+	runRedisTests(t, func(t *testing.T, store *RedisStorage) {
+		ctx := t.Context()
+
+		if _, err := store.UpdateEventStatus(ctx, "event-1", setState("event-1", "agent-1", model.StateCompleted)); err != nil {
+			t.Fatal(err)
+		}
+		// Entries left behind by an interrupted write, and by statuses that expired
+		now := float64(time.Now().Unix())
+		store.client.SAdd(ctx, store.key("events:state:%s", model.StateInProgress), "event-1", "event-gone")
+		store.client.ZAdd(ctx, store.key("agent:events:%s", "agent-2"), redis.Z{Score: now, Member: "event-1"}, redis.Z{Score: now, Member: "event-gone"})
+
+		result, err := store.Compact(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.StateEntries != 2 || result.AgentEntries != 2 {
+			t.Fatalf("Compact() removed %d state and %d agent entries, want 2 each", result.StateEntries, result.AgentEntries)
+		}
+		if running, _ := store.ListEventsByState(ctx, model.StateInProgress); len(running) != 0 {
+			t.Fatalf("in progress events after compaction = %v", running)
+		}
+		if completed, _ := store.ListEventsByState(ctx, model.StateCompleted); !slices.Equal(completed, []string{"event-1"}) {
+			t.Fatalf("completed events after compaction = %v, want [event-1]", completed)
+		}
+		if events, _ := store.ListEventsByAgent(ctx, "agent-1", 0); !slices.Equal(events, []string{"event-1"}) {
+			t.Fatalf("agent-1 events after compaction = %v, want [event-1]", events)
+		}
+	})
+}
+
+func TestRedisPruneKeepsEventsMovedIn(t *testing.T) {
+	runRedisTests(t, func(t *testing.T, store *RedisStorage) {
+		ctx := t.Context()
+
+		if _, err := store.UpdateEventStatus(ctx, "event-1", setState("event-1", "agent-1", model.StateAssigned)); err != nil {
+			t.Fatal(err)
+		}
+		index := statusIndex{key: store.key("events:state:%s", model.StateInProgress), field: "state", value: string(model.StateInProgress)}
+		candidates, err := store.staleMembers(ctx, []string{"event-1"}, index.matches)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(candidates, []string{"event-1"}) {
+			t.Fatalf("stale candidates = %v, want [event-1]", candidates)
+		}
+
+		// The event starts between the compactor reading its status and pruning
+		if _, err := store.UpdateEventStatus(ctx, "event-1", setState("event-1", "agent-1", model.StateInProgress)); err != nil {
+			t.Fatal(err)
+		}
+		removed, err := store.removeStale(ctx, index, candidates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed != 0 {
+			t.Fatalf("removeStale() removed %d entries of an event that moved in", removed)
+		}
+		if running, _ := store.ListEventsByState(ctx, model.StateInProgress); !slices.Equal(running, []string{"event-1"}) {
+			t.Fatalf("in progress events = %v, want [event-1]", running)
+		}
+
+		// Still stale entries are removed
+		agents := statusIndex{key: store.key("agent:events:%s", "agent-2"), sorted: true, field: "agent_id", value: "agent-2"}
+		store.client.ZAdd(ctx, agents.key, redis.Z{Score: float64(time.Now().Unix()), Member: "event-1"})
+		if removed, err := store.removeStale(ctx, agents, []string{"event-1"}); err != nil || removed != 1 {
+			t.Fatalf("removeStale() = %d, %v; want the stale agent entry removed", removed, err)
+		}
+	})
+}
+
+func TestStoreEvents(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, store Store) {
+		ctx := t.Context()
+
+		if _, err := store.GetEvent(ctx, "event-1"); !errors.Is(err, model.ErrEventNotFound) {
+			t.Fatalf("GetEvent() of an unknown event error = %v, want ErrEventNotFound", err)
+		}
+
+		shared := "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: shared"
+		first := model.NewEvent(model.EventTypeK8sResource, "agent-1", model.EventPayload{
+			Manifests: []string{shared, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: first"},
+		}, "alice")
+		second := model.NewEvent(model.EventTypeK8sResource, "agent-2", model.EventPayload{
+			Manifests: []string{"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: second", shared},
+		}, "bob")
+
+		for _, event := range []*model.Event{first, second} {
+			saved, err := store.SaveEvent(ctx, event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved.ManifestHashes) != 2 || saved.ContentHash == "" {
+				t.Fatalf("SaveEvent() = %+v, want two manifest hashes and a content hash", saved)
+			}
+		}
+
+		for _, event := range []*model.Event{first, second} {
+			spec, err := store.GetEvent(ctx, event.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(spec.Event.Payload.Manifests, event.Payload.Manifests) || spec.Event.CreatedBy != event.CreatedBy {
+				t.Fatalf("GetEvent(%s) = %v by %s, want the saved manifests", event.ID, spec.Event.Payload.Manifests, spec.Event.CreatedBy)
+			}
+		}
+	})
+}
+
+func TestStoreAgents(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, store Store) {
+		ctx := t.Context()
+
+		if _, err := store.GetAgent(ctx, "agent-1"); !errors.Is(err, model.ErrAgentNotFound) {
+			t.Fatalf("GetAgent() of an unknown agent error = %v, want ErrAgentNotFound", err)
+		}
+
+		for _, agent := range []*model.Agent{
+			{ID: "agent-1", Name: "agent-1", ClusterName: "cluster-1", Status: model.AgentStatusConnected},
+			{ID: "agent-2", Name: "agent-2", ClusterName: "cluster-1", Status: model.AgentStatusConnected},
+			{ID: "agent-2", Name: "agent-2", ClusterName: "cluster-2", Status: model.AgentStatusDisconnected}, // Moved
+		} {
+			if err := store.SaveAgent(ctx, agent); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		agent, err := store.GetAgent(ctx, "agent-2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if agent.ClusterName != "cluster-2" || agent.Status != model.AgentStatusDisconnected {
+			t.Fatalf("GetAgent() = %s in %s, want the last save", agent.Status, agent.ClusterName)
+		}
+
+		lists := []struct {
+			name string
+			list func() ([]string, error)
+			want []string
+		}{
+			{"all", func() ([]string, error) { return store.ListAllAgents(ctx) }, []string{"agent-1", "agent-2"}},
+			{"cluster-1", func() ([]string, error) { return store.ListAgentsByCluster(ctx, "cluster-1") }, []string{"agent-1"}},
+			{"cluster-2", func() ([]string, error) { return store.ListAgentsByCluster(ctx, "cluster-2") }, []string{"agent-2"}},
+		}
+		for _, list := range lists {
+			got, err := list.list()
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, list.want) {
+				t.Errorf("%s agents = %v, want %v", list.name, got, list.want)
+			}
+		}
+
+		if err := store.DeleteAgent(ctx, "agent-2"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.GetAgent(ctx, "agent-2"); !errors.Is(err, model.ErrAgentNotFound) {
+			t.Fatalf("GetAgent() of a deleted agent error = %v, want ErrAgentNotFound", err)
+		}
+		if all, _ := store.ListAllAgents(ctx); !slices.Equal(all, []string{"agent-1"}) {
+			t.Fatalf("agents after delete = %v, want [agent-1]", all)
+		}
+		if err := store.DeleteAgent(ctx, "agent-2"); !errors.Is(err, model.ErrAgentNotFound) {
+			t.Fatalf("DeleteAgent() of a deleted agent error = %v, want ErrAgentNotFound", err)
+		}
+
+		if err := store.RevokeAgent(ctx, "agent-2"); err != nil {
+			t.Fatal(err)
+		}
+		if revoked, err := store.IsAgentRevoked(ctx, "agent-2"); err != nil || !revoked {
+			t.Fatalf("IsAgentRevoked() = %v, %v; want revoked", revoked, err)
+		}
+		for i, want := range []bool{true, false} {
+			if restored, err := store.RestoreAgent(ctx, "agent-2"); err != nil || restored != want {
+				t.Fatalf("RestoreAgent() call %d = %v, %v; want %v", i+1, restored, err, want)
+			}
+		}
+		if revoked, _ := store.IsAgentRevoked(ctx, "agent-2"); revoked {
+			t.Fatal("restored agent is still revoked")
+		}
+	})
+}
+
+func TestStoreAuditLog(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, store Store) {
+		ctx := t.Context()
+
+		start := time.Now()
+		for i := range 5 {
+			entry := &AuditLogEntry{
+				Timestamp: start.Add(time.Duration(i) * time.Millisecond),
+				EventID:   fmt.Sprintf("event-%d", i),
+				Action:    "event_routed",
+				User:      []string{"alice", "bob"}[i%2],
+				WriteID:   fmt.Sprintf("write-%d", i),
+			}
+			if err := store.SaveAuditLog(ctx, entry); err != nil {
+				t.Fatal(err)
+			}
+			if i == 4 {
+				// A retried write is appended once
+				retry := *entry
+				retry.ID, retry.Hash, retry.PrevHash = "", "", ""
+				if err := store.SaveAuditLog(ctx, &retry); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		recent, err := store.GetRecentAuditLogs(ctx, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recent) != 2 || recent[0].EventID != "event-4" || recent[1].EventID != "event-3" {
+			t.Fatalf("recent entries %v, want event-4 then event-3", auditEventIDs(recent))
+		}
+
+		var pages [][]string
+		query := AuditQuery{Limit: 2}
+		for {
+			page, err := store.QueryAuditLog(ctx, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pages = append(pages, auditEventIDs(page.Entries))
+			if page.NextCursor == "" {
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+		if fmt.Sprint(pages) != "[[event-0 event-1] [event-2 event-3] [event-4]]" {
+			t.Fatalf("pages %v, want all entries oldest first, two per page", pages)
+		}
+
+		page, err := store.QueryAuditLog(ctx, AuditQuery{User: "bob"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := auditEventIDs(page.Entries); !slices.Equal(got, []string{"event-1", "event-3"}) {
+			t.Fatalf("entries by bob = %v, want [event-1 event-3]", got)
+		}
+
+		verifier := NewChainVerifier(nil, false)
+		all, err := store.QueryAuditLog(ctx, AuditQuery{Limit: MaxAuditPageSize})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range all.Entries {
+			verifier.Add(entry)
+		}
+		if err := verifier.Err(); err != nil {
+			t.Fatalf("stored chain does not verify: %v", err)
+		}
+	})
+}
+
+func TestStoreAuditQuery(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, store Store) {
+		ctx := t.Context()
+
+		start := time.Now().Truncate(time.Second)
+		save := func(i int, user string) {
+			t.Helper()
+			entry := &AuditLogEntry{
+				Timestamp: start.Add(time.Duration(i) * time.Second),
+				EventID:   fmt.Sprintf("event-%d", i),
+				Action:    "event_routed",
+				User:      user,
+			}
+			if err := store.SaveAuditLog(ctx, entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i, user := range []string{"alice", "bob", "bob", "alice", "alice", "bob"} {
+			save(i, user)
+		}
+
+		// readAll pages through a query, returning the pages' event IDs
+		readAll := func(query AuditQuery) [][]string {
+			t.Helper()
+			var pages [][]string
+			for {
+				page, err := store.QueryAuditLog(ctx, query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pages = append(pages, auditEventIDs(page.Entries))
+				if page.NextCursor == "" {
+					return pages
+				}
+				query.Cursor = page.NextCursor
+			}
+		}
+
+		tests := []struct {
+			name  string
+			query AuditQuery
+			want  string
+		}{
+			{name: "filtered pages skip other entries", query: AuditQuery{User: "bob", Limit: 1}, want: "[[event-1] [event-2] [event-5]]"},
+			{name: "last page that fits exactly has no cursor", query: AuditQuery{User: "alice", Limit: 3}, want: "[[event-0 event-3 event-4]]"},
+			{
+				name:  "since is inclusive and until exclusive",
+				query: AuditQuery{Since: start.Add(2 * time.Second), Until: start.Add(4 * time.Second), Limit: 1},
+				want:  "[[event-2] [event-3]]",
+			},
+			{name: "no matches", query: AuditQuery{Action: "agent_revoked"}, want: "[[]]"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if got := fmt.Sprint(readAll(tt.query)); got != tt.want {
+					t.Fatalf("pages %s, want %s", got, tt.want)
+				}
+			})
+		}
+
+		// A cursor stays valid while entries are appended
+		page, err := store.QueryAuditLog(ctx, AuditQuery{Limit: 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		save(6, "carol")
+		if got := fmt.Sprint(readAll(AuditQuery{Limit: 4, Cursor: page.NextCursor})); got != "[[event-4 event-5 event-6]]" {
+			t.Fatalf("pages after the cursor %s, want the rest including the new entry", got)
+		}
+
+		for _, cursor := range []string{"not-a-cursor", "12abc"} {
+			if _, err := store.QueryAuditLog(ctx, AuditQuery{Cursor: cursor}); !errors.Is(err, ErrInvalidAuditCursor) {
+				t.Errorf("QueryAuditLog() with cursor %q error = %v, want ErrInvalidAuditCursor", cursor, err)
+			}
+		}
+	})
+}
+
+func TestAuditQueryDefaults(t *testing.T) {
+	for limit, want := range map[int]int{0: DefaultAuditPageSize, -1: DefaultAuditPageSize, 10: 10, MaxAuditPageSize + 1: MaxAuditPageSize} {
+		if got := (AuditQuery{Limit: limit}).withDefaults().Limit; got != want {
+			t.Errorf("limit %d becomes %d, want %d", limit, got, want)
+		}
+	}
+}
+
+func TestStoreStatistics(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, store Store) {
+		ctx := t.Context()
+
+		now := time.Now()
+		transitions := []Transition{
+			{EventID: "event-1", AgentID: "agent-1", Cluster: "cluster-1", EventType: model.EventTypeK8sResource, CreatedBy: "alice", To: model.StateCreated, At: now},
+			{EventID: "event-1", AgentID: "agent-1", Cluster: "cluster-1", EventType: model.EventTypeK8sResource, CreatedBy: "alice", From: model.StateCreated, To: model.StateAssigned, At: now},
+			{EventID: "event-1", AgentID: "agent-1", Cluster: "cluster-1", EventType: model.EventTypeK8sResource, CreatedBy: "alice", From: model.StateAssigned, To: model.StateCompleted, At: now, Duration: 2 * time.Second},
+		}
+		for _, transition := range transitions {
+			if err := store.RecordTransition(ctx, transition); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		stats, err := store.GetEventStats(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Totals["total"] != 1 || stats.Totals[string(model.StateCompleted)] != 1 {
+			t.Fatalf("totals %v, want one event created and completed", stats.Totals)
+		}
+		if stats.ByAgent["agent-1"][string(model.StateAssigned)] != 1 || stats.ByCluster["cluster-1"][string(model.StateCompleted)] != 1 {
+			t.Fatalf("by agent %v, by cluster %v", stats.ByAgent, stats.ByCluster)
+		}
+		if stats.Durations[string(model.EventTypeK8sResource)] == nil {
+			t.Fatalf("no execution durations for %s: %v", model.EventTypeK8sResource, stats.Durations)
+		}
+	})
+}
+
+func auditEventIDs(entries []*AuditLogEntry) []string {
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.EventID
+	}
+	return ids
+}
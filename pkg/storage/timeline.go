@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+)
+
+// statusUpdate is the outcome of running an UpdateFunc inside a backend's transaction
+type statusUpdate struct {
+	previous   *model.EventStatus  // Status before the update, nil if the event had none
+	status     *model.EventStatus  // Status materialized by applying change to previous
+	change     *model.StatusChange // Next entry of the event's timeline
+	statusData []byte
+	changeData []byte
+}
+
+// applyUpdate runs update on an event's encoded status (nil if it has none yet) and
+// turns the result into the change to append to the event's timeline. The status to
+// save is materialized from the change rather than taken from update, so it always
+// equals the event's timeline replayed, with its log capped.
+func applyUpdate(eventID string, data []byte, update UpdateFunc) (*statusUpdate, error) {
+	decode := func() (*model.EventStatus, error) {
+		if data == nil {
+			return nil, nil
+		}
+		status := &model.EventStatus{}
+		if err := json.Unmarshal(data, status); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal event status: %w", err)
+		}
+		return status, nil
+	}
+
+	previous, err := decode()
+	if err != nil {
+		return nil, err
+	}
+	current, _ := decode() // Given to update, which may change it in place
+
+	updated, err := update(current)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &statusUpdate{
+		previous: previous,
+		change:   model.NewStatusChange(previous, updated),
+	}
+	if previous != nil {
+		status := *previous
+		result.status = &status
+	} else {
+		result.status = &model.EventStatus{EventID: eventID, ExecutionLog: []model.LogEntry{}}
+	}
+	result.status.Apply(result.change)
+
+	if result.statusData, err = json.Marshal(result.status); err != nil {
+		return nil, fmt.Errorf("failed to marshal event status: %w", err)
+	}
+	if result.changeData, err = json.Marshal(result.change); err != nil {
+		return nil, fmt.Errorf("failed to marshal status change: %w", err)
+	}
+	return result, nil
+}
+
+// decodeTimeline decodes an event's timeline entries, oldest first, numbering them
+func decodeTimeline(entries [][]byte) ([]*model.StatusChange, error) {
+	timeline := make([]*model.StatusChange, 0, len(entries))
+	for i, data := range entries {
+		change := &model.StatusChange{}
+		if err := json.Unmarshal(data, change); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal status change: %w", err)
+		}
+		change.Seq = i + 1
+		timeline = append(timeline, change)
+	}
+	return timeline, nil
+}
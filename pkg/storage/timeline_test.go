@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+)
+
+func TestTimelineKeepsNewestEntries(t *testing.T) {
+	runStoreTests(t, func(t *testing.T, store Store) {
+		ctx := t.Context()
+
+		writes := model.MaxTimelineEntries + 5
+		for i := range writes {
+			_, err := store.UpdateEventStatus(ctx, "event-1", func(status *model.EventStatus) (*model.EventStatus, error) {
+				if status == nil {
+					status = model.NewEventStatus("event-1", "agent-1")
+				}
+				status.Message = fmt.Sprintf("update %d", i)
+				return status, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		timeline, err := store.GetEventTimeline(ctx, "event-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(timeline) != model.MaxTimelineEntries {
+			t.Fatalf("timeline has %d entries, want %d", len(timeline), model.MaxTimelineEntries)
+		}
+		if first, want := timeline[0].Message, fmt.Sprintf("update %d", writes-model.MaxTimelineEntries); first != want {
+			t.Fatalf("oldest kept change = %q, want %q", first, want)
+		}
+		if last, want := timeline[len(timeline)-1].Message, fmt.Sprintf("update %d", writes-1); last != want {
+			t.Fatalf("newest change = %q, want %q", last, want)
+		}
+	})
+}
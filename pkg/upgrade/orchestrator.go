@@ -0,0 +1,467 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+)
+
+// LabelRollout is set on upgrade events to the ID of their rollout
+const LabelRollout = "transporter.io/rollout"
+
+// State is the state of a rollout or of a single agent within it
+type State string
+
+const (
+	StatePending   State = "pending"
+	StateRunning   State = "running"   // Rollout in progress
+	StateUpgrading State = "upgrading" // Agent told to upgrade, waiting for the new version
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateSkipped   State = "skipped"
+	StateBlocked   State = "blocked" // A stage failed; later stages were not started
+	StateAborted   State = "aborted"
+)
+
+// Request asks for a set of agents to be upgraded
+type Request struct {
+	Image           string               `json:"image"`
+	Version         string               `json:"version"`
+	Agents          []string             `json:"agents,omitempty"`   // Explicit agent IDs, upgraded in order
+	Selector        *model.AgentSelector `json:"selector,omitempty"` // Or every matching agent
+	BatchSize       int                  `json:"batch_size"`         // Agents per stage after the canary
+	RegisterTimeout time.Duration        `json:"register_timeout"`   // Per agent deadline to register the new version
+	CreatedBy       string               `json:"created_by"`
+}
+
+// Rollout is a staged upgrade of a set of agents. The first stage is a single canary
+// agent; each following stage upgrades BatchSize agents in parallel. A stage with a
+// failed agent blocks the rollout until it is resumed.
+type Rollout struct {
+	ID              string           `json:"id"`
+	Image           string           `json:"image"`
+	Version         string           `json:"version"`
+	BatchSize       int              `json:"batch_size"`
+	RegisterTimeout time.Duration    `json:"register_timeout"`
+	State           State            `json:"state"`
+	Message         string           `json:"message,omitempty"`
+	Agents          []*AgentProgress `json:"agents"`
+	CreatedBy       string           `json:"created_by"`
+	CreatedAt       time.Time        `json:"created_at"`
+	FinishedAt      *time.Time       `json:"finished_at,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// AgentProgress is the upgrade progress of one agent in a rollout
+type AgentProgress struct {
+	AgentID     string     `json:"agent_id"`
+	Stage       int        `json:"stage"`
+	State       State      `json:"state"`
+	FromVersion string     `json:"from_version,omitempty"`
+	EventID     string     `json:"event_id,omitempty"`
+	Message     string     `json:"message,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// Config holds configuration for the upgrade orchestrator
+type Config struct {
+	// Route delivers an upgrade event to its agent
+	Route func(*model.Event) error
+	// LiveAgent returns a connected agent
+	LiveAgent func(agentID string) (*model.Agent, error)
+	// Agents returns all known agents, for selector rollouts
+	Agents func() ([]*model.Agent, error)
+	// EventStatus returns the status of an upgrade event
+	EventStatus func(eventID string) (*model.EventStatus, error)
+
+	DefaultBatchSize       int
+	DefaultRegisterTimeout time.Duration
+	PollInterval           time.Duration
+
+	// Optional callbacks, called with snapshots
+	OnAgentProgress   func(*Rollout, *AgentProgress)
+	OnRolloutFinished func(*Rollout)
+}
+
+// Orchestrator runs agent upgrade rollouts. Rollouts are kept in memory and do
+// not survive a control plane restart.
+type Orchestrator struct {
+	cfg      Config
+	rollouts map[string]*Rollout
+	mu       sync.Mutex
+}
+
+// NewOrchestrator creates a new upgrade orchestrator
+func NewOrchestrator(config Config) *Orchestrator {
+	if config.DefaultBatchSize == 0 {
+		config.DefaultBatchSize = 5
+	}
+	if config.DefaultRegisterTimeout == 0 {
+		config.DefaultRegisterTimeout = 5 * time.Minute
+	}
+	if config.PollInterval == 0 {
+		config.PollInterval = 2 * time.Second
+	}
+
+	return &Orchestrator{
+		cfg:      config,
+		rollouts: make(map[string]*Rollout),
+	}
+}
+
+// Start validates the request and starts a rollout in the background
+func (o *Orchestrator) Start(req Request) (*Rollout, error) {
+	if req.Image == "" {
+		return nil, model.ErrMissingUpgradeImage
+	}
+	if req.Version == "" {
+		return nil, model.ErrMissingUpgradeVersion
+	}
+	if req.BatchSize <= 0 {
+		req.BatchSize = o.cfg.DefaultBatchSize
+	}
+	if req.RegisterTimeout <= 0 {
+		req.RegisterTimeout = o.cfg.DefaultRegisterTimeout
+	}
+
+	agentIDs, err := o.resolveAgents(req)
+	if err != nil {
+		return nil, err
+	}
+
+	rollout := &Rollout{
+		ID:              uuid.New().String(),
+		Image:           req.Image,
+		Version:         req.Version,
+		BatchSize:       req.BatchSize,
+		RegisterTimeout: req.RegisterTimeout,
+		State:           StatePending,
+		CreatedBy:       req.CreatedBy,
+		CreatedAt:       time.Now(),
+	}
+	for i, agentID := range agentIDs {
+		rollout.Agents = append(rollout.Agents, &AgentProgress{
+			AgentID: agentID,
+			Stage:   stageOf(i, req.BatchSize),
+			State:   StatePending,
+		})
+	}
+
+	o.mu.Lock()
+	o.rollouts[rollout.ID] = rollout
+	o.mu.Unlock()
+
+	o.launch(rollout)
+	return o.Get(rollout.ID)
+}
+
+// Resume restarts a blocked or aborted rollout from its first unfinished stage.
+// Failed and aborted agents are retried.
+func (o *Orchestrator) Resume(rolloutID string) (*Rollout, error) {
+	o.mu.Lock()
+	rollout, exists := o.rollouts[rolloutID]
+	if !exists {
+		o.mu.Unlock()
+		return nil, fmt.Errorf("rollout %s not found", rolloutID)
+	}
+	if rollout.State == StateRunning || rollout.State == StateSucceeded {
+		o.mu.Unlock()
+		return nil, fmt.Errorf("rollout %s is %s", rolloutID, rollout.State)
+	}
+	for _, progress := range rollout.Agents {
+		if progress.State == StateFailed || progress.State == StateAborted {
+			progress.State = StatePending
+		}
+	}
+	rollout.State = StateRunning // Claimed before unlocking so a concurrent resume is refused
+	rollout.FinishedAt = nil
+	o.mu.Unlock()
+
+	o.launch(rollout)
+	return o.Get(rolloutID)
+}
+
+// Abort stops a running rollout. Agents already told to upgrade are not rolled back.
+func (o *Orchestrator) Abort(rolloutID string) (*Rollout, error) {
+	o.mu.Lock()
+	rollout, exists := o.rollouts[rolloutID]
+	if !exists {
+		o.mu.Unlock()
+		return nil, fmt.Errorf("rollout %s not found", rolloutID)
+	}
+	if rollout.State != StateRunning || rollout.cancel == nil {
+		o.mu.Unlock()
+		return nil, fmt.Errorf("rollout %s is %s", rolloutID, rollout.State)
+	}
+	rollout.cancel()
+	o.mu.Unlock()
+
+	return o.Get(rolloutID)
+}
+
+// Get returns a snapshot of a rollout
+func (o *Orchestrator) Get(rolloutID string) (*Rollout, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	rollout, exists := o.rollouts[rolloutID]
+	if !exists {
+		return nil, fmt.Errorf("rollout %s not found", rolloutID)
+	}
+	return rollout.snapshot(), nil
+}
+
+// List returns snapshots of all rollouts, newest first
+func (o *Orchestrator) List() []*Rollout {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	rollouts := make([]*Rollout, 0, len(o.rollouts))
+	for _, rollout := range o.rollouts {
+		rollouts = append(rollouts, rollout.snapshot())
+	}
+	slices.SortFunc(rollouts, func(a, b *Rollout) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return rollouts
+}
+
+// resolveAgents returns the agents a request targets
+func (o *Orchestrator) resolveAgents(req Request) ([]string, error) {
+	if len(req.Agents) > 0 && req.Selector != nil {
+		return nil, fmt.Errorf("set either agents or selector, not both")
+	}
+	if req.Selector == nil {
+		if len(req.Agents) == 0 {
+			return nil, fmt.Errorf("no agents to upgrade")
+		}
+		agentIDs := make([]string, 0, len(req.Agents))
+		for _, agentID := range req.Agents {
+			if !slices.Contains(agentIDs, agentID) {
+				agentIDs = append(agentIDs, agentID)
+			}
+		}
+		return agentIDs, nil
+	}
+
+	if req.Selector.IsEmpty() {
+		return nil, model.ErrEmptySelector
+	}
+	agents, err := o.cfg.Agents()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list agents: %w", err)
+	}
+	agentIDs := make([]string, 0)
+	for _, agent := range agents {
+		if req.Selector.Matches(agent) {
+			agentIDs = append(agentIDs, agent.ID)
+		}
+	}
+	if len(agentIDs) == 0 {
+		return nil, model.ErrNoMatchingAgents
+	}
+	slices.Sort(agentIDs)
+	return slices.Compact(agentIDs), nil
+}
+
+// launch runs the rollout's remaining stages in the background
+func (o *Orchestrator) launch(rollout *Rollout) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	o.mu.Lock()
+	rollout.State = StateRunning
+	rollout.Message = ""
+	rollout.cancel = cancel
+	o.mu.Unlock()
+
+	logger.Info("Agent upgrade rollout started", "rollout_id", rollout.ID, "version", rollout.Version,
+		"agents", len(rollout.Agents), "batch_size", rollout.BatchSize)
+	go o.run(ctx, rollout)
+}
+
+// run upgrades one stage at a time and stops at the first stage with a failure
+func (o *Orchestrator) run(ctx context.Context, rollout *Rollout) {
+	defer o.finish(ctx, rollout)
+
+	for stage := 0; ; stage++ {
+		o.mu.Lock()
+		batch := make([]*AgentProgress, 0)
+		for _, progress := range rollout.Agents {
+			if progress.Stage == stage && progress.State == StatePending {
+				batch = append(batch, progress)
+			}
+		}
+		last := stage >= rollout.Agents[len(rollout.Agents)-1].Stage
+		o.mu.Unlock()
+
+		var wg sync.WaitGroup
+		for _, progress := range batch {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				o.upgradeAgent(ctx, rollout, progress)
+			}()
+		}
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		o.mu.Lock()
+		failed := slices.ContainsFunc(rollout.Agents, func(p *AgentProgress) bool {
+			return p.Stage == stage && p.State == StateFailed
+		})
+		if failed {
+			rollout.State = StateBlocked
+			rollout.Message = fmt.Sprintf("stage %d failed; later stages were not started", stage)
+		}
+		o.mu.Unlock()
+
+		if failed || last {
+			return
+		}
+	}
+}
+
+// finish records the final rollout state
+func (o *Orchestrator) finish(ctx context.Context, rollout *Rollout) {
+	o.mu.Lock()
+	now := time.Now()
+	rollout.FinishedAt = &now
+	switch {
+	case rollout.State == StateBlocked:
+	case ctx.Err() != nil:
+		rollout.State = StateAborted
+		rollout.Message = "aborted by request"
+	default:
+		rollout.State = StateSucceeded
+	}
+	rollout.cancel()
+	rollout.cancel = nil
+	snapshot := rollout.snapshot()
+	o.mu.Unlock()
+
+	logger.Info("Agent upgrade rollout finished", "rollout_id", rollout.ID, "state", snapshot.State, "message", snapshot.Message)
+	if o.cfg.OnRolloutFinished != nil {
+		o.cfg.OnRolloutFinished(snapshot)
+	}
+}
+
+// upgradeAgent sends the upgrade event to one agent and waits for the agent to
+// register with the target version
+func (o *Orchestrator) upgradeAgent(ctx context.Context, rollout *Rollout, progress *AgentProgress) {
+	now := time.Now()
+	deadline := now.Add(rollout.RegisterTimeout)
+
+	current, err := o.cfg.LiveAgent(progress.AgentID)
+	if err == nil && current.Version == rollout.Version {
+		o.update(rollout, progress, StateSkipped, "already running the target version")
+		return
+	}
+
+	event := model.NewEvent(model.EventTypeAgentUpgrade, progress.AgentID, model.EventPayload{
+		AgentUpgrade: &model.AgentUpgradeSpec{Image: rollout.Image, Version: rollout.Version},
+	}, rollout.CreatedBy)
+	event.ID = fmt.Sprintf("%s.%s.%d", rollout.ID, progress.AgentID, now.Unix())
+	event.TTL = rollout.RegisterTimeout
+	event.Labels = map[string]string{LabelRollout: rollout.ID}
+
+	o.mu.Lock()
+	progress.EventID = event.ID
+	progress.StartedAt = &now
+	progress.FinishedAt = nil
+	if current != nil {
+		progress.FromVersion = current.Version
+	}
+	o.mu.Unlock()
+	o.update(rollout, progress, StateUpgrading, "upgrade event sent")
+
+	if err := o.cfg.Route(event); err != nil {
+		o.update(rollout, progress, StateFailed, fmt.Sprintf("failed to route upgrade event: %v", err))
+		return
+	}
+
+	ticker := time.NewTicker(o.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			o.update(rollout, progress, StateAborted, "rollout aborted")
+			return
+		case <-ticker.C:
+		}
+
+		// The registered version is checked first: the upgrade restarts the agent, which
+		// may fail over its upgrade event before the new version registers
+		if agent, err := o.cfg.LiveAgent(progress.AgentID); err == nil &&
+			agent.Version == rollout.Version && agent.Status == model.AgentStatusConnected {
+			o.update(rollout, progress, StateSucceeded, fmt.Sprintf("registered with version %s", rollout.Version))
+			return
+		}
+
+		// An unknown outcome is expected while the agent restarts; the deadline bounds it
+		if status, err := o.cfg.EventStatus(event.ID); err == nil {
+			switch status.State {
+			case model.StateFailed, model.StateCancelled, model.StateExpired:
+				o.update(rollout, progress, StateFailed, fmt.Sprintf("upgrade event %s: %s", status.State, status.Message))
+				return
+			}
+		}
+
+		if time.Now().After(deadline) {
+			o.update(rollout, progress, StateFailed,
+				fmt.Sprintf("version %s did not register within %v", rollout.Version, rollout.RegisterTimeout))
+			return
+		}
+	}
+}
+
+// update records an agent state change and reports it
+func (o *Orchestrator) update(rollout *Rollout, progress *AgentProgress, state State, message string) {
+	o.mu.Lock()
+	progress.State = state
+	progress.Message = message
+	if state != StateUpgrading {
+		now := time.Now()
+		progress.FinishedAt = &now
+	}
+	snapshot := rollout.snapshot()
+	agentSnapshot := *progress
+	o.mu.Unlock()
+
+	logger.Info("Agent upgrade progress", "rollout_id", rollout.ID, "agent_id", progress.AgentID,
+		"state", state, "message", message)
+	if o.cfg.OnAgentProgress != nil {
+		o.cfg.OnAgentProgress(snapshot, &agentSnapshot)
+	}
+}
+
+// snapshot deep-copies the rollout. Caller must hold the orchestrator lock.
+func (r *Rollout) snapshot() *Rollout {
+	copied := *r
+	copied.cancel = nil
+	copied.Agents = make([]*AgentProgress, len(r.Agents))
+	for i, progress := range r.Agents {
+		p := *progress
+		copied.Agents[i] = &p
+	}
+	return &copied
+}
+
+// stageOf returns the stage of the i-th agent: a single canary, then batches
+func stageOf(i, batchSize int) int {
+	if i == 0 {
+		return 0
+	}
+	return 1 + (i-1)/batchSize
+}
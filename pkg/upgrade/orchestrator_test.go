@@ -0,0 +1,61 @@
+package upgrade
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/suyog1pathak/transporter/internal/model"
+	"github.com/suyog1pathak/transporter/pkg/logger"
+)
+
+func TestUpgradeAgentOutcome(t *testing.T) {
+	tests := []struct {
+		name       string
+		eventState model.ExecutionState
+		registered bool // The agent registers with the target version
+		want       State
+	}{
+		{name: "registered after completing", eventState: model.StateCompleted, registered: true, want: StateSucceeded},
+		{name: "registered after the event was failed over", eventState: model.StateUnknown, registered: true, want: StateSucceeded},
+		{name: "unknown until the deadline", eventState: model.StateUnknown, want: StateFailed},
+		{name: "event failed", eventState: model.StateFailed, want: StateFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger.InitLogger(false)
+
+			var mu sync.Mutex
+			version := "v1"
+			orchestrator := NewOrchestrator(Config{
+				Route: func(event *model.Event) error {
+					if tt.registered {
+						mu.Lock()
+						version = "v2"
+						mu.Unlock()
+					}
+					return nil
+				},
+				LiveAgent: func(agentID string) (*model.Agent, error) {
+					mu.Lock()
+					defer mu.Unlock()
+					return &model.Agent{ID: agentID, Version: version, Status: model.AgentStatusConnected}, nil
+				},
+				EventStatus: func(eventID string) (*model.EventStatus, error) {
+					return &model.EventStatus{EventID: eventID, State: tt.eventState}, nil
+				},
+				PollInterval: time.Millisecond,
+			})
+
+			rollout := &Rollout{ID: "rollout-1", Version: "v2", RegisterTimeout: 50 * time.Millisecond}
+			progress := &AgentProgress{AgentID: "agent-1"}
+			rollout.Agents = []*AgentProgress{progress}
+
+			orchestrator.upgradeAgent(t.Context(), rollout, progress)
+			if progress.State != tt.want {
+				t.Fatalf("state = %s (%s), want %s", progress.State, progress.Message, tt.want)
+			}
+		})
+	}
+}
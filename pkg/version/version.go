@@ -0,0 +1,18 @@
+// Package version holds build information injected at link time, e.g.
+//
+//	go build -ldflags "-X github.com/suyog1pathak/transporter/pkg/version.Version=1.2.3"
+package version
+
+import "fmt"
+
+// Build information, overridden with -ldflags -X at build time
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+// String returns the version with its commit and build time
+func String() string {
+	return fmt.Sprintf("%s (commit %s, built %s)", Version, Commit, BuildTime)
+}